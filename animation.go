@@ -0,0 +1,338 @@
+package img
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"sort"
+)
+
+// Animation is an animated image, such as an animated GIF, whose frames are drawn in turn onto a canvas.
+type Animation struct {
+	// Width and Height are the size of the canvas, which frames may cover only part of.
+	Width, Height int
+	// Frames holds the frames, with their bounds in the coordinates of the canvas.
+	Frames []image.Image
+	// Delay holds how long each frame is shown, in 100ths of a second.
+	Delay []int
+	// Disposal holds what becomes of the area of each frame before the next one is drawn: it is left as it is
+	// (gif.DisposalNone), cleared to transparent (gif.DisposalBackground) or restored to what it was before the frame
+	// was drawn (gif.DisposalPrevious).
+	Disposal []byte
+	// LoopCount is the number of times the animation is repeated after it is first shown, as in gif.GIF: 0 repeats
+	// it forever and -1 shows it once.
+	LoopCount int
+}
+
+// DecodeAnimation decodes an animated GIF. GIFs of a single frame are decoded as animations of one frame.
+func DecodeAnimation(r io.Reader) (*Animation, error) {
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("gif.DecodeAll: %s", err)
+	}
+	a := &Animation{
+		Width:     g.Config.Width,
+		Height:    g.Config.Height,
+		Frames:    make([]image.Image, len(g.Image)),
+		Delay:     g.Delay,
+		Disposal:  g.Disposal,
+		LoopCount: g.LoopCount,
+	}
+	for k, m := range g.Image {
+		a.Frames[k] = m
+	}
+	return a, nil
+}
+
+// EncodeAnimation writes the Animation to w in GIF format. Frames that aren't *image.Paletted are reduced to a
+// palette of their own of up to 256 colors, with a transparent one for pixels that are more than half transparent.
+func EncodeAnimation(w io.Writer, a *Animation) error {
+	if len(a.Frames) == 0 {
+		return errors.New("EncodeAnimation: no frames")
+	}
+	if len(a.Delay) != len(a.Frames) || a.Disposal != nil && len(a.Disposal) != len(a.Frames) {
+		return errors.New("EncodeAnimation: mismatched frames, delays and disposals")
+	}
+	g := &gif.GIF{
+		Image:     make([]*image.Paletted, len(a.Frames)),
+		Delay:     a.Delay,
+		Disposal:  a.Disposal,
+		LoopCount: a.LoopCount,
+		Config:    image.Config{Width: a.Width, Height: a.Height},
+	}
+	for k, m := range a.Frames {
+		p, ok := m.(*image.Paletted)
+		if !ok {
+			p = quantize(m)
+		}
+		g.Image[k] = p
+	}
+	return gif.EncodeAll(w, g)
+}
+
+// composite calls f with each frame of a as it is shown: the whole canvas, with the frames before it drawn
+// underneath according to their disposal. The canvas starts out transparent, and is reused from one call to the
+// next.
+func (a *Animation) composite(f func(k int, canvas *image.NRGBA) error) error {
+	canvas := image.NewNRGBA(image.Rect(0, 0, a.Width, a.Height))
+	var previous *image.NRGBA
+	for k, m := range a.Frames {
+		disposal := byte(gif.DisposalNone)
+		if k < len(a.Disposal) {
+			disposal = a.Disposal[k]
+		}
+		if disposal == gif.DisposalPrevious {
+			if previous == nil {
+				previous = image.NewNRGBA(canvas.Rect)
+			}
+			copy(previous.Pix, canvas.Pix)
+		}
+		draw.Draw(canvas, m.Bounds(), m, m.Bounds().Min, draw.Over)
+		if err := f(k, canvas); err != nil {
+			return err
+		}
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, m.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			copy(canvas.Pix, previous.Pix)
+		}
+	}
+	return nil
+}
+
+// transform returns a copy of a with each frame, as it is shown, replaced by f of it. The new frames cover the
+// whole canvas, which takes their size, and are reduced to a palette as EncodeAnimation does. Frames with transparent
+// pixels are cleared before the next one is drawn, so that the frames before them don't show through.
+func (a *Animation) transform(f func(Image) (Image, error)) (*Animation, error) {
+	t := &Animation{
+		Frames:    make([]image.Image, len(a.Frames)),
+		Delay:     append([]int(nil), a.Delay...),
+		Disposal:  make([]byte, len(a.Frames)),
+		LoopCount: a.LoopCount,
+	}
+	err := a.composite(func(k int, canvas *image.NRGBA) error {
+		i, err := f(Image{buf: &bytes.Buffer{}, Image: canvas})
+		if err != nil {
+			return err
+		}
+		b := i.Image.Bounds()
+		if k == 0 {
+			t.Width, t.Height = b.Dx(), b.Dy()
+		}
+		p := quantize(i.Image)
+		p.Rect = image.Rect(0, 0, b.Dx(), b.Dy())
+		t.Frames[k] = p
+		t.Disposal[k] = gif.DisposalNone
+		if !p.Opaque() {
+			t.Disposal[k] = gif.DisposalBackground
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ResizeAnimation scales every frame of a to w by h pixels with the given filter, as Resize does. The frames are
+// composited first, so that those that only cover part of the canvas are scaled along with what shows through them.
+func ResizeAnimation(a *Animation, w, h int, f Filter) (*Animation, error) {
+	return a.transform(func(i Image) (Image, error) { return Resize(i, w, h, f) })
+}
+
+// ThumbnailAnimation returns a w by h thumbnail of a, or one that fits inside w by h in Fit mode, as Thumbnail does
+// for every frame once they are composited.
+func ThumbnailAnimation(a *Animation, w, h int, mode ThumbnailMode) (*Animation, error) {
+	return a.transform(func(i Image) (Image, error) { return Thumbnail(i, w, h, mode) })
+}
+
+// Still returns a representative frame of a, as it is shown, for a still thumbnail of it. It is the frame with the
+// most detail, measured as the contrast between neighboring pixels, which passes over the blank or faded frames that
+// animations often start with. The earliest frame wins ties.
+func (a *Animation) Still() Image {
+	var best *image.NRGBA
+	bestDetail := -1
+	a.composite(func(k int, canvas *image.NRGBA) error {
+		if d := detail(canvas); d > bestDetail {
+			if best == nil {
+				best = image.NewNRGBA(canvas.Rect)
+			}
+			copy(best.Pix, canvas.Pix)
+			bestDetail = d
+		}
+		return nil
+	})
+	if best == nil {
+		best = image.NewNRGBA(image.Rect(0, 0, a.Width, a.Height))
+	}
+	return Image{buf: &bytes.Buffer{}, Image: best}
+}
+
+// detail returns the sum of the differences in luma between neighboring pixels of m, on a grid of about 256x256
+// pixels. Transparent pixels count as black.
+func detail(m *image.NRGBA) int {
+	b := m.Rect
+	step := 1
+	for b.Dx()/step > 256 || b.Dy()/step > 256 {
+		step++
+	}
+	luma := func(x, y int) int {
+		p := m.PixOffset(x, y)
+		c := m.Pix[p : p+4]
+		return (299*int(c[0]) + 587*int(c[1]) + 114*int(c[2])) * int(c[3]) / 255
+	}
+	sum := 0
+	for y := b.Min.Y; y+step < b.Max.Y; y += step {
+		for x := b.Min.X; x+step < b.Max.X; x += step {
+			l := luma(x, y)
+			sum += abs(l-luma(x+step, y)) + abs(l-luma(x, y+step))
+		}
+	}
+	return sum
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// quantize returns m with its colors reduced to a palette of up to 256, chosen by median cut: the colors are split
+// into boxes by halving the box with the widest range of a channel, at the median of the pixels, until there are as
+// many as the palette holds, and each box is given the average of its colors. Images with few enough colors keep them
+// exactly. Pixels that are more than half transparent get a transparent color of their own.
+func quantize(m image.Image) *image.Paletted {
+	b := m.Bounds()
+	counts := make(map[color.NRGBA]int)
+	transparent := false
+	pixels := make([]color.NRGBA, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c.A < 0x80 {
+				c = color.NRGBA{}
+				transparent = true
+			} else {
+				c.A = 0xff
+				counts[c]++
+			}
+			pixels = append(pixels, c)
+		}
+	}
+
+	n := 256
+	if transparent {
+		n--
+	}
+	if len(counts) == 0 {
+		// The palette can't be empty, even if every pixel is transparent.
+		counts[color.NRGBA{A: 0xff}] = 0
+	}
+	type colorCount struct {
+		c color.NRGBA
+		n int
+	}
+	all := make([]colorCount, 0, len(counts))
+	for c, k := range counts {
+		all = append(all, colorCount{c, k})
+	}
+	// Sort the colors so that the palette doesn't depend on the order of map iteration.
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i].c, all[j].c
+		return a.R < b.R || a.R == b.R && (a.G < b.G || a.G == b.G && a.B < b.B)
+	})
+	channel := func(c color.NRGBA, ch int) uint8 {
+		return [3]uint8{c.R, c.G, c.B}[ch]
+	}
+	// colorBox is a box of colors with the channel that has the widest range in it, and that range, which are worked
+	// out once when the box is made.
+	type colorBox struct {
+		colors    []colorCount
+		ch, width int
+	}
+	newBox := func(colors []colorCount) colorBox {
+		box := colorBox{colors: colors, width: -1}
+		for ch := 0; ch < 3; ch++ {
+			lo, hi := 255, 0
+			for _, c := range colors {
+				v := int(channel(c.c, ch))
+				if v < lo {
+					lo = v
+				}
+				if v > hi {
+					hi = v
+				}
+			}
+			if hi-lo > box.width {
+				box.ch, box.width = ch, hi-lo
+			}
+		}
+		return box
+	}
+
+	boxes := []colorBox{newBox(all)}
+	for len(boxes) < n {
+		// Split the box with the widest range, if it has more than one color.
+		split, splitRange := -1, 0
+		for k, box := range boxes {
+			if len(box.colors) >= 2 && box.width > splitRange {
+				split, splitRange = k, box.width
+			}
+		}
+		if split < 0 {
+			break
+		}
+		box, splitCh := boxes[split].colors, boxes[split].ch
+		sort.SliceStable(box, func(i, j int) bool { return channel(box[i].c, splitCh) < channel(box[j].c, splitCh) })
+		total := 0
+		for _, c := range box {
+			total += c.n
+		}
+		half, at := 0, 1
+		for ; at < len(box)-1; at++ {
+			half += box[at-1].n
+			if 2*half >= total {
+				break
+			}
+		}
+		boxes[split] = newBox(box[:at])
+		boxes = append(boxes, newBox(box[at:]))
+	}
+
+	var pal color.Palette
+	index := make(map[color.NRGBA]uint8, len(all))
+	for _, box := range boxes {
+		var r, g, bl, total int
+		for _, c := range box.colors {
+			k := c.n
+			if k == 0 {
+				k = 1
+			}
+			r += int(c.c.R) * k
+			g += int(c.c.G) * k
+			bl += int(c.c.B) * k
+			total += k
+		}
+		for _, c := range box.colors {
+			index[c.c] = uint8(len(pal))
+		}
+		pal = append(pal, color.NRGBA{uint8(r / total), uint8(g / total), uint8(bl / total), 0xff})
+	}
+	if transparent {
+		index[color.NRGBA{}] = uint8(len(pal))
+		pal = append(pal, color.NRGBA{})
+	}
+
+	p := image.NewPaletted(b, pal)
+	for k, c := range pixels {
+		p.Pix[k] = index[c]
+	}
+	return p
+}
@@ -0,0 +1,186 @@
+package img
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"testing"
+)
+
+var (
+	red   = color.NRGBA{0xff, 0, 0, 0xff}
+	green = color.NRGBA{0, 0xff, 0, 0xff}
+	blue  = color.NRGBA{0, 0, 0xff, 0xff}
+)
+
+// testGIF returns a 20x10 GIF of 4 frames: a red background, a blue patch that is then cleared, a green patch that
+// is then undone, and a single pixel.
+func testGIF(t *testing.T) []byte {
+	pal := color.Palette{color.NRGBA{}, red, green, blue}
+	frame := func(r image.Rectangle, index uint8) *image.Paletted {
+		p := image.NewPaletted(r, pal)
+		for k := range p.Pix {
+			p.Pix[k] = index
+		}
+		return p
+	}
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			frame(image.Rect(0, 0, 20, 10), 1),
+			frame(image.Rect(5, 2, 10, 6), 3),
+			frame(image.Rect(12, 2, 16, 6), 2),
+			frame(image.Rect(0, 0, 1, 1), 2),
+		},
+		Delay:     []int{10, 20, 30, 40},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious, gif.DisposalNone},
+		LoopCount: 2,
+	}
+	buf := &bytes.Buffer{}
+	if err := gif.EncodeAll(buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAnimation(t *testing.T) {
+	a, err := DecodeAnimation(bytes.NewReader(testGIF(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Width != 20 || a.Height != 10 || len(a.Frames) != 4 || a.LoopCount != 2 || a.Delay[3] != 40 ||
+		a.Disposal[1] != gif.DisposalBackground {
+		t.Fatalf("got %dx%d animation of %d frames, looping %d times, delays %v, disposal %v",
+			a.Width, a.Height, len(a.Frames), a.LoopCount, a.Delay, a.Disposal)
+	}
+
+	// The pixels at the blue patch, the green patch and the corner, as each frame is shown.
+	transparent := color.NRGBA{}
+	want := [][3]color.NRGBA{
+		{red, red, red},
+		{blue, red, red},
+		{transparent, green, red},
+		{transparent, red, green},
+	}
+	a.composite(func(k int, canvas *image.NRGBA) error {
+		got := [3]color.NRGBA{canvas.NRGBAAt(7, 3), canvas.NRGBAAt(13, 3), canvas.NRGBAAt(0, 0)}
+		if got != want[k] {
+			t.Errorf("frame %d: got %v, want %v", k, got, want[k])
+		}
+		return nil
+	})
+
+	th, err := ThumbnailAnimation(a, 10, 5, Stretch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := EncodeAnimation(buf, th); err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Config.Width != 10 || g.Config.Height != 5 || len(g.Image) != 4 || g.LoopCount != 2 || g.Delay[2] != 30 {
+		t.Fatalf("got %dx%d GIF of %d frames, looping %d times, delays %v",
+			g.Config.Width, g.Config.Height, len(g.Image), g.LoopCount, g.Delay)
+	}
+	// The cleared blue patch stays transparent in the third frame.
+	if _, _, _, alpha := g.Image[2].At(3, 2).RGBA(); alpha != 0 {
+		t.Error("cleared patch isn't transparent")
+	}
+	if c := color.NRGBAModel.Convert(g.Image[2].At(7, 2)).(color.NRGBA); c.G < 0xc0 || c.R > 0x80 || c.B > 0x40 {
+		t.Errorf("got %v in the green patch", c)
+	}
+}
+
+func TestResizeAnimationTransparency(t *testing.T) {
+	// Two frames that are each cleared once shown: red on the left, then blue on the right.
+	left, right := image.NewNRGBA(image.Rect(0, 0, 8, 8)), image.NewNRGBA(image.Rect(8, 0, 16, 8))
+	draw.Draw(left, left.Rect, image.NewUniform(red), image.Point{}, draw.Src)
+	draw.Draw(right, right.Rect, image.NewUniform(blue), image.Point{}, draw.Src)
+	a := &Animation{
+		Width:    16,
+		Height:   8,
+		Frames:   []image.Image{left, right},
+		Delay:    []int{10, 10},
+		Disposal: []byte{gif.DisposalBackground, gif.DisposalBackground},
+	}
+	var want [][2]uint8
+	a.composite(func(k int, canvas *image.NRGBA) error {
+		want = append(want, [2]uint8{canvas.NRGBAAt(2, 4).A, canvas.NRGBAAt(13, 4).A})
+		return nil
+	})
+
+	r, err := ResizeAnimation(a, 8, 4, Lanczos3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.composite(func(k int, canvas *image.NRGBA) error {
+		if got := [2]uint8{canvas.NRGBAAt(1, 2).A, canvas.NRGBAAt(6, 2).A}; got != want[k] {
+			t.Errorf("frame %d: got alpha %v, want %v", k, got, want[k])
+		}
+		return nil
+	})
+}
+
+func TestAnimationStill(t *testing.T) {
+	blank := image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{color.Black, color.White})
+	checker := image.NewPaletted(blank.Rect, blank.Palette)
+	for k := range checker.Pix {
+		checker.Pix[k] = uint8((k + k/8) % 2)
+	}
+	a := &Animation{
+		Width:  8,
+		Height: 8,
+		Frames: []image.Image{blank, checker, blank},
+		Delay:  []int{0, 0, 0},
+	}
+	i := a.Still()
+	if i.Image.Bounds() != blank.Rect {
+		t.Fatalf("got %v still", i.Image.Bounds())
+	}
+	if c := color.GrayModel.Convert(i.Image.At(1, 0)); c != (color.Gray{0xff}) {
+		t.Error("didn't pick the detailed frame")
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{uint8(4 * x), uint8(4 * y), uint8(2 * (x + y)), 0xff})
+		}
+	}
+	m.SetNRGBA(0, 0, color.NRGBA{0xff, 0xff, 0xff, 0x10})
+	p := quantize(m)
+	if len(p.Palette) != 256 {
+		t.Errorf("got %d colors", len(p.Palette))
+	}
+	if _, _, _, a := p.At(0, 0).RGBA(); a != 0 {
+		t.Error("transparent pixel isn't transparent")
+	}
+	// Colors are off by less than the size of their boxes.
+	for y := 0; y < 64; y++ {
+		for x := 1; x < 64; x++ {
+			got, want := p.At(x, y).(color.NRGBA), m.NRGBAAt(x, y)
+			if d := abs(int(got.R)-int(want.R)) + abs(int(got.G)-int(want.G)) + abs(int(got.B)-int(want.B)); d > 48 {
+				t.Fatalf("at (%d, %d): got %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	// Images with few colors keep them exactly.
+	few := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	for x, c := range []color.NRGBA{red, green, blue} {
+		few.SetNRGBA(x, 0, c)
+	}
+	p = quantize(few)
+	for x, c := range []color.NRGBA{red, green, blue} {
+		if got := p.At(x, 0); got != c {
+			t.Errorf("got %v, want %v", got, c)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/snapas/img"
+	"github.com/snapas/img/jpeg"
+	"github.com/snapas/img/jpegfile"
+)
+
+// subsamplingFlag is a flag.Value for jpeg.Subsampling, given as "420" or "444".
+type subsamplingFlag struct{ s *jpeg.Subsampling }
+
+func (f subsamplingFlag) String() string {
+	if f.s != nil && *f.s == jpeg.Subsample444 {
+		return "444"
+	}
+	return "420"
+}
+
+func (f subsamplingFlag) Set(v string) error {
+	switch v {
+	case "420", "4:2:0":
+		*f.s = jpeg.Subsample420
+	case "444", "4:4:4":
+		*f.s = jpeg.Subsample444
+	default:
+		return errors.New("must be 420 or 444")
+	}
+	return nil
+}
+
+func convert(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	o := &jpeg.Options{}
+	fs.IntVar(&o.Quality, "q", jpeg.DefaultQuality, "JPEG quality, from 1 to 100")
+	fs.Var(subsamplingFlag{&o.Subsampling}, "subsampling", "chroma subsampling, 420 or 444")
+	fs.BoolVar(&o.Progressive, "progressive", false, "write a progressive JPEG")
+	fs.BoolVar(&o.DetectGray, "gray", false, "encode images whose pixels are all gray as grayscale")
+	fs.BoolVar(&o.CompactProfile, "compact-icc", false, "replace well-known ICC profiles with compact equivalents")
+	fs.BoolVar(&o.DropSRGB, "drop-srgb", false, "with -compact-icc, remove sRGB profiles entirely")
+	args, err := parseFlags(fs, args, stderr, 2, "IN OUT")
+	if err != nil {
+		return err
+	}
+	i, err := decode(args[0])
+	if err != nil {
+		return err
+	}
+	return writeFile(args[1], stdout, func(w io.Writer) error {
+		return encode(w, args[1], i, o)
+	})
+}
+
+func rotate(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	o := &jpeg.Options{}
+	fs.IntVar(&o.Quality, "q", jpeg.DefaultQuality, "JPEG quality, from 1 to 100")
+	angle := fs.Int("angle", 90, "clockwise rotation in degrees, a multiple of 90")
+	args, err := parseFlags(fs, args, stderr, 2, "IN OUT")
+	if err != nil {
+		return err
+	}
+	i, err := decode(args[0])
+	if err != nil {
+		return err
+	}
+	if i, err = img.Rotate(i, *angle); err != nil {
+		return err
+	}
+	return writeFile(args[1], stdout, func(w io.Writer) error {
+		return encode(w, args[1], i, o)
+	})
+}
+
+// encode encodes i for the named output file, as a PNG or a lossless WebP if its name ends in ".png" or ".webp", and
+// as a JPEG with the given options otherwise.
+func encode(w io.Writer, name string, i img.Image, o *jpeg.Options) error {
+	switch ext := filepath.Ext(name); {
+	case strings.EqualFold(ext, ".png"):
+		return img.EncodePNG(w, i, nil)
+	case strings.EqualFold(ext, ".webp"):
+		return img.EncodeWebP(w, i, nil)
+	}
+	return img.Encode(w, i, o)
+}
+
+// decode decodes the named image, which is turned upright according to its EXIF orientation.
+func decode(name string) (img.Image, error) {
+	b, err := readFile(name)
+	if err != nil {
+		return img.Image{}, err
+	}
+	i, _, err := img.Decode(bytes.NewReader(b))
+	return i, err
+}
+
+func strip(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("strip", flag.ContinueOnError)
+	keepICC := fs.Bool("icc", true, "keep the ICC profile")
+	args, err := parseFlags(fs, args, stderr, 2, "IN OUT")
+	if err != nil {
+		return err
+	}
+	f, err := readJPEG(args[0])
+	if err != nil {
+		return err
+	}
+	if at := f.Find(jpegfile.APP1, []byte(exifHeader), 0); at >= 0 {
+		if o := img.Orientation(f.Segments[at].Data[len(exifHeader):]); o != 1 {
+			return fmt.Errorf("strip: the image is %s by its EXIF orientation, which would be lost; "+
+				"run convert or rotate first", orientations[o])
+		}
+	}
+
+	// JFIF and Adobe segments describe the color of the pixels, and are kept along with any ICC profile. Secondary
+	// MPF images and anything else after the image go too.
+	segs := f.Segments[:0]
+	for _, s := range f.Segments {
+		if !s.Marker.IsMetadata() ||
+			s.Marker == jpegfile.APP0 && bytes.HasPrefix(s.Data, []byte("JFIF\x00")) ||
+			s.Marker == jpegfile.APP14 && bytes.HasPrefix(s.Data, []byte("Adobe")) ||
+			s.Marker == jpegfile.APP2 && bytes.HasPrefix(s.Data, []byte(iccHeader)) && *keepICC {
+			segs = append(segs, s)
+		}
+	}
+	f.Segments, f.Trailer = segs, nil
+	return writeFile(args[1], stdout, func(w io.Writer) error {
+		_, err := f.WriteTo(w)
+		return err
+	})
+}
+
+func extractICC(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("extract-icc", flag.ContinueOnError)
+	args, err := parseFlags(fs, args, stderr, 2, "IN OUT")
+	if err != nil {
+		return err
+	}
+	f, err := readJPEG(args[0])
+	if err != nil {
+		return err
+	}
+	profile := iccProfile(f)
+	if profile == nil {
+		return errors.New("extract-icc: the image has no ICC profile")
+	}
+	return writeFile(args[1], stdout, func(w io.Writer) error {
+		_, err := w.Write(profile)
+		return err
+	})
+}
+
+// readJPEG reads and parses the named JPEG file.
+func readJPEG(name string) (*jpegfile.File, error) {
+	b, err := readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return jpegfile.Parse(b)
+}
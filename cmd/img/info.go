@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+
+	"github.com/snapas/img"
+	"github.com/snapas/img/heif"
+	"github.com/snapas/img/icc"
+	"github.com/snapas/img/jpeg"
+	"github.com/snapas/img/jpegfile"
+	"github.com/snapas/img/mpf"
+)
+
+const (
+	exifHeader = "Exif\x00\x00"
+	xmpHeader  = "http://ns.adobe.com/xap/1.0/\x00"
+	iccHeader  = "ICC_PROFILE\x00"
+)
+
+var orientations = [...]string{
+	1: "upright",
+	2: "mirrored",
+	3: "rotated 180°",
+	4: "mirrored vertically",
+	5: "mirrored and rotated 90° counterclockwise",
+	6: "rotated 90° clockwise",
+	7: "mirrored and rotated 90° clockwise",
+	8: "rotated 90° counterclockwise",
+}
+
+func info(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	args, err := parseFlags(fs, args, stderr, 1, "FILE")
+	if err != nil {
+		return err
+	}
+	b, err := readFile(args[0])
+	if err != nil {
+		return err
+	}
+	if len(b) >= 8 && string(b[4:8]) == "ftyp" {
+		// HEIF images can't be decoded, but the metadata of their container can be read.
+		m, err := heif.ReadMeta(bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		printHEIFInfo(stdout, m, len(b))
+		return nil
+	}
+	c, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Format:      %s\n", format)
+	fmt.Fprintf(stdout, "Dimensions:  %dx%d\n", c.Width, c.Height)
+	fmt.Fprintf(stdout, "Size:        %d bytes\n", len(b))
+	if format != "jpeg" {
+		return nil
+	}
+	f, err := jpegfile.Parse(b)
+	if err != nil {
+		return err
+	}
+	printJPEGInfo(stdout, f)
+	return nil
+}
+
+// printJPEGInfo prints how the JPEG file f is encoded, and a summary of its metadata.
+func printJPEGInfo(w io.Writer, f *jpegfile.File) {
+	var dqt []byte
+	var exif, xmp []byte
+	var mpfData []byte
+	var comments []string
+	for _, s := range f.Segments {
+		switch {
+		case isSOF(s.Marker):
+			fmt.Fprintf(w, "Encoding:    %s\n", process(s.Marker))
+			fmt.Fprintf(w, "Sampling:    %s\n", sampling(s.Data))
+		case s.Marker == jpegfile.DQT:
+			dqt = append(dqt, s.Data...)
+		case s.Marker == jpegfile.APP1 && bytes.HasPrefix(s.Data, []byte(exifHeader)) && exif == nil:
+			exif = s.Data[len(exifHeader):]
+		case s.Marker == jpegfile.APP1 && bytes.HasPrefix(s.Data, []byte(xmpHeader)) && xmp == nil:
+			xmp = s.Data[len(xmpHeader):]
+		case s.Marker == jpegfile.APP2 && bytes.HasPrefix(s.Data, []byte(mpf.Header)) && mpfData == nil:
+			mpfData = s.Data
+		case s.Marker == jpegfile.COM:
+			comments = append(comments, string(s.Data))
+		}
+	}
+	if q := jpeg.EstimateQuality(dqt); q > 0 {
+		fmt.Fprintf(w, "Quality:     ~%d\n", q)
+	}
+	o := img.Orientation(exif)
+	fmt.Fprintf(w, "Orientation: %d (%s)\n", o, orientations[o])
+
+	if profile := iccProfile(f); profile != nil {
+		fmt.Fprintf(w, "ICC profile: %s\n", describeProfile(profile))
+	} else {
+		fmt.Fprintln(w, "ICC profile: none")
+	}
+	if exif != nil {
+		fmt.Fprintf(w, "EXIF:        %d bytes\n", len(exif))
+	}
+	if xmp != nil {
+		fmt.Fprintf(w, "XMP:         %d bytes\n", len(xmp))
+	}
+	if mpfData != nil {
+		if x, err := mpf.Parse(mpfData); err != nil {
+			fmt.Fprintf(w, "MPF:         %s\n", err)
+		} else {
+			fmt.Fprintf(w, "MPF:         %d images\n", len(x.Entries))
+			for k, e := range x.Entries {
+				fmt.Fprintf(w, "  %d: %s, %d bytes\n", k, e.Type(), e.Size)
+			}
+		}
+	}
+	for _, c := range comments {
+		fmt.Fprintf(w, "Comment:     %q\n", c)
+	}
+	if len(f.Trailer) > 0 {
+		fmt.Fprintf(w, "After EOI:   %d bytes\n", len(f.Trailer))
+	}
+}
+
+// printHEIFInfo prints the coding and metadata of the primary image of a HEIF file of the given size.
+func printHEIFInfo(w io.Writer, m *heif.Meta, size int) {
+	fmt.Fprintln(w, "Format:      heif")
+	fmt.Fprintf(w, "Dimensions:  %dx%d\n", m.Width, m.Height)
+	fmt.Fprintf(w, "Size:        %d bytes\n", size)
+	fmt.Fprintf(w, "Brand:       %s\n", m.Brand)
+	fmt.Fprintf(w, "Coding:      %s\n", m.Type)
+	o := m.Orientation()
+	fmt.Fprintf(w, "Orientation: %d (%s)\n", o, orientations[o])
+	if m.ICCProfile != nil {
+		fmt.Fprintf(w, "ICC profile: %s\n", describeProfile(m.ICCProfile))
+	} else {
+		fmt.Fprintln(w, "ICC profile: none")
+	}
+	if m.Exif != nil {
+		fmt.Fprintf(w, "EXIF:        %d bytes\n", len(m.Exif))
+	}
+	if m.XMP != nil {
+		fmt.Fprintf(w, "XMP:         %d bytes\n", len(m.XMP))
+	}
+	if m.Thumbnail != nil {
+		fmt.Fprintf(w, "Thumbnail:   %d bytes of JPEG\n", len(m.Thumbnail))
+	}
+}
+
+// isSOF reports whether m is one of the SOFn markers, which share their range with DHT, JPG and DAC.
+func isSOF(m jpegfile.Marker) bool {
+	return jpegfile.SOF0 <= m && m <= jpegfile.SOF15 && m != jpegfile.DHT && m != jpegfile.JPG && m != jpegfile.DAC
+}
+
+// process returns the encoding process of SOFn segments with the marker.
+func process(m jpegfile.Marker) string {
+	switch m {
+	case jpegfile.SOF0:
+		return "baseline"
+	case jpegfile.SOF1:
+		return "extended sequential"
+	case jpegfile.SOF2:
+		return "progressive"
+	case jpegfile.SOF3:
+		return "lossless"
+	}
+	return m.String()
+}
+
+// sampling returns the chroma subsampling described by the data of an SOFn segment, such as "4:2:0".
+func sampling(sof []byte) string {
+	if len(sof) < 6 {
+		return "unknown"
+	}
+	n := int(sof[5])
+	if n == 1 {
+		return "grayscale"
+	}
+	if len(sof) < 6+3*n || n < 3 {
+		return fmt.Sprintf("%d components", n)
+	}
+	h, v := sof[7]>>4, sof[7]&0x0f
+	for k := 1; k < n; k++ {
+		if sof[7+3*k] != 0x11 {
+			return fmt.Sprintf("%d components, irregular", n)
+		}
+	}
+	switch {
+	case h == 1 && v == 1:
+		return "4:4:4"
+	case h == 2 && v == 1:
+		return "4:2:2"
+	case h == 1 && v == 2:
+		return "4:4:0"
+	case h == 2 && v == 2:
+		return "4:2:0"
+	case h == 4 && v == 1:
+		return "4:1:1"
+	}
+	return fmt.Sprintf("%dx%d", h, v)
+}
+
+// iccProfile returns the ICC profile embedded in the APP2 segments of f, or nil if it has none. Large profiles are
+// split across several segments, which are put back together in order of their sequence numbers.
+func iccProfile(f *jpegfile.File) []byte {
+	type chunk struct {
+		seq  byte
+		data []byte
+	}
+	var chunks []chunk
+	for at := f.Find(jpegfile.APP2, []byte(iccHeader), 0); at >= 0; at = f.Find(jpegfile.APP2, []byte(iccHeader), at+1) {
+		d := f.Segments[at].Data[len(iccHeader):]
+		if len(d) >= 2 {
+			chunks = append(chunks, chunk{d[0], d[2:]})
+		}
+	}
+	if len(chunks) == 0 {
+		return nil
+	}
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].seq < chunks[j].seq })
+	var profile []byte
+	for _, c := range chunks {
+		profile = append(profile, c.data...)
+	}
+	return profile
+}
+
+// describeProfile returns the description of the ICC profile, and the well-known color space it matches, if any.
+func describeProfile(b []byte) string {
+	p, err := icc.Parse(b)
+	if err != nil {
+		return fmt.Sprintf("%d bytes, %s", len(b), err)
+	}
+	desc, err := p.Description()
+	if err != nil {
+		desc = p.ColorSpace
+	}
+	s := fmt.Sprintf("%q, %d bytes", desc, len(b))
+	if k := icc.Identify(p); k != icc.Unknown {
+		s += fmt.Sprintf(", %s", k)
+	}
+	return s
+}
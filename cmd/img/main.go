@@ -0,0 +1,127 @@
+// Command img inspects and converts images with the img packages, keeping their metadata.
+//
+// Usage:
+//
+//	img info FILE
+//	img segments FILE
+//	img convert [-q QUALITY] [-subsampling 420|444] [-progressive] [-gray] [-compact-icc] [-drop-srgb] IN OUT
+//	img rotate [-q QUALITY] -angle DEGREES IN OUT
+//	img strip [-icc=false] IN OUT
+//	img extract-icc IN OUT
+//
+// Images are written as JPEGs, unless the output file name ends in ".png", or ".webp" for lossless WebPs. A file name
+// of "-" reads from standard input or writes to standard output.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	_ "image/gif"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const usage = `usage: img COMMAND [FLAGS] ARGS
+
+Commands:
+  info FILE          print the dimensions, encoding and metadata of an image, or of the container of a HEIF image
+  segments FILE      list the segments of a JPEG file with their offsets
+  convert IN OUT     re-encode an image as a JPEG, or a PNG or WebP for .png or .webp files, keeping its metadata
+  rotate IN OUT      rotate an image clockwise and re-encode it, keeping its metadata
+  strip IN OUT       remove the metadata of a JPEG file without re-encoding it
+  extract-icc IN OUT write the ICC profile of a JPEG file to OUT
+
+Run "img COMMAND -h" for the flags of a command.
+`
+
+// errUsage is returned for invalid command lines, after the usage has been printed.
+var errUsage = errors.New("invalid arguments")
+
+type command func(args []string, stdout, stderr io.Writer) error
+
+var commands = map[string]command{
+	"info":        info,
+	"segments":    segments,
+	"convert":     convert,
+	"rotate":      rotate,
+	"strip":       strip,
+	"extract-icc": extractICC,
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if err != errUsage {
+			fmt.Fprintln(os.Stderr, "img:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+// run runs the command line in args, without the program name.
+func run(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return errUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "img: unknown command %q\n%s", args[0], usage)
+		return errUsage
+	}
+	return cmd(args[1:], stdout, stderr)
+}
+
+// parseFlags parses the flags of the named command, and checks that n arguments follow them.
+func parseFlags(fs *flag.FlagSet, args []string, stderr io.Writer, n int, argsUsage string) ([]string, error) {
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: img %s [FLAGS] %s\n", fs.Name(), argsUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	if fs.NArg() != n {
+		fs.Usage()
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+// readFile reads the named file, or standard input for "-".
+func readFile(name string) ([]byte, error) {
+	if name == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(name)
+}
+
+// writeFile writes the output of write to the named file, or to stdout for "-". The file is only created once write
+// has succeeded, so that failed commands leave no partial files behind.
+func writeFile(name string, stdout io.Writer, write func(w io.Writer) error) error {
+	if name == "-" {
+		return write(stdout)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".img-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(name); err == nil {
+		os.Chmod(tmp.Name(), fi.Mode())
+	} else {
+		os.Chmod(tmp.Name(), 0644)
+	}
+	return os.Rename(tmp.Name(), name)
+}
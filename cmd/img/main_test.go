@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/snapas/img"
+	"github.com/snapas/img/icc"
+	"github.com/snapas/img/iccjpeg"
+)
+
+// exifOrientation is TIFF-structured EXIF data with only an orientation tag, whose value is at offset 18.
+const exifOrientation = "MM\x00*\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x01\x00\x00\x00\x00\x00\x00"
+
+// writeTestJPEG writes a 64x48 JPEG with an ICC profile, XMP metadata and EXIF metadata with the given orientation to
+// dir, and returns its name.
+func writeTestJPEG(t *testing.T, dir string, orientation byte) string {
+	exif := []byte(exifOrientation)
+	exif[19] = orientation
+	m := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			m.Set(x, y, color.RGBA{uint8(4 * x), uint8(5 * y), 0x80, 0xff})
+		}
+	}
+	buf := &bytes.Buffer{}
+	i := img.Image{
+		Image: m,
+		App2:  iccjpeg.App2Data(icc.DisplayP3Data),
+		Exif:  exif,
+		XMP:   []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`),
+	}
+	if err := img.Encode(buf, i, nil); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, fmt.Sprintf("test-%d.jpg", orientation))
+	if err := ioutil.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// runOutput runs the command line and returns what it wrote to stdout.
+func runOutput(t *testing.T, args ...string) string {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := run(args, stdout, stderr); err != nil {
+		t.Fatalf("img %s: %v\n%s", strings.Join(args, " "), err, stderr)
+	}
+	return stdout.String()
+}
+
+func TestCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := writeTestJPEG(t, dir, 1)
+	out := filepath.Join(dir, "out.jpg")
+	png := filepath.Join(dir, "out.png")
+	webp := filepath.Join(dir, "out.webp")
+
+	for _, c := range []struct {
+		args []string
+		want []string
+	}{
+		{
+			[]string{"info", in},
+			[]string{"Dimensions:  64x48", "Encoding:    baseline", "Sampling:    4:2:0", "Quality:     ~75",
+				"Orientation: 1 (upright)", `ICC profile: "Display P3"`, "XMP:"},
+		},
+		{
+			[]string{"segments", in},
+			[]string{"         0  SOI\n", "         2  APP1", "  Exif\n", "ICC_PROFILE", "SOF0       17  64x48", "EOI\n"},
+		},
+		{[]string{"convert", "-q", "90", "-subsampling", "444", in, out}, nil},
+		{[]string{"info", out}, []string{"Sampling:    4:4:4", "Quality:     ~90", "Display P3", "XMP:"}},
+		{[]string{"convert", "-progressive", in, out}, nil},
+		{[]string{"info", out}, []string{"Encoding:    progressive", "Sampling:    4:2:0", "Display P3", "XMP:"}},
+		{[]string{"convert", in, png}, nil},
+		{[]string{"info", png}, []string{"Format:      png", "Dimensions:  64x48"}},
+		{[]string{"convert", png, out}, nil},
+		{[]string{"info", out}, []string{"Display P3", "EXIF:", "XMP:"}},
+		{[]string{"convert", in, webp}, nil},
+		{[]string{"info", webp}, []string{"Format:      webp", "Dimensions:  64x48"}},
+		{[]string{"convert", webp, out}, nil},
+		{[]string{"info", out}, []string{"Display P3", "EXIF:", "XMP:"}},
+		{[]string{"rotate", "-angle", "270", in, out}, nil},
+		{[]string{"info", out}, []string{"Dimensions:  48x64", "Display P3"}},
+		{[]string{"strip", in, out}, nil},
+		{[]string{"info", out}, []string{"Dimensions:  64x48", "Display P3"}},
+		{[]string{"strip", "-icc=false", in, out}, nil},
+		{[]string{"info", out}, []string{"ICC profile: none"}},
+	} {
+		got := runOutput(t, c.args...)
+		for _, w := range c.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("img %s: output doesn't contain %q:\n%s", strings.Join(c.args, " "), w, got)
+			}
+		}
+		if c.args[0] == "strip" {
+			if s := runOutput(t, "info", out); strings.Contains(s, "EXIF") || strings.Contains(s, "XMP") {
+				t.Errorf("img %s left metadata:\n%s", strings.Join(c.args, " "), s)
+			}
+		}
+	}
+
+	profile := filepath.Join(dir, "profile.icc")
+	runOutput(t, "extract-icc", in, profile)
+	if b, err := ioutil.ReadFile(profile); err != nil || !bytes.Equal(b, icc.DisplayP3Data) {
+		t.Errorf("extracted a %d-byte profile: %v", len(b), err)
+	}
+}
+
+func TestHEIFInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A HEIF file with the meta box of an HEVC image rotated 90° counterclockwise, with a Display P3 profile and no
+	// image data.
+	box := func(typ string, data ...string) string {
+		b := typ + strings.Join(data, "")
+		n := len(b) + 4
+		return string([]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}) + b
+	}
+	full := "\x00\x00\x00\x00"
+	file := box("ftyp", "heic\x00\x00\x00\x00mif1heic") + box("meta", full,
+		box("hdlr", full, "\x00\x00\x00\x00pict", strings.Repeat("\x00", 13)),
+		box("pitm", full, "\x00\x01"),
+		box("iloc", full, "\x44\x00\x00\x00"),
+		box("iinf", full, "\x00\x01", box("infe", "\x02\x00\x00\x00\x00\x01\x00\x00hvc1\x00")),
+		box("iprp",
+			box("ipco",
+				box("ispe", full, "\x00\x00\x00\x40\x00\x00\x00\x30"),
+				box("colr", "prof", string(icc.DisplayP3Data)),
+				box("irot", "\x01")),
+			box("ipma", full, "\x00\x00\x00\x01\x00\x01\x03\x81\x82\x03")))
+	name := filepath.Join(dir, "test.heic")
+	if err := ioutil.WriteFile(name, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got := runOutput(t, "info", name)
+	for _, w := range []string{"Format:      heif", "Dimensions:  64x48", "Brand:       heic", "Coding:      hvc1",
+		"Orientation: 8 (rotated 90° counterclockwise)", `ICC profile: "Display P3"`} {
+		if !strings.Contains(got, w) {
+			t.Errorf("output doesn't contain %q:\n%s", w, got)
+		}
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := writeTestJPEG(t, dir, 6)
+	out := filepath.Join(dir, "out.jpg")
+	runOutput(t, "strip", "-icc=false", writeTestJPEG(t, dir, 1), out)
+
+	for _, args := range [][]string{
+		nil,
+		{"resize", in},
+		{"info"},
+		{"info", "-x", in},
+		{"info", filepath.Join(dir, "missing.jpg")},
+		{"convert", "-subsampling", "411", in, out},
+		{"rotate", "-angle", "45", in, out},
+		{"strip", in, out},
+		{"extract-icc", out, filepath.Join(dir, "profile.icc")},
+	} {
+		if err := run(args, ioutil.Discard, ioutil.Discard); err == nil {
+			t.Errorf("img %s succeeded", strings.Join(args, " "))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "profile.icc")); !os.IsNotExist(err) {
+		t.Error("failed command left its output behind")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode"
+
+	"github.com/snapas/img/iccjpeg"
+	"github.com/snapas/img/jpegfile"
+)
+
+func segments(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("segments", flag.ContinueOnError)
+	args, err := parseFlags(fs, args, stderr, 1, "FILE")
+	if err != nil {
+		return err
+	}
+	b, err := readFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "%10s  %-6s %6s\n", "OFFSET", "MARKER", "LENGTH")
+	p := iccjpeg.NewParser(bytes.NewReader(b))
+	for {
+		s, err := p.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("segments: %s", err)
+		}
+		// Offset is where the data starts, after the marker and any length.
+		m := jpegfile.Marker(s.MarkerID)
+		start, length := s.Offset-2, ""
+		if m.HasLength() {
+			start -= 2
+			length = fmt.Sprint(2 + s.Size)
+		}
+		line := fmt.Sprintf("%10d  %-6s %6s  %s", start, m, length, hint(m, s.Data))
+		fmt.Fprintln(stdout, strings.TrimRight(line, " "))
+		if m == jpegfile.EOI {
+			break
+		}
+	}
+	if n, _ := io.Copy(ioutil.Discard, p); n > 0 {
+		fmt.Fprintf(stdout, "%10d  %-6s %6d  data after EOI\n", len(b)-int(n), "", n)
+	}
+	return nil
+}
+
+// hint returns a short description of a segment with the marker and data, such as the identifier that APPn segments
+// start with.
+func hint(m jpegfile.Marker, data []byte) string {
+	switch {
+	case jpegfile.APP0 <= m && m <= jpegfile.APP15:
+		id := data
+		if k := bytes.IndexByte(id, 0); k >= 0 {
+			id = id[:k]
+		}
+		if len(id) > 32 {
+			id = id[:32]
+		}
+		for _, r := range string(id) {
+			if !unicode.IsPrint(r) {
+				return ""
+			}
+		}
+		return string(id)
+	case m == jpegfile.COM:
+		return fmt.Sprintf("%.40q", data)
+	case m == jpegfile.SOS && len(data) > 0:
+		return fmt.Sprintf("%d components", data[0])
+	case isSOF(m) && len(data) >= 6:
+		return fmt.Sprintf("%dx%d, %d components", int(data[3])<<8|int(data[4]), int(data[1])<<8|int(data[2]), data[5])
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+package img
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/snapas/img/iccjpeg"
+	"github.com/snapas/img/jpeg"
+)
+
+// ErrNoThumbnail is returned by EmbeddedThumbnail for images without an embedded thumbnail.
+var ErrNoThumbnail = errors.New("no embedded thumbnail")
+
+// jfxxHeader precedes JFIF extension data in APP0 segments.
+const jfxxHeader = "JFXX\x00"
+
+// JFIF extension codes of thumbnails.
+const (
+	jfxxJPEG    = 0x10
+	jfxxPalette = 0x11
+	jfxxRGB     = 0x13
+)
+
+// EmbeddedThumbnail returns the small preview embedded in the metadata of the JPEG in r, which is a lot quicker to get
+// than decoding the image itself. The thumbnail in EXIF IFD1 is preferred, and the one in a JFXX APP0 segment used
+// otherwise. Only the metadata segments are read from r, stopping as soon as the EXIF segment has been seen. The
+// thumbnail is turned upright according to the image's EXIF orientation, like Decode does with the image. The returned
+// Image has no metadata of its own. ErrNoThumbnail is returned if the image has no thumbnail.
+func EmbeddedThumbnail(r io.Reader) (Image, error) {
+	i := Image{
+		buf: &bytes.Buffer{},
+	}
+	p := iccjpeg.NewParser(r)
+	if err := p.ReadSOI(); err != nil {
+		return i, fmt.Errorf("ReadSOI: %s", err)
+	}
+	var jfxx []byte
+	var exif []byte
+	for exif == nil {
+		s, err := p.ReadMetadataSegment()
+		if err != nil {
+			return i, fmt.Errorf("ReadMetadataSegment: %s", err)
+		}
+		if s == nil {
+			break
+		}
+		switch {
+		case s.MarkerID == 0xe0 && jfxx == nil && bytes.HasPrefix(s.Data, []byte(jfxxHeader)):
+			jfxx = s.Data[len(jfxxHeader):]
+		case s.MarkerID == 0xe1 && bytes.HasPrefix(s.Data, []byte(exifHeader)):
+			exif = s.Data[len(exifHeader):]
+		}
+	}
+
+	var m image.Image
+	if t := exifThumbnail(exif); t != nil {
+		var err error
+		if m, err = jpeg.Decode(bytes.NewReader(t)); err != nil {
+			return i, fmt.Errorf("jpeg.Decode: %s", err)
+		}
+	} else if jfxx != nil {
+		var err error
+		if m, err = jfxxThumbnail(jfxx); err != nil {
+			return i, err
+		}
+	} else {
+		return i, ErrNoThumbnail
+	}
+	i.Image = orient(m, Orientation(exif))
+	return i, nil
+}
+
+// jfxxThumbnail decodes the thumbnail in JFIF extension data, without its "JFXX\x00" header.
+func jfxxThumbnail(b []byte) (image.Image, error) {
+	if len(b) < 1 {
+		return nil, ErrNoThumbnail
+	}
+	code, b := b[0], b[1:]
+	if code == jfxxJPEG {
+		m, err := jpeg.Decode(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("jpeg.Decode: %s", err)
+		}
+		return m, nil
+	}
+	if code != jfxxPalette && code != jfxxRGB || len(b) < 2 {
+		return nil, ErrNoThumbnail
+	}
+	w, h, b := int(b[0]), int(b[1]), b[2:]
+	if w == 0 || h == 0 {
+		return nil, ErrNoThumbnail
+	}
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	if code == jfxxPalette {
+		if len(b) < 768+w*h {
+			return nil, errors.New("jfxxThumbnail: short palette thumbnail")
+		}
+		palette, pix := b[:768], b[768:]
+		for j, v := range pix[:w*h] {
+			c := palette[3*int(v):]
+			m.SetRGBA(j%w, j/w, color.RGBA{c[0], c[1], c[2], 0xff})
+		}
+		return m, nil
+	}
+	if len(b) < 3*w*h {
+		return nil, errors.New("jfxxThumbnail: short RGB thumbnail")
+	}
+	for j := 0; j < w*h; j++ {
+		m.SetRGBA(j%w, j/w, color.RGBA{b[3*j], b[3*j+1], b[3*j+2], 0xff})
+	}
+	return m, nil
+}
@@ -0,0 +1,140 @@
+package img
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/snapas/img/jpeg"
+)
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestEmbeddedThumbnail(t *testing.T) {
+	for _, name := range []string{"gopro.jpg", "holden-3-noicc.jpg"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := ioutil.ReadFile("testdata/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &countingReader{r: bytes.NewReader(data)}
+			thumb, err := EmbeddedThumbnail(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.n > len(data)/4 {
+				t.Errorf("read %d of %d bytes", r.n, len(data))
+			}
+
+			// The thumbnail looks like the upright image.
+			full, _, err := Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tb, fb := thumb.Image.Bounds(), full.Image.Bounds()
+			if (tb.Dx() > tb.Dy()) != (fb.Dx() > fb.Dy()) {
+				t.Fatalf("thumbnail is %v, image is %v", tb, fb)
+			}
+			// Thumbnails may be padded to a fixed aspect ratio, so only their middles are compared.
+			want, err := Thumbnail(full, tb.Dx(), tb.Dy(), Fill)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mid := image.Rect(tb.Dx()/4, tb.Dy()/4, tb.Dx()*3/4, tb.Dy()*3/4)
+			if d := averageDiff(crop(thumb.Image, mid), crop(want.Image, mid)); d > 16 {
+				t.Errorf("average difference %.1f from the image", d)
+			}
+		})
+	}
+}
+
+// jfxxJPEGFile returns the start of a JPEG with a JFXX APP0 segment holding the given extension data, and an EXIF
+// segment with the given orientation and no thumbnail.
+func jfxxJPEGFile(ext []byte, orientation uint16) []byte {
+	b := []byte{0xff, 0xd8}
+	seg := func(marker byte, data []byte) {
+		b = append(b, 0xff, marker, byte((len(data)+2)>>8), byte(len(data)+2))
+		b = append(b, data...)
+	}
+	seg(0xe0, append([]byte(jfxxHeader), ext...))
+	if orientation != 0 {
+		seg(0xe1, append([]byte(exifHeader), testExif(orientation, 2, 1)...))
+	}
+	return append(b, 0xff, 0xda)
+}
+
+func TestEmbeddedThumbnailJFXX(t *testing.T) {
+	// A 2x1 thumbnail, red on the left and blue on the right.
+	red, blue := color.RGBA{0xff, 0, 0, 0xff}, color.RGBA{0, 0, 0xff, 0xff}
+	rgb := []byte{jfxxRGB, 2, 1, 0xff, 0, 0, 0, 0, 0xff}
+	palette := make([]byte, 3+768+2)
+	copy(palette, []byte{jfxxPalette, 2, 1})
+	copy(palette[3:], []byte{0xff, 0, 0, 0, 0, 0xff})
+	palette[3+768], palette[3+768+1] = 0, 1
+
+	src := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 8; y++ {
+			if x < 8 {
+				src.SetRGBA(x, y, red)
+			} else {
+				src.SetRGBA(x, y, blue)
+			}
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, src, &jpeg.Options{Quality: 100}, nil); err != nil {
+		t.Fatal(err)
+	}
+	jpg := append([]byte{jfxxJPEG}, buf.Bytes()...)
+
+	testCases := []struct {
+		name        string
+		ext         []byte
+		orientation uint16
+		left, right color.RGBA
+	}{
+		{"rgb", rgb, 0, red, blue},
+		{"palette", palette, 0, red, blue},
+		{"jpeg", jpg, 0, red, blue},
+		{"rgb rotated", rgb, 3, blue, red},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			thumb, err := EmbeddedThumbnail(bytes.NewReader(jfxxJPEGFile(tc.ext, tc.orientation)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := thumb.Image.Bounds()
+			if b.Dx() != 2*b.Dy() {
+				t.Fatalf("got bounds %v", b)
+			}
+			for _, p := range []struct {
+				x    int
+				want color.RGBA
+			}{{b.Min.X, tc.left}, {b.Max.X - 1, tc.right}} {
+				got := color.RGBAModel.Convert(thumb.Image.At(p.x, b.Min.Y)).(color.RGBA)
+				if diff(got.R, p.want.R) > 8 || diff(got.G, p.want.G) > 8 || diff(got.B, p.want.B) > 8 {
+					t.Errorf("pixel %d = %v, want %v", p.x, got, p.want)
+				}
+			}
+		})
+	}
+
+	if _, err := EmbeddedThumbnail(bytes.NewReader(jfxxJPEGFile(nil, 1))); err != ErrNoThumbnail {
+		t.Errorf("got error %v, want ErrNoThumbnail", err)
+	}
+}
@@ -0,0 +1,161 @@
+package img
+
+import (
+	"encoding/binary"
+)
+
+// EXIF tags that describe an image's pixels, and so change when they are transformed.
+const (
+	tagImageWidth      = 0x0100
+	tagImageLength     = 0x0101
+	tagOrientation     = 0x0112
+	tagExifIFD         = 0x8769
+	tagPixelXDimension = 0xa002
+	tagPixelYDimension = 0xa003
+)
+
+// TIFF field types.
+const (
+	typeShort = 3
+	typeLong  = 4
+)
+
+// tiffHeader parses the header of TIFF-structured EXIF data, returning its byte order and the offset of IFD0.
+func tiffHeader(b []byte) (binary.ByteOrder, uint32, bool) {
+	if len(b) < 8 {
+		return nil, 0, false
+	}
+	var order binary.ByteOrder
+	switch string(b[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, 0, false
+	}
+	return order, order.Uint32(b[4:]), true
+}
+
+// ifdEntries returns the 12-byte entries of the IFD at off in b, and the offset of the next IFD.
+func ifdEntries(b []byte, order binary.ByteOrder, off uint32) ([][]byte, uint32, bool) {
+	if uint64(off)+2 > uint64(len(b)) {
+		return nil, 0, false
+	}
+	n := uint64(order.Uint16(b[off:]))
+	end := uint64(off) + 2 + 12*n
+	if end+4 > uint64(len(b)) {
+		return nil, 0, false
+	}
+	entries := make([][]byte, n)
+	for i := range entries {
+		e := uint64(off) + 2 + 12*uint64(i)
+		entries[i] = b[e : e+12]
+	}
+	return entries, order.Uint32(b[end:]), true
+}
+
+// setExifTags returns a copy of the TIFF-structured EXIF data in b with the given tags of IFD0 and the Exif IFD set
+// to new values. Only single-valued SHORT and LONG tags that are already present are changed, as adding tags would
+// mean moving data around. The tags of IFD1, which describe the thumbnail, are left alone. b is returned unchanged if
+// it can't be parsed.
+func setExifTags(b []byte, tags map[uint16]uint32) []byte {
+	order, ifd0, ok := tiffHeader(b)
+	if !ok {
+		return b
+	}
+	b = append([]byte(nil), b...)
+	set := func(off uint32) (exifIFD uint32) {
+		entries, _, _ := ifdEntries(b, order, off)
+		for _, e := range entries {
+			tag := order.Uint16(e)
+			if tag == tagExifIFD {
+				exifIFD = order.Uint32(e[8:])
+			}
+			v, ok := tags[tag]
+			if !ok || order.Uint32(e[4:]) != 1 {
+				continue
+			}
+			switch order.Uint16(e[2:]) {
+			case typeShort:
+				if v <= 0xffff {
+					order.PutUint16(e[8:], uint16(v))
+				}
+			case typeLong:
+				order.PutUint32(e[8:], v)
+			}
+		}
+		return exifIFD
+	}
+	if exifIFD := set(ifd0); exifIFD != 0 && exifIFD != ifd0 {
+		set(exifIFD)
+	}
+	return b
+}
+
+// Orientation returns the orientation tag of IFD0 in the TIFF-structured EXIF data in b, such as an Image's Exif, or 1
+// if it is missing or invalid. Images returned by Decode are already upright, with an orientation of 1.
+func Orientation(b []byte) int {
+	order, ifd0, ok := tiffHeader(b)
+	if !ok {
+		return 1
+	}
+	entries, _, _ := ifdEntries(b, order, ifd0)
+	for _, e := range entries {
+		if order.Uint16(e) == tagOrientation && order.Uint16(e[2:]) == typeShort {
+			if o := int(order.Uint16(e[8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// EXIF tags of IFD1 that locate a JPEG thumbnail.
+const (
+	tagCompression                 = 0x0103
+	tagJPEGInterchangeFormat       = 0x0201
+	tagJPEGInterchangeFormatLength = 0x0202
+)
+
+// compressionJPEG is the Compression tag value of JPEG thumbnails.
+const compressionJPEG = 6
+
+// exifThumbnail returns the JPEG thumbnail that IFD1 of the TIFF-structured EXIF data in b points to, or nil if there
+// isn't one.
+func exifThumbnail(b []byte) []byte {
+	order, ifd0, ok := tiffHeader(b)
+	if !ok {
+		return nil
+	}
+	_, ifd1, ok := ifdEntries(b, order, ifd0)
+	if !ok || ifd1 == 0 {
+		return nil
+	}
+	entries, _, ok := ifdEntries(b, order, ifd1)
+	if !ok {
+		return nil
+	}
+	var off, n uint64
+	for _, e := range entries {
+		v := uint64(order.Uint32(e[8:]))
+		if order.Uint16(e[2:]) == typeShort {
+			v = uint64(order.Uint16(e[8:]))
+		}
+		switch order.Uint16(e) {
+		case tagCompression:
+			if v != compressionJPEG {
+				return nil
+			}
+		case tagJPEGInterchangeFormat:
+			off = v
+		case tagJPEGInterchangeFormatLength:
+			n = v
+		}
+	}
+	if off == 0 || n < 2 || off+n > uint64(len(b)) || b[off] != 0xff || b[off+1] != 0xd8 {
+		return nil
+	}
+	return b[off : off+n]
+}
@@ -0,0 +1,349 @@
+package img
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/snapas/img/jpeg"
+	"github.com/snapas/img/mpf"
+)
+
+// GainMap is an Ultra HDR gain map, which records how much brighter each part of an image is in its HDR rendition
+// than in the SDR image that is displayed by default. Displays with more headroom than SDR combine the two to show
+// highlights at their full brightness.
+type GainMap struct {
+	// Image holds the gain map values, which are gray when the gain is the same for all channels. It covers the whole
+	// primary image, usually at a lower resolution.
+	Image image.Image
+	// Metadata describes how the gain map values are applied.
+	Metadata GainMapMetadata
+}
+
+// GainMapMetadata holds the hdrgm XMP properties of a gain map, as specified by Adobe's gain map specification and
+// Android's Ultra HDR format. Gains are in log2 units, and the arrays hold the values for red, green and blue.
+type GainMapMetadata struct {
+	Version            string
+	GainMapMin         [3]float64
+	GainMapMax         [3]float64
+	Gamma              [3]float64
+	OffsetSDR          [3]float64
+	OffsetHDR          [3]float64
+	HDRCapacityMin     float64
+	HDRCapacityMax     float64
+	BaseRenditionIsHDR bool
+}
+
+// hdrgmNamespace is the XMP namespace of gain map properties.
+const hdrgmNamespace = "http://ns.adobe.com/hdr-gain-map/1.0/"
+
+// parseGainMapMetadata parses the hdrgm properties in the XMP packet of a gain map image, with the defaults of the
+// specification for those that are missing. It reports false if the packet doesn't describe a gain map.
+func parseGainMapMetadata(xmp []byte) (GainMapMetadata, bool) {
+	m := GainMapMetadata{
+		GainMapMax:     [3]float64{1, 1, 1},
+		Gamma:          [3]float64{1, 1, 1},
+		OffsetSDR:      [3]float64{1.0 / 64, 1.0 / 64, 1.0 / 64},
+		OffsetHDR:      [3]float64{1.0 / 64, 1.0 / 64, 1.0 / 64},
+		HDRCapacityMax: 1,
+	}
+	v := xmpProperty(xmp, "hdrgm:Version")
+	if len(v) != 1 {
+		return m, false
+	}
+	m.Version = v[0]
+	// The capacity range is required along with GainMapMax, which only the primary image's packet lacks.
+	if xmpProperty(xmp, "hdrgm:GainMapMax") == nil {
+		return m, false
+	}
+	for name, dst := range map[string]*[3]float64{
+		"hdrgm:GainMapMin": &m.GainMapMin,
+		"hdrgm:GainMapMax": &m.GainMapMax,
+		"hdrgm:Gamma":      &m.Gamma,
+		"hdrgm:OffsetSDR":  &m.OffsetSDR,
+		"hdrgm:OffsetHDR":  &m.OffsetHDR,
+	} {
+		vs := xmpProperty(xmp, name)
+		if len(vs) != 1 && len(vs) != 3 {
+			continue
+		}
+		for c := range dst {
+			f, err := strconv.ParseFloat(vs[c%len(vs)], 64)
+			if err != nil {
+				return m, false
+			}
+			dst[c] = f
+		}
+	}
+	for name, dst := range map[string]*float64{
+		"hdrgm:HDRCapacityMin": &m.HDRCapacityMin,
+		"hdrgm:HDRCapacityMax": &m.HDRCapacityMax,
+	} {
+		if vs := xmpProperty(xmp, name); len(vs) == 1 {
+			f, err := strconv.ParseFloat(vs[0], 64)
+			if err != nil {
+				return m, false
+			}
+			*dst = f
+		}
+	}
+	if vs := xmpProperty(xmp, "hdrgm:BaseRenditionIsHDR"); len(vs) == 1 {
+		m.BaseRenditionIsHDR = strings.EqualFold(vs[0], "true")
+	}
+	return m, true
+}
+
+// xmp returns the XMP packet of a gain map image with the metadata.
+func (m GainMapMetadata) xmp() []byte {
+	version := m.Version
+	if version == "" {
+		version = "1.0"
+	}
+	var attrs, elems strings.Builder
+	attr := func(name, v string) {
+		fmt.Fprintf(&attrs, "\n    hdrgm:%s=\"%s\"", name, v)
+	}
+	attr("Version", version)
+	for _, p := range []struct {
+		name string
+		v    [3]float64
+	}{
+		{"GainMapMin", m.GainMapMin},
+		{"GainMapMax", m.GainMapMax},
+		{"Gamma", m.Gamma},
+		{"OffsetSDR", m.OffsetSDR},
+		{"OffsetHDR", m.OffsetHDR},
+	} {
+		if p.v[0] == p.v[1] && p.v[1] == p.v[2] {
+			attr(p.name, formatFloat(p.v[0]))
+			continue
+		}
+		fmt.Fprintf(&elems, "\n   <hdrgm:%s>\n    <rdf:Seq>", p.name)
+		for _, v := range p.v {
+			fmt.Fprintf(&elems, "\n     <rdf:li>%s</rdf:li>", formatFloat(v))
+		}
+		fmt.Fprintf(&elems, "\n    </rdf:Seq>\n   </hdrgm:%s>", p.name)
+	}
+	attr("HDRCapacityMin", formatFloat(m.HDRCapacityMin))
+	attr("HDRCapacityMax", formatFloat(m.HDRCapacityMax))
+	if m.BaseRenditionIsHDR {
+		attr("BaseRenditionIsHDR", "True")
+	} else {
+		attr("BaseRenditionIsHDR", "False")
+	}
+	return []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:hdrgm="` + hdrgmNamespace + `"` + attrs.String() + `>` + elems.String() + `
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// readGainMap looks for an Ultra HDR gain map among the secondary images of i, and moves it to i.GainMap, turning it
+// according to the EXIF orientation o of the primary image. The MP index is dropped if the gain map was its only
+// secondary image, as Encode writes a new one for gain maps.
+func readGainMap(i *Image, o int) {
+	for k, b := range i.Secondary {
+		var g Image
+		if _, err := readMetadata(&g, bytes.NewReader(b)); err != nil {
+			continue
+		}
+		meta, ok := parseGainMapMetadata(g.XMP)
+		if !ok {
+			continue
+		}
+		m, err := jpeg.Decode(bytes.NewReader(b))
+		if err != nil {
+			continue
+		}
+		i.GainMap = &GainMap{Image: orient(m, o), Metadata: meta}
+		i.Secondary = append(i.Secondary[:k:k], i.Secondary[k+1:]...)
+		entries := append([]mpf.Entry(nil), i.MPF.Entries[:k+1]...)
+		i.MPF.Entries = append(entries, i.MPF.Entries[k+2:]...)
+		if i.MPF.UIDs != nil {
+			uids := append([][]byte(nil), i.MPF.UIDs[:k+1]...)
+			i.MPF.UIDs = append(uids, i.MPF.UIDs[k+2:]...)
+		}
+		if len(i.Secondary) == 0 {
+			i.MPF, i.Secondary = nil, nil
+		}
+		return
+	}
+}
+
+// resize returns the gain map of an image with bounds b that is resized to w by h pixels, which keeps covering the
+// whole image.
+func (g *GainMap) resize(b image.Rectangle, w, h int, f Filter) *GainMap {
+	gb := g.Image.Bounds()
+	gw := int(math.Max(1, math.Round(float64(gb.Dx())*float64(w)/float64(b.Dx()))))
+	gh := int(math.Max(1, math.Round(float64(gb.Dy())*float64(h)/float64(b.Dy()))))
+	if gb.Size() == image.Pt(gw, gh) {
+		return g
+	}
+	return &GainMap{Image: resample(g.Image, gw, gh, f, true), Metadata: g.Metadata}
+}
+
+// crop returns the part of the gain map of an image with bounds b that covers r.
+func (g *GainMap) crop(b, r image.Rectangle) *GainMap {
+	gb := g.Image.Bounds()
+	scale := func(v, from, to, min, gmin int) int {
+		return gmin + int(math.Round(float64(v-min)*float64(to)/float64(from)))
+	}
+	gr := image.Rect(
+		scale(r.Min.X, b.Dx(), gb.Dx(), b.Min.X, gb.Min.X),
+		scale(r.Min.Y, b.Dy(), gb.Dy(), b.Min.Y, gb.Min.Y),
+		scale(r.Max.X, b.Dx(), gb.Dx(), b.Min.X, gb.Min.X),
+		scale(r.Max.Y, b.Dy(), gb.Dy(), b.Min.Y, gb.Min.Y),
+	)
+	// Keep at least one gain map pixel.
+	if gr.Dx() == 0 {
+		gr.Max.X = gr.Min.X + 1
+	}
+	if gr.Dy() == 0 {
+		gr.Max.Y = gr.Min.Y + 1
+	}
+	return &GainMap{Image: crop(g.Image, gr.Intersect(gb)), Metadata: g.Metadata}
+}
+
+// encode returns the gain map as a JPEG image, with its metadata.
+func (g *GainMap) encode(o *jpeg.Options) ([]byte, error) {
+	gopts := &jpeg.Options{Quality: jpeg.DefaultQuality}
+	if o != nil {
+		gopts.Quality = o.Quality
+	}
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, g.Image, gopts, &jpeg.Meta{XMP: g.Metadata.xmp()}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ToneMap returns the HDR rendition of i, which must have a gain map, tone-mapped into an SDR image. Highlights that
+// the SDR image clips keep their detail, compressed into the top of the SDR range, which makes for a better SDR image
+// on displays and in apps that don't support gain maps. The result has no gain map. The image is assumed to use the
+// sRGB tone curve, as its ICC profile isn't applied.
+func ToneMap(i Image) (Image, error) {
+	if i.GainMap == nil {
+		return i, fmt.Errorf("ToneMap: image has no gain map")
+	}
+	meta := i.GainMap.Metadata
+	if meta.BaseRenditionIsHDR {
+		return i, fmt.Errorf("ToneMap: HDR base renditions are unsupported")
+	}
+	b := i.Image.Bounds()
+	gm := newGainSampler(i.GainMap.Image, b)
+	peak := math.Exp2(meta.HDRCapacityMax)
+	if peak < 1 {
+		peak = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	src := newLinearReader(i.Image, false)
+	parallel(b.Dy(), func(lo, hi int) {
+		row := make([]float32, 4*b.Dx())
+		gain := make([]float64, 3)
+		for y := lo; y < hi; y++ {
+			if src.channels == 1 {
+				// Gray images are read with one channel, so expand them to RGBA.
+				src.read(row[:b.Dx()], b.Min.Y+y)
+				for x := b.Dx() - 1; x >= 0; x-- {
+					v := row[x]
+					row[4*x], row[4*x+1], row[4*x+2], row[4*x+3] = v, v, v, 1
+				}
+			} else {
+				src.read(row, b.Min.Y+y)
+			}
+			pix := dst.Pix[y*dst.Stride:]
+			for x := 0; x < b.Dx(); x++ {
+				gm.at(x, y, gain)
+				var hdr [3]float64
+				for c := 0; c < 3; c++ {
+					g := math.Pow(gain[c], 1/meta.Gamma[c])
+					logBoost := meta.GainMapMin[c]*(1-g) + meta.GainMapMax[c]*g
+					hdr[c] = (float64(row[4*x+c])+meta.OffsetSDR[c])*math.Exp2(logBoost) - meta.OffsetHDR[c]
+				}
+				// Extended Reinhard on the luminance, which maps peak to 1 and keeps the hue.
+				l := 0.2126*hdr[0] + 0.7152*hdr[1] + 0.0722*hdr[2]
+				scale := 1.0
+				if l > 0 {
+					scale = (1 + l/(peak*peak)) / (1 + l)
+				}
+				for c := 0; c < 3; c++ {
+					pix[4*x+c] = encodeLinear(float32(hdr[c] * scale))
+				}
+				pix[4*x+3] = 0xff
+			}
+		}
+	})
+	i.Image = dst
+	i.GainMap = nil
+	fixMetadata(&i)
+	return i, nil
+}
+
+// gainSampler interpolates the values of a gain map at the pixels of the image that it covers.
+type gainSampler struct {
+	m      image.Image
+	b      image.Rectangle
+	sx, sy float64
+}
+
+func newGainSampler(m image.Image, b image.Rectangle) *gainSampler {
+	gb := m.Bounds()
+	return &gainSampler{m, gb, float64(gb.Dx()) / float64(b.Dx()), float64(gb.Dy()) / float64(b.Dy())}
+}
+
+// at sets gain to the gain map values at the image pixel (x, y), relative to the image bounds, in [0, 1].
+func (s *gainSampler) at(x, y int, gain []float64) {
+	fx := (float64(x)+0.5)*s.sx - 0.5
+	fy := (float64(y)+0.5)*s.sy - 0.5
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+	for c := range gain {
+		gain[c] = 0
+	}
+	for _, p := range [4]struct {
+		x, y int
+		w    float64
+	}{
+		{x0, y0, (1 - tx) * (1 - ty)},
+		{x0 + 1, y0, tx * (1 - ty)},
+		{x0, y0 + 1, (1 - tx) * ty},
+		{x0 + 1, y0 + 1, tx * ty},
+	} {
+		v := s.value(p.x, p.y)
+		for c := range gain {
+			gain[c] += v[c] * p.w
+		}
+	}
+}
+
+// value returns the gain map values at (x, y) relative to its bounds, clamped to the edges.
+func (s *gainSampler) value(x, y int) [3]float64 {
+	if x < 0 {
+		x = 0
+	} else if x >= s.b.Dx() {
+		x = s.b.Dx() - 1
+	}
+	if y < 0 {
+		y = 0
+	} else if y >= s.b.Dy() {
+		y = s.b.Dy() - 1
+	}
+	x, y = x+s.b.Min.X, y+s.b.Min.Y
+	if g, ok := s.m.(*image.Gray); ok {
+		v := float64(g.GrayAt(x, y).Y) / 255
+		return [3]float64{v, v, v}
+	}
+	c := color.NRGBAModel.Convert(s.m.At(x, y)).(color.NRGBA)
+	return [3]float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255}
+}
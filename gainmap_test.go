@@ -0,0 +1,180 @@
+package img
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func testGainMap(w, h int) *GainMap {
+	m := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m.SetGray(x, y, color.Gray{uint8(255 * x / (w - 1))})
+		}
+	}
+	return &GainMap{
+		Image: m,
+		Metadata: GainMapMetadata{
+			Version:        "1.0",
+			GainMapMax:     [3]float64{2, 2.5, 3},
+			Gamma:          [3]float64{1, 1, 1},
+			OffsetSDR:      [3]float64{0.015625, 0.015625, 0.015625},
+			OffsetHDR:      [3]float64{0.015625, 0.015625, 0.015625},
+			HDRCapacityMax: 3,
+		},
+	}
+}
+
+func TestGainMap(t *testing.T) {
+	f, err := os.Open("testdata/gopro.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	i, _, err := Decode(f)
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if i.GainMap != nil {
+		t.Fatal("found a gain map in an SDR image")
+	}
+	thumb := i.Secondary[0]
+	if i, err = Resize(i, 400, 0, Box); err != nil {
+		t.Fatal(err)
+	}
+	i.GainMap = testGainMap(100, 75)
+
+	buf := &bytes.Buffer{}
+	if err := Encode(buf, i, nil); err != nil {
+		t.Fatal("Encode failed:", err)
+	}
+	j, _, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if j.GainMap == nil {
+		t.Fatal("gain map was lost")
+	}
+	if j.GainMap.Metadata != i.GainMap.Metadata {
+		t.Errorf("got gain map metadata %+v, want %+v", j.GainMap.Metadata, i.GainMap.Metadata)
+	}
+	if got := j.GainMap.Image.Bounds(); got != image.Rect(0, 0, 100, 75) {
+		t.Errorf("gain map bounds are %v", got)
+	}
+	if d := averageDiff(j.GainMap.Image, i.GainMap.Image); d > 2 {
+		t.Errorf("gain map differs by %.1f on average", d)
+	}
+	if j.MPF == nil || len(j.Secondary) != 1 || !bytes.Equal(j.Secondary[0], thumb) {
+		t.Fatal("secondary image was lost")
+	}
+
+	// The primary image lists the gain map, which is the first secondary image.
+	if v := xmpProperty(j.XMP, "hdrgm:Version"); len(v) != 1 || v[0] != "1.0" {
+		t.Errorf("primary hdrgm:Version is %q", v)
+	}
+	m := regexp.MustCompile(`Item:Length="(\d+)"`).FindSubmatch(j.XMP)
+	if m == nil {
+		t.Fatal("gain map length is missing")
+	}
+	l, _ := strconv.Atoi(string(m[1]))
+	if int(j.MPF.Entries[0].Size)+l+len(thumb) != buf.Len() {
+		t.Errorf("gain map length is %d in a %d-byte file with a %d-byte primary image", l, buf.Len(), j.MPF.Entries[0].Size)
+	}
+
+	// Transformations keep the gain map covering the whole image.
+	k, err := Resize(j, 200, 0, Lanczos3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := k.GainMap.Image.Bounds().Size(); got != image.Pt(50, 38) {
+		t.Errorf("resized gain map is %v", got)
+	}
+	k, err = Thumbnail(j, 100, 100, Fill)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := k.GainMap.Image.Bounds().Size(); got != image.Pt(25, 25) {
+		t.Errorf("cropped gain map is %v", got)
+	}
+	// The 4:3 image loses an eighth of its width on each side, along with the ends of the gain map gradient.
+	gm := k.GainMap.Image.(*image.Gray)
+	if l, r := gm.Pix[gm.PixOffset(0, 12)], gm.Pix[gm.PixOffset(24, 12)]; diff(l, 32) > 8 || diff(r, 223) > 8 {
+		t.Errorf("cropped gain map goes from %d to %d", l, r)
+	}
+}
+
+func TestToneMap(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 8, 8))
+	for k := range m.Pix {
+		m.Pix[k] = 0xf0
+	}
+	i := Image{Image: m}
+	if _, err := ToneMap(i); err == nil {
+		t.Fatal("tone-mapped an image without a gain map")
+	}
+
+	// The right of the gain map boosts the highlights, blue the most, which end up brighter than on the left.
+	i.GainMap = testGainMap(4, 4)
+	tm, err := ToneMap(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	left, right := tm.Image.At(0, 4).(color.RGBA), tm.Image.At(7, 4).(color.RGBA)
+	if left.R >= right.R || left.G >= right.G || left.B >= right.B || right.R >= right.B {
+		t.Errorf("tone-mapped pixels go from %v to %v", left, right)
+	}
+	if tm.GainMap != nil {
+		t.Error("tone-mapped image kept its gain map")
+	}
+}
+
+func TestXMPProperty(t *testing.T) {
+	xmp := []byte(testGainMap(2, 2).Metadata.xmp())
+	for _, c := range []struct {
+		name string
+		want []string
+	}{
+		{"hdrgm:Version", []string{"1.0"}},
+		{"hdrgm:GainMapMin", []string{"0"}},
+		{"hdrgm:GainMapMax", []string{"2", "2.5", "3"}},
+		{"hdrgm:BaseRenditionIsHDR", []string{"False"}},
+		{"hdrgm:Missing", nil},
+	} {
+		got := xmpProperty(xmp, c.name)
+		if len(got) != len(c.want) {
+			t.Errorf("%s is %q, want %q", c.name, got, c.want)
+			continue
+		}
+		for k := range got {
+			if got[k] != c.want[k] {
+				t.Errorf("%s is %q, want %q", c.name, got, c.want)
+			}
+		}
+	}
+	if _, ok := parseGainMapMetadata(xmp); !ok {
+		t.Error("gain map metadata wasn't recognized")
+	}
+}
+
+func TestSetGainMapDirectory(t *testing.T) {
+	for _, xmp := range []string{
+		"",
+		`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+			`<rdf:Description rdf:about="" tiff:Orientation="1"/></rdf:RDF></x:xmpmeta>`,
+		`<rdf:RDF><Container:Item Item:Semantic="GainMap" Item:Mime="image/jpeg"/></rdf:RDF>`,
+		`<rdf:RDF><Container:Item Item:Semantic="GainMap" Item:Length="12"/></rdf:RDF>`,
+	} {
+		got := setGainMapDirectory([]byte(xmp), 1234)
+		if n := bytes.Count(got, []byte(`Item:Length="1234"`)); n != 1 {
+			t.Errorf("%q has %d gain map lengths", got, n)
+		}
+		if bytes.Contains(got, []byte(`"12"`)) {
+			t.Errorf("%q has the old length", got)
+		}
+	}
+}
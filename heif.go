@@ -0,0 +1,46 @@
+package img
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/snapas/img/heif"
+	"github.com/snapas/img/iccjpeg"
+)
+
+// ErrHEIFCoding is returned by Decode for HEIF files whose primary image is coded in HEVC, AV1 or any other format
+// than JPEG, which can't be decoded. The Image returned with it holds their metadata, but no image.
+var ErrHEIFCoding = errors.New("img: can't decode the image coding of HEIF file")
+
+// decodeHEIF decodes the HEIF file b into i, with its ICC profile stored in i.App2 as it would be stored in a JPEG.
+// Its image is turned upright according to its rotation and mirroring properties, which take precedence over its EXIF
+// orientation.
+func decodeHEIF(i Image, b []byte) (Image, string, error) {
+	m, err := heif.ReadMeta(bytes.NewReader(b))
+	if err != nil {
+		return i, "", fmt.Errorf("heif.ReadMeta: %s", err)
+	}
+	format := "heif"
+	if m.Brand == "avif" || m.Brand == "avis" {
+		format = "avif"
+	}
+	if m.ICCProfile != nil {
+		if app2 := iccjpeg.App2Data(m.ICCProfile); len(app2) <= maxApp2Len {
+			i.App2 = app2
+		}
+	}
+	i.Exif = m.Exif
+	i.XMP = m.XMP
+	if m.JPEG == nil {
+		return i, format, ErrHEIFCoding
+	}
+	j, _, err := image.Decode(bytes.NewReader(m.JPEG))
+	if err != nil {
+		return i, "", fmt.Errorf("image.Decode: %s", err)
+	}
+	i.Image = orient(j, m.Orientation())
+	fixMetadata(&i)
+	return i, format, nil
+}
@@ -0,0 +1,100 @@
+package heif
+
+import "encoding/binary"
+
+// box is an ISOBMFF box: a 32-bit size, a four-character type and the data that follows them. The data of full boxes
+// starts with their version and flags.
+type box struct {
+	typ  string
+	data []byte
+}
+
+// boxes splits b into the boxes it holds. A size of 0 makes a box extend to the end of b, and a size of 1 means that a
+// 64-bit size follows the type.
+func boxes(b []byte) ([]box, error) {
+	var bs []box
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, FormatError("short box header")
+		}
+		size, typ, header := uint64(binary.BigEndian.Uint32(b)), string(b[4:8]), uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return nil, FormatError("short box header")
+			}
+			size, header = binary.BigEndian.Uint64(b[8:]), 16
+		}
+		if size < header || size > uint64(len(b)) {
+			return nil, FormatError("bad size of " + typ + " box")
+		}
+		bs = append(bs, box{typ, b[header:size]})
+		b = b[size:]
+	}
+	return bs, nil
+}
+
+// find returns the first box of the given type in bs, or nil.
+func find(bs []box, typ string) *box {
+	for k := range bs {
+		if bs[k].typ == typ {
+			return &bs[k]
+		}
+	}
+	return nil
+}
+
+// reader reads the fields of a box. Reading past the end of the data sets err and returns zeros.
+type reader struct {
+	b   []byte
+	err error
+}
+
+// uint reads an n-byte big-endian integer, where n is at most 8.
+func (r *reader) uint(n int) uint64 {
+	if r.err != nil || n > len(r.b) {
+		r.err = FormatError("short box")
+		return 0
+	}
+	v := uint64(0)
+	for _, c := range r.b[:n] {
+		v = v<<8 | uint64(c)
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) fourCC() string {
+	if r.err != nil || len(r.b) < 4 {
+		r.err = FormatError("short box")
+		return ""
+	}
+	s := string(r.b[:4])
+	r.b = r.b[4:]
+	return s
+}
+
+// string reads a null-terminated string. A missing terminator ends the string at the end of the data.
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	for k, c := range r.b {
+		if c == 0 {
+			s := string(r.b[:k])
+			r.b = r.b[k+1:]
+			return s
+		}
+	}
+	s := string(r.b)
+	r.b = nil
+	return s
+}
+
+// fullBox reads the version and flags at the start of a full box.
+func (r *reader) fullBox() (version uint8, flags uint32) {
+	v := r.uint(4)
+	return uint8(v >> 24), uint32(v & 0xffffff)
+}
@@ -0,0 +1,555 @@
+// Package heif reads the metadata of HEIF files (ISO/IEC 23008-12), such as the HEIC photos of phones and AVIF
+// images, without decoding their HEVC or AV1 coded images.
+//
+// HEIF files are ISOBMFF files, made of nested boxes. The meta box at the top level lists the items of the file in its
+// iinf box, such as coded images, EXIF and XMP metadata, and locates their data in the file with its iloc box. Its iprp
+// box associates properties with the items, such as the size, color profile, rotation and mirroring of images, and its
+// iref box holds the references between items, such as from metadata and thumbnails to the images they describe. One
+// of the images is the primary image, named by the pitm box.
+package heif
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+// Mirror is the axis that an image is mirrored about.
+type Mirror uint8
+
+// Mirror axes, as stored in imir boxes with 1 added.
+const (
+	NoMirror Mirror = iota
+	// MirrorVertical mirrors an image about its vertical axis, swapping left and right.
+	MirrorVertical
+	// MirrorHorizontal mirrors an image about its horizontal axis, swapping top and bottom.
+	MirrorHorizontal
+)
+
+// Meta is the metadata of the primary image of a HEIF file. Fields are nil or zero for metadata that the file doesn't
+// have.
+type Meta struct {
+	// Brand is the major brand of the file, such as "heic", "mif1" or "avif".
+	Brand string
+	// Type is the item type of the primary image, such as "hvc1" for HEVC, "av01" for AV1, "jpeg", or "grid" for
+	// images that are made of tiles.
+	Type string
+	// Width and Height are the size of the primary image as it is coded, before it is rotated and mirrored.
+	Width, Height int
+	// Rotation is how far the image is rotated counterclockwise to display it: 0, 90, 180 or 270 degrees.
+	Rotation int
+	// Mirror is the axis that the image is mirrored about to display it.
+	Mirror Mirror
+	// ICCProfile is the ICC profile of the colr property of the image.
+	ICCProfile []byte
+	// Exif is the TIFF structured EXIF data of the Exif item that describes the image.
+	Exif []byte
+	// XMP is the XMP packet of the XMP item that describes the image.
+	XMP []byte
+	// JPEG holds the JPEG data of the image, for the files whose primary image is coded as a JPEG.
+	JPEG []byte
+	// Thumbnail holds the JPEG data of a thumbnail of the image, if it has one coded as a JPEG.
+	Thumbnail []byte
+
+	// mirrorFirst is whether the image is mirrored before it is rotated.
+	mirrorFirst bool
+}
+
+// orientations maps the matrices [a b; c d] that transform the coordinates of coded images, with y pointing down,
+// into those of displayed images to their EXIF orientation.
+var orientations = map[[4]int]int{
+	{1, 0, 0, 1}:   1,
+	{-1, 0, 0, 1}:  2,
+	{-1, 0, 0, -1}: 3,
+	{1, 0, 0, -1}:  4,
+	{0, 1, 1, 0}:   5,
+	{0, -1, 1, 0}:  6,
+	{0, -1, -1, 0}: 7,
+	{0, 1, -1, 0}:  8,
+}
+
+// Orientation returns the EXIF orientation, from 1 to 8, that describes the rotation and mirroring of the image.
+func (m *Meta) Orientation() int {
+	mul := func(x, y [4]int) [4]int {
+		return [4]int{x[0]*y[0] + x[1]*y[2], x[0]*y[1] + x[1]*y[3], x[2]*y[0] + x[3]*y[2], x[2]*y[1] + x[3]*y[3]}
+	}
+	t := [4]int{1, 0, 0, 1}
+	mirror := func() {
+		switch m.Mirror {
+		case MirrorVertical:
+			t = mul([4]int{-1, 0, 0, 1}, t)
+		case MirrorHorizontal:
+			t = mul([4]int{1, 0, 0, -1}, t)
+		}
+	}
+	if m.mirrorFirst {
+		mirror()
+	}
+	for k := 0; k < m.Rotation/90%4; k++ {
+		t = mul([4]int{0, 1, -1, 0}, t)
+	}
+	if !m.mirrorFirst {
+		mirror()
+	}
+	return orientations[t]
+}
+
+// A FormatError reports that a HEIF file is malformed.
+type FormatError string
+
+func (e FormatError) Error() string { return "heif: invalid format: " + string(e) }
+
+// brands are the brands of files that hold HEIF images.
+var brands = map[string]bool{
+	"mif1": true, "msf1": true, "heic": true, "heix": true, "heim": true, "heis": true, "hevc": true, "hevx": true,
+	"avif": true, "avis": true,
+}
+
+// IsHEIF reports whether b starts with the ftyp box of a HEIF file, whose major brand or one of whose compatible
+// brands is a brand of HEIF images. Only the ftyp box needs to be in b.
+func IsHEIF(b []byte) bool {
+	if len(b) < 16 || string(b[4:8]) != "ftyp" {
+		return false
+	}
+	size := uint64(binary.BigEndian.Uint32(b))
+	if size < 16 || size > uint64(len(b)) {
+		return false
+	}
+	if brands[string(b[8:12])] {
+		return true
+	}
+	for c := b[16:size]; len(c) >= 4; c = c[4:] {
+		if brands[string(c[:4])] {
+			return true
+		}
+	}
+	return false
+}
+
+// exifHeader is the header of EXIF data in JPEG APP1 segments, which some software also writes before the TIFF
+// header of Exif items.
+const exifHeader = "Exif\x00\x00"
+
+// item is an item of a HEIF file, with its location and properties.
+type item struct {
+	id          uint32
+	typ         string
+	contentType string
+	// method is the construction method of the item: 0 for data at offsets in the file, 1 for data at offsets in the
+	// idat box and 2 for data taken from other items, which isn't supported.
+	method  uint8
+	extents []extent
+	// props holds the 1-based indexes of the properties of the item in the ipco box.
+	props []int
+}
+
+type extent struct {
+	offset, length uint64
+}
+
+// file is a parsed HEIF file.
+type file struct {
+	b     []byte
+	idat  []byte
+	items map[uint32]*item
+	// ids holds the IDs of the items in the order of the iinf box.
+	ids []uint32
+	// refs maps each type of reference to the items that each item refers to.
+	refs  map[string]map[uint32][]uint32
+	props []box
+}
+
+// ReadMeta reads the metadata of the primary image of the HEIF file in r. Item data can be anywhere in the file, so
+// all of r is read.
+func ReadMeta(r io.Reader) (*Meta, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := boxes(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(bs) == 0 || bs[0].typ != "ftyp" || len(bs[0].data) < 8 {
+		return nil, FormatError("missing ftyp box")
+	}
+	if !IsHEIF(b) {
+		return nil, FormatError("not a HEIF file")
+	}
+	m := &Meta{Brand: string(bs[0].data[:4])}
+	meta := find(bs, "meta")
+	if meta == nil {
+		return nil, FormatError("missing meta box")
+	}
+	f, primary, err := parseMeta(b, meta.data)
+	if err != nil {
+		return nil, err
+	}
+
+	p := f.items[primary]
+	m.Type = p.typ
+	rotated := false
+	for _, k := range p.props {
+		prop := f.props[k-1]
+		r := &reader{b: prop.data}
+		switch prop.typ {
+		case "ispe":
+			r.fullBox()
+			m.Width, m.Height = int(r.uint(4)), int(r.uint(4))
+		case "irot":
+			m.Rotation = int(r.uint(1)&3) * 90
+			rotated = true
+		case "imir":
+			m.Mirror = Mirror(r.uint(1)&1) + MirrorVertical
+			m.mirrorFirst = !rotated
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	// The tiles of grid images often carry the color profile instead of the grid.
+	m.ICCProfile = f.iccProfile(p)
+	if tiles := f.refs["dimg"][primary]; m.ICCProfile == nil && len(tiles) > 0 {
+		if tile := f.items[tiles[0]]; tile != nil {
+			m.ICCProfile = f.iccProfile(tile)
+		}
+	}
+
+	if p.typ == "jpeg" {
+		m.JPEG = f.data(p)
+	}
+	for _, id := range f.ids {
+		it := f.items[id]
+		describes := f.refers(id, "cdsc", primary)
+		switch {
+		case it.typ == "Exif" && (m.Exif == nil || describes):
+			m.Exif = exifData(f.data(it))
+		case it.typ == "mime" && it.contentType == "application/rdf+xml" && (m.XMP == nil || describes):
+			m.XMP = f.data(it)
+		case it.typ == "jpeg" && m.Thumbnail == nil && f.refers(id, "thmb", primary):
+			m.Thumbnail = f.data(it)
+		}
+	}
+	return m, nil
+}
+
+// parseMeta parses the data of the meta box of the file b, returning the items it describes and the ID of the
+// primary item.
+func parseMeta(b, meta []byte) (*file, uint32, error) {
+	r := &reader{b: meta}
+	r.fullBox()
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	bs, err := boxes(r.b)
+	if err != nil {
+		return nil, 0, err
+	}
+	f := &file{b: b, items: make(map[uint32]*item), refs: make(map[string]map[uint32][]uint32)}
+	for _, typ := range []string{"hdlr", "pitm", "iinf", "iloc"} {
+		if find(bs, typ) == nil {
+			return nil, 0, FormatError("missing " + typ + " box")
+		}
+	}
+
+	r = &reader{b: find(bs, "hdlr").data}
+	r.fullBox()
+	r.uint(4)
+	if r.fourCC() != "pict" {
+		return nil, 0, FormatError("not an image file")
+	}
+
+	r = &reader{b: find(bs, "pitm").data}
+	primary := uint32(r.uint(idSize(r.fullBox())))
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+
+	if err := f.parseItemInfo(find(bs, "iinf").data); err != nil {
+		return nil, 0, err
+	}
+	if f.items[primary] == nil {
+		return nil, 0, FormatError("missing primary item")
+	}
+	if err := f.parseItemLocations(find(bs, "iloc").data); err != nil {
+		return nil, 0, err
+	}
+	if iref := find(bs, "iref"); iref != nil {
+		if err := f.parseItemReferences(iref.data); err != nil {
+			return nil, 0, err
+		}
+	}
+	if iprp := find(bs, "iprp"); iprp != nil {
+		if err := f.parseItemProperties(iprp.data); err != nil {
+			return nil, 0, err
+		}
+	}
+	if idat := find(bs, "idat"); idat != nil {
+		f.idat = idat.data
+	}
+	return f, primary, nil
+}
+
+// idSize returns the size of item IDs in boxes of the given version, which are 16 bits in version 0 and 32 bits in
+// later versions.
+func idSize(version uint8, flags uint32) int {
+	if version == 0 {
+		return 2
+	}
+	return 4
+}
+
+// parseItemInfo parses the infe boxes of an iinf box. Only version 2 and 3 boxes have item types, so items of
+// earlier versions are skipped.
+func (f *file) parseItemInfo(iinf []byte) error {
+	r := &reader{b: iinf}
+	r.uint(idSize(r.fullBox()))
+	if r.err != nil {
+		return r.err
+	}
+	bs, err := boxes(r.b)
+	if err != nil {
+		return err
+	}
+	for _, b := range bs {
+		if b.typ != "infe" {
+			continue
+		}
+		r := &reader{b: b.data}
+		version, _ := r.fullBox()
+		if version < 2 {
+			continue
+		}
+		it := &item{}
+		if version == 2 {
+			it.id = uint32(r.uint(2))
+		} else {
+			it.id = uint32(r.uint(4))
+		}
+		r.uint(2)
+		it.typ = r.fourCC()
+		r.string()
+		if it.typ == "mime" {
+			it.contentType = r.string()
+		}
+		if r.err != nil {
+			return r.err
+		}
+		if f.items[it.id] == nil {
+			f.items[it.id] = it
+			f.ids = append(f.ids, it.id)
+		}
+	}
+	return nil
+}
+
+// parseItemLocations parses an iloc box, which gives the extents of the data of each item.
+func (f *file) parseItemLocations(iloc []byte) error {
+	r := &reader{b: iloc}
+	version, _ := r.fullBox()
+	sizes := r.uint(2)
+	offsetSize, lengthSize, baseOffsetSize, indexSize := int(sizes>>12), int(sizes>>8&0xf), int(sizes>>4&0xf), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0xf)
+	}
+	for _, n := range []int{offsetSize, lengthSize, baseOffsetSize, indexSize} {
+		if n != 0 && n != 4 && n != 8 {
+			return FormatError("bad iloc field size")
+		}
+	}
+	count := r.uint(2)
+	if version == 2 {
+		count = count<<16 | r.uint(2)
+	}
+	for k := uint64(0); k < count && r.err == nil; k++ {
+		var id uint32
+		if version < 2 {
+			id = uint32(r.uint(2))
+		} else {
+			id = uint32(r.uint(4))
+		}
+		method := uint8(0)
+		if version == 1 || version == 2 {
+			method = uint8(r.uint(2) & 0xf)
+		}
+		r.uint(2)
+		baseOffset := r.uint(baseOffsetSize)
+		n := r.uint(2)
+		// Each extent takes at least a byte of the box, except for a single extent of the whole data.
+		if per := uint64(indexSize + offsetSize + lengthSize); n*per > uint64(len(r.b)) || per == 0 && n > 1 {
+			return FormatError("bad iloc extent count")
+		}
+		var extents []extent
+		for e := uint64(0); e < n && r.err == nil; e++ {
+			r.uint(indexSize)
+			offset := r.uint(offsetSize)
+			extents = append(extents, extent{baseOffset + offset, r.uint(lengthSize)})
+		}
+		if it := f.items[id]; it != nil && it.extents == nil {
+			it.method, it.extents = method, extents
+		}
+	}
+	return r.err
+}
+
+// parseItemReferences parses an iref box, which holds a box for each reference from an item to others, of the type
+// of the reference.
+func (f *file) parseItemReferences(iref []byte) error {
+	r := &reader{b: iref}
+	n := idSize(r.fullBox())
+	if r.err != nil {
+		return r.err
+	}
+	bs, err := boxes(r.b)
+	if err != nil {
+		return err
+	}
+	for _, b := range bs {
+		r := &reader{b: b.data}
+		from := uint32(r.uint(n))
+		count := r.uint(2)
+		for k := uint64(0); k < count && r.err == nil; k++ {
+			to := uint32(r.uint(n))
+			if f.refs[b.typ] == nil {
+				f.refs[b.typ] = make(map[uint32][]uint32)
+			}
+			f.refs[b.typ][from] = append(f.refs[b.typ][from], to)
+		}
+		if r.err != nil {
+			return r.err
+		}
+	}
+	return nil
+}
+
+// parseItemProperties parses an iprp box, which holds the properties in its ipco box, and associates them with items
+// in its ipma boxes.
+func (f *file) parseItemProperties(iprp []byte) error {
+	bs, err := boxes(iprp)
+	if err != nil {
+		return err
+	}
+	ipco := find(bs, "ipco")
+	if ipco == nil {
+		return FormatError("missing ipco box")
+	}
+	if f.props, err = boxes(ipco.data); err != nil {
+		return err
+	}
+	for _, b := range bs {
+		if b.typ != "ipma" {
+			continue
+		}
+		r := &reader{b: b.data}
+		version, flags := r.fullBox()
+		count := r.uint(4)
+		for k := uint64(0); k < count && r.err == nil; k++ {
+			id := uint32(r.uint(idSize(version, flags)))
+			n := r.uint(1)
+			var props []int
+			for a := uint64(0); a < n && r.err == nil; a++ {
+				// The top bit marks essential properties.
+				var index int
+				if flags&1 != 0 {
+					index = int(r.uint(2) & 0x7fff)
+				} else {
+					index = int(r.uint(1) & 0x7f)
+				}
+				if index > len(f.props) {
+					return FormatError("bad property index")
+				}
+				if index > 0 {
+					props = append(props, index)
+				}
+			}
+			if it := f.items[id]; it != nil {
+				it.props = append(it.props, props...)
+			}
+		}
+		if r.err != nil {
+			return r.err
+		}
+	}
+	return nil
+}
+
+// refers reports whether item from has a reference of type typ to item to.
+func (f *file) refers(from uint32, typ string, to uint32) bool {
+	for _, id := range f.refs[typ][from] {
+		if id == to {
+			return true
+		}
+	}
+	return false
+}
+
+// iccProfile returns the ICC profile of the first colr property of it that has one, or nil.
+func (f *file) iccProfile(it *item) []byte {
+	for _, k := range it.props {
+		prop := f.props[k-1]
+		if prop.typ != "colr" || len(prop.data) < 4 {
+			continue
+		}
+		if t := string(prop.data[:4]); t == "prof" || t == "rICC" {
+			return prop.data[4:]
+		}
+	}
+	return nil
+}
+
+// data returns the data of it, or nil if it can't be found. An extent with a length of 0 extends to the end of the
+// file or idat box. Items whose extents add up to more than the whole file or idat box are rejected, as they can only
+// be made of the same bytes over and over.
+func (f *file) data(it *item) []byte {
+	var src []byte
+	switch it.method {
+	case 0:
+		src = f.b
+	case 1:
+		src = f.idat
+	default:
+		return nil
+	}
+	size, total := uint64(len(src)), uint64(0)
+	for _, e := range it.extents {
+		if e.offset > size || e.length > size-e.offset {
+			return nil
+		}
+		n := e.length
+		if n == 0 {
+			n = size - e.offset
+		}
+		if total += n; total > size {
+			return nil
+		}
+	}
+	var d []byte
+	for _, e := range it.extents {
+		end := e.offset + e.length
+		if e.length == 0 {
+			end = uint64(len(src))
+		}
+		if len(it.extents) == 1 {
+			return src[e.offset:end]
+		}
+		d = append(d, src[e.offset:end]...)
+	}
+	return d
+}
+
+// exifData returns the TIFF structured EXIF data in the data of an Exif item, which starts with the offset of the TIFF
+// header from the end of the offset, or nil if it is malformed.
+func exifData(b []byte) []byte {
+	if len(b) < 4 {
+		return nil
+	}
+	off := uint64(binary.BigEndian.Uint32(b)) + 4
+	if off > uint64(len(b)) {
+		return nil
+	}
+	b = b[off:]
+	if len(b) >= len(exifHeader) && string(b[:len(exifHeader)]) == exifHeader {
+		b = b[len(exifHeader):]
+	}
+	return b
+}
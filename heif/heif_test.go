@@ -0,0 +1,274 @@
+package heif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// mkbox returns a box of the given type holding the concatenation of data.
+func mkbox(typ string, data ...[]byte) []byte {
+	b := append(make([]byte, 4), typ...)
+	for _, d := range data {
+		b = append(b, d...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+// fullBox returns the version and flags of a full box, followed by fields of 1, 2 or 4 bytes.
+func fullBox(version uint8, flags uint32, fields ...interface{}) []byte {
+	b := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	for _, f := range fields {
+		switch f := f.(type) {
+		case uint8:
+			b = append(b, f)
+		case uint16:
+			b = append(b, byte(f>>8), byte(f))
+		case uint32:
+			b = append(b, byte(f>>24), byte(f>>16), byte(f>>8), byte(f))
+		case string:
+			b = append(b, f...)
+		case []byte:
+			b = append(b, f...)
+		}
+	}
+	return b
+}
+
+// testItem is an item of a test file, with its data and the properties associated with it.
+type testItem struct {
+	typ, contentType string
+	data             []byte
+	// idat stores the data in the idat box instead of after the meta box.
+	idat  bool
+	props []uint8
+}
+
+// testFile returns a HEIF file of items 1 to len(items), whose primary item is the first one, with the given
+// properties and references, in the order of boxes of iPhone photos.
+func testFile(brand string, items []testItem, props [][]byte, refs []byte) []byte {
+	var infe [][]byte
+	var ipma []byte
+	for k, it := range items {
+		name := "\x00"
+		if it.contentType != "" {
+			name += it.contentType + "\x00"
+		}
+		infe = append(infe, mkbox("infe", fullBox(2, 0, uint16(k+1), uint16(0), it.typ, name)))
+		ipma = append(ipma, fullBox(0, 0, uint16(k+1), uint8(len(it.props)))[4:]...)
+		ipma = append(ipma, it.props...)
+	}
+	iinf := mkbox("iinf", append(fullBox(0, 0, uint16(len(items))), bytes.Join(infe, nil)...))
+	iprp := mkbox("iprp", mkbox("ipco", props...), mkbox("ipma", fullBox(0, 0, uint32(len(items))), ipma))
+
+	// The iloc box has a fixed size, so the offsets of the data after the meta box can be worked out before it is
+	// written.
+	iloc := func(offset int) []byte {
+		b := fullBox(1, 0, uint16(0x4400), uint16(len(items)))
+		idat := 0
+		for k, it := range items {
+			method, off := uint16(0), offset
+			if it.idat {
+				method, off = 1, idat
+				idat += len(it.data)
+			} else {
+				offset += len(it.data)
+			}
+			b = append(b, fullBox(0, 0, uint16(k+1), method, uint16(0), uint16(1), uint32(off), uint32(len(it.data)))[4:]...)
+		}
+		return mkbox("iloc", b)
+	}
+	var idat, mdat []byte
+	for _, it := range items {
+		if it.idat {
+			idat = append(idat, it.data...)
+		} else {
+			mdat = append(mdat, it.data...)
+		}
+	}
+	ftyp := mkbox("ftyp", []byte(brand+"\x00\x00\x00\x00mif1"+brand))
+	meta := func(offset int) []byte {
+		return mkbox("meta", fullBox(0, 0),
+			mkbox("hdlr", fullBox(0, 0, uint32(0), "pict", make([]byte, 13))),
+			mkbox("pitm", fullBox(0, 0, uint16(1))),
+			iloc(offset), iinf, refs, iprp, mkbox("idat", idat))
+	}
+	head := len(ftyp) + len(meta(0)) + 8
+	return bytes.Join([][]byte{ftyp, meta(head), mkbox("mdat", mdat)}, nil)
+}
+
+func TestReadMeta(t *testing.T) {
+	profile := []byte("an ICC profile")
+	tiff := []byte("MM\x00*\x00\x00\x00\x08\x00\x00")
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`)
+	thumbnail := []byte("\xff\xd8thumbnail\xff\xd9")
+	props := [][]byte{
+		mkbox("ispe", fullBox(0, 0, uint32(4032), uint32(3024))),
+		mkbox("colr", []byte("prof"), profile),
+		mkbox("irot", []byte{3}),
+		mkbox("imir", []byte{0}),
+		mkbox("ispe", fullBox(0, 0, uint32(320), uint32(240))),
+		mkbox("colr", []byte("nclx\x00\x01\x00\x0d\x00\x06\x80")),
+	}
+	refs := mkbox("iref", fullBox(0, 0),
+		mkbox("thmb", []byte{0, 2, 0, 1, 0, 1}),
+		mkbox("cdsc", []byte{0, 3, 0, 1, 0, 1}),
+		mkbox("cdsc", []byte{0, 4, 0, 1, 0, 1}),
+	)
+	items := []testItem{
+		{typ: "hvc1", data: []byte("HEVC data"), props: []uint8{0x80 | 1, 0x80 | 6, 2, 0x80 | 3}},
+		{typ: "jpeg", data: thumbnail, props: []uint8{5}},
+		{typ: "Exif", data: append([]byte("\x00\x00\x00\x06Exif\x00\x00"), tiff...)},
+		{typ: "mime", contentType: "application/rdf+xml", data: xmp, idat: true},
+	}
+	m, err := ReadMeta(bytes.NewReader(testFile("heic", items, props, refs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Brand != "heic" || m.Type != "hvc1" || m.Width != 4032 || m.Height != 3024 || m.Rotation != 270 ||
+		m.Mirror != NoMirror || m.JPEG != nil {
+		t.Errorf("got %+v", m)
+	}
+	if o := m.Orientation(); o != 6 {
+		t.Errorf("got orientation %d, want 6", o)
+	}
+	for _, c := range []struct {
+		name      string
+		got, want []byte
+	}{
+		{"ICC profile", m.ICCProfile, profile},
+		{"EXIF", m.Exif, tiff},
+		{"XMP", m.XMP, xmp},
+		{"thumbnail", m.Thumbnail, thumbnail},
+	} {
+		if !bytes.Equal(c.got, c.want) {
+			t.Errorf("got %s %q, want %q", c.name, c.got, c.want)
+		}
+	}
+
+	// AVIF files with a mirrored JPEG as their primary image, and an Exif item without a header.
+	props[3] = mkbox("imir", []byte{1})
+	items = []testItem{
+		{typ: "jpeg", data: thumbnail, props: []uint8{1, 4}},
+		{typ: "Exif", data: append([]byte{0, 0, 0, 0}, tiff...)},
+	}
+	m, err = ReadMeta(bytes.NewReader(testFile("avif", items, props, mkbox("free"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Brand != "avif" || !bytes.Equal(m.JPEG, thumbnail) || !bytes.Equal(m.Exif, tiff) || m.ICCProfile != nil ||
+		m.Mirror != MirrorHorizontal || m.Orientation() != 4 {
+		t.Errorf("got %+v", m)
+	}
+}
+
+func TestIsHEIF(t *testing.T) {
+	for _, c := range []struct {
+		ftyp []byte
+		want bool
+	}{
+		{mkbox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic")), true},
+		{mkbox("ftyp", []byte("hevx\x00\x00\x00\x00")), true},
+		{mkbox("ftyp", []byte("iso8\x00\x00\x00\x00mp41avif")), true},
+		{mkbox("ftyp", []byte("isom\x00\x00\x00\x00mp41")), false},
+		{mkbox("ftyp", []byte("isom\x00\x00\x00\x00")[:4]), false},
+		{mkbox("moov", []byte("heic\x00\x00\x00\x00")), false},
+	} {
+		if got := IsHEIF(append(c.ftyp, mkbox("meta")...)); got != c.want {
+			t.Errorf("%q: got %v, want %v", c.ftyp, got, c.want)
+		}
+	}
+}
+
+func TestOrientation(t *testing.T) {
+	for _, c := range []struct {
+		rotation    int
+		mirror      Mirror
+		mirrorFirst bool
+		want        int
+	}{
+		{0, NoMirror, false, 1},
+		{0, MirrorVertical, false, 2},
+		{180, NoMirror, false, 3},
+		{0, MirrorHorizontal, true, 4},
+		{90, MirrorVertical, true, 5},
+		{270, NoMirror, false, 6},
+		{90, MirrorVertical, false, 7},
+		{90, NoMirror, false, 8},
+		{270, MirrorHorizontal, true, 5},
+		{270, MirrorVertical, true, 7},
+	} {
+		m := &Meta{Rotation: c.rotation, Mirror: c.mirror, mirrorFirst: c.mirrorFirst}
+		if got := m.Orientation(); got != c.want {
+			t.Errorf("%+v: got orientation %d, want %d", c, got, c.want)
+		}
+	}
+}
+
+func TestReadMetaErrors(t *testing.T) {
+	props := [][]byte{mkbox("ispe", fullBox(0, 0, uint32(64), uint32(48)))}
+	items := []testItem{{typ: "av01", data: []byte("AV1 data"), props: []uint8{1}}}
+	file := testFile("avif", items, props, mkbox("free"))
+	if _, err := ReadMeta(bytes.NewReader(file)); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		desc string
+		file []byte
+	}{
+		{"MP4", append(mkbox("ftyp", []byte("isom\x00\x00\x00\x00mp41")), mkbox("moov")...)},
+		{"no meta box", mkbox("ftyp", []byte("heic\x00\x00\x00\x00mif1"))},
+		{"bad property index", testFile("avif", []testItem{{typ: "av01", props: []uint8{2}}}, props, mkbox("free"))},
+		{"bad box size", append(file[:len(file)-16:len(file)-16], 0xff, 0, 0, 0, 'm', 'd', 'a', 't')},
+	} {
+		if _, err := ReadMeta(bytes.NewReader(c.file)); err == nil {
+			t.Errorf("%s: no error", c.desc)
+		}
+	}
+	// Files truncated before the image data are rejected without panicking.
+	for n := 0; n < bytes.Index(file, []byte("mdat"))-4; n++ {
+		if _, err := ReadMeta(bytes.NewReader(file[:n])); err == nil {
+			t.Errorf("no error for file truncated to %d bytes", n)
+		}
+	}
+}
+
+func TestReadMetaRepeatedExtents(t *testing.T) {
+	// iloc returns an iloc box that places the Exif item 2 in n extents of the whole file, with fields of the given
+	// size.
+	iloc := func(fieldSize uint8, n int) []byte {
+		b := fullBox(1, 0, fieldSize<<4|fieldSize, uint8(0), uint16(1), uint16(2), uint16(0), uint16(0), uint16(n))
+		return mkbox("iloc", b, make([]byte, 2*int(fieldSize)*n))
+	}
+	file := func(iloc []byte) []byte {
+		return bytes.Join([][]byte{
+			mkbox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic")),
+			mkbox("meta", fullBox(0, 0),
+				mkbox("hdlr", fullBox(0, 0, uint32(0), "pict", make([]byte, 13))),
+				mkbox("pitm", fullBox(0, 0, uint16(1))),
+				iloc,
+				mkbox("iinf", fullBox(0, 0, uint16(2)),
+					mkbox("infe", fullBox(2, 0, uint16(1), uint16(0), "hvc1\x00")),
+					mkbox("infe", fullBox(2, 0, uint16(2), uint16(0), "Exif\x00")))),
+		}, nil)
+	}
+
+	if _, err := ReadMeta(bytes.NewReader(file(iloc(0, 0xffff)))); err == nil {
+		t.Error("no error for extents without fields")
+	}
+	m, err := ReadMeta(bytes.NewReader(file(iloc(4, 500))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Exif != nil {
+		t.Errorf("got %d bytes of EXIF from repeated extents", len(m.Exif))
+	}
+	m, err = ReadMeta(bytes.NewReader(file(iloc(4, 1))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Exif == nil {
+		t.Error("lost EXIF of a single extent")
+	}
+}
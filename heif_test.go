@@ -0,0 +1,102 @@
+package img
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+
+	"github.com/snapas/img/icc"
+	"github.com/snapas/img/iccjpeg"
+)
+
+// testHEIF returns a HEIF file of an image of the given type with its data, rotated 90° counterclockwise, with a
+// Display P3 profile and EXIF metadata.
+func testHEIF(typ string, data, exif []byte) []byte {
+	box := func(typ string, data ...[]byte) []byte {
+		b := append(make([]byte, 4), typ...)
+		b = append(b, bytes.Join(data, nil)...)
+		binary.BigEndian.PutUint32(b, uint32(len(b)))
+		return b
+	}
+	u16 := func(v int) []byte { return []byte{byte(v >> 8), byte(v)} }
+	u32 := func(v int) []byte { return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)} }
+	full := make([]byte, 4)
+
+	exif = append(make([]byte, 4), exif...)
+	iloc := func(offset int) []byte {
+		return box("iloc", full, []byte{0x44, 0}, u16(2),
+			u16(1), u16(0), u16(1), u32(offset), u32(len(data)),
+			u16(2), u16(0), u16(1), u32(offset+len(data)), u32(len(exif)))
+	}
+	meta := func(offset int) []byte {
+		return box("meta", full,
+			box("hdlr", full, u32(0), []byte("pict"), make([]byte, 13)),
+			box("pitm", full, u16(1)),
+			iloc(offset),
+			box("iinf", full, u16(2),
+				box("infe", []byte{2, 0, 0, 0}, u16(1), u16(0), []byte(typ+"\x00")),
+				box("infe", []byte{2, 0, 0, 0}, u16(2), u16(0), []byte("Exif\x00"))),
+			box("iref", full, box("cdsc", u16(2), u16(1), u16(1))),
+			box("iprp",
+				box("ipco", box("colr", []byte("prof"), icc.DisplayP3Data), box("irot", []byte{1})),
+				box("ipma", full, u32(1), u16(1), []byte{2, 0x81, 0x82})))
+	}
+	ftyp := box("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	offset := len(ftyp) + len(meta(0)) + 8
+	return bytes.Join([][]byte{ftyp, meta(offset), box("mdat", data, exif)}, nil)
+}
+
+func TestHEIF(t *testing.T) {
+	// A 16x8 image, red on the left and blue on the right, which is displayed 8x16, red at the bottom.
+	m := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	draw.Draw(m, image.Rect(0, 0, 8, 8), image.NewUniform(color.RGBA{0xff, 0, 0, 0xff}), image.Point{}, draw.Src)
+	draw.Draw(m, image.Rect(8, 0, 16, 8), image.NewUniform(color.RGBA{0, 0, 0xff, 0xff}), image.Point{}, draw.Src)
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, m, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The EXIF orientation is ignored in favor of the irot property.
+	i, format, err := Decode(bytes.NewReader(testHEIF("jpeg", buf.Bytes(), testExif(6, 16, 8))))
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if format != "heif" || i.Image.Bounds() != image.Rect(0, 0, 8, 16) {
+		t.Fatalf("got %s image %v", format, i.Image.Bounds())
+	}
+	if r, _, b, _ := i.Image.At(4, 12).RGBA(); r < 0xc000 || b > 0x4000 {
+		t.Error("image isn't rotated counterclockwise")
+	}
+	if o := Orientation(i.Exif); o != 1 {
+		t.Errorf("orientation is %d", o)
+	}
+	if w, _ := exifTag(i.Exif, tagPixelXDimension); w != 8 {
+		t.Errorf("EXIF width is %d", w)
+	}
+	if !bytes.Equal(iccjpeg.ProfileData(i.App2), icc.DisplayP3Data) {
+		t.Error("ICC profile was lost")
+	}
+
+	// Files are recognized by their compatible brands too, whatever their major brand.
+	for _, brand := range []string{"hevc", "iso8"} {
+		file := testHEIF("jpeg", buf.Bytes(), nil)
+		copy(file[8:12], brand)
+		if _, format, err := Decode(bytes.NewReader(file)); err != nil || format != "heif" {
+			t.Errorf("%s brand: got %s image, error %v", brand, format, err)
+		}
+	}
+
+	// HEVC images can't be decoded, but their metadata is kept.
+	exif := testExif(8, 16, 8)
+	i, format, err = Decode(bytes.NewReader(testHEIF("hvc1", []byte("HEVC data"), exif)))
+	if err != ErrHEIFCoding {
+		t.Fatalf("got error %v", err)
+	}
+	if format != "heif" || i.Image != nil || !bytes.Equal(i.Exif, exif) || i.App2 == nil {
+		t.Errorf("got %s image with EXIF %v and ICC profile %v", format, i.Exif != nil, i.App2 != nil)
+	}
+}
@@ -0,0 +1,183 @@
+package icc
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+// d50 is the PCS illuminant, which the white point of version 4 display profiles is always set to.
+var d50 = [3]float64{0.9642, 1.0, 0.8249}
+
+// srgbTRC is the sRGB tone curve as parametricCurveType parameters.
+var srgbTRC = []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045}
+
+// d65ToD50 is the Bradford chromatic adaptation matrix from the D65 white point of sRGB, Display P3 and Adobe RGB to
+// D50.
+var d65ToD50 = []float64{
+	1.0478112, 0.0228866, -0.0501270,
+	0.0295424, 0.9904844, -0.0170491,
+	-0.0092345, 0.0150436, 0.7521316,
+}
+
+var (
+	// SRGBData is a compact ICC v4 profile for the sRGB color space, the default target for conversions.
+	SRGBData = buildProfile("mntr", "RGB ", "XYZ ", []tagData{
+		{"desc", mlucTag("sRGB")},
+		{"cprt", mlucTag("No copyright, use freely")},
+		{"wtpt", xyzTag(d50)},
+		{"chad", sf32Tag(d65ToD50)},
+		{"rXYZ", xyzTag([3]float64{0.4360747, 0.2225045, 0.0139322})},
+		{"gXYZ", xyzTag([3]float64{0.3850649, 0.7168786, 0.0971045})},
+		{"bXYZ", xyzTag([3]float64{0.1430804, 0.0606169, 0.7141733})},
+		{"rTRC", paraTag(3, srgbTRC)},
+		{"gTRC", paraTag(3, srgbTRC)},
+		{"bTRC", paraTag(3, srgbTRC)},
+	})
+
+	// DisplayP3Data is a compact ICC v4 profile for the Display P3 color space, which recent phones shoot in.
+	DisplayP3Data = buildProfile("mntr", "RGB ", "XYZ ", []tagData{
+		{"desc", mlucTag("Display P3")},
+		{"cprt", mlucTag("No copyright, use freely")},
+		{"wtpt", xyzTag(d50)},
+		{"chad", sf32Tag(d65ToD50)},
+		{"rXYZ", xyzTag([3]float64{0.5151215, 0.2411957, -0.0010529})},
+		{"gXYZ", xyzTag([3]float64{0.2919769, 0.6922455, 0.0418854})},
+		{"bXYZ", xyzTag([3]float64{0.1571045, 0.0665741, 0.7840729})},
+		{"rTRC", paraTag(3, srgbTRC)},
+		{"gTRC", paraTag(3, srgbTRC)},
+		{"bTRC", paraTag(3, srgbTRC)},
+	})
+
+	// AdobeRGBData is a compact ICC v4 profile compatible with the Adobe RGB (1998) color space, which cameras offer
+	// as a wide gamut option.
+	AdobeRGBData = buildProfile("mntr", "RGB ", "XYZ ", []tagData{
+		{"desc", mlucTag("Compatible with Adobe RGB (1998)")},
+		{"cprt", mlucTag("No copyright, use freely")},
+		{"wtpt", xyzTag(d50)},
+		{"chad", sf32Tag(d65ToD50)},
+		{"rXYZ", xyzTag([3]float64{0.6097559, 0.3111242, 0.0194811})},
+		{"gXYZ", xyzTag([3]float64{0.2052401, 0.6256560, 0.0608902})},
+		{"bXYZ", xyzTag([3]float64{0.1492240, 0.0632197, 0.7448387})},
+		{"rTRC", adobeRGBTRC},
+		{"gTRC", adobeRGBTRC},
+		{"bTRC", adobeRGBTRC},
+	})
+
+	// SGrayData is a compact ICC v4 profile for gray images with the sRGB tone curve.
+	SGrayData = buildProfile("mntr", "GRAY", "XYZ ", []tagData{
+		{"desc", mlucTag("sGray")},
+		{"cprt", mlucTag("No copyright, use freely")},
+		{"wtpt", xyzTag(d50)},
+		{"kTRC", paraTag(3, srgbTRC)},
+	})
+
+	// SRGB is the parsed form of SRGBData.
+	SRGB = mustParse(SRGBData)
+
+	// GenericCMYKData is an ICC v4 profile for CMYK images that don't have one of their own, modelling process inks
+	// on coated paper.
+	GenericCMYKData = genericCMYK()
+
+	// GenericCMYK is the parsed form of GenericCMYKData.
+	GenericCMYK = mustParse(GenericCMYKData)
+)
+
+// adobeRGBTRC is the tone curve of Adobe RGB (1998), a gamma of 563/256.
+var adobeRGBTRC = []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x33")
+
+func mustParse(b []byte) *Profile {
+	p, err := Parse(b)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// tagData is a tag's signature and encoded data, for building profiles.
+type tagData struct {
+	sig  string
+	data []byte
+}
+
+// buildProfile encodes an ICC v4 profile with the given class, data color space, PCS and tags.
+func buildProfile(class, colorSpace, pcs string, tags []tagData) []byte {
+	offset := headerLen + 4 + 12*len(tags)
+	p := make([]byte, offset)
+	binary.BigEndian.PutUint32(p[8:], 0x04300000) // Version 4.3.
+	copy(p[12:], class)
+	copy(p[16:], colorSpace)
+	copy(p[20:], pcs)
+	binary.BigEndian.PutUint16(p[24:], 2021) // Creation date: 2021-01-01.
+	binary.BigEndian.PutUint16(p[26:], 1)
+	binary.BigEndian.PutUint16(p[28:], 1)
+	copy(p[36:], "acsp")
+	for i, v := range d50 {
+		putS15Fixed16(p[68+4*i:], v)
+	}
+	binary.BigEndian.PutUint32(p[headerLen:], uint32(len(tags)))
+	for i, t := range tags {
+		e := p[headerLen+4+12*i:]
+		copy(e, t.sig)
+		binary.BigEndian.PutUint32(e[4:], uint32(len(p)))
+		binary.BigEndian.PutUint32(e[8:], uint32(len(t.data)))
+		p = append(p, t.data...)
+		// Tag data is 4-byte aligned.
+		for len(p)%4 != 0 {
+			p = append(p, 0)
+		}
+	}
+	binary.BigEndian.PutUint32(p, uint32(len(p)))
+	id := ComputeID(p)
+	copy(p[84:], id[:])
+	return p
+}
+
+func putS15Fixed16(b []byte, f float64) {
+	if f < 0 {
+		binary.BigEndian.PutUint32(b, uint32(int32(f*65536-0.5)))
+		return
+	}
+	binary.BigEndian.PutUint32(b, uint32(int32(f*65536+0.5)))
+}
+
+// mlucTag encodes a multiLocalizedUnicodeType tag with a single en-US record.
+func mlucTag(text string) []byte {
+	u := utf16.Encode([]rune(text))
+	b := make([]byte, 28+2*len(u))
+	copy(b, "mluc")
+	binary.BigEndian.PutUint32(b[8:], 1)   // Number of records.
+	binary.BigEndian.PutUint32(b[12:], 12) // Record size.
+	copy(b[16:], "enUS")
+	binary.BigEndian.PutUint32(b[20:], uint32(2*len(u)))
+	binary.BigEndian.PutUint32(b[24:], 28)
+	for i, c := range u {
+		binary.BigEndian.PutUint16(b[28+2*i:], c)
+	}
+	return b
+}
+
+// xyzTag encodes an XYZType tag holding one value.
+func xyzTag(v [3]float64) []byte {
+	return append([]byte("XYZ \x00\x00\x00\x00"), sf32(v[:])...)
+}
+
+// sf32Tag encodes an s15Fixed16ArrayType tag.
+func sf32Tag(v []float64) []byte {
+	return append([]byte("sf32\x00\x00\x00\x00"), sf32(v)...)
+}
+
+func sf32(v []float64) []byte {
+	b := make([]byte, 4*len(v))
+	for i, f := range v {
+		putS15Fixed16(b[4*i:], f)
+	}
+	return b
+}
+
+// paraTag encodes a parametricCurveType tag.
+func paraTag(functionType uint16, params []float64) []byte {
+	b := make([]byte, 12, 12+4*len(params))
+	copy(b, "para")
+	binary.BigEndian.PutUint16(b[8:], functionType)
+	return append(b, sf32(params)...)
+}
@@ -0,0 +1,262 @@
+package icc
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+)
+
+// cmykGridSize is the number of points along each axis of the grid that a CMYKTransform samples its source profile
+// at. Colors in between are interpolated.
+const cmykGridSize = 17
+
+// CMYKTransform converts CMYK colors to an RGB color space, through the look-up tables of a CMYK profile.
+type CMYKTransform struct {
+	// grid holds the 16-bit encoded destination colors at each grid point. K varies fastest, then Y, M and C.
+	grid []uint16
+}
+
+// NewCMYKTransform returns a CMYKTransform from src's color space to dst's, using the given rendering intent. src must
+// be a CMYK profile with a device to PCS look-up table ("mft1", "mft2" or "mAB " type), and dst an RGB matrix/TRC
+// profile.
+func NewCMYKTransform(src, dst *Profile, intent Intent) (*CMYKTransform, error) {
+	if src.ColorSpace != "CMYK" || (src.PCS != "XYZ " && src.PCS != "Lab ") || dst.PCS != "XYZ " {
+		return nil, ErrUnsupported
+	}
+	l, err := src.pipeline(intent)
+	if err != nil {
+		return nil, err
+	}
+	if l.nIn != 4 {
+		return nil, FormatError("CMYK look-up table doesn't have 4 inputs")
+	}
+	dstM, trc, err := rgbColorants(dst)
+	if err != nil {
+		return nil, err
+	}
+	inv, ok := invert(dstM)
+	if !ok {
+		return nil, FormatError("singular colorant matrix")
+	}
+	var fromLinear [3][fromLinearSize + 1]float64
+	for i, c := range trc {
+		for v := range fromLinear[i] {
+			fromLinear[i][v] = c.Inverse(float64(v) / fromLinearSize)
+		}
+	}
+	scale := [3]float64{1, 1, 1}
+	if intent == AbsoluteColorimetric {
+		srcW, err1 := src.XYZ("wtpt")
+		dstW, err2 := dst.XYZ("wtpt")
+		if err1 == nil && err2 == nil {
+			scale = [3]float64{srcW.X / dstW.X, srcW.Y / dstW.Y, srcW.Z / dstW.Z}
+		}
+	}
+
+	const n = cmykGridSize
+	t := &CMYKTransform{grid: make([]uint16, 0, 3*n*n*n*n)}
+	in := make([]float64, 4)
+	for i := 0; i < n*n*n*n; i++ {
+		in[0], in[1], in[2], in[3] = float64(i/(n*n*n))/(n-1), float64(i/(n*n)%n)/(n-1), float64(i/n%n)/(n-1),
+			float64(i%n)/(n-1)
+		xyz := l.toXYZ(src.PCS, l.eval(in))
+		var c [3]float64
+		for j := range c {
+			c[j] = inv[j][0]*xyz[0]*scale[0] + inv[j][1]*xyz[1]*scale[1] + inv[j][2]*xyz[2]*scale[2]
+		}
+		if intent != RelativeColorimetric && intent != AbsoluteColorimetric {
+			compress(dstM[1], &c)
+		}
+		for j, v := range c {
+			// Interpolate between the steps of the inverse tone curve, so smooth gradients stay smooth.
+			v = math.Max(0, math.Min(1, v)) * fromLinearSize
+			k := int(v)
+			if k == fromLinearSize {
+				k--
+			}
+			e := fromLinear[j][k] + (fromLinear[j][k+1]-fromLinear[j][k])*(v-float64(k))
+			t.grid = append(t.grid, uint16(e*65535+0.5))
+		}
+	}
+	return t, nil
+}
+
+// gridPos returns the grid cell that the 8-bit value v falls in, and its position within the cell out of 256.
+func gridPos(v uint8) (int, int) {
+	p := int(v) * (cmykGridSize - 1) * 256 / 255
+	i, f := p>>8, p&0xff
+	if i == cmykGridSize-1 {
+		i, f = cmykGridSize-2, 256
+	}
+	return i, f
+}
+
+// Convert converts the CMYK color (c, m, y, k), where 0 is no ink and 255 is full ink, as in color.CMYK. Colors are
+// interpolated tetrahedrally between the grid points around them in C, M and Y, and linearly in K.
+func (t *CMYKTransform) Convert(c, m, y, k uint8) (uint8, uint8, uint8) {
+	const (
+		sK = 3
+		sY = sK * cmykGridSize
+		sM = sY * cmykGridSize
+		sC = sM * cmykGridSize
+	)
+	ic, fc := gridPos(c)
+	im, fm := gridPos(m)
+	iy, fy := gridPos(y)
+	ik, fk := gridPos(k)
+
+	// Order the axes by decreasing fraction, to pick the tetrahedron that contains the color.
+	s1, s2, s3, f1, f2, f3 := sC, sM, sY, fc, fm, fy
+	if f1 < f2 {
+		s1, s2, f1, f2 = s2, s1, f2, f1
+	}
+	if f2 < f3 {
+		s2, s3, f2, f3 = s3, s2, f3, f2
+	}
+	if f1 < f2 {
+		s1, s2, f1, f2 = s2, s1, f2, f1
+	}
+
+	base := ic*sC + im*sM + iy*sY + ik*sK
+	var out [3]uint8
+	for ch := range out {
+		var v [2]int
+		for j := range v {
+			p0 := base + ch + j*sK
+			g0, g1 := int(t.grid[p0]), int(t.grid[p0+s1])
+			g2, g3 := int(t.grid[p0+s1+s2]), int(t.grid[p0+s1+s2+s3])
+			v[j] = g0<<8 + f1*(g1-g0) + f2*(g2-g1) + f3*(g3-g2)
+		}
+		e := (v[0]*(256-fk) + v[1]*fk) >> 8 // 16-bit value, scaled by 256.
+		out[ch] = uint8((e*255 + 65535*128) / (65535 * 256))
+	}
+	return out[0], out[1], out[2]
+}
+
+// ConvertImage converts every pixel of m, returning a new, opaque image.
+func (t *CMYKTransform) ConvertImage(m image.Image) *image.RGBA {
+	b := m.Bounds()
+	dst := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		pix := dst.Pix[dst.PixOffset(b.Min.X, y):]
+		if m, ok := m.(*image.CMYK); ok {
+			src := m.Pix[m.PixOffset(b.Min.X, y):]
+			for x := b.Min.X; x < b.Max.X; x++ {
+				pix[0], pix[1], pix[2] = t.Convert(src[0], src[1], src[2], src[3])
+				pix[3] = 0xff
+				pix, src = pix[4:], src[4:]
+			}
+			continue
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.CMYKModel.Convert(m.At(x, y)).(color.CMYK)
+			pix[0], pix[1], pix[2] = t.Convert(c.C, c.M, c.Y, c.K)
+			pix[3] = 0xff
+			pix = pix[4:]
+		}
+	}
+	return dst
+}
+
+// genericCMYKLab holds the colors of process inks on coated paper, and of overprints of C, M and Y, indexed by a bit
+// mask of the inks: 1 for cyan, 2 for magenta, 4 for yellow and 8 for black.
+var genericCMYKLab = map[int][3]float64{
+	0: {95, 0, -2},
+	1: {55, -37, -50},
+	2: {48, 74, -3},
+	3: {24, 22, -46},
+	4: {89, -5, 93},
+	5: {50, -65, 27},
+	6: {47, 68, 48},
+	7: {23, 0, 1},
+	8: {16, 0, 0},
+}
+
+// genericCMYKGridSize is the number of points along each axis of the generic CMYK profile's look-up table.
+const genericCMYKGridSize = 9
+
+// genericCMYK builds a CMYK profile for images without one of their own. It models halftone printing with the
+// Yule-Nielsen modified Neugebauer equations, which average the reflectance of every ink overprint weighted by its
+// area coverage. Overprints of black are approximated by multiplying reflectances, and paper maps to PCS white.
+func genericCMYK() []byte {
+	// Reflectances of the 16 overprints relative to paper, per XYZ channel.
+	var primaries [16][3]float64
+	paper := labToXYZ(genericCMYKLab[0])
+	black := labToXYZ(genericCMYKLab[8])
+	for i := range primaries {
+		xyz := labToXYZ(genericCMYKLab[i&7])
+		for j := range xyz {
+			primaries[i][j] = xyz[j] / paper[j]
+			if i&8 != 0 {
+				primaries[i][j] *= black[j] / paper[j]
+			}
+		}
+	}
+
+	const n = genericCMYKGridSize
+	const yuleNielsen = 2
+	clut := make([]uint16, 0, 3*n*n*n*n)
+	for i := 0; i < n*n*n*n; i++ {
+		ink := [4]float64{
+			float64(i/(n*n*n)) / (n - 1), float64(i/(n*n)%n) / (n - 1),
+			float64(i/n%n) / (n - 1), float64(i%n) / (n - 1),
+		}
+		var sum [3]float64
+		for p, r := range primaries {
+			// The Demichel weight of an overprint is the area covered by exactly its inks.
+			w := 1.0
+			for j, v := range ink {
+				if p&(1<<uint(j)) != 0 {
+					w *= v
+				} else {
+					w *= 1 - v
+				}
+			}
+			for j := range sum {
+				sum[j] += w * math.Pow(r[j], 1.0/yuleNielsen)
+			}
+		}
+		var xyz [3]float64
+		for j := range xyz {
+			xyz[j] = math.Pow(sum[j], yuleNielsen) * d50[j]
+		}
+		lab := xyzToLab(xyz)
+		clut = append(clut,
+			uint16(math.Max(0, math.Min(65535, lab[0]*652.8+0.5))),
+			uint16(math.Max(0, math.Min(65535, (lab[1]+128)*256+0.5))),
+			uint16(math.Max(0, math.Min(65535, (lab[2]+128)*256+0.5))),
+		)
+	}
+
+	return buildProfile("prtr", "CMYK", "Lab ", []tagData{
+		{"desc", mlucTag("Generic CMYK")},
+		{"cprt", mlucTag("No copyright, use freely")},
+		{"wtpt", xyzTag(d50)},
+		{"A2B0", lut16Tag(4, n, clut)},
+	})
+}
+
+// lut16Tag encodes a lut16Type tag with identity input and output tables, the given number of input channels and
+// grid points, and 3 output channels.
+func lut16Tag(nIn, points int, clut []uint16) []byte {
+	b := make([]byte, 52, 52+2*(2*nIn+len(clut)+2*3))
+	copy(b, "mft2")
+	b[8], b[9], b[10] = byte(nIn), 3, byte(points)
+	for i := 0; i < 3; i++ {
+		putS15Fixed16(b[12+16*i:], 1) // Identity matrix.
+	}
+	binary.BigEndian.PutUint16(b[48:], 2)
+	binary.BigEndian.PutUint16(b[50:], 2)
+	identity := []byte{0, 0, 0xff, 0xff}
+	for i := 0; i < nIn; i++ {
+		b = append(b, identity...)
+	}
+	for _, v := range clut {
+		b = append(b, byte(v>>8), byte(v))
+	}
+	for i := 0; i < 3; i++ {
+		b = append(b, identity...)
+	}
+	return b
+}
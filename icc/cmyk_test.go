@@ -0,0 +1,188 @@
+package icc
+
+import (
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+func TestGenericCMYK(t *testing.T) {
+	tr, err := NewCMYKTransform(GenericCMYK, SRGB, RelativeColorimetric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, g, b := tr.Convert(0, 0, 0, 0)
+	if r < 254 || g < 254 || b < 254 {
+		t.Errorf("paper = (%d, %d, %d), want white", r, g, b)
+	}
+	r, g, b = tr.Convert(0, 0, 0, 255)
+	if r > 64 || diff(r, g) > 8 || diff(r, b) > 8 {
+		t.Errorf("black ink = (%d, %d, %d), want dark gray", r, g, b)
+	}
+	r, g, b = tr.Convert(255, 255, 255, 255)
+	if r > 16 || g > 16 || b > 16 {
+		t.Errorf("all inks = (%d, %d, %d), want black", r, g, b)
+	}
+
+	// Each ink absorbs its complementary primary.
+	testCases := []struct {
+		name       string
+		c, m, y    uint8
+		absorbedCh int
+	}{
+		{"cyan", 255, 0, 0, 0},
+		{"magenta", 0, 255, 0, 1},
+		{"yellow", 0, 0, 255, 2},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, g, b := tr.Convert(tc.c, tc.m, tc.y, 0)
+			rgb := [3]uint8{r, g, b}
+			for i, v := range rgb {
+				if i != tc.absorbedCh && v <= rgb[tc.absorbedCh]+64 {
+					t.Errorf("got (%d, %d, %d), want channel %d well below the others", r, g, b, tc.absorbedCh)
+				}
+			}
+		})
+	}
+
+	// Colors between grid points are interpolated smoothly.
+	prev := uint8(255)
+	for k := 0; k < 256; k++ {
+		v, _, _ := tr.Convert(0, 0, 0, uint8(k))
+		if v > prev {
+			t.Fatalf("K = %d: %d is lighter than %d", k, v, prev)
+		}
+		prev = v
+	}
+}
+
+func diff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// testCMYKLab is a device to PCS mapping for test profiles, returning Lab values normalised as in version 4.
+func testCMYKLab(c, m, y, k float64) [3]float64 {
+	l := 1 - 0.3*c - 0.4*m - 0.1*y - 0.8*k
+	if l < 0 {
+		l = 0
+	}
+	return [3]float64{l, 0.5 - 0.2*c + 0.3*m, 0.5 - 0.1*c + 0.4*y}
+}
+
+// testCMYKGrid samples testCMYKLab at 3 points per axis, with K varying fastest.
+func testCMYKGrid(f func(lab [3]float64, i int)) {
+	for i := 0; i < 81; i++ {
+		f(testCMYKLab(float64(i/27)/2, float64(i/9%3)/2, float64(i/3%3)/2, float64(i%3)/2), i)
+	}
+}
+
+func TestCMYKLUTTypes(t *testing.T) {
+	// lut8Type, with identity tables.
+	mft1 := make([]byte, 48+256*7+81*3)
+	copy(mft1, "mft1")
+	mft1[8], mft1[9], mft1[10] = 4, 3, 3
+	for i := 0; i < 7; i++ {
+		for v := 0; v < 256; v++ {
+			off := 48 + 256*i
+			if i >= 4 {
+				off += 81 * 3
+			}
+			mft1[off+v] = byte(v)
+		}
+	}
+	testCMYKGrid(func(lab [3]float64, i int) {
+		for j, v := range lab {
+			mft1[48+256*4+3*i+j] = byte(v*255 + 0.5)
+		}
+	})
+
+	// lut16Type uses the legacy Lab encoding.
+	var clut []uint16
+	testCMYKGrid(func(lab [3]float64, i int) {
+		for _, v := range lab {
+			clut = append(clut, uint16(v*65280+0.5))
+		}
+	})
+	mft2 := lut16Tag(4, 3, clut)
+
+	// lutAtoBType, with identity A and B curves around a 16-bit CLUT.
+	mAB := make([]byte, 32)
+	copy(mAB, "mAB ")
+	mAB[8], mAB[9] = 4, 3
+	binary.BigEndian.PutUint32(mAB[28:], uint32(len(mAB)))
+	for i := 0; i < 4; i++ {
+		mAB = append(mAB, paraTag(0, []float64{1})...)
+	}
+	binary.BigEndian.PutUint32(mAB[24:], uint32(len(mAB)))
+	grid := make([]byte, 20)
+	copy(grid, []byte{3, 3, 3, 3})
+	grid[16] = 2
+	mAB = append(mAB, grid...)
+	testCMYKGrid(func(lab [3]float64, i int) {
+		for _, v := range lab {
+			mAB = append(mAB, byte(uint16(v*65535+0.5)>>8), byte(uint16(v*65535+0.5)))
+		}
+	})
+	binary.BigEndian.PutUint32(mAB[12:], uint32(len(mAB)))
+	for i := 0; i < 3; i++ {
+		mAB = append(mAB, []byte("curv\x00\x00\x00\x00\x00\x00\x00\x00")...)
+	}
+
+	var want [3]uint8
+	for i, tag := range [][]byte{mft2, mft1, mAB} {
+		p, err := Parse(buildProfile("prtr", "CMYK", "Lab ", []tagData{{"A2B0", tag}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, err := NewCMYKTransform(p, SRGB, RelativeColorimetric)
+		if err != nil {
+			t.Fatalf("%q: %v", tag[:4], err)
+		}
+		m := image.NewCMYK(image.Rect(0, 0, 1, 1))
+		copy(m.Pix, []byte{64, 128, 32, 16})
+		c := tr.ConvertImage(m).Pix
+		if i == 0 {
+			copy(want[:], c)
+			continue
+		}
+		for j := range want {
+			if diff(c[j], want[j]) > 2 {
+				t.Errorf("%q: got %v, want %v", tag[:4], c[:3], want)
+				break
+			}
+		}
+	}
+}
+
+func TestCMYKLUTOversizedGrid(t *testing.T) {
+	// Tags of 8 inputs with 255 grid points each, whose CLUTs would hold more values than an int can count.
+	mft1 := make([]byte, 48+256*11+64)
+	copy(mft1, "mft1")
+	mft1[8], mft1[9], mft1[10] = 8, 3, 255
+	mft2 := make([]byte, 52+2*2*11+64)
+	copy(mft2, "mft2")
+	mft2[8], mft2[9], mft2[10] = 8, 3, 255
+	mft2[49], mft2[51] = 2, 2
+	mAB := make([]byte, 32+20+64)
+	copy(mAB, "mAB ")
+	mAB[8], mAB[9] = 8, 3
+	binary.BigEndian.PutUint32(mAB[24:], 32)
+	for i := 0; i < 8; i++ {
+		mAB[32+i] = 255
+	}
+	mAB[32+16] = 2
+
+	for _, tag := range [][]byte{mft1, mft2, mAB} {
+		p, err := Parse(buildProfile("prtr", "CMYK", "Lab ", []tagData{{"A2B0", tag}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewCMYKTransform(p, SRGB, RelativeColorimetric); err == nil {
+			t.Errorf("%q: no error", tag[:4])
+		}
+	}
+}
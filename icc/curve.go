@@ -0,0 +1,131 @@
+package icc
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// Curve is a tone reproduction curve, mapping encoded device values to linear light. Both inputs and outputs are
+// in the range [0, 1].
+type Curve struct {
+	// Table holds the sampled curve of a curveType tag with more than one entry, equally spaced over the input range.
+	Table []uint16
+	// FunctionType and Params describe a parametricCurveType tag, or a curveType tag holding a single gamma value,
+	// which is stored as function type 0.
+	FunctionType uint16
+	Params       []float64
+}
+
+// parseCurve decodes a curveType ("curv") or parametricCurveType ("para") tag.
+func parseCurve(b []byte) (*Curve, error) {
+	if len(b) < 12 {
+		return nil, FormatError("short curve")
+	}
+	switch string(b[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(b[8:]))
+		if n > (len(b)-12)/2 {
+			return nil, FormatError("curve table out of bounds")
+		}
+		switch n {
+		case 0:
+			return &Curve{Params: []float64{1}}, nil
+		case 1:
+			return &Curve{Params: []float64{float64(binary.BigEndian.Uint16(b[12:])) / 256}}, nil
+		}
+		c := &Curve{Table: make([]uint16, n)}
+		for i := range c.Table {
+			c.Table[i] = binary.BigEndian.Uint16(b[12+2*i:])
+		}
+		return c, nil
+	case "para":
+		nParams := [...]int{1, 3, 4, 5, 7}
+		t := binary.BigEndian.Uint16(b[8:])
+		if int(t) >= len(nParams) {
+			return nil, FormatError("unknown parametric curve type")
+		}
+		if len(b) < 12+4*nParams[t] {
+			return nil, FormatError("short parametric curve")
+		}
+		c := &Curve{FunctionType: t, Params: make([]float64, nParams[t])}
+		for i := range c.Params {
+			c.Params[i] = s15Fixed16(b[12+4*i:])
+		}
+		return c, nil
+	}
+	return nil, FormatError("unknown curve type " + string(b[:4]))
+}
+
+// Eval returns the curve's value at x.
+func (c *Curve) Eval(x float64) float64 {
+	if x <= 0 {
+		x = 0
+	} else if x >= 1 {
+		x = 1
+	}
+	if c.Table != nil {
+		f := x * float64(len(c.Table)-1)
+		i := int(f)
+		if i >= len(c.Table)-1 {
+			return float64(c.Table[len(c.Table)-1]) / 65535
+		}
+		lo, hi := float64(c.Table[i]), float64(c.Table[i+1])
+		return (lo + (hi-lo)*(f-float64(i))) / 65535
+	}
+	p := c.Params
+	var y float64
+	switch c.FunctionType {
+	case 0:
+		y = math.Pow(x, p[0])
+	case 1:
+		if x >= -p[2]/p[1] {
+			y = math.Pow(p[1]*x+p[2], p[0])
+		}
+	case 2:
+		if x >= -p[2]/p[1] {
+			y = math.Pow(p[1]*x+p[2], p[0]) + p[3]
+		} else {
+			y = p[3]
+		}
+	case 3:
+		if x >= p[4] {
+			y = math.Pow(p[1]*x+p[2], p[0])
+		} else {
+			y = p[3] * x
+		}
+	case 4:
+		if x >= p[4] {
+			y = math.Pow(p[1]*x+p[2], p[0]) + p[5]
+		} else {
+			y = p[3]*x + p[6]
+		}
+	}
+	if y < 0 || math.IsNaN(y) {
+		return 0
+	} else if y > 1 {
+		return 1
+	}
+	return y
+}
+
+// Inverse returns the input for which the curve's value is y, assuming that the curve is monotonically
+// non-decreasing, as tone curves are.
+func (c *Curve) Inverse(y float64) float64 {
+	const steps = 1 << 16
+	i := sort.Search(steps+1, func(i int) bool {
+		return c.Eval(float64(i)/steps) >= y
+	})
+	if i == 0 {
+		return 0
+	} else if i > steps {
+		return 1
+	}
+	// Interpolate between the two samples around y.
+	x0, x1 := float64(i-1)/steps, float64(i)/steps
+	y0, y1 := c.Eval(x0), c.Eval(x1)
+	if y1 == y0 {
+		return x1
+	}
+	return x0 + (x1-x0)*(y-y0)/(y1-y0)
+}
@@ -0,0 +1,190 @@
+// Package icc implements parsing of ICC color profiles and conversion of images between the color spaces they
+// describe, so that photos look the same whether or not the software displaying them honours embedded profiles.
+//
+// Only matrix/TRC profiles are supported for conversion, which covers the RGB and gray profiles found in nearly all
+// photos (sRGB, Adobe RGB, Display P3, ProPhoto RGB and the like), in both version 2 and version 4 of the format.
+package icc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const headerLen = 128
+
+// An Intent is an ICC rendering intent, which selects how colors that can't be reproduced in the destination color
+// space are handled.
+type Intent uint32
+
+const (
+	// Perceptual compresses out-of-gamut colors towards neutral, keeping their hue and lightness.
+	Perceptual Intent = iota
+	// RelativeColorimetric reproduces in-gamut colors exactly and clips the rest.
+	RelativeColorimetric
+	Saturation
+	AbsoluteColorimetric
+)
+
+var intentNames = [...]string{"Perceptual", "Relative Colorimetric", "Saturation", "Absolute Colorimetric"}
+
+func (i Intent) String() string {
+	if int(i) < len(intentNames) {
+		return intentNames[i]
+	}
+	return fmt.Sprintf("Intent(%d)", uint32(i))
+}
+
+// A FormatError reports that the input is not a valid ICC profile.
+type FormatError string
+
+func (e FormatError) Error() string { return "icc: invalid profile: " + string(e) }
+
+// ErrUnsupported means that a profile is valid, but can't be used for conversion.
+var ErrUnsupported = errors.New("icc: unsupported profile: only matrix/TRC profiles can be converted")
+
+// Header is the fixed-size header at the start of every ICC profile. Signatures are kept as 4-character strings,
+// including any trailing spaces, e.g. "RGB ".
+type Header struct {
+	// Size is the length of the profile in bytes.
+	Size uint32
+	// CMM is the preferred color management module, e.g. "lcms" or "appl".
+	CMM string
+	// Version is the profile's version in the header's binary coded decimal form, e.g. 0x02100000 for 2.1 and
+	// 0x04300000 for 4.3.
+	Version uint32
+	// Class is the profile/device class, e.g. "mntr" for display profiles.
+	Class string
+	// ColorSpace is the data color space, e.g. "RGB " or "GRAY".
+	ColorSpace string
+	// PCS is the profile connection space, either "XYZ " or "Lab ".
+	PCS string
+	// Created is the date and time that the profile was created.
+	Created time.Time
+	// Platform is the primary platform, e.g. "APPL" or "MSFT".
+	Platform     string
+	Flags        uint32
+	Manufacturer string
+	Model        string
+	Attributes   uint64
+	// Intent is the profile's default rendering intent.
+	Intent Intent
+	// Illuminant is the PCS illuminant, which is always D50 in practice.
+	Illuminant XYZ
+	Creator    string
+	// ID is the MD5 checksum of the profile, or all zeroes if it wasn't computed.
+	ID [16]byte
+}
+
+// VersionString returns the profile's version in "major.minor.bugfix" form, e.g. "4.3.0".
+func (h Header) VersionString() string {
+	return fmt.Sprintf("%d.%d.%d", h.Version>>24, h.Version>>20&0xf, h.Version>>16&0xf)
+}
+
+// Tag is an entry in a profile's tag table.
+type Tag struct {
+	// Signature identifies the tag, e.g. "desc" or "rTRC".
+	Signature string
+	// Offset and Size locate the tag's data in the profile.
+	Offset, Size uint32
+}
+
+// Profile is a parsed ICC profile.
+type Profile struct {
+	Header
+
+	data  []byte
+	table []Tag
+	tags  map[string][]byte
+}
+
+// Parse parses the ICC profile in b. The profile keeps a reference to b, so it shouldn't be modified afterwards.
+func Parse(b []byte) (*Profile, error) {
+	if len(b) < headerLen+4 {
+		return nil, FormatError("too short")
+	}
+	size := binary.BigEndian.Uint32(b)
+	if size < headerLen+4 || int64(size) > int64(len(b)) {
+		return nil, FormatError("bad profile size")
+	}
+	b = b[:size]
+	if string(b[36:40]) != "acsp" {
+		return nil, FormatError("missing acsp signature")
+	}
+	p := &Profile{
+		Header: Header{
+			Size:         size,
+			CMM:          string(b[4:8]),
+			Version:      binary.BigEndian.Uint32(b[8:]),
+			Class:        string(b[12:16]),
+			ColorSpace:   string(b[16:20]),
+			PCS:          string(b[20:24]),
+			Created:      dateTime(b[24:36]),
+			Platform:     string(b[40:44]),
+			Flags:        binary.BigEndian.Uint32(b[44:]),
+			Manufacturer: string(b[48:52]),
+			Model:        string(b[52:56]),
+			Attributes:   binary.BigEndian.Uint64(b[56:]),
+			Intent:       Intent(binary.BigEndian.Uint32(b[64:]) & 0xffff),
+			Illuminant:   xyzNumber(b[68:]),
+			Creator:      string(b[80:84]),
+		},
+		data: b,
+		tags: map[string][]byte{},
+	}
+	copy(p.ID[:], b[84:100])
+
+	n := binary.BigEndian.Uint32(b[headerLen:])
+	if uint64(n)*12 > uint64(len(b)-headerLen-4) {
+		return nil, FormatError("tag count too large")
+	}
+	p.table = make([]Tag, n)
+	for i := range p.table {
+		e := b[headerLen+4+12*i:]
+		t := Tag{
+			Signature: string(e[:4]),
+			Offset:    binary.BigEndian.Uint32(e[4:]),
+			Size:      binary.BigEndian.Uint32(e[8:]),
+		}
+		if uint64(t.Offset)+uint64(t.Size) > uint64(len(b)) {
+			return nil, FormatError(fmt.Sprintf("tag %q out of bounds", t.Signature))
+		}
+		p.table[i] = t
+		p.tags[t.Signature] = b[t.Offset : t.Offset+t.Size]
+	}
+	return p, nil
+}
+
+// Bytes returns the profile's encoded form.
+func (p *Profile) Bytes() []byte {
+	return p.data
+}
+
+// Tags returns the profile's tag table, in the order it is stored.
+func (p *Profile) Tags() []Tag {
+	return p.table
+}
+
+// TagData returns the raw data of the tag with the given signature, including its type signature, or nil if the
+// profile doesn't have that tag.
+func (p *Profile) TagData(sig string) []byte {
+	return p.tags[sig]
+}
+
+// dateTime decodes a dateTimeNumber.
+func dateTime(b []byte) time.Time {
+	var v [6]int
+	for i := range v {
+		v[i] = int(binary.BigEndian.Uint16(b[2*i:]))
+	}
+	if v[0] == 0 {
+		return time.Time{}
+	}
+	return time.Date(v[0], time.Month(v[1]), v[2], v[3], v[4], v[5], 0, time.UTC)
+}
+
+// s15Fixed16 decodes a signed 15.16 fixed point number.
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
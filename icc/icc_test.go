@@ -0,0 +1,147 @@
+package icc
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	p, err := Parse(SRGBData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Version != 0x04300000 || p.Class != "mntr" || p.ColorSpace != "RGB " || p.PCS != "XYZ " {
+		t.Errorf("unexpected header %+v", p.Header)
+	}
+	if v := p.VersionString(); v != "4.3.0" {
+		t.Errorf("VersionString() = %q, want 4.3.0", v)
+	}
+	if want := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC); !p.Created.Equal(want) {
+		t.Errorf("Created = %v, want %v", p.Created, want)
+	}
+	if p.Intent != Perceptual || p.Intent.String() != "Perceptual" {
+		t.Errorf("Intent = %v, want Perceptual", p.Intent)
+	}
+	if math.Abs(p.Illuminant.X-d50[0]) > 1e-4 || math.Abs(p.Illuminant.Z-d50[2]) > 1e-4 {
+		t.Errorf("Illuminant = %v, want %v", p.Illuminant, d50)
+	}
+	if n := len(p.Tags()); n != 10 {
+		t.Errorf("got %d tags, want 10", n)
+	}
+	for _, tag := range p.Tags() {
+		if int(tag.Size) != len(p.TagData(tag.Signature)) {
+			t.Errorf("tag %q: size %d, data %d bytes", tag.Signature, tag.Size, len(p.TagData(tag.Signature)))
+		}
+	}
+	wtpt, err := p.XYZ("wtpt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(wtpt.X-d50[0]) > 1e-4 || math.Abs(wtpt.Y-d50[1]) > 1e-4 || math.Abs(wtpt.Z-d50[2]) > 1e-4 {
+		t.Errorf("wtpt = %v, want %v", wtpt, d50)
+	}
+
+	for _, b := range [][]byte{nil, SRGBData[:100], append([]byte{0, 0, 0xff, 0xff}, SRGBData[4:]...)} {
+		if _, err := Parse(b); err == nil {
+			t.Errorf("Parse of %d bytes succeeded, want error", len(b))
+		}
+	}
+}
+
+func TestCurve(t *testing.T) {
+	testCases := []struct {
+		tag  []byte
+		x, y float64
+	}{
+		{paraTag(3, srgbTRC), 0.5, 0.2140},
+		{paraTag(3, srgbTRC), 0.02, 0.02 / 12.92},
+		{paraTag(0, []float64{2.2}), 0.5, 0.2176},
+		{[]byte("curv\x00\x00\x00\x00\x00\x00\x00\x00"), 0.3, 0.3},
+		{[]byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x00"), 0.5, 0.25},
+		{[]byte("curv\x00\x00\x00\x00\x00\x00\x00\x03\x00\x00\x40\x00\xff\xff"), 0.25, 0.125},
+	}
+	for _, tc := range testCases {
+		c, err := parseCurve(tc.tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if y := c.Eval(tc.x); math.Abs(y-tc.y) > 1e-3 {
+			t.Errorf("%q: Eval(%v) = %v, want %v", tc.tag[:4], tc.x, y, tc.y)
+		}
+		if x := c.Inverse(tc.y); math.Abs(x-tc.x) > 1e-3 {
+			t.Errorf("%q: Inverse(%v) = %v, want %v", tc.tag[:4], tc.y, x, tc.x)
+		}
+	}
+}
+
+func TestTags(t *testing.T) {
+	desc := append([]byte("desc\x00\x00\x00\x00\x00\x00\x00\x0cAdobe RGB\x00\x00\x00"), make([]byte, 67)...)
+	mluc := mlucTag("Farbe")
+	copy(mluc[16:], "deDE")
+	p, err := Parse(buildProfile("mntr", "RGB ", "XYZ ", []tagData{
+		{"desc", desc},
+		{"cprt", []byte("text\x00\x00\x00\x00Public domain\x00")},
+		{"dmdd", mluc},
+		{"chad", sf32Tag([]float64{1, 0, 0, 0, 1, 0, 0, 0, 1})},
+		{"rTRC", paraTag(0, []float64{2.2})},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		sig, want string
+	}{
+		{"desc", "Adobe RGB"},
+		{"cprt", "Public domain"},
+		{"dmdd", "Farbe"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.sig, func(t *testing.T) {
+			s, err := p.Text(tc.sig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s != tc.want {
+				t.Errorf("Text(%q) = %q, want %q", tc.sig, s, tc.want)
+			}
+		})
+	}
+
+	recs, err := p.MultiLocalizedText("dmdd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0] != (LocalizedString{"de", "DE", "Farbe"}) {
+		t.Errorf("MultiLocalizedText = %+v", recs)
+	}
+	if s, err := SRGB.Description(); err != nil || s != "sRGB" {
+		t.Errorf("Description() = %q, %v, want sRGB", s, err)
+	}
+
+	chad, err := p.ChromaticAdaptation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chad != [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}} {
+		t.Errorf("ChromaticAdaptation() = %v, want identity", chad)
+	}
+	c, err := p.Curve("rTRC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.FunctionType != 0 || math.Abs(c.Params[0]-2.2) > 1e-4 {
+		t.Errorf("Curve(rTRC) = %+v, want gamma 2.2", c)
+	}
+
+	if _, err := p.XYZ("wtpt"); err == nil {
+		t.Error("XYZ of missing tag succeeded, want error")
+	}
+	if _, err := p.XYZ("desc"); err == nil {
+		t.Error("XYZ of text tag succeeded, want error")
+	}
+	if _, err := p.Text("chad"); err == nil {
+		t.Error("Text of sf32 tag succeeded, want error")
+	}
+}
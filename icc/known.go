@@ -0,0 +1,192 @@
+package icc
+
+import (
+	"crypto/md5"
+	"fmt"
+	"math"
+)
+
+// Known is a well-known color space, which many different profiles describe. Phones and cameras often embed
+// multi-kilobyte profiles for color spaces that a few hundred bytes are enough for.
+type Known int
+
+const (
+	Unknown Known = iota
+	KnownSRGB
+	KnownDisplayP3
+	KnownAdobeRGB
+	KnownSGray
+)
+
+var knownNames = [...]string{"Unknown", "sRGB", "Display P3", "Adobe RGB (1998)", "sGray"}
+
+func (k Known) String() string {
+	if k >= 0 && int(k) < len(knownNames) {
+		return knownNames[k]
+	}
+	return fmt.Sprintf("Known(%d)", int(k))
+}
+
+// Data returns the canonical, compact profile for the color space, or nil for Unknown.
+func (k Known) Data() []byte {
+	switch k {
+	case KnownSRGB:
+		return SRGBData
+	case KnownDisplayP3:
+		return DisplayP3Data
+	case KnownAdobeRGB:
+		return AdobeRGBData
+	case KnownSGray:
+		return SGrayData
+	}
+	return nil
+}
+
+var (
+	// knownProfiles holds the parsed canonical profiles of the well-known color spaces.
+	knownProfiles = map[Known]*Profile{}
+	// knownIDs maps profile IDs to the color space of the profile.
+	knownIDs = map[[16]byte]Known{}
+)
+
+func init() {
+	for k := KnownSRGB; k <= KnownSGray; k++ {
+		p := mustParse(k.Data())
+		knownProfiles[k] = p
+		knownIDs[p.ID] = k
+	}
+}
+
+// Identify returns the well-known color space that p describes, if any. Profiles are matched by their ID first, and
+// then by comparing their colorants and tone curves with those of the canonical profiles, so that profiles from
+// different vendors for the same color space are recognised. Media white points aren't compared, as they differ
+// between version 2 and version 4 profiles without changing how colors are rendered with the perceptual and relative
+// colorimetric intents. Profiles with look-up tables are only matched by their ID.
+func Identify(p *Profile) Known {
+	id := p.ID
+	if id == ([16]byte{}) {
+		id = ComputeID(p.data)
+	}
+	if k, ok := knownIDs[id]; ok {
+		return k
+	}
+	if p.PCS != "XYZ " {
+		return Unknown
+	}
+	for k := KnownSRGB; k <= KnownSGray; k++ {
+		if equivalent(p, knownProfiles[k]) {
+			return k
+		}
+	}
+	return Unknown
+}
+
+// Compact returns the canonical profile for the color space that the profile in b describes, if it is a well-known
+// one and the canonical profile is smaller, and b itself otherwise. With dropSRGB, nil is returned for sRGB profiles,
+// as images without a profile are assumed to be sRGB.
+func Compact(b []byte, dropSRGB bool) []byte {
+	p, err := Parse(b)
+	if err != nil {
+		return b
+	}
+	k := Identify(p)
+	if k == KnownSRGB && dropSRGB {
+		return nil
+	}
+	if c := k.Data(); c != nil && len(c) < len(b) {
+		return c
+	}
+	return b
+}
+
+// ComputeID returns the profile ID of the profile in b, which is the MD5 checksum of the profile with its flags,
+// rendering intent and profile ID fields set to zero.
+func ComputeID(b []byte) [16]byte {
+	h := md5.New()
+	var zero [16]byte
+	if len(b) < headerLen {
+		h.Write(b)
+	} else {
+		h.Write(b[:44])
+		h.Write(zero[:4]) // Profile flags.
+		h.Write(b[48:64])
+		h.Write(zero[:4]) // Rendering intent.
+		h.Write(b[68:84])
+		h.Write(zero[:]) // Profile ID.
+		h.Write(b[100:])
+	}
+	var id [16]byte
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+const (
+	// colorantTolerance is how far apart colorant XYZ values can be for profiles to be equivalent. Vendors round
+	// the same primaries differently.
+	colorantTolerance = 0.002
+	// curveTolerance is how far apart tone curves can be for profiles to be equivalent, which distinguishes the sRGB
+	// curve from a pure 2.2 gamma.
+	curveTolerance = 0.001
+)
+
+// equivalent reports whether the matrix/TRC profiles p and q render colors in the same way. Profiles with look-up
+// tables aren't equivalent to any other, as CMMs use the tables instead of the colorants and tone curves.
+func equivalent(p, q *Profile) bool {
+	if p.ColorSpace != q.ColorSpace || hasLUTs(p) || hasLUTs(q) {
+		return false
+	}
+	switch p.ColorSpace {
+	case "RGB ":
+		pm, ptrc, err := rgbColorants(p)
+		if err != nil {
+			return false
+		}
+		qm, qtrc, err := rgbColorants(q)
+		if err != nil {
+			return false
+		}
+		for i := range pm {
+			for j := range pm[i] {
+				if math.Abs(pm[i][j]-qm[i][j]) > colorantTolerance {
+					return false
+				}
+			}
+		}
+		for i := range ptrc {
+			if !equivalentCurves(ptrc[i], qtrc[i]) {
+				return false
+			}
+		}
+		return true
+	case "GRAY":
+		pc, err := p.Curve("kTRC")
+		if err != nil {
+			return false
+		}
+		qc, err := q.Curve("kTRC")
+		return err == nil && equivalentCurves(pc, qc)
+	}
+	return false
+}
+
+// hasLUTs reports whether p has AToB, BToA, DToB or BToD tags for any rendering intent.
+func hasLUTs(p *Profile) bool {
+	for _, t := range p.table {
+		switch t.Signature[:3] {
+		case "A2B", "B2A", "D2B", "B2D":
+			return true
+		}
+	}
+	return false
+}
+
+func equivalentCurves(a, b *Curve) bool {
+	const samples = 64
+	for i := 0; i <= samples; i++ {
+		x := float64(i) / samples
+		if math.Abs(a.Eval(x)-b.Eval(x)) > curveTolerance {
+			return false
+		}
+	}
+	return true
+}
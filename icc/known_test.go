@@ -0,0 +1,117 @@
+package icc
+
+import (
+	"bytes"
+	"testing"
+)
+
+// curvTag encodes a curveType tag sampling f at n points.
+func curvTag(n int, f func(float64) float64) []byte {
+	b := append([]byte("curv\x00\x00\x00\x00"), byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	for i := 0; i < n; i++ {
+		v := uint16(f(float64(i)/float64(n-1))*65535 + 0.5)
+		b = append(b, byte(v>>8), byte(v))
+	}
+	return b
+}
+
+func TestIdentify(t *testing.T) {
+	srgb := (&Curve{FunctionType: 3, Params: srgbTRC}).Eval
+	// A version 2 style sRGB profile, as embedded by many cameras and editors, with sampled tone curves, a D65 media
+	// white point and colorants rounded differently.
+	largeSRGB := buildProfile("mntr", "RGB ", "XYZ ", []tagData{
+		{"desc", append([]byte("desc\x00\x00\x00\x00\x00\x00\x00\x12sRGB IEC61966-2.1\x00"), make([]byte, 512)...)},
+		{"wtpt", xyzTag([3]float64{0.95045, 1, 1.08905})},
+		{"rXYZ", xyzTag([3]float64{0.43607, 0.22249, 0.01392})},
+		{"gXYZ", xyzTag([3]float64{0.38515, 0.71687, 0.09708})},
+		{"bXYZ", xyzTag([3]float64{0.14307, 0.06061, 0.71410})},
+		{"rTRC", curvTag(1024, srgb)},
+		{"gTRC", curvTag(1024, srgb)},
+		{"bTRC", curvTag(1024, srgb)},
+	})
+	gamma22 := buildProfile("mntr", "RGB ", "XYZ ", []tagData{
+		{"rXYZ", xyzTag([3]float64{0.4360747, 0.2225045, 0.0139322})},
+		{"gXYZ", xyzTag([3]float64{0.3850649, 0.7168786, 0.0971045})},
+		{"bXYZ", xyzTag([3]float64{0.1430804, 0.0606169, 0.7141733})},
+		{"rTRC", paraTag(0, []float64{2.2})},
+		{"gTRC", paraTag(0, []float64{2.2})},
+		{"bTRC", paraTag(0, []float64{2.2})},
+	})
+	// The same profile with a look-up table for the perceptual intent, which renders colors differently from its
+	// colorants and tone curves.
+	lutSRGB := buildProfile("mntr", "RGB ", "XYZ ", []tagData{
+		{"rXYZ", xyzTag([3]float64{0.43607, 0.22249, 0.01392})},
+		{"gXYZ", xyzTag([3]float64{0.38515, 0.71687, 0.09708})},
+		{"bXYZ", xyzTag([3]float64{0.14307, 0.06061, 0.71410})},
+		{"rTRC", curvTag(1024, srgb)},
+		{"gTRC", curvTag(1024, srgb)},
+		{"bTRC", curvTag(1024, srgb)},
+		{"A2B0", []byte("mAB \x00\x00\x00\x00\x03\x03\x00\x00")},
+	})
+	gray := buildProfile("mntr", "GRAY", "XYZ ", []tagData{
+		{"kTRC", curvTag(256, srgb)},
+	})
+
+	testCases := []struct {
+		name string
+		data []byte
+		want Known
+	}{
+		{"sRGB", SRGBData, KnownSRGB},
+		{"Display P3", DisplayP3Data, KnownDisplayP3},
+		{"Adobe RGB", AdobeRGBData, KnownAdobeRGB},
+		{"sGray", SGrayData, KnownSGray},
+		{"v2 sRGB", largeSRGB, KnownSRGB},
+		{"v2 Adobe RGB", adobeRGB.Bytes(), KnownAdobeRGB},
+		{"sampled gray", gray, KnownSGray},
+		{"gamma 2.2", gamma22, Unknown},
+		{"sRGB with LUT", lutSRGB, Unknown},
+		{"CMYK", GenericCMYKData, Unknown},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := Parse(tc.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k := Identify(p); k != tc.want {
+				t.Errorf("Identify() = %v, want %v", k, tc.want)
+			}
+		})
+	}
+
+	if c := Compact(largeSRGB, false); !bytes.Equal(c, SRGBData) {
+		t.Errorf("Compact of large sRGB profile returned %d bytes, want the %d byte canonical profile", len(c), len(SRGBData))
+	}
+	if c := Compact(largeSRGB, true); c != nil {
+		t.Errorf("Compact with dropSRGB returned %d bytes, want nil", len(c))
+	}
+	if c := Compact(lutSRGB, true); !bytes.Equal(c, lutSRGB) {
+		t.Error("Compact changed a profile with a look-up table")
+	}
+	if c := Compact(gamma22, true); !bytes.Equal(c, gamma22) {
+		t.Error("Compact changed an unknown profile")
+	}
+	if c := Compact(AdobeRGBData, true); !bytes.Equal(c, AdobeRGBData) {
+		t.Error("Compact changed a canonical profile")
+	}
+}
+
+func TestComputeID(t *testing.T) {
+	for _, k := range []Known{KnownSRGB, KnownDisplayP3, KnownAdobeRGB, KnownSGray} {
+		p := knownProfiles[k]
+		if p.ID != ComputeID(p.Bytes()) {
+			t.Errorf("%v: header ID %x, computed %x", k, p.ID, ComputeID(p.Bytes()))
+		}
+	}
+	// The ID doesn't depend on the rendering intent.
+	b := append([]byte(nil), SRGBData...)
+	b[67] = byte(RelativeColorimetric)
+	if ComputeID(b) != SRGB.ID {
+		t.Error("ID changed with the rendering intent")
+	}
+	b[200]++
+	if ComputeID(b) == SRGB.ID {
+		t.Error("ID didn't change with the profile's data")
+	}
+}
@@ -0,0 +1,366 @@
+package icc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// pipeline is a device to PCS conversion stored in a lut8Type ("mft1"), lut16Type ("mft2") or lutAtoBType ("mAB ")
+// tag. Values are normalised to [0, 1] throughout, and stages that are absent are nil.
+type pipeline struct {
+	nIn, nOut int
+	// a holds the input curves, applied before the color look-up table.
+	a []*Curve
+	// grid is the number of grid points along each input dimension of clut, which holds nOut values per point.
+	grid []int
+	clut []float64
+	// m, matrix and b are applied in that order after the color look-up table. Only lutAtoBType tags have a matrix
+	// and b curves; the output tables of the other types are stored in m.
+	m      []*Curve
+	matrix *[12]float64
+	b      []*Curve
+	// legacyLab is whether a Lab PCS is stored in the version 2 16-bit encoding, where 0xff00 is 100 L*.
+	legacyLab bool
+}
+
+// pipeline parses the device to PCS tag for the given rendering intent, falling back to the perceptual one. There is
+// no tag for absolute colorimetric, which uses the relative colorimetric one.
+func (p *Profile) pipeline(intent Intent) (*pipeline, error) {
+	if intent == AbsoluteColorimetric {
+		intent = RelativeColorimetric
+	}
+	sig := "A2B" + string(rune('0'+intent%3))
+	b, ok := p.tags[sig]
+	if !ok {
+		sig = "A2B0"
+		if b, ok = p.tags[sig]; !ok {
+			return nil, ErrUnsupported
+		}
+	}
+	if len(b) < 32 {
+		return nil, FormatError(fmt.Sprintf("short %q tag", sig))
+	}
+	switch string(b[:4]) {
+	case "mft1":
+		return parseLUT8(b)
+	case "mft2":
+		return parseLUT16(b)
+	case "mAB ":
+		return parseLUTAToB(b)
+	}
+	return nil, ErrUnsupported
+}
+
+// parseLUT8 decodes a lut8Type tag. Its matrix only applies to XYZ input, so it is ignored.
+func parseLUT8(b []byte) (*pipeline, error) {
+	l := lutHeader(b)
+	if l == nil {
+		return nil, FormatError("bad lut8Type channels")
+	}
+	nCLUT, ok := clutSize(l.grid, l.nOut, 1, len(b)-48-256*(l.nIn+l.nOut))
+	if !ok {
+		return nil, FormatError("short lut8Type tag")
+	}
+	b = b[48:]
+	table := func() *Curve {
+		c := &Curve{Table: make([]uint16, 256)}
+		for i := range c.Table {
+			c.Table[i] = uint16(b[i]) * 257
+		}
+		b = b[256:]
+		return c
+	}
+	for i := range l.a {
+		l.a[i] = table()
+	}
+	l.clut = make([]float64, nCLUT)
+	for i := range l.clut {
+		l.clut[i] = float64(b[i]) / 255
+	}
+	b = b[len(l.clut):]
+	for i := range l.m {
+		l.m[i] = table()
+	}
+	return l, nil
+}
+
+// parseLUT16 decodes a lut16Type tag. As with lut8Type, the matrix is ignored.
+func parseLUT16(b []byte) (*pipeline, error) {
+	l := lutHeader(b)
+	if l == nil || len(b) < 52 {
+		return nil, FormatError("bad lut16Type channels")
+	}
+	nInEntries := int(binary.BigEndian.Uint16(b[48:]))
+	nOutEntries := int(binary.BigEndian.Uint16(b[50:]))
+	if nInEntries < 2 || nOutEntries < 2 {
+		return nil, FormatError("bad lut16Type table size")
+	}
+	nCLUT, ok := clutSize(l.grid, l.nOut, 2, len(b)-52-2*(nInEntries*l.nIn+nOutEntries*l.nOut))
+	if !ok {
+		return nil, FormatError("short lut16Type tag")
+	}
+	b = b[52:]
+	table := func(n int) *Curve {
+		c := &Curve{Table: make([]uint16, n)}
+		for i := range c.Table {
+			c.Table[i] = binary.BigEndian.Uint16(b[2*i:])
+		}
+		b = b[2*n:]
+		return c
+	}
+	for i := range l.a {
+		l.a[i] = table(nInEntries)
+	}
+	l.clut = make([]float64, nCLUT)
+	for i := range l.clut {
+		l.clut[i] = float64(binary.BigEndian.Uint16(b[2*i:])) / 65535
+	}
+	b = b[2*len(l.clut):]
+	for i := range l.m {
+		l.m[i] = table(nOutEntries)
+	}
+	l.legacyLab = true
+	return l, nil
+}
+
+// lutHeader decodes the header shared by lut8Type and lut16Type tags, returning nil if the channel counts are bad.
+func lutHeader(b []byte) *pipeline {
+	nIn, nOut, points := int(b[8]), int(b[9]), int(b[10])
+	if nIn < 1 || nIn > 8 || nOut != 3 || points < 2 {
+		return nil
+	}
+	l := &pipeline{nIn: nIn, nOut: nOut, a: make([]*Curve, nIn), m: make([]*Curve, nOut), grid: make([]int, nIn)}
+	for i := range l.grid {
+		l.grid[i] = points
+	}
+	return l
+}
+
+// parseLUTAToB decodes a lutAtoBType tag.
+func parseLUTAToB(b []byte) (*pipeline, error) {
+	l := &pipeline{nIn: int(b[8]), nOut: int(b[9])}
+	if l.nIn < 1 || l.nIn > 8 || l.nOut != 3 {
+		return nil, FormatError("bad lutAtoBType channels")
+	}
+	offB := binary.BigEndian.Uint32(b[12:])
+	offMatrix := binary.BigEndian.Uint32(b[16:])
+	offM := binary.BigEndian.Uint32(b[20:])
+	offCLUT := binary.BigEndian.Uint32(b[24:])
+	offA := binary.BigEndian.Uint32(b[28:])
+
+	var err error
+	if l.b, err = curves(b, offB, l.nOut); err != nil {
+		return nil, err
+	}
+	if l.m, err = curves(b, offM, l.nOut); err != nil {
+		return nil, err
+	}
+	if l.a, err = curves(b, offA, l.nIn); err != nil {
+		return nil, err
+	}
+	if offMatrix != 0 {
+		if uint64(offMatrix)+48 > uint64(len(b)) {
+			return nil, FormatError("lutAtoBType matrix out of bounds")
+		}
+		l.matrix = new([12]float64)
+		for i := range l.matrix {
+			l.matrix[i] = s15Fixed16(b[int(offMatrix)+4*i:])
+		}
+	}
+	if offCLUT != 0 {
+		if uint64(offCLUT)+20 > uint64(len(b)) {
+			return nil, FormatError("lutAtoBType CLUT out of bounds")
+		}
+		c := b[offCLUT:]
+		l.grid = make([]int, l.nIn)
+		for i := range l.grid {
+			if l.grid[i] = int(c[i]); l.grid[i] < 2 {
+				return nil, FormatError("bad lutAtoBType grid")
+			}
+		}
+		precision := int(c[16])
+		if precision != 1 && precision != 2 {
+			return nil, FormatError("bad lutAtoBType CLUT precision")
+		}
+		nCLUT, ok := clutSize(l.grid, l.nOut, precision, len(c)-20)
+		if !ok {
+			return nil, FormatError("short lutAtoBType CLUT")
+		}
+		l.clut = make([]float64, nCLUT)
+		for i := range l.clut {
+			if precision == 1 {
+				l.clut[i] = float64(c[20+i]) / 255
+			} else {
+				l.clut[i] = float64(binary.BigEndian.Uint16(c[20+2*i:])) / 65535
+			}
+		}
+	} else if l.nIn != l.nOut {
+		return nil, FormatError("lutAtoBType without CLUT changes channel count")
+	}
+	return l, nil
+}
+
+// curves decodes the n consecutive curves at offset in b, which are each padded to 4 bytes. An offset of 0 means
+// that there are no curves.
+func curves(b []byte, offset uint32, n int) ([]*Curve, error) {
+	if offset == 0 {
+		return nil, nil
+	}
+	cs := make([]*Curve, n)
+	for i := range cs {
+		if uint64(offset)+12 > uint64(len(b)) {
+			return nil, FormatError("lutAtoBType curve out of bounds")
+		}
+		c, err := parseCurve(b[offset:])
+		if err != nil {
+			return nil, err
+		}
+		cs[i] = c
+		offset += (curveSize(b[offset:]) + 3) &^ 3
+	}
+	return cs, nil
+}
+
+// curveSize returns the encoded length of the curve at the start of b, which has already been parsed successfully.
+func curveSize(b []byte) uint32 {
+	if string(b[:4]) == "curv" {
+		return 12 + 2*binary.BigEndian.Uint32(b[8:])
+	}
+	return 12 + 4*uint32([...]int{1, 3, 4, 5, 7}[binary.BigEndian.Uint16(b[8:])])
+}
+
+// clutSize returns the number of values in a color look-up table with the given grid points along each input and
+// nOut outputs, and whether they fit in the given number of bytes with entrySize bytes each. The size is checked at
+// each step, so that large grids don't overflow.
+func clutSize(grid []int, nOut, entrySize, bytes int) (int, bool) {
+	n := nOut
+	if n*entrySize > bytes {
+		return 0, false
+	}
+	for _, g := range grid {
+		if n *= g; n*entrySize > bytes {
+			return 0, false
+		}
+	}
+	return n, true
+}
+
+// eval runs the device values in through the pipeline, returning normalised PCS values.
+func (l *pipeline) eval(in []float64) [3]float64 {
+	v := make([]float64, len(in))
+	for i, x := range in {
+		v[i] = evalCurve(l.a, i, x)
+	}
+	if l.clut != nil {
+		v = l.interpolate(v)
+	}
+	var out [3]float64
+	for i := range out {
+		out[i] = evalCurve(l.m, i, v[i])
+	}
+	if l.matrix != nil {
+		m := l.matrix
+		out = [3]float64{
+			m[0]*out[0] + m[1]*out[1] + m[2]*out[2] + m[9],
+			m[3]*out[0] + m[4]*out[1] + m[5]*out[2] + m[10],
+			m[6]*out[0] + m[7]*out[1] + m[8]*out[2] + m[11],
+		}
+	}
+	for i := range out {
+		out[i] = evalCurve(l.b, i, out[i])
+	}
+	return out
+}
+
+func evalCurve(cs []*Curve, i int, x float64) float64 {
+	if cs == nil {
+		return math.Max(0, math.Min(1, x))
+	}
+	return cs[i].Eval(x)
+}
+
+// interpolate looks v up in the color look-up table, interpolating linearly between the grid points around it along
+// every dimension. The first input dimension varies slowest in the table.
+func (l *pipeline) interpolate(v []float64) []float64 {
+	base := 0
+	frac := make([]float64, l.nIn)
+	strides := make([]int, l.nIn)
+	stride := l.nOut
+	for i := l.nIn - 1; i >= 0; i-- {
+		strides[i] = stride
+		f := math.Max(0, math.Min(1, v[i])) * float64(l.grid[i]-1)
+		j := int(f)
+		if j >= l.grid[i]-1 {
+			j = l.grid[i] - 2
+		}
+		frac[i] = f - float64(j)
+		base += j * stride
+		stride *= l.grid[i]
+	}
+	out := make([]float64, l.nOut)
+	for corner := 0; corner < 1<<uint(l.nIn); corner++ {
+		w, off := 1.0, base
+		for i := 0; i < l.nIn; i++ {
+			if corner&(1<<uint(i)) != 0 {
+				w *= frac[i]
+				off += strides[i]
+			} else {
+				w *= 1 - frac[i]
+			}
+		}
+		if w == 0 {
+			continue
+		}
+		for o := range out {
+			out[o] += w * l.clut[off+o]
+		}
+	}
+	return out
+}
+
+// toXYZ converts normalised PCS values from the pipeline to XYZ relative to the D50 illuminant.
+func (l *pipeline) toXYZ(pcs string, v [3]float64) [3]float64 {
+	if pcs == "XYZ " {
+		// XYZ is encoded as u1Fixed15Number, where 0xffff is 1 + 32767/32768.
+		const scale = 65535.0 / 32768
+		return [3]float64{v[0] * scale, v[1] * scale, v[2] * scale}
+	}
+	var lab [3]float64
+	if l.legacyLab {
+		lab = [3]float64{v[0] * 65535 / 652.8, v[1]*65535/256 - 128, v[2]*65535/256 - 128}
+	} else {
+		lab = [3]float64{v[0] * 100, v[1]*255 - 128, v[2]*255 - 128}
+	}
+	return labToXYZ(lab)
+}
+
+// labToXYZ converts CIE L*a*b* to XYZ, both relative to the D50 illuminant.
+func labToXYZ(lab [3]float64) [3]float64 {
+	fy := (lab[0] + 16) / 116
+	f := [3]float64{fy + lab[1]/500, fy, fy - lab[2]/200}
+	var xyz [3]float64
+	for i, t := range f {
+		if t > 6.0/29 {
+			xyz[i] = t * t * t
+		} else {
+			xyz[i] = 3 * (6.0 / 29) * (6.0 / 29) * (t - 4.0/29)
+		}
+		xyz[i] *= d50[i]
+	}
+	return xyz
+}
+
+// xyzToLab converts XYZ to CIE L*a*b*, both relative to the D50 illuminant.
+func xyzToLab(xyz [3]float64) [3]float64 {
+	var f [3]float64
+	for i := range f {
+		t := xyz[i] / d50[i]
+		if t > 216.0/24389 {
+			f[i] = math.Cbrt(t)
+		} else {
+			f[i] = t/(3*(6.0/29)*(6.0/29)) + 4.0/29
+		}
+	}
+	return [3]float64{116*f[1] - 16, 500 * (f[0] - f[1]), 200 * (f[1] - f[2])}
+}
@@ -0,0 +1,159 @@
+package icc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+)
+
+// XYZ is a color in the CIE XYZ color space, as used by the profile connection space.
+type XYZ struct {
+	X, Y, Z float64
+}
+
+func xyzNumber(b []byte) XYZ {
+	return XYZ{s15Fixed16(b), s15Fixed16(b[4:]), s15Fixed16(b[8:])}
+}
+
+// LocalizedString is one record of a multiLocalizedUnicodeType tag.
+type LocalizedString struct {
+	// Language is an ISO 639-1 language code, and Country an ISO 3166-1 country code, e.g. "en" and "US".
+	Language, Country string
+	Value             string
+}
+
+// tag returns the data of the tag with the given signature, checking that it is at least n bytes long.
+func (p *Profile) tag(sig string, n int) ([]byte, error) {
+	b, ok := p.tags[sig]
+	if !ok {
+		return nil, fmt.Errorf("icc: missing %q tag", sig)
+	}
+	if len(b) < n {
+		return nil, FormatError(fmt.Sprintf("short %q tag", sig))
+	}
+	return b, nil
+}
+
+// XYZ decodes the first value of an XYZType tag, such as "wtpt" or "rXYZ".
+func (p *Profile) XYZ(sig string) (XYZ, error) {
+	b, err := p.tag(sig, 20)
+	if err != nil {
+		return XYZ{}, err
+	}
+	if string(b[:4]) != "XYZ " {
+		return XYZ{}, FormatError(fmt.Sprintf("%q tag is not XYZType", sig))
+	}
+	return xyzNumber(b[8:]), nil
+}
+
+// Curve decodes a curveType or parametricCurveType tag, such as "rTRC".
+func (p *Profile) Curve(sig string) (*Curve, error) {
+	b, err := p.tag(sig, 12)
+	if err != nil {
+		return nil, err
+	}
+	return parseCurve(b)
+}
+
+// ChromaticAdaptation decodes the "chad" tag, the matrix that adapts colors from the actual illuminant of the
+// device to the D50 illuminant of the PCS.
+func (p *Profile) ChromaticAdaptation() ([3][3]float64, error) {
+	var m [3][3]float64
+	b, err := p.tag("chad", 8+9*4)
+	if err != nil {
+		return m, err
+	}
+	if string(b[:4]) != "sf32" {
+		return m, FormatError(`"chad" tag is not s15Fixed16ArrayType`)
+	}
+	for i := 0; i < 9; i++ {
+		m[i/3][i%3] = s15Fixed16(b[8+4*i:])
+	}
+	return m, nil
+}
+
+// MultiLocalizedText decodes a multiLocalizedUnicodeType tag into its records.
+func (p *Profile) MultiLocalizedText(sig string) ([]LocalizedString, error) {
+	b, err := p.tag(sig, 16)
+	if err != nil {
+		return nil, err
+	}
+	if string(b[:4]) != "mluc" {
+		return nil, FormatError(fmt.Sprintf("%q tag is not multiLocalizedUnicodeType", sig))
+	}
+	n := int(binary.BigEndian.Uint32(b[8:]))
+	size := int(binary.BigEndian.Uint32(b[12:]))
+	if size < 12 || n > (len(b)-16)/size {
+		return nil, FormatError(fmt.Sprintf("bad %q records", sig))
+	}
+	recs := make([]LocalizedString, n)
+	for i := range recs {
+		r := b[16+i*size:]
+		length := uint64(binary.BigEndian.Uint32(r[4:]))
+		offset := uint64(binary.BigEndian.Uint32(r[8:]))
+		if offset+length > uint64(len(b)) {
+			return nil, FormatError(fmt.Sprintf("%q record out of bounds", sig))
+		}
+		u := make([]uint16, length/2)
+		for j := range u {
+			u[j] = binary.BigEndian.Uint16(b[offset+2*uint64(j):])
+		}
+		recs[i] = LocalizedString{
+			Language: string(r[:2]),
+			Country:  string(r[2:4]),
+			Value:    string(utf16.Decode(u)),
+		}
+	}
+	return recs, nil
+}
+
+// Text decodes a tag holding text, whether stored as a textType, textDescriptionType (version 2) or
+// multiLocalizedUnicodeType (version 4) tag. For the latter, the English record is preferred.
+func (p *Profile) Text(sig string) (string, error) {
+	b, err := p.tag(sig, 12)
+	if err != nil {
+		return "", err
+	}
+	switch string(b[:4]) {
+	case "text":
+		return cString(b[8:]), nil
+	case "desc":
+		n := uint64(binary.BigEndian.Uint32(b[8:]))
+		if 12+n > uint64(len(b)) {
+			return "", FormatError(fmt.Sprintf("%q text out of bounds", sig))
+		}
+		return cString(b[12 : 12+n]), nil
+	case "mluc":
+		recs, err := p.MultiLocalizedText(sig)
+		if err != nil || len(recs) == 0 {
+			return "", err
+		}
+		for _, r := range recs {
+			if r.Language == "en" {
+				return r.Value, nil
+			}
+		}
+		return recs[0].Value, nil
+	}
+	return "", FormatError(fmt.Sprintf("%q tag is not text", sig))
+}
+
+// Description returns the profile's description from its "desc" tag, e.g. "sRGB IEC61966-2.1".
+func (p *Profile) Description() (string, error) {
+	return p.Text("desc")
+}
+
+// Copyright returns the profile's copyright notice from its "cprt" tag.
+func (p *Profile) Copyright() (string, error) {
+	return p.Text("cprt")
+}
+
+// cString returns b up to its first NUL byte.
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,244 @@
+package icc
+
+import (
+	"image"
+	"image/color"
+)
+
+// fromLinearSize is the number of steps in the look-up tables that encode linear light back to 8-bit values.
+const fromLinearSize = 1 << 12
+
+// Transform converts colors from one profile's color space to another's.
+type Transform struct {
+	intent Intent
+	// toLinear maps the source's 8-bit encoded values to linear light, per channel.
+	toLinear [3][256]float64
+	// m maps linear source RGB to linear destination RGB, through the PCS.
+	m [3][3]float64
+	// lum holds the contribution of each destination channel to luminance, for gamut mapping.
+	lum [3]float64
+	// fromLinear maps linear light to the destination's 8-bit encoded values, per channel.
+	fromLinear [3][fromLinearSize + 1]uint8
+}
+
+// NewTransform returns a Transform from src's color space to dst's, using the given rendering intent. src must be
+// an RGB or gray matrix/TRC profile, and dst an RGB one.
+func NewTransform(src, dst *Profile, intent Intent) (*Transform, error) {
+	t := &Transform{intent: intent}
+
+	// Build the source's matrix, whose columns are the PCS values of its primaries.
+	var srcM [3][3]float64
+	switch src.ColorSpace {
+	case "RGB ":
+		m, trc, err := rgbColorants(src)
+		if err != nil {
+			return nil, err
+		}
+		srcM = m
+		for i, c := range trc {
+			for v := range t.toLinear[i] {
+				t.toLinear[i][v] = c.Eval(float64(v) / 255)
+			}
+		}
+	case "GRAY":
+		// Gray values are fed in as equal R, G and B values, each contributing a third of the white point.
+		c, err := src.Curve("kTRC")
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		for i := 0; i < 3; i++ {
+			for j := range d50 {
+				srcM[j][i] = d50[j] / 3
+			}
+			for v := range t.toLinear[i] {
+				t.toLinear[i][v] = c.Eval(float64(v) / 255)
+			}
+		}
+	default:
+		return nil, ErrUnsupported
+	}
+	if src.PCS != "XYZ " || dst.PCS != "XYZ " || dst.ColorSpace != "RGB " {
+		return nil, ErrUnsupported
+	}
+	dstM, trc, err := rgbColorants(dst)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range trc {
+		for v := range t.fromLinear[i] {
+			t.fromLinear[i][v] = uint8(c.Inverse(float64(v)/fromLinearSize)*255 + 0.5)
+		}
+	}
+	t.lum = dstM[1]
+	inv, ok := invert(dstM)
+	if !ok {
+		return nil, FormatError("singular colorant matrix")
+	}
+
+	// Absolute colorimetric keeps the media white of the source, rather than mapping it to the destination's.
+	if intent == AbsoluteColorimetric {
+		srcW, err1 := src.XYZ("wtpt")
+		dstW, err2 := dst.XYZ("wtpt")
+		if err1 == nil && err2 == nil {
+			scale := [3]float64{srcW.X / dstW.X, srcW.Y / dstW.Y, srcW.Z / dstW.Z}
+			for j := range srcM {
+				for i := range srcM[j] {
+					srcM[j][i] *= scale[j]
+				}
+			}
+		}
+	}
+	t.m = multiply(inv, srcM)
+	return t, nil
+}
+
+// Convert converts the 8-bit encoded color (r, g, b).
+func (t *Transform) Convert(r, g, b uint8) (uint8, uint8, uint8) {
+	lr, lg, lb := t.toLinear[0][r], t.toLinear[1][g], t.toLinear[2][b]
+	c := [3]float64{
+		t.m[0][0]*lr + t.m[0][1]*lg + t.m[0][2]*lb,
+		t.m[1][0]*lr + t.m[1][1]*lg + t.m[1][2]*lb,
+		t.m[2][0]*lr + t.m[2][1]*lg + t.m[2][2]*lb,
+	}
+	if t.intent != RelativeColorimetric && t.intent != AbsoluteColorimetric {
+		compress(t.lum, &c)
+	}
+	var out [3]uint8
+	for i, v := range c {
+		if v <= 0 {
+			out[i] = t.fromLinear[i][0]
+		} else if v >= 1 {
+			out[i] = t.fromLinear[i][fromLinearSize]
+		} else {
+			out[i] = t.fromLinear[i][int(v*fromLinearSize+0.5)]
+		}
+	}
+	return out[0], out[1], out[2]
+}
+
+// compress moves an out-of-gamut linear color towards the neutral gray of the same luminance, as weighted by lum,
+// until it fits in the destination gamut. In-gamut colors are unchanged.
+func compress(lum [3]float64, c *[3]float64) {
+	y := lum[0]*c[0] + lum[1]*c[1] + lum[2]*c[2]
+	if y <= 0 {
+		*c = [3]float64{}
+		return
+	} else if y >= 1 {
+		*c = [3]float64{1, 1, 1}
+		return
+	}
+	k := 1.0
+	for _, v := range c {
+		if v > 1 && (1-y)/(v-y) < k {
+			k = (1 - y) / (v - y)
+		} else if v < 0 && y/(y-v) < k {
+			k = y / (y - v)
+		}
+	}
+	if k < 1 {
+		for i, v := range c {
+			c[i] = y + k*(v-y)
+		}
+	}
+}
+
+// ConvertImage converts every pixel of m, returning a new image. Alpha is kept as it is.
+func (t *Transform) ConvertImage(m image.Image) *image.NRGBA {
+	b := m.Bounds()
+	dst := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		pix := dst.Pix[dst.PixOffset(b.Min.X, y):]
+		switch m := m.(type) {
+		case *image.YCbCr:
+			for x := b.Min.X; x < b.Max.X; x++ {
+				yi, ci := m.YOffset(x, y), m.COffset(x, y)
+				r, g, bb := color.YCbCrToRGB(m.Y[yi], m.Cb[ci], m.Cr[ci])
+				pix[0], pix[1], pix[2] = t.Convert(r, g, bb)
+				pix[3] = 0xff
+				pix = pix[4:]
+			}
+		case *image.Gray:
+			for x := b.Min.X; x < b.Max.X; x++ {
+				v := m.Pix[m.PixOffset(x, y)]
+				pix[0], pix[1], pix[2] = t.Convert(v, v, v)
+				pix[3] = 0xff
+				pix = pix[4:]
+			}
+		case *image.NRGBA:
+			src := m.Pix[m.PixOffset(b.Min.X, y):]
+			for x := b.Min.X; x < b.Max.X; x++ {
+				pix[0], pix[1], pix[2] = t.Convert(src[0], src[1], src[2])
+				pix[3] = src[3]
+				pix, src = pix[4:], src[4:]
+			}
+		default:
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+				pix[0], pix[1], pix[2] = t.Convert(c.R, c.G, c.B)
+				pix[3] = c.A
+				pix = pix[4:]
+			}
+		}
+	}
+	return dst
+}
+
+// rgbColorants returns the matrix of an RGB matrix/TRC profile, whose columns are the PCS values of its primaries,
+// and its tone curves.
+func rgbColorants(p *Profile) ([3][3]float64, [3]*Curve, error) {
+	var m [3][3]float64
+	var trc [3]*Curve
+	if p.ColorSpace != "RGB " {
+		return m, trc, ErrUnsupported
+	}
+	for i, sig := range [3]string{"r", "g", "b"} {
+		xyz, err := p.XYZ(sig + "XYZ")
+		if err != nil {
+			return m, trc, ErrUnsupported
+		}
+		m[0][i], m[1][i], m[2][i] = xyz.X, xyz.Y, xyz.Z
+		if trc[i], err = p.Curve(sig + "TRC"); err != nil {
+			return m, trc, ErrUnsupported
+		}
+	}
+	return m, trc, nil
+}
+
+func multiply(a, b [3][3]float64) [3][3]float64 {
+	var m [3][3]float64
+	for i := range m {
+		for j := range m[i] {
+			for k := 0; k < 3; k++ {
+				m[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return m
+}
+
+// invert returns the inverse of m, and whether it exists.
+func invert(m [3][3]float64) ([3][3]float64, bool) {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if det == 0 {
+		return m, false
+	}
+	return [3][3]float64{
+		{
+			(m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det,
+			(m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det,
+			(m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det,
+		},
+		{
+			(m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det,
+			(m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det,
+			(m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det,
+		},
+		{
+			(m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det,
+			(m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det,
+			(m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det,
+		},
+	}, true
+}
@@ -0,0 +1,99 @@
+package icc
+
+import (
+	"image"
+	"testing"
+)
+
+// adobeRGB is a matrix/TRC profile for the Adobe RGB (1998) color space, in the v2 style with a curveType gamma.
+var adobeRGB = mustParse(buildProfile("mntr", "RGB ", "XYZ ", []tagData{
+	{"desc", mlucTag("Adobe RGB (1998)")},
+	{"wtpt", xyzTag(d50)},
+	{"rXYZ", xyzTag([3]float64{0.6097559, 0.3111242, 0.0194811})},
+	{"gXYZ", xyzTag([3]float64{0.2052401, 0.6256560, 0.0608902})},
+	{"bXYZ", xyzTag([3]float64{0.1492240, 0.0632197, 0.7448387})},
+	{"rTRC", []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x33")},
+	{"gTRC", []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x33")},
+	{"bTRC", []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x33")},
+}))
+
+func near(a, b uint8, tolerance int) bool {
+	d := int(a) - int(b)
+	return -tolerance <= d && d <= tolerance
+}
+
+func TestTransformIdentity(t *testing.T) {
+	tr, err := NewTransform(SRGB, SRGB, RelativeColorimetric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v := 0; v < 256; v += 5 {
+		r, g, b := tr.Convert(uint8(v), uint8(255-v), uint8(v/2))
+		if !near(r, uint8(v), 1) || !near(g, uint8(255-v), 1) || !near(b, uint8(v/2), 1) {
+			t.Errorf("Convert(%d, %d, %d) = (%d, %d, %d)", v, 255-v, v/2, r, g, b)
+		}
+	}
+}
+
+func TestTransformAdobeRGB(t *testing.T) {
+	rel, err := NewTransform(adobeRGB, SRGB, RelativeColorimetric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	per, err := NewTransform(adobeRGB, SRGB, Perceptual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Neutrals stay neutral, with a slightly different tone curve.
+	for _, tr := range []*Transform{rel, per} {
+		if r, g, b := tr.Convert(128, 128, 128); !near(r, 128, 2) || r != g || g != b {
+			t.Errorf("gray = (%d, %d, %d), want about 128", r, g, b)
+		}
+		if r, g, b := tr.Convert(255, 255, 255); r != 255 || g != 255 || b != 255 {
+			t.Errorf("white = (%d, %d, %d), want 255", r, g, b)
+		}
+	}
+
+	// Adobe RGB's green is outside the sRGB gamut, so the relative intent
+	// clips it, while the perceptual intent desaturates it.
+	if r, g, b := rel.Convert(0, 255, 0); r != 0 || g != 255 || b != 0 {
+		t.Errorf("relative green = (%d, %d, %d), want (0, 255, 0)", r, g, b)
+	}
+	if r, g, b := per.Convert(0, 255, 0); g == 255 || b == 0 || g <= r || g <= b {
+		t.Errorf("perceptual green = (%d, %d, %d), want a desaturated green", r, g, b)
+	}
+
+	// In-gamut colors are the same for both intents.
+	r0, g0, b0 := rel.Convert(120, 100, 90)
+	r1, g1, b1 := per.Convert(120, 100, 90)
+	if r0 != r1 || g0 != g1 || b0 != b1 {
+		t.Errorf("in-gamut colors differ: (%d, %d, %d) and (%d, %d, %d)", r0, g0, b0, r1, g1, b1)
+	}
+}
+
+func TestConvertImage(t *testing.T) {
+	gray, err := Parse(SGrayData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := NewTransform(gray, SRGB, Perceptual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := image.NewGray(image.Rect(0, 0, 4, 1))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(60 * i)
+	}
+	dst := tr.ConvertImage(m)
+	for x := 0; x < 4; x++ {
+		c := dst.NRGBAAt(x, 0)
+		if !near(c.R, m.Pix[x], 1) || c.R != c.G || c.G != c.B || c.A != 0xff {
+			t.Errorf("pixel %d = %v, want gray %d", x, c, m.Pix[x])
+		}
+	}
+
+	if _, err := NewTransform(SRGB, gray, Perceptual); err != ErrUnsupported {
+		t.Errorf("transform to gray: got %v, want ErrUnsupported", err)
+	}
+}
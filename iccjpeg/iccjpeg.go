@@ -28,26 +28,23 @@ func GetICCRaw(input io.Reader) ([]byte, error) {
 	numMarkers := -1
 	p := NewParser(input)
 	p.ReadSOI()
-	seg, err := p.GetSegment(app2Marker)
-	if err != nil {
-		return nil, err
-	}
-	if seg == nil {
-		// No such segment found
-		return nil, nil
-	}
-	if seg.Size < iccHeaderLen {
-		return nil, nil // errors.New("ICC segment invalid")
+	var seg *Segment
+	for {
+		seg, err = p.GetSegment(app2Marker)
+		if err != nil {
+			return nil, err
+		}
+		if seg == nil {
+			// No such segment found
+			return nil, nil
+		}
+		// Other APP2 segments, such as MPF indexes, may come before the profile
+		if ProfileData(seg.Data) != nil {
+			break
+		}
 	}
 
-	i := 11
-	if string(seg.Data[:i]) != "ICC_PROFILE" || seg.Data[i] != 0 {
-		// We can't handle anything other than ICC_Profile data, so return now
-		return nil, nil
-		// TODO: support data besides ICC Profile
-		//return nil, errors.New(fmt.Sprintf("ICC segment string '%s' or seg.Data %x invalid", seg.Data[:i], seg.Data[i]))
-	}
-	i++
+	i := 12
 
 	seqN := seg.Data[i]
 	i++
@@ -83,3 +80,19 @@ func GetICCRaw(input io.Reader) ([]byte, error) {
 
 	return nil, nil
 }
+
+// ProfileData returns the ICC profile held in the raw APP2 data returned by GetICCRaw, or nil if it doesn't hold one.
+func ProfileData(app2 []byte) []byte {
+	if len(app2) < iccHeaderLen || string(app2[:11]) != "ICC_PROFILE" || app2[11] != 0 {
+		return nil
+	}
+	return app2[iccHeaderLen:]
+}
+
+// App2Data returns the raw APP2 data that embeds the given ICC profile in a single segment, as written by
+// jpeg.Encode.
+func App2Data(profile []byte) []byte {
+	b := make([]byte, iccHeaderLen, iccHeaderLen+len(profile))
+	copy(b, "ICC_PROFILE\x00\x01\x01")
+	return append(b, profile...)
+}
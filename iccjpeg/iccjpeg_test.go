@@ -1,6 +1,7 @@
 package iccjpeg
 
 import (
+	"bytes"
 	"log"
 	"os"
 	"testing"
@@ -30,3 +31,21 @@ func TestGetICCRaw(t *testing.T) {
 		})
 	}
 }
+
+func TestGetICCRawAfterMPF(t *testing.T) {
+	app2 := func(data string) []byte {
+		return append([]byte{0xff, app2Marker, byte((len(data) + 2) >> 8), byte(len(data) + 2)}, data...)
+	}
+	jpeg := []byte{0xff, soiMarker}
+	jpeg = append(jpeg, app2("MPF\x00MM\x00*\x00\x00\x00\x08")...)
+	jpeg = append(jpeg, app2("ICC_PROFILE\x00\x01\x01profile")...)
+	jpeg = append(jpeg, 0xff, eoiMarker)
+
+	b, err := GetICCRaw(bytes.NewReader(jpeg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ProfileData(b)) != "profile" {
+		t.Errorf("got %q, want the profile", b)
+	}
+}
@@ -1,20 +1,48 @@
 package iccjpeg
 
+import "fmt"
+
 const (
 	// JPEG Markers
-	soiMarker  = 0xD8
-	eoiMarker  = 0xD9
-	app0Marker = 0xE0
-	app1Marker = 0xE1
-	app2Marker = 0xE2
-	rst0Marker = 0xD0
-	rst7Marker = 0xD7
+	temMarker   = 0x01
+	sof0Marker  = 0xC0
+	dhtMarker   = 0xC4
+	soiMarker   = 0xD8
+	eoiMarker   = 0xD9
+	app0Marker  = 0xE0
+	app1Marker  = 0xE1
+	app2Marker  = 0xE2
+	rst0Marker  = 0xD0
+	rst7Marker  = 0xD7
+	sosMarker   = 0xDA
+	dqtMarker   = 0xDB
+	driMarker   = 0xDD
+	app15Marker = 0xEF
+	comMarker   = 0xFE
 )
 
 var markerNames = map[byte]string{
-	soiMarker:  "SOI",
-	eoiMarker:  "EOI",
-	app0Marker: "APP0",
-	app1Marker: "APP1",
-	app2Marker: "APP2",
+	temMarker: "TEM",
+	dhtMarker: "DHT",
+	soiMarker: "SOI",
+	eoiMarker: "EOI",
+	sosMarker: "SOS",
+	dqtMarker: "DQT",
+	driMarker: "DRI",
+	comMarker: "COM",
+}
+
+func init() {
+	// SOF markers take up 0xC0 to 0xCF, except for DHT, JPG and DAC.
+	for m := byte(sof0Marker); m <= 0xCF; m++ {
+		if m != dhtMarker && m != 0xC8 && m != 0xCC {
+			markerNames[m] = fmt.Sprintf("SOF%d", m-sof0Marker)
+		}
+	}
+	for m := byte(rst0Marker); m <= rst7Marker; m++ {
+		markerNames[m] = fmt.Sprintf("RST%d", m-rst0Marker)
+	}
+	for m := byte(app0Marker); m <= app15Marker; m++ {
+		markerNames[m] = fmt.Sprintf("APP%d", m-app0Marker)
+	}
 }
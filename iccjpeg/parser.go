@@ -12,9 +12,15 @@ type (
 	Parser struct {
 		count int
 		in    *bufio.Reader
+		// inScan is set after an SOS segment or RSTn marker has been returned by Next, when entropy-coded data follows.
+		inScan bool
+		// done is set once Next has returned the EOI segment.
+		done bool
 	}
 
-	// Segment represents one segment in a JPEG file.
+	// Segment represents one segment in a JPEG file. Offset is the absolute offset in the file of the segment's data,
+	// after its marker and 2-byte length, and Size is the length of the data. Markers without a length, such as SOI,
+	// EOI and RSTn, have no data, and their Offset is just after the marker.
 	Segment struct {
 		MarkerID   byte
 		MarkerName string
@@ -31,193 +37,291 @@ func NewParser(input io.Reader) *Parser {
 	}
 }
 
-// ReadSOI reads the Start of Image marker at the beginning of the JPEG. Always call this before parsing anything else.
+// ReadSOI reads the Start of Image marker at the beginning of the JPEG. Always call this before parsing anything else,
+// except with Next, which reads it by itself.
 func (p *Parser) ReadSOI() error {
-	var buf [1024]byte
-	_, err := io.ReadFull(p.in, buf[0:2])
+	var buf [2]byte
+	n, err := io.ReadFull(p.in, buf[0:2])
+	p.count += n
 	if err != nil {
 		return err
 	}
-	if buf[0] != 0xFF && buf[1] != soiMarker {
+	if buf[0] != 0xFF || buf[1] != soiMarker {
 		return errors.New("no SOI Marker")
 	}
 	return nil
 }
 
-// GetCommonAppSegments parses the JPEG and returns all APP0, APP1, and APP2 segments.
-func (p *Parser) GetCommonAppSegments() ([]Segment, error) {
-	var buf [1024]byte
-	var err error
-	var n int
-	var marker byte
-	segs := []Segment{}
+// readMarker reads up to the next marker and returns it, skipping any stray bytes and fill bytes before it.
+func (p *Parser) readMarker() (byte, error) {
+	var buf [2]byte
+	n, err := io.ReadFull(p.in, buf[0:2])
+	p.count += n
+	if err != nil {
+		return 0, err
+	}
 
-	for {
-		n, err = io.ReadFull(p.in, buf[0:2])
+	// Handle broken jpegs
+	for buf[0] != 0xFF {
+		buf[0] = buf[1]
+		buf[1], err = p.in.ReadByte()
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
-		p.count += n
+		p.count++
+	}
 
-		// Handle broken jpegs
-		for buf[0] != 0xFF {
-			buf[0] = buf[1]
-			buf[1], err = p.in.ReadByte()
-			if err != nil {
-				return nil, err
-			}
+	// Skip stuffing
+	for buf[1] == 0xFF {
+		buf[1], err = p.in.ReadByte()
+		if err != nil {
+			return 0, err
 		}
+		p.count++
+	}
+	return buf[1], nil
+}
 
-		// Skip 00 markers
-		if buf[1] == 0 {
-			continue
+// readSegmentMarker reads up to the next marker that starts a segment or ends the image, skipping the 00 and RSTn
+// markers that can be found in or after entropy-coded data.
+func (p *Parser) readSegmentMarker() (byte, error) {
+	for {
+		marker, err := p.readMarker()
+		if err != nil || marker != 0 && !isRST(marker) {
+			return marker, err
 		}
+	}
+}
 
-		// Skip stuffing
-		for buf[1] == 0xFF {
-			buf[1], err = p.in.ReadByte()
-			if err != nil {
-				return nil, err
-			}
+// readSegment reads the length and data of the segment of the marker that was just read.
+func (p *Parser) readSegment(marker byte) (*Segment, error) {
+	size, n, err := getSize(p.in)
+	p.count += n
+	if err != nil {
+		return nil, err
+	}
+	seg := &Segment{
+		MarkerID:   marker,
+		MarkerName: markerNames[marker],
+		Size:       size,
+		Offset:     p.count,
+		Data:       make([]byte, size),
+	}
+	n, err = io.ReadFull(p.in, seg.Data)
+	p.count += n
+	if err != nil {
+		return nil, err
+	}
+	return seg, nil
+}
+
+// skipSegment skips the length and data of the segment of the marker that was just read.
+func (p *Parser) skipSegment() error {
+	size, n, err := getSize(p.in)
+	p.count += n
+	if err != nil {
+		return err
+	}
+	n64, err := io.CopyN(ioutil.Discard, p.in, int64(size))
+	p.count += int(n64)
+	return err
+}
+
+// marker returns the segment of a marker without a length, which was just read.
+func (p *Parser) marker(marker byte) *Segment {
+	return &Segment{MarkerID: marker, MarkerName: markerNames[marker], Offset: p.count}
+}
+
+// isRST reports whether the marker is one of the RSTn markers, which have no length.
+func isRST(marker byte) bool {
+	return marker >= rst0Marker && marker <= rst7Marker
+}
+
+// GetCommonAppSegments parses the JPEG and returns all APP0, APP1, and APP2 segments.
+func (p *Parser) GetCommonAppSegments() ([]Segment, error) {
+	segs := []Segment{}
+	for {
+		marker, err := p.readSegmentMarker()
+		if err != nil {
+			return nil, err
 		}
 
 		// We reached the end of the image
-		if buf[1] == eoiMarker {
-			break
+		if marker == eoiMarker {
+			return segs, nil
 		}
 
-		if buf[1] == app0Marker || buf[1] == app1Marker || buf[1] == app2Marker {
-			marker = buf[1]
-
-			// Found the marker we're looking for
-			seg := &Segment{
-				MarkerID:   marker,
-				MarkerName: markerNames[marker],
-				Offset:     p.count,
-			}
-			seg.Size, n, err = getSize(p.in)
+		if marker == app0Marker || marker == app1Marker || marker == app2Marker {
+			seg, err := p.readSegment(marker)
 			if err != nil {
 				return nil, err
 			}
-			p.count += n
+			segs = append(segs, *seg)
+		} else if err := p.skipSegment(); err != nil {
+			return nil, err
+		}
+	}
+}
 
-			seg.Data = make([]byte, seg.Size)
-			n, err = io.ReadFull(p.in, seg.Data)
-			p.count += n
+// GetMetadataSegments parses the JPEG up to the start of its image data, and returns all of its APPn and COM segments
+// in the order they appear.
+func (p *Parser) GetMetadataSegments() ([]Segment, error) {
+	segs := []Segment{}
+	for {
+		seg, err := p.ReadMetadataSegment()
+		if err != nil {
+			return nil, err
+		}
+		if seg == nil {
+			return segs, nil
+		}
+		segs = append(segs, *seg)
+	}
+}
 
-			segs = append(segs, *seg)
-		} else {
-			// Skip RST if need be
-			if buf[1] >= rst0Marker && buf[1] <= rst7Marker {
-				continue
-			}
+// ReadMetadataSegment reads the JPEG up to its next APPn or COM segment and returns it, skipping any other segments on
+// the way. nil is returned once the start of the image data is reached, so callers can stop reading as soon as they
+// have found what they are looking for.
+func (p *Parser) ReadMetadataSegment() (*Segment, error) {
+	for {
+		marker, err := p.readSegmentMarker()
+		if err != nil {
+			return nil, err
+		}
 
-			size, n, err := getSize(p.in)
-			if err != nil {
-				return nil, err
-			}
-			p.count += n
+		// Metadata segments all come before the first scan
+		if marker == sosMarker || marker == eoiMarker {
+			return nil, nil
+		}
 
-			// Skip sections we're not looking for
-			n64, err := io.CopyN(ioutil.Discard, p.in, int64(size))
-			if err != nil {
-				return nil, err
-			}
-			p.count += int(n64)
+		if marker >= app0Marker && marker <= app15Marker || marker == comMarker {
+			return p.readSegment(marker)
+		}
+		if err := p.skipSegment(); err != nil {
+			return nil, err
 		}
 	}
-
-	return segs, nil
 }
 
-// GetSegment searches for the given marker and returns the first instance it encounters. Important: This does NOT find
-// multiple instances of segments that might be split up, e.g. APP1.
-func (p *Parser) GetSegment(marker uint8) (*Segment, error) {
-	var buf [1024]byte
-	var err error
-	var n int
+// ReadSegment reads the next segment of the JPEG before its image data, whatever its marker. The SOS segment that
+// starts the image data is returned with its header, after which the rest of the file, starting with the entropy-coded
+// data, can be read from the Parser itself. An EOI segment without data is returned if the image ends first.
+func (p *Parser) ReadSegment() (*Segment, error) {
+	marker, err := p.readSegmentMarker()
+	if err != nil {
+		return nil, err
+	}
+	if marker == eoiMarker {
+		return p.marker(marker), nil
+	}
+	return p.readSegment(marker)
+}
 
+// Next reads the next marker of the JPEG and returns its segment, whatever the marker, so that every segment of the
+// file is returned in order: SOI first, unless ReadSOI was called, and EOI last. The entropy-coded data after each SOS
+// segment is skipped, except for the RSTn markers in it, which are returned as segments of their own. io.EOF is
+// returned after EOI, or at the end of the input between segments.
+func (p *Parser) Next() (*Segment, error) {
+	if p.done {
+		return nil, io.EOF
+	}
+	if p.count == 0 {
+		if err := p.ReadSOI(); err != nil {
+			return nil, err
+		}
+		return p.marker(soiMarker), nil
+	}
 	for {
-		n, err = io.ReadFull(p.in, buf[0:2])
+		var marker byte
+		var err error
+		if p.inScan {
+			marker, err = p.skipScan()
+		} else {
+			marker, err = p.readMarker()
+		}
 		if err != nil {
 			return nil, err
 		}
-		p.count += n
-
-		// Handle broken jpegs
-		for buf[0] != 0xFF {
-			buf[0] = buf[1]
-			buf[1], err = p.in.ReadByte()
-			if err != nil {
-				return nil, err
-			}
+		switch {
+		case marker == 0:
+			continue
+		case marker == eoiMarker:
+			p.inScan, p.done = false, true
+			return p.marker(marker), nil
+		case isRST(marker) || marker == temMarker:
+			return p.marker(marker), nil
 		}
+		p.inScan = marker == sosMarker
+		return p.readSegment(marker)
+	}
+}
 
-		// Skip 00 markers
-		if buf[1] == 0 {
+// skipScan skips the entropy-coded data that follows an SOS segment or RSTn marker, and returns the marker that ends
+// it. 0xFF bytes in the data are followed by a zero byte, which tells them apart from markers.
+func (p *Parser) skipScan() (byte, error) {
+	for {
+		s, err := p.in.ReadSlice(0xFF)
+		p.count += len(s)
+		if err == bufio.ErrBufferFull {
 			continue
+		} else if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		b, err := p.in.ReadByte()
+		for err == nil && b == 0xFF {
+			p.count++
+			b, err = p.in.ReadByte()
+		}
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		p.count++
+		if b != 0 {
+			return b, nil
 		}
+	}
+}
 
-		// Skip stuffing
-		for buf[1] == 0xFF {
-			buf[1], err = p.in.ReadByte()
-			if err != nil {
-				return nil, err
-			}
+// Read reads the JPEG from where parsing stopped, such as the entropy-coded data after the SOS segment returned by
+// ReadSegment.
+func (p *Parser) Read(b []byte) (int, error) {
+	n, err := p.in.Read(b)
+	p.count += n
+	return n, err
+}
+
+// GetSegment searches for the given marker and returns the first instance it encounters. Important: This does NOT find
+// multiple instances of segments that might be split up, e.g. APP1.
+func (p *Parser) GetSegment(marker uint8) (*Segment, error) {
+	for {
+		m, err := p.readSegmentMarker()
+		if err != nil {
+			return nil, err
 		}
 
 		// We reached the end of the image
-		if buf[1] == eoiMarker {
+		if m == eoiMarker {
 			return nil, nil
 		}
 
-		if buf[1] == marker {
+		if m == marker {
 			// Found the marker we're looking for
-			break
-		} else {
-			// Skip RST if need be
-			if buf[1] >= rst0Marker && buf[1] <= rst7Marker {
-				continue
-			}
-
-			size, n, err := getSize(p.in)
-			if err != nil {
-				return nil, err
-			}
-			p.count += n
-
-			// Skip sections we're not looking for
-			n64, err := io.CopyN(ioutil.Discard, p.in, int64(size))
-			if err != nil {
-				return nil, err
-			}
-			p.count += int(n64)
+			return p.readSegment(marker)
 		}
-	}
 
-	seg := &Segment{
-		MarkerID:   marker,
-		MarkerName: markerNames[marker],
-		Offset:     p.count,
-	}
-	seg.Size, n, err = getSize(p.in)
-	if err != nil {
-		return nil, err
+		// Skip sections we're not looking for
+		if err := p.skipSegment(); err != nil {
+			return nil, err
+		}
 	}
-	p.count += n
-
-	seg.Data = make([]byte, seg.Size)
-	n, err = io.ReadFull(p.in, seg.Data)
-	p.count += n
-	return seg, nil
 }
 
 // getSize returns the segment length, the number of bytes read, and any error.
 func getSize(input io.Reader) (int, int, error) {
 	var buf [2]byte
-	_, err := io.ReadFull(input, buf[0:2])
+	n, err := io.ReadFull(input, buf[0:2])
 	if err != nil {
-		return 0, 0, err
+		return 0, n, err
 	}
 
 	ret := int(buf[0])<<8 + int(buf[1]) - 2
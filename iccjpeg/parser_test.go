@@ -1,6 +1,10 @@
 package iccjpeg
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"testing"
@@ -62,3 +66,132 @@ func TestGetSegment(t *testing.T) {
 	}
 	t.Logf("ID %x Name %s Size %d Offset %d", seg.MarkerID, seg.MarkerName, seg.Size, seg.Offset)
 }
+
+func TestGetMetadataSegments(t *testing.T) {
+	f, err := os.Open("../testdata/gopro.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	p := NewParser(f)
+	if err := p.ReadSOI(); err != nil {
+		t.Fatal("ReadSOI failed:", err)
+	}
+	segs, err := p.GetMetadataSegments()
+	if err != nil {
+		t.Fatal("GetMetadataSegments failed:", err)
+	}
+	if len(segs) != 2 {
+		t.Fatalf("got %d segments, want 2", len(segs))
+	}
+	if segs[0].MarkerID != app1Marker || string(segs[0].Data[:6]) != "Exif\x00\x00" || segs[0].Size != len(segs[0].Data) {
+		t.Errorf("first segment is %s of %d bytes, want EXIF APP1", segs[0].MarkerName, segs[0].Size)
+	}
+	if segs[1].MarkerID != app2Marker || string(segs[1].Data[:4]) != "MPF\x00" {
+		t.Errorf("second segment is %s, want MPF APP2", segs[1].MarkerName)
+	}
+	// Offsets are where the data of each segment starts in the file, after the marker and length.
+	if want := 2 + 2 + 2; segs[0].Offset != want {
+		t.Errorf("first segment at offset %d, want %d", segs[0].Offset, want)
+	}
+	if want := segs[0].Offset + segs[0].Size + 2 + 2; segs[1].Offset != want {
+		t.Errorf("second segment at offset %d, want %d", segs[1].Offset, want)
+	}
+}
+
+func TestReadSegment(t *testing.T) {
+	f, err := os.Open("../testdata/gopro.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	p := NewParser(f)
+	if err := p.ReadSOI(); err != nil {
+		t.Fatal("ReadSOI failed:", err)
+	}
+	var markers []byte
+	for {
+		s, err := p.ReadSegment()
+		if err != nil {
+			t.Fatal("ReadSegment failed:", err)
+		}
+		markers = append(markers, s.MarkerID)
+		if s.MarkerID == sosMarker {
+			break
+		}
+	}
+	if len(markers) < 6 || markers[0] != app1Marker || markers[1] != app2Marker {
+		t.Errorf("got markers % x", markers)
+	}
+	// The entropy-coded data follows, and doesn't start with a marker.
+	var b [1]byte
+	if _, err := p.Read(b[:]); err != nil || b[0] == 0xff {
+		t.Errorf("read %#x, %v after the SOS segment", b[0], err)
+	}
+}
+
+// checkOffset checks that seg is where its Offset says in the file b.
+func checkOffset(t *testing.T, b []byte, seg *Segment) {
+	t.Helper()
+	if seg.Data == nil {
+		if seg.Offset < 2 || b[seg.Offset-2] != 0xFF || b[seg.Offset-1] != seg.MarkerID {
+			t.Errorf("%s marker isn't before offset %d", seg.MarkerName, seg.Offset)
+		}
+		return
+	}
+	i := seg.Offset - 4
+	if i < 0 || b[i] != 0xFF || b[i+1] != seg.MarkerID || int(b[i+2])<<8|int(b[i+3]) != seg.Size+2 ||
+		!bytes.Equal(b[seg.Offset:seg.Offset+seg.Size], seg.Data) {
+		t.Errorf("%s segment of %d bytes isn't at offset %d", seg.MarkerName, seg.Size, seg.Offset)
+	}
+}
+
+func TestNext(t *testing.T) {
+	gopro, err := ioutil.ReadFile("../testdata/gopro.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		b    []byte
+		want string
+		err  error
+	}{
+		{"gopro", gopro, "[SOI APP1 APP2 DQT SOF0 DHT SOS EOI]", nil},
+		{
+			"restarts and scans",
+			[]byte("\xFF\xD8\xFF\xDD\x00\x04\x00\x01\xFF\xDA\x00\x03\x01\x12\xFF\x00\x34\xFF\xD0\x56\xFF\xD1" +
+				"\xFF\xC4\x00\x02\xFF\xFF\xDA\x00\x02\x9A\xFF\xFF\xD9trailer"),
+			"[SOI DRI SOS RST0 RST1 DHT SOS EOI]",
+			nil,
+		},
+		{"no EOI", []byte("\xFF\xD8\xFF\xFE\x00\x03a"), "[SOI COM]", nil},
+		{"truncated scan", []byte("\xFF\xD8\xFF\xDA\x00\x02\x12\xFF\x00"), "[SOI SOS]", io.ErrUnexpectedEOF},
+		{"truncated segment", []byte("\xFF\xD8\xFF\xE1\x00\x10ab"), "[SOI]", io.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := NewParser(bytes.NewReader(test.b))
+			var names []string
+			for {
+				seg, err := p.Next()
+				if err != nil {
+					if err != io.EOF && err != test.err {
+						t.Errorf("got error %v, want %v", err, test.err)
+					}
+					break
+				}
+				names = append(names, seg.MarkerName)
+				checkOffset(t, test.b, seg)
+			}
+			if got := fmt.Sprint(names); got != test.want {
+				t.Errorf("got segments %s, want %s", got, test.want)
+			}
+			if _, err := p.Next(); err != io.EOF && err != test.err {
+				t.Errorf("got %v after the last segment, want EOF", err)
+			}
+		})
+	}
+}
@@ -7,9 +7,13 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/snapas/imageorient"
+	"github.com/snapas/img/heif"
 	"github.com/snapas/img/iccjpeg"
+	"github.com/snapas/img/jpeg"
+	"github.com/snapas/img/mpf"
 	"image"
 	"io"
+	"strconv"
 )
 
 // Image contains an image.Image plus any metadata we want to preserve through future image transformations.
@@ -17,22 +21,88 @@ type Image struct {
 	buf   *bytes.Buffer
 	Image image.Image
 	App2  []byte
+	// Exif holds TIFF-structured EXIF metadata, without the "Exif\x00\x00" header that precedes it in JPEG files.
+	Exif []byte
+	// XMP holds an XMP packet.
+	XMP []byte
+	// MPF holds the Multi-Picture Format index of images that are followed by secondary images, such as previews,
+	// depth maps and gain maps, and Secondary holds the JPEG data of each of those, in the order of MPF.Entries[1:].
+	// Encode writes them back after the image, with the offsets in the index updated.
+	MPF       *mpf.Index
+	Secondary [][]byte
+	// GainMap holds the Ultra HDR gain map of HDR photos, which is found among the secondary images by Decode and kept
+	// aligned with the image by transformations. Encode writes it back as the first secondary image.
+	GainMap *GainMap
+	// PNGChunks holds the textual metadata and pixel density of PNG images, from their tEXt, zTXt, iTXt and pHYs
+	// chunks, other than XMP, which EncodePNG writes back. Their ICC profile, EXIF and XMP metadata are kept in App2,
+	// Exif and XMP like those of JPEGs.
+	PNGChunks []PNGChunk
+	// Trailer holds any data that follows the images of a JPEG file, such as the video of a motion photo, which Encode
+	// writes back after them. Set it to nil to strip it, which also turns off the motion photo properties in XMP.
+	Trailer []byte
 }
 
+const (
+	exifHeader = "Exif\x00\x00"
+	xmpHeader  = "http://ns.adobe.com/xap/1.0/\x00"
+)
+
 // Decode decodes an image and changes its orientation according to the EXIF orientation tag (if present), while also
-// preserving any ICC profile (APP2 data) in the returned Image.
+// preserving any ICC profile (APP2 data), EXIF and XMP metadata of JPEGs, PNGs, WebPs and HEIFs in the returned Image.
+// The metadata is updated to describe the upright pixels. Only HEIF files of JPEG images can be decoded; for others,
+// such as HEIC photos, ErrHEIFCoding is returned along with their metadata.
 func Decode(r io.Reader) (Image, string, error) {
 	i := Image{
 		buf: &bytes.Buffer{},
 	}
 	buf := &bytes.Buffer{}
-	var err error
 
 	// Parse out needed metadata we need to retain
 	tr := io.TeeReader(r, buf)
-	i.App2, err = iccjpeg.GetICCRaw(tr)
+	mpfOffset, err := readMetadata(&i, tr)
 	if err != nil {
-		return i, "", fmt.Errorf("GetICCRaw: %s", err)
+		return i, "", fmt.Errorf("readMetadata: %s", err)
+	}
+	if bytes.HasPrefix(buf.Bytes(), []byte{0xff, 0xd8}) {
+		// Secondary images and trailers come after the end of the primary image, so the rest of the file is needed too.
+		if _, err := io.Copy(buf, r); err != nil {
+			return i, "", fmt.Errorf("io.Copy: %s", err)
+		}
+		if i.MPF != nil {
+			readSecondary(&i, buf.Bytes(), mpfOffset)
+		}
+		readTrailer(&i, buf.Bytes(), mpfOffset)
+	}
+	// HEIF files start with the size of their ftyp box, which is far below 64 KiB.
+	if b := buf.Bytes(); bytes.HasPrefix(b, []byte(pngHeader[:2])) || bytes.HasPrefix(b, []byte("RI")) ||
+		bytes.HasPrefix(b, []byte{0, 0}) {
+		if _, err := io.Copy(buf, r); err != nil {
+			return i, "", fmt.Errorf("io.Copy: %s", err)
+		}
+		var readFormatMetadata func(*Image, []byte)
+		switch b := buf.Bytes(); {
+		case bytes.HasPrefix(b, []byte(pngHeader)):
+			readFormatMetadata = readPNGMetadata
+		case isWebP(b):
+			readFormatMetadata = readWebPMetadata
+		case heif.IsHEIF(b):
+			return decodeHEIF(i, b)
+		}
+		if readFormatMetadata != nil {
+			readFormatMetadata(&i, buf.Bytes())
+			// imageorient only finds the orientation of JPEGs, so PNGs and WebPs are turned upright according to their
+			// EXIF metadata.
+			m, s, err := image.Decode(buf)
+			if err != nil {
+				return i, "", fmt.Errorf("image.Decode: %s", err)
+			}
+			i.Image = orient(m, Orientation(i.Exif))
+			fixMetadata(&i)
+			return i, s, nil
+		}
+	}
+	if i.MPF != nil {
+		readGainMap(&i, Orientation(i.Exif))
 	}
 
 	// Fix orientation
@@ -42,9 +112,152 @@ func Decode(r io.Reader) (Image, string, error) {
 	}
 
 	i.Image = ri
+	fixMetadata(&i)
 	return i, s, nil
 }
 
+// readMetadata reads the metadata segments of a JPEG from r into i, returning the offset of the data of its MPF
+// segment, if any. Other formats are left alone.
+func readMetadata(i *Image, r io.Reader) (int, error) {
+	p := iccjpeg.NewParser(r)
+	if err := p.ReadSOI(); err != nil {
+		return 0, nil
+	}
+	segs, err := p.GetMetadataSegments()
+	if err != nil {
+		return 0, err
+	}
+	mpfOffset := 0
+	for _, s := range segs {
+		switch {
+		case s.MarkerID == 0xe1 && i.Exif == nil && bytes.HasPrefix(s.Data, []byte(exifHeader)):
+			i.Exif = s.Data[len(exifHeader):]
+		case s.MarkerID == 0xe1 && i.XMP == nil && bytes.HasPrefix(s.Data, []byte(xmpHeader)):
+			i.XMP = s.Data[len(xmpHeader):]
+		case s.MarkerID == 0xe2 && i.App2 == nil && iccjpeg.ProfileData(s.Data) != nil:
+			// Profiles split over several segments aren't kept.
+			if s.Data[12] == 1 && s.Data[13] == 1 {
+				i.App2 = s.Data
+			}
+		case s.MarkerID == 0xe2 && i.MPF == nil && bytes.HasPrefix(s.Data, []byte(mpf.Header)):
+			// Malformed indexes are dropped, along with the images they point to.
+			if x, err := mpf.Parse(s.Data); err == nil {
+				i.MPF, mpfOffset = x, s.Offset
+			}
+		}
+	}
+	return mpfOffset, nil
+}
+
+// readSecondary copies the secondary images of the MP file in file into i, given the offset of the data of its MPF
+// segment. The MP index is dropped if the images can't be found.
+func readSecondary(i *Image, file []byte, mpfOffset int) {
+	images, err := i.MPF.Images(file, mpfOffset+len(mpf.Header))
+	if err != nil {
+		i.MPF = nil
+		return
+	}
+	for _, b := range images {
+		i.Secondary = append(i.Secondary, append([]byte(nil), b...))
+	}
+}
+
+// fixMetadata updates the EXIF and XMP metadata of i to describe its pixels: the dimensions are set to those of
+// i.Image, and the orientation to normal, as pixels are always kept upright.
+func fixMetadata(i *Image) {
+	b := i.Image.Bounds()
+	w, h := uint32(b.Dx()), uint32(b.Dy())
+	i.Exif = setExifTags(i.Exif, map[uint16]uint32{
+		tagImageWidth:      w,
+		tagImageLength:     h,
+		tagOrientation:     1,
+		tagPixelXDimension: w,
+		tagPixelYDimension: h,
+	})
+	i.XMP = setXMPProperties(i.XMP, map[string]string{
+		"tiff:ImageWidth":      strconv.Itoa(int(w)),
+		"tiff:ImageLength":     strconv.Itoa(int(h)),
+		"tiff:Orientation":     "1",
+		"exif:PixelXDimension": strconv.Itoa(int(w)),
+		"exif:PixelYDimension": strconv.Itoa(int(h)),
+	})
+}
+
+// Encode writes the Image to w in JPEG format with the given options, including any ICC profile (APP2 data), EXIF and
+// XMP metadata, secondary MPF images, gain map and trailer preserved by Decode. Set o.Background to flatten transparent
+// images onto a color other than black. Default parameters are used if a nil *jpeg.Options is passed.
+func Encode(w io.Writer, i Image, o *jpeg.Options) error {
+	meta := &jpeg.Meta{
+		App2: i.App2,
+		Exif: i.Exif,
+		XMP:  i.XMP,
+	}
+	var x mpf.Index
+	secondary := i.Secondary
+	if i.MPF != nil && len(i.Secondary) == len(i.MPF.Entries)-1 {
+		x = *i.MPF
+		x.Entries = append([]mpf.Entry(nil), x.Entries...)
+	} else {
+		x.Entries = []mpf.Entry{{Attribute: uint32(mpf.Baseline)}}
+		secondary = nil
+	}
+	if i.GainMap != nil {
+		// Ultra HDR gain maps come right after the primary image, which lists them in its XMP container directory.
+		gm, err := i.GainMap.encode(o)
+		if err != nil {
+			return err
+		}
+		x.Entries = append(x.Entries[:1], append([]mpf.Entry{{}}, x.Entries[1:]...)...)
+		if x.UIDs != nil {
+			x.UIDs = append(x.UIDs[:1:1], append([][]byte{make([]byte, len(x.UIDs[0]))}, x.UIDs[1:]...)...)
+		}
+		secondary = append([][]byte{gm}, secondary...)
+		meta.XMP = setGainMapDirectory(meta.XMP, len(gm))
+	} else {
+		meta.XMP = removeGainMapDirectory(meta.XMP)
+	}
+	meta.XMP = setTrailerXMP(meta.XMP, i.Trailer)
+	if len(secondary) == 0 {
+		if err := jpeg.Encode(w, i.Image, o, meta); err != nil {
+			return err
+		}
+		_, err := w.Write(i.Trailer)
+		return err
+	}
+
+	// The offsets of the secondary images depend on the size of the encoded image, so it is encoded with the MP index
+	// as it was, and the index is updated in place afterwards, which doesn't change its size.
+	meta.MPF = x.Marshal()
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, i.Image, o, meta); err != nil {
+		return err
+	}
+	b := buf.Bytes()
+	at := bytes.Index(b, meta.MPF)
+	if at < 0 {
+		return fmt.Errorf("Encode: MPF segment not found")
+	}
+	header := at + len(mpf.Header)
+	x.Entries[0].Size, x.Entries[0].Offset = uint32(len(b)), 0
+	end := len(b)
+	for k, s := range secondary {
+		x.Entries[k+1].Size, x.Entries[k+1].Offset = uint32(len(s)), uint32(end-header)
+		end += len(s)
+	}
+	copy(b[at:], x.Marshal())
+
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	for _, s := range secondary {
+		if _, err := w.Write(s); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(i.Trailer)
+	return err
+}
+
 // Len returns the number of bytes of the unread portion of the Image's buffer.
 func (i Image) Len() int {
 	return i.buf.Len()
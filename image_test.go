@@ -1,9 +1,15 @@
 package img
 
 import (
+	"bytes"
+	"image"
+	"image/color"
 	"log"
 	"os"
 	"testing"
+
+	"github.com/snapas/img/jpeg"
+	"github.com/snapas/img/mpf"
 )
 
 func TestDecode(t *testing.T) {
@@ -18,3 +24,123 @@ func TestDecode(t *testing.T) {
 		t.Fatal("Decode failed:", err)
 	}
 }
+
+func TestEncode(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	buf := &bytes.Buffer{}
+	err := Encode(buf, Image{Image: m}, &jpeg.Options{Quality: 90, Background: color.White})
+	if err != nil {
+		t.Fatal("Encode failed:", err)
+	}
+
+	i, _, err := Decode(buf)
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if r, g, b, _ := i.Image.At(8, 8).RGBA(); r>>8 < 0xf0 || g>>8 < 0xf0 || b>>8 < 0xf0 {
+		t.Errorf("transparent pixel is (%d, %d, %d), want white", r>>8, g>>8, b>>8)
+	}
+}
+
+// exifTag returns the value of a single-valued SHORT or LONG tag in IFD0 or the Exif IFD.
+func exifTag(b []byte, tag uint16) (uint32, bool) {
+	order, off, ok := tiffHeader(b)
+	if !ok {
+		return 0, false
+	}
+	for _, ifd := range []int{0, 1} {
+		entries, _, _ := ifdEntries(b, order, off)
+		off = 0
+		for _, e := range entries {
+			t := order.Uint16(e)
+			if t == tagExifIFD && ifd == 0 {
+				off = order.Uint32(e[8:])
+			}
+			if t != tag {
+				continue
+			}
+			if order.Uint16(e[2:]) == typeShort {
+				return uint32(order.Uint16(e[8:])), true
+			}
+			return order.Uint32(e[8:]), true
+		}
+		if off == 0 {
+			break
+		}
+	}
+	return 0, false
+}
+
+func TestDecodeMetadata(t *testing.T) {
+	f, err := os.Open("testdata/gopro.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	i, _, err := Decode(f)
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if len(i.Exif) == 0 {
+		t.Fatal("EXIF wasn't preserved")
+	}
+	b := i.Image.Bounds()
+	for tag, want := range map[uint16]int{tagPixelXDimension: b.Dx(), tagPixelYDimension: b.Dy(), tagOrientation: 1} {
+		if v, ok := exifTag(i.Exif, tag); !ok || int(v) != want {
+			t.Errorf("tag %#04x = %d, %v, want %d", tag, v, ok, want)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	i.XMP = []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`)
+	if err := Encode(buf, i, nil); err != nil {
+		t.Fatal("Encode failed:", err)
+	}
+	j, _, err := Decode(buf)
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if !bytes.Equal(j.Exif, i.Exif) || !bytes.Equal(j.XMP, i.XMP) {
+		t.Error("metadata changed after re-encoding")
+	}
+}
+
+func TestMPF(t *testing.T) {
+	f, err := os.Open("testdata/gopro.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	i, _, err := Decode(f)
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if i.MPF == nil || len(i.MPF.Entries) != 2 || len(i.Secondary) != 1 {
+		t.Fatalf("got MPF %+v with %d secondary images, want 2 entries", i.MPF, len(i.Secondary))
+	}
+	if i.MPF.Entries[1].Type() != mpf.LargeThumbnailVGA || !bytes.HasPrefix(i.Secondary[0], []byte{0xff, 0xd8}) {
+		t.Fatalf("secondary image is a %v", i.MPF.Entries[1].Type())
+	}
+
+	// Re-encoding moves the secondary image, which must still be found through the index.
+	if i, err = Resize(i, 300, 0, Box); err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := Encode(buf, i, nil); err != nil {
+		t.Fatal("Encode failed:", err)
+	}
+	j, _, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if j.MPF == nil || len(j.Secondary) != 1 || !bytes.Equal(j.Secondary[0], i.Secondary[0]) {
+		t.Fatal("secondary image was lost")
+	}
+	primary := j.MPF.Entries[0]
+	if int(primary.Size)+len(i.Secondary[0]) != buf.Len() || primary.Attribute != i.MPF.Entries[0].Attribute {
+		t.Errorf("primary entry is %+v for %d bytes", primary, buf.Len())
+	}
+}
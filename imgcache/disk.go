@@ -0,0 +1,183 @@
+package imgcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Disk is a Cache that keeps up to a number of bytes of data in files in a directory, evicting the least recently used
+// entries first. Each entry is a file named after the hash of its key, in a subdirectory named after the first two hex
+// digits of the hash. The files are indexed in memory, and entries are marked as used by setting their modification
+// time, so that the order of use survives restarts.
+type Disk struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // of *diskEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type diskEntry struct {
+	name string
+	size int64
+}
+
+// NewDisk returns a Disk cache that holds up to maxBytes of data in dir, creating the directory if it doesn't exist.
+// Entries left in it by earlier Disk caches are kept, and evicted first if they are over the size.
+func NewDisk(dir string, maxBytes int64) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	d := &Disk{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+
+	type file struct {
+		diskEntry
+		modTime time.Time
+	}
+	var files []file
+	subdirs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subdirs {
+		if !sub.IsDir() || len(sub.Name()) != 2 {
+			continue
+		}
+		fis, err := ioutil.ReadDir(filepath.Join(dir, sub.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range fis {
+			if fi.Mode().IsRegular() && len(fi.Name()) == 2*sha256.Size && fi.Name()[:2] == sub.Name() {
+				files = append(files, file{diskEntry{fi.Name(), fi.Size()}, fi.ModTime()})
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	for _, f := range files {
+		e := f.diskEntry
+		d.entries[e.name] = d.lru.PushBack(&e)
+		d.size += e.size
+	}
+	d.evict()
+	return d, nil
+}
+
+// fileName returns the name of the file of the entry with the key.
+func fileName(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+func (d *Disk) path(name string) string {
+	return filepath.Join(d.dir, name[:2], name)
+}
+
+// Get returns the data stored under key, and whether it was found. Entries that can't be read are treated as missing.
+func (d *Disk) Get(key string) ([]byte, bool) {
+	name := fileName(key)
+	d.mu.Lock()
+	e, ok := d.entries[name]
+	if ok {
+		d.lru.MoveToFront(e)
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(d.path(name))
+	if err != nil {
+		d.mu.Lock()
+		d.removeElement(name, e)
+		d.mu.Unlock()
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(d.path(name), now, now)
+	return data, true
+}
+
+// Put stores data under key, and evicts the least recently used entries until the cache is within its size. Data
+// larger than the whole cache isn't stored. The file is written under a temporary name and renamed, so that entries
+// are never seen half-written.
+func (d *Disk) Put(key string, data []byte) error {
+	if int64(len(data)) > d.maxBytes {
+		return nil
+	}
+	name := fileName(key)
+	if err := os.MkdirAll(filepath.Join(d.dir, name[:2]), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Join(d.dir, name[:2]), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := os.Rename(tmp.Name(), d.path(name)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	d.remove(name, false)
+	d.entries[name] = d.lru.PushFront(&diskEntry{name, int64(len(data))})
+	d.size += int64(len(data))
+	d.evict()
+	return nil
+}
+
+// Size returns the number of bytes of data in the cache.
+func (d *Disk) Size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.size
+}
+
+// evict removes the least recently used entries until the cache is within its size. d.mu must be held.
+func (d *Disk) evict() {
+	for d.size > d.maxBytes {
+		d.remove(d.lru.Back().Value.(*diskEntry).name, true)
+	}
+}
+
+// removeElement removes the named entry and its file if it is still indexed by e, which it isn't if the file was
+// written again since e was looked up. d.mu must be held.
+func (d *Disk) removeElement(name string, e *list.Element) {
+	if d.entries[name] == e {
+		d.remove(name, true)
+	}
+}
+
+// remove removes the named entry from the index, and its file if removeFile is set. d.mu must be held.
+func (d *Disk) remove(name string, removeFile bool) {
+	if e, ok := d.entries[name]; ok {
+		d.lru.Remove(e)
+		delete(d.entries, name)
+		d.size -= e.Value.(*diskEntry).size
+		if removeFile {
+			os.Remove(d.path(name))
+		}
+	}
+}
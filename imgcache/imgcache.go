@@ -0,0 +1,93 @@
+// Package imgcache caches derived images, such as thumbnails, so that they are only generated once. Derivatives are
+// keyed by a hash of the source image and of the options they were made with, which NewKey computes, and Group makes
+// sure that concurrent requests for the same missing derivative generate it only once.
+package imgcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Cache stores the encoded data of derived images by key. Implementations are safe for concurrent use, and bound the
+// size of what they store by evicting entries.
+type Cache interface {
+	// Get returns the data stored under key, and whether it was found.
+	Get(key string) ([]byte, bool)
+	// Put stores data under key, replacing anything stored under it before. The data mustn't be modified afterwards.
+	Put(key string, data []byte) error
+}
+
+// NewKey returns the key of the image derived from the source image src with the given options, such as a struct of
+// the transform's parameters. The options are serialised canonically as JSON, so only their exported fields count,
+// and maps are serialised in key order.
+func NewKey(src []byte, options interface{}) (string, error) {
+	opts, err := json.Marshal(options)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %s", err)
+	}
+	srcSum := sha256.Sum256(src)
+	h := sha256.New()
+	h.Write(srcSum[:])
+	h.Write(opts)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+var errPanicked = errors.New("imgcache: generating the image panicked")
+
+// Group generates derivatives that are missing from a Cache, only once for concurrent requests with the same key.
+// The zero Group has no cache, but still de-duplicates concurrent requests.
+type Group struct {
+	Cache Cache
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// call is a derivative being generated.
+type call struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// Get returns the data stored under key in the cache, or calls generate to make it, and stores it. Concurrent calls
+// with the same key wait for the first one to generate the data, and share its result, including any error. Errors
+// aren't cached, and neither is data that the cache fails to store, which is returned anyway.
+func (g *Group) Get(key string, generate func() ([]byte, error)) ([]byte, error) {
+	if g.Cache != nil {
+		if data, ok := g.Cache.Get(key); ok {
+			return data, nil
+		}
+	}
+
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		return c.data, c.err
+	}
+	c := &call{done: make(chan struct{})}
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	// Waiting calls get this error if generate panics.
+	c.err = errPanicked
+	c.data, c.err = generate()
+	if c.err == nil && g.Cache != nil {
+		g.Cache.Put(key, c.data)
+	}
+	return c.data, c.err
+}
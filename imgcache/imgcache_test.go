@@ -0,0 +1,228 @@
+package imgcache
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type options struct {
+	Width, Height int
+	Format        string
+	Extra         map[string]string
+}
+
+func TestNewKey(t *testing.T) {
+	src := []byte("source image")
+	key := func(src []byte, o interface{}) string {
+		k, err := NewKey(src, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	k := key(src, options{100, 0, "jpeg", map[string]string{"a": "1", "b": "2"}})
+	if len(k) != 64 || strings.Trim(k, "0123456789abcdef") != "" {
+		t.Errorf("key %q isn't a hex SHA-256 hash", k)
+	}
+	for _, c := range []struct {
+		desc string
+		src  []byte
+		o    interface{}
+		same bool
+	}{
+		{"same options", src, options{100, 0, "jpeg", map[string]string{"b": "2", "a": "1"}}, true},
+		{"different source", []byte("source imagf"), options{100, 0, "jpeg", map[string]string{"a": "1", "b": "2"}}, false},
+		{"different width", src, options{101, 0, "jpeg", map[string]string{"a": "1", "b": "2"}}, false},
+		{"different map", src, options{100, 0, "jpeg", map[string]string{"a": "1"}}, false},
+		{"different type", src, map[string]int{"Width": 100}, false},
+	} {
+		if got := key(c.src, c.o); (got == k) != c.same {
+			t.Errorf("%s: got key %s for %s", c.desc, got, k)
+		}
+	}
+	if _, err := NewKey(src, func() {}); err == nil {
+		t.Error("got a key for options that can't be serialised")
+	}
+}
+
+// testCache checks the eviction order of a cache of 10 bytes.
+func testCache(t *testing.T, c Cache) {
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("got an entry from an empty cache")
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		if err := c.Put(k, []byte(k+k+k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Using a makes b the least recently used, which is evicted to make room for d.
+	if data, ok := c.Get("a"); !ok || string(data) != "aaa" {
+		t.Fatalf("got %q, %v", data, ok)
+	}
+	if err := c.Put("d", []byte("ddd")); err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if _, ok := c.Get(k); ok != want {
+			t.Errorf("entry %s found: %v, want %v", k, ok, want)
+		}
+	}
+	// Replacing an entry doesn't count it twice.
+	if err := c.Put("d", []byte("dd")); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := c.Get("d"); !ok || string(data) != "dd" {
+		t.Errorf("got %q, %v after replacing d", data, ok)
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("replacing d evicted a")
+	}
+	// Data larger than the cache isn't stored, and evicts nothing.
+	if err := c.Put("e", make([]byte, 11)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("e"); ok {
+		t.Error("stored data larger than the cache")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Error("storing too much data evicted c")
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory(10)
+	testCache(t, m)
+	if m.Len() != 3 || m.Size() != 8 {
+		t.Errorf("got %d entries of %d bytes", m.Len(), m.Size())
+	}
+}
+
+func TestDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imgcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := NewDisk(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCache(t, d)
+	if d.Size() != 8 {
+		t.Errorf("got %d bytes", d.Size())
+	}
+
+	// A new cache finds the entries, and keeps the most recently used ones within its size.
+	now := time.Now()
+	for k, key := range []string{"a", "c", "d"} {
+		at := now.Add(time.Duration(k-10) * time.Second)
+		if err := os.Chtimes(d.path(fileName(key)), at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, err = NewDisk(dir, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]string{"a": "", "c": "ccc", "d": "dd"} {
+		if data, ok := d.Get(k); string(data) != want || ok != (want != "") {
+			t.Errorf("got %q, %v for %s", data, ok, k)
+		}
+	}
+	if _, err := os.Stat(d.path(fileName("a"))); !os.IsNotExist(err) {
+		t.Error("evicted entry is still on disk")
+	}
+
+	// Entries deleted behind the cache's back are missing.
+	os.Remove(d.path(fileName("c")))
+	if _, ok := d.Get("c"); ok || d.Size() != 2 {
+		t.Errorf("found deleted entry, with %d bytes in the cache", d.Size())
+	}
+
+	// A failed read doesn't drop an entry that was written again since it was looked up.
+	name := fileName("d")
+	d.mu.Lock()
+	stale := d.entries[name]
+	d.mu.Unlock()
+	if err := d.Put("d", []byte("dd")); err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	d.removeElement(name, stale)
+	d.mu.Unlock()
+	if data, ok := d.Get("d"); !ok || string(data) != "dd" || d.Size() != 2 {
+		t.Errorf("got %q, %v, with %d bytes in the cache", data, ok, d.Size())
+	}
+}
+
+func TestGroup(t *testing.T) {
+	g := &Group{Cache: NewMemory(100)}
+	var calls int32
+	release := make(chan struct{})
+	generate := func() ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []byte("thumbnail"), nil
+	}
+
+	// Concurrent requests share a single call.
+	var wg sync.WaitGroup
+	results := make([]string, 10)
+	for k := range results {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			data, err := g.Get("key", generate)
+			if err != nil {
+				t.Error(err)
+			}
+			results[k] = string(data)
+		}(k)
+	}
+	// Give the goroutines time to wait on the first call.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("generated the image %d times", calls)
+	}
+	for _, r := range results {
+		if r != "thumbnail" {
+			t.Errorf("got %q", r)
+		}
+	}
+
+	// Later requests are served from the cache.
+	if data, err := g.Get("key", generate); err != nil || string(data) != "thumbnail" || calls != 1 {
+		t.Errorf("got %q, %v after %d calls", data, err, calls)
+	}
+
+	// Errors aren't cached.
+	fail := errors.New("failed")
+	if _, err := g.Get("other", func() ([]byte, error) { return nil, fail }); err != fail {
+		t.Errorf("got error %v", err)
+	}
+	data, err := g.Get("other", func() ([]byte, error) { return []byte("ok"), nil })
+	if err != nil || string(data) != "ok" {
+		t.Errorf("got %q, %v after an error", data, err)
+	}
+}
+
+func ExampleGroup() {
+	g := &Group{Cache: NewMemory(64 << 20)}
+	src := []byte("the source image")
+	key, _ := NewKey(src, struct{ Width, Height int }{400, 300})
+	data, _ := g.Get(key, func() ([]byte, error) {
+		// Decode src, resize it and encode it.
+		return []byte("the thumbnail"), nil
+	})
+	fmt.Printf("%s\n", data)
+	// Output: the thumbnail
+}
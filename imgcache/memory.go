@@ -0,0 +1,82 @@
+package imgcache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Memory is a Cache that keeps up to a number of bytes of data in memory, evicting the least recently used entries
+// first.
+type Memory struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // of *entry, most recently used first
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key  string
+	data []byte
+}
+
+// NewMemory returns a Memory cache that holds up to maxBytes of data.
+func NewMemory(maxBytes int64) *Memory {
+	return &Memory{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get returns the data stored under key, and whether it was found.
+func (m *Memory) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	m.lru.MoveToFront(e)
+	return e.Value.(*entry).data, true
+}
+
+// Put stores data under key, and evicts the least recently used entries until the cache is within its size. Data
+// larger than the whole cache isn't stored.
+func (m *Memory) Put(key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(key)
+	if int64(len(data)) > m.maxBytes {
+		return nil
+	}
+	m.entries[key] = m.lru.PushFront(&entry{key, data})
+	m.size += int64(len(data))
+	for m.size > m.maxBytes {
+		m.remove(m.lru.Back().Value.(*entry).key)
+	}
+	return nil
+}
+
+// Len returns the number of entries in the cache.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
+
+// Size returns the number of bytes of data in the cache.
+func (m *Memory) Size() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.size
+}
+
+func (m *Memory) remove(key string) {
+	if e, ok := m.entries[key]; ok {
+		m.lru.Remove(e)
+		delete(m.entries, key)
+		m.size -= int64(len(e.Value.(*entry).data))
+	}
+}
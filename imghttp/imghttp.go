@@ -0,0 +1,353 @@
+// Package imghttp serves resized variants of stored images over HTTP, keeping the metadata that the img package
+// preserves, such as ICC profiles, as chosen by a metadata policy.
+//
+// The name of the image is the path of the request, and its query parameters select the variant:
+//
+//	w    the width, in pixels
+//	h    the height, in pixels
+//	fit  how the image is made to fit w by h: fit (the default), fill or stretch, as for img.Thumbnail
+//	q    the JPEG quality, from 1 to 100
+//	fm   the format: jpeg, png, which is the default for PNG sources only, or webp, which is lossless
+//
+// For example, with the handler installed at /img/ under http.StripPrefix, /img/2021/cat.jpg?w=400&h=400&fit=fill is
+// a 400x400 crop of the stored 2021/cat.jpg.
+package imghttp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snapas/img"
+	"github.com/snapas/img/imgcache"
+	"github.com/snapas/img/jpeg"
+)
+
+// Source loads the original images that a Handler serves variants of.
+type Source interface {
+	// Open returns the image with the given name, which is a slash-separated path without a leading slash. It returns
+	// an error for which os.IsNotExist is true, such as os.ErrNotExist, if there's no such image.
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// MetadataPolicy is the metadata that a Handler keeps in the images it serves.
+type MetadataPolicy int
+
+const (
+	// KeepMetadata keeps the ICC profile, EXIF and XMP metadata, the gain map of JPEG images and the textual metadata
+	// of PNG images.
+	KeepMetadata MetadataPolicy = iota
+	// StripMetadata keeps only the ICC profile, which the colors depend on, and removes the EXIF and XMP metadata,
+	// which can give away where and when a photo was taken, along with any gain map and textual metadata.
+	StripMetadata
+)
+
+// Default limits of Handlers.
+const (
+	DefaultMaxSourceSize = 32 << 20
+	DefaultMaxPixels     = 50000000
+	DefaultMaxDimension  = 4096
+)
+
+// DefaultCacheControl is the Cache-Control header of responses if Handler.CacheControl is empty.
+const DefaultCacheControl = "public, max-age=86400"
+
+// Handler is an http.Handler that serves the images of Source, resized according to the query parameters of each
+// request. Responses have an ETag that is derived from the cache key of the variant, which depends on the source image
+// and the parameters, so that conditional requests get a 304 Not Modified response without the image being decoded or
+// encoded.
+//
+// The secondary images and trailers of JPEG sources, such as previews and the videos of motion photos, are always left
+// out, and so are gain maps in PNG variants.
+type Handler struct {
+	Source   Source
+	Metadata MetadataPolicy
+	// Quality is the JPEG quality when a request doesn't give one. jpeg.DefaultQuality is used if it is 0.
+	Quality int
+	// CacheControl is the Cache-Control header of successful responses. DefaultCacheControl is used if it is empty.
+	CacheControl string
+	// MaxSourceSize is the largest source image, in bytes, that is served. MaxPixels is the largest number of pixels
+	// of a source image, or of a variant, which bounds the memory needed for decoding it. MaxDimension is the largest
+	// width or height that can be requested. Requests beyond the limits fail, and each limit takes its default value
+	// if it is 0.
+	MaxSourceSize int64
+	MaxPixels     int
+	MaxDimension  int
+	// Cache stores the variants that are served, so that they are only rendered once. Concurrent requests for the same
+	// variant render it once even without a Cache.
+	Cache imgcache.Cache
+	// ErrorLog logs the errors of Source and of encoding images. The log package's standard logger is used if it is
+	// nil.
+	ErrorLog *log.Logger
+
+	group     imgcache.Group
+	groupOnce sync.Once
+}
+
+// params are the parameters of a request, which select the variant of an image.
+type params struct {
+	w, h    int
+	mode    img.ThumbnailMode
+	quality int
+	format  string
+}
+
+var modes = map[string]img.ThumbnailMode{"fit": img.Fit, "fill": img.Fill, "stretch": img.Stretch}
+
+// httpError is an error with the status code of the response it causes.
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+func errorf(code int, format string, a ...interface{}) error {
+	return &httpError{code, fmt.Sprintf(format, a...)}
+}
+
+// parseParams parses the query parameters of a request.
+func (h *Handler) parseParams(q map[string][]string) (params, error) {
+	p := params{mode: img.Fit, quality: h.Quality}
+	if p.quality == 0 {
+		p.quality = jpeg.DefaultQuality
+	}
+	get := func(key string) string {
+		if v := q[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	max := h.MaxDimension
+	if max == 0 {
+		max = DefaultMaxDimension
+	}
+	for _, d := range []struct {
+		key string
+		v   *int
+	}{{"w", &p.w}, {"h", &p.h}} {
+		s := get(d.key)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return p, errorf(http.StatusBadRequest, "invalid %s %q", d.key, s)
+		}
+		if n > max {
+			return p, errorf(http.StatusBadRequest, "%s is larger than %d", d.key, max)
+		}
+		*d.v = n
+	}
+	if p.w*p.h > h.maxPixels() {
+		return p, errorf(http.StatusBadRequest, "%dx%d is too many pixels", p.w, p.h)
+	}
+	if s := get("fit"); s != "" {
+		mode, ok := modes[s]
+		if !ok {
+			return p, errorf(http.StatusBadRequest, "unknown fit %q", s)
+		}
+		if mode != img.Fit && (p.w == 0 || p.h == 0) {
+			return p, errorf(http.StatusBadRequest, "fit %s needs both w and h", s)
+		}
+		p.mode = mode
+	}
+	if s := get("q"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 100 {
+			return p, errorf(http.StatusBadRequest, "invalid q %q", s)
+		}
+		p.quality = n
+	}
+	switch s := get("fm"); s {
+	case "":
+	case "jpeg", "jpg":
+		p.format = "jpeg"
+	case "png", "webp":
+		p.format = s
+	default:
+		return p, errorf(http.StatusBadRequest, "unknown fm %q", s)
+	}
+	return p, nil
+}
+
+func (h *Handler) maxPixels() int {
+	if h.MaxPixels == 0 {
+		return DefaultMaxPixels
+	}
+	return h.MaxPixels
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.serve(w, r); err != nil {
+		// Errors mustn't be cached like the image would have been.
+		w.Header().Del("ETag")
+		w.Header().Del("Cache-Control")
+		if e, ok := err.(*httpError); ok {
+			http.Error(w, e.msg, e.code)
+			return
+		}
+		h.logf("imghttp: %s: %s", r.URL.Path, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) error {
+	p, err := h.parseParams(r.URL.Query())
+	if err != nil {
+		return err
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if name == "" {
+		return errorf(http.StatusNotFound, "not found")
+	}
+	src, err := h.load(r.Context(), name)
+	if err != nil {
+		return err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		return errorf(http.StatusUnsupportedMediaType, "unsupported image format")
+	}
+	if cfg.Width*cfg.Height > h.maxPixels() {
+		return errorf(http.StatusRequestEntityTooLarge, "image is too large")
+	}
+	if p.format == "" {
+		p.format = "jpeg"
+		if format == "png" {
+			p.format = "png"
+		}
+	}
+
+	key, err := imgcache.NewKey(src, h.variant(p))
+	if err != nil {
+		return err
+	}
+	etag := `"` + key[:32] + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", h.cacheControl())
+	w.Header().Set("Content-Type", "image/"+p.format)
+	if matchETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	h.groupOnce.Do(func() { h.group.Cache = h.Cache })
+	out, err := h.group.Get(key, func() ([]byte, error) { return h.render(src, p) })
+	if err != nil {
+		return err
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(out))
+	return nil
+}
+
+// load reads the named image from the source, up to the size limit.
+func (h *Handler) load(ctx context.Context, name string) ([]byte, error) {
+	rc, err := h.Source.Open(ctx, name)
+	if os.IsNotExist(err) {
+		return nil, errorf(http.StatusNotFound, "not found")
+	} else if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	max := h.MaxSourceSize
+	if max == 0 {
+		max = DefaultMaxSourceSize
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(rc, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, errorf(http.StatusRequestEntityTooLarge, "image is too large")
+	}
+	return b, nil
+}
+
+// render decodes the source image, and encodes the variant of it selected by p.
+func (h *Handler) render(src []byte, p params) ([]byte, error) {
+	var i img.Image
+	var err error
+	if p.w == 0 && p.h == 0 {
+		i, _, err = img.Decode(bytes.NewReader(src))
+	} else {
+		i, _, err = img.DecodeThumbnail(bytes.NewReader(src), p.w, p.h, p.mode)
+	}
+	if err != nil {
+		return nil, errorf(http.StatusUnsupportedMediaType, "can't decode image")
+	}
+	i.MPF, i.Secondary, i.Trailer = nil, nil, nil
+	if h.Metadata == StripMetadata {
+		i.Exif, i.XMP, i.GainMap, i.PNGChunks = nil, nil, nil, nil
+	}
+
+	buf := &bytes.Buffer{}
+	switch p.format {
+	case "png":
+		err = img.EncodePNG(buf, i, nil)
+	case "webp":
+		err = img.EncodeWebP(buf, i, nil)
+	default:
+		err = img.Encode(buf, i, &jpeg.Options{Quality: p.quality, Background: color.White, CompactProfile: true})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// variant identifies the variant of a source image selected by p, for its cache key.
+type variant struct {
+	Width, Height int
+	Mode          img.ThumbnailMode
+	Quality       int
+	Format        string
+	Metadata      MetadataPolicy
+}
+
+func (h *Handler) variant(p params) variant {
+	return variant{p.w, p.h, p.mode, p.quality, p.format, h.Metadata}
+}
+
+// matchETag reports whether the If-None-Match header matches etag.
+func matchETag(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) cacheControl() string {
+	if h.CacheControl == "" {
+		return DefaultCacheControl
+	}
+	return h.CacheControl
+}
+
+func (h *Handler) logf(format string, a ...interface{}) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf(format, a...)
+	} else {
+		log.Printf(format, a...)
+	}
+}
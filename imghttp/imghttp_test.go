@@ -0,0 +1,228 @@
+package imghttp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/snapas/img"
+	"github.com/snapas/img/icc"
+	"github.com/snapas/img/iccjpeg"
+	"github.com/snapas/img/imgcache"
+)
+
+// testDir returns a directory with a 400x300 JPEG with metadata, photo.jpg, and a 40x30 PNG, sub/icon.png.
+func testDir(t *testing.T) Dir {
+	dir, err := ioutil.TempDir("", "imghttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 400; x++ {
+			m.Set(x, y, color.RGBA{uint8(x / 2), uint8(y), 0x80, 0xff})
+		}
+	}
+	buf := &bytes.Buffer{}
+	i := img.Image{
+		Image: m,
+		App2:  iccjpeg.App2Data(icc.DisplayP3Data),
+		Exif:  []byte("MM\x00*\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00"),
+		XMP:   []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`),
+	}
+	if err := img.Encode(buf, i, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "photo.jpg"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err := png.Encode(buf, image.NewNRGBA(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "icon.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Dir(dir)
+}
+
+func serve(h http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(string(dir))
+	h := &Handler{Source: dir}
+
+	for _, c := range []struct {
+		target      string
+		contentType string
+		size        image.Point
+	}{
+		{"/photo.jpg", "image/jpeg", image.Pt(400, 300)},
+		{"/photo.jpg?w=100", "image/jpeg", image.Pt(100, 75)},
+		{"/photo.jpg?h=30", "image/jpeg", image.Pt(40, 30)},
+		{"/photo.jpg?w=100&h=100&fit=fill", "image/jpeg", image.Pt(100, 100)},
+		{"/photo.jpg?w=100&h=100&fit=stretch&q=90", "image/jpeg", image.Pt(100, 100)},
+		{"/photo.jpg?w=1000", "image/jpeg", image.Pt(400, 300)},
+		{"/photo.jpg?w=80&fm=png", "image/png", image.Pt(80, 60)},
+		{"/sub/icon.png?w=20", "image/png", image.Pt(20, 15)},
+		{"/sub/icon.png?fm=jpg", "image/jpeg", image.Pt(40, 30)},
+		{"/photo.jpg?w=80&fm=webp", "image/webp", image.Pt(80, 60)},
+	} {
+		w := serve(h, "GET", c.target, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d: %s", c.target, w.Code, w.Body)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != c.contentType {
+			t.Errorf("%s: got Content-Type %q, want %q", c.target, got, c.contentType)
+		}
+		if w.Header().Get("ETag") == "" || w.Header().Get("Cache-Control") != DefaultCacheControl {
+			t.Errorf("%s: got headers %v", c.target, w.Header())
+		}
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(w.Body.Bytes()))
+		if err != nil {
+			t.Errorf("%s: %v", c.target, err)
+		} else if got := image.Pt(cfg.Width, cfg.Height); got != c.size {
+			t.Errorf("%s: got %v image, want %v", c.target, got, c.size)
+		}
+	}
+
+	// Different variants have different ETags, and a matching one gets a 304 response.
+	w := serve(h, "GET", "/photo.jpg?w=100", nil)
+	etag := w.Header().Get("ETag")
+	if other := serve(h, "GET", "/photo.jpg?w=101", nil).Header().Get("ETag"); other == etag {
+		t.Error("different variants have the same ETag")
+	}
+	w = serve(h, "GET", "/photo.jpg?w=100", http.Header{"If-None-Match": {`"x", ` + etag}})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("got status %d with %d bytes for a matching ETag", w.Code, w.Body.Len())
+	}
+	w = serve(h, "HEAD", "/photo.jpg?w=100", nil)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") == "" {
+		t.Errorf("HEAD got status %d with %d bytes", w.Code, w.Body.Len())
+	}
+}
+
+func TestHandlerMetadata(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(string(dir))
+
+	for _, c := range []struct {
+		policy   MetadataPolicy
+		wantExif bool
+	}{
+		{KeepMetadata, true},
+		{StripMetadata, false},
+	} {
+		for _, fm := range []string{"jpeg", "png", "webp"} {
+			w := serve(&Handler{Source: dir, Metadata: c.policy}, "GET", "/photo.jpg?w=100&fm="+fm, nil)
+			i, _, err := img.Decode(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (i.Exif != nil) != c.wantExif || (i.XMP != nil) != c.wantExif {
+				t.Errorf("policy %d, %s: got %d bytes of EXIF and %d bytes of XMP", c.policy, fm, len(i.Exif), len(i.XMP))
+			}
+			if !bytes.Equal(iccjpeg.ProfileData(i.App2), icc.DisplayP3Data) {
+				t.Errorf("policy %d, %s: ICC profile was lost", c.policy, fm)
+			}
+		}
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(string(dir))
+	if err := ioutil.WriteFile(filepath.Join(string(dir), "notes.txt"), []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{Source: dir, MaxDimension: 500, MaxPixels: 100000}
+
+	for _, c := range []struct {
+		method, target string
+		code           int
+	}{
+		{"GET", "/missing.jpg", http.StatusNotFound},
+		{"GET", "/", http.StatusNotFound},
+		{"GET", "/sub", http.StatusNotFound},
+		{"GET", "/../" + filepath.Base(string(dir)) + "/photo.jpg", http.StatusNotFound},
+		{"POST", "/photo.jpg", http.StatusMethodNotAllowed},
+		{"GET", "/photo.jpg?w=0", http.StatusBadRequest},
+		{"GET", "/photo.jpg?w=abc", http.StatusBadRequest},
+		{"GET", "/photo.jpg?w=501", http.StatusBadRequest},
+		{"GET", "/photo.jpg?w=400&h=400", http.StatusBadRequest},
+		{"GET", "/photo.jpg?w=10&fit=crop", http.StatusBadRequest},
+		{"GET", "/photo.jpg?w=10&fit=fill", http.StatusBadRequest},
+		{"GET", "/photo.jpg?q=101", http.StatusBadRequest},
+		{"GET", "/photo.jpg?fm=gif", http.StatusBadRequest},
+		{"GET", "/notes.txt", http.StatusUnsupportedMediaType},
+		{"GET", "/photo.jpg?w=100", http.StatusRequestEntityTooLarge},
+	} {
+		w := serve(h, c.method, c.target, nil)
+		if w.Code != c.code {
+			t.Errorf("%s %s: got status %d, want %d", c.method, c.target, w.Code, c.code)
+		}
+		if w.Header().Get("ETag") != "" || w.Header().Get("Cache-Control") != "" {
+			t.Errorf("%s %s: error response has caching headers", c.method, c.target)
+		}
+	}
+
+	h = &Handler{Source: dir, MaxSourceSize: 100}
+	if w := serve(h, "GET", "/photo.jpg", nil); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d for a source over the size limit", w.Code)
+	}
+}
+
+// countingCache counts the hits of a cache.
+type countingCache struct {
+	imgcache.Cache
+	hits int
+}
+
+func (c *countingCache) Get(key string) ([]byte, bool) {
+	data, ok := c.Cache.Get(key)
+	if ok {
+		c.hits++
+	}
+	return data, ok
+}
+
+func TestHandlerCache(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(string(dir))
+	cache := &countingCache{Cache: imgcache.NewMemory(1 << 20)}
+	h := &Handler{Source: dir, Cache: cache}
+
+	first := serve(h, "GET", "/photo.jpg?w=100", nil)
+	second := serve(h, "GET", "/photo.jpg?w=100", nil)
+	if cache.hits != 1 || !bytes.Equal(first.Body.Bytes(), second.Body.Bytes()) {
+		t.Errorf("got %d cache hits", cache.hits)
+	}
+	if first.Header().Get("ETag") != second.Header().Get("ETag") {
+		t.Error("cached variant has a different ETag")
+	}
+	serve(h, "GET", "/photo.jpg?w=100&q=80", nil)
+	if cache.hits != 1 {
+		t.Error("different variant was served from the cache")
+	}
+}
@@ -0,0 +1,34 @@
+package imghttp
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Dir is a Source of the images in a directory of the local file system, like http.Dir. Names can't refer to files
+// outside of it.
+type Dir string
+
+// Open opens the named image in the directory.
+func (d Dir) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if filepath.Separator != '/' && strings.ContainsRune(name, filepath.Separator) || strings.ContainsRune(name, 0) {
+		return nil, os.ErrNotExist
+	}
+	dir := string(d)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name))))
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err != nil || fi.IsDir() {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
+	return f, nil
+}
@@ -0,0 +1,51 @@
+package jpeg
+
+import (
+	"bytes"
+
+	"github.com/snapas/img/icc"
+)
+
+// iccSignature identifies APP2 data that contains an ICC profile. It is
+// followed by the 1-based sequence number of the chunk and the total number of
+// chunks.
+const iccSignature = "ICC_PROFILE\x00"
+
+// grayProfileApp2 is the APP2 data for a gray ICC profile using the sRGB tone
+// curve. It replaces color profiles on images that are written with a single
+// component.
+var grayProfileApp2 = append([]byte(iccSignature+"\x01\x01"), icc.SGrayData...)
+
+// grayApp2 returns the APP2 data to write with an image that was detected as
+// monochrome. An RGB profile can't describe a single-component image, so any
+// ICC profile in app2 that isn't already gray is swapped for a gray one. Other
+// APP2 data is kept as it is.
+func grayApp2(app2 []byte) []byte {
+	if !bytes.HasPrefix(app2, []byte(iccSignature)) {
+		return app2
+	}
+	// The profile's color space is at offset 16 of its header, after the
+	// signature and the two chunk bytes.
+	if p := app2[len(iccSignature)+2:]; len(p) >= 20 && string(p[16:20]) == "GRAY" {
+		return app2
+	}
+	return grayProfileApp2
+}
+
+// compactApp2 returns the APP2 data to write in place of app2 when profiles
+// are compacted. A well-known ICC profile is swapped for its compact
+// equivalent, or dropped if it is sRGB and dropSRGB is set. Other APP2 data,
+// and profiles split over several chunks, are kept as they are.
+func compactApp2(app2 []byte, dropSRGB bool) []byte {
+	if !bytes.HasPrefix(app2, []byte(iccSignature+"\x01\x01")) {
+		return app2
+	}
+	p := app2[len(iccSignature)+2:]
+	c := icc.Compact(p, dropSRGB)
+	if c == nil {
+		return nil
+	} else if len(c) == len(p) {
+		return app2
+	}
+	return append([]byte(iccSignature+"\x01\x01"), c...)
+}
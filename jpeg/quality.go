@@ -0,0 +1,57 @@
+package jpeg
+
+// EstimateQuality returns the quality, on the scale of Options.Quality, that
+// the luminance quantization table in the data of a DQT segment was most
+// likely made with, assuming that it was scaled from the standard table as
+// Encode and libjpeg do. It returns 0 if the data holds no luminance table.
+func EstimateQuality(dqt []byte) int {
+	for len(dqt) > 0 {
+		pq, tq := dqt[0]>>4, dqt[0]&0x0f
+		n := 1 + blockSize
+		if pq == 1 {
+			n += blockSize
+		}
+		if len(dqt) < n {
+			return 0
+		}
+		if tq != 0 {
+			dqt = dqt[n:]
+			continue
+		}
+		var table [blockSize]int
+		for i := range table {
+			table[i] = int(dqt[1+i])
+			if pq == 1 {
+				table[i] = int(dqt[1+2*i])<<8 | int(dqt[2+2*i])
+			}
+		}
+		// Find the quality whose table is the closest, scaling the standard
+		// table as Encode does.
+		best, bestDiff := 0, -1
+		for quality := 1; quality <= 100; quality++ {
+			scale := 200 - quality*2
+			if quality < 50 {
+				scale = 5000 / quality
+			}
+			diff := 0
+			for i, q := range table {
+				x := (int(unscaledQuant[quantIndexLuminance][i])*scale + 50) / 100
+				if x < 1 {
+					x = 1
+				} else if x > 255 {
+					x = 255
+				}
+				if x > q {
+					diff += x - q
+				} else {
+					diff += q - x
+				}
+			}
+			if bestDiff < 0 || diff < bestDiff {
+				best, bestDiff = quality, diff
+			}
+		}
+		return best
+	}
+	return 0
+}
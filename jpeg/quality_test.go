@@ -0,0 +1,39 @@
+package jpeg
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestEstimateQuality(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for _, quality := range []int{10, 30, 50, 75, 85, 90, 95, 100} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, m, &Options{Quality: quality}, nil); err != nil {
+			t.Fatal(err)
+		}
+		b := buf.Bytes()
+		i := bytes.Index(b, []byte{0xff, dqtMarker})
+		n := int(b[i+2])<<8 | int(b[i+3])
+		got := EstimateQuality(b[i+4 : i+2+n])
+		if got != quality {
+			t.Errorf("estimated quality %d as %d", quality, got)
+		}
+	}
+
+	// 16-bit tables, and data without a luminance table.
+	dqt16 := []byte{0x10}
+	for i := 0; i < blockSize; i++ {
+		dqt16 = append(dqt16, 0, unscaledQuant[0][i])
+	}
+	if got := EstimateQuality(dqt16); got != 50 {
+		t.Errorf("estimated the 16-bit standard table as %d, want 50", got)
+	}
+	if got := EstimateQuality(append([]byte{0x01}, make([]byte, blockSize)...)); got != 0 {
+		t.Errorf("estimated a chrominance table as %d, want 0", got)
+	}
+	if got := EstimateQuality([]byte{0x00, 1, 2}); got != 0 {
+		t.Errorf("estimated a short table as %d, want 0", got)
+	}
+}
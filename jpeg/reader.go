@@ -8,9 +8,13 @@
 package jpeg
 
 import (
+	"fmt"
 	"image"
 	"image/color"
 	"io"
+	"sync"
+
+	"github.com/snapas/img/icc"
 )
 
 // A FormatError reports that the input is not a valid JPEG.
@@ -146,6 +150,22 @@ type decoder struct {
 	huff       [maxTc + 1][maxTh + 1]huffman
 	quant      [maxTq + 1]block // Quantization tables, in zig-zag order.
 	tmp        [2 * blockSize]byte
+
+	// allowTruncated is whether an image that ends early is recovered instead
+	// of discarded. scans is the number of SOS markers seen so far, and mcus
+	// and totalMCUs are the progress of the most recent scan.
+	allowTruncated  bool
+	scans           int
+	mcus, totalMCUs int
+
+	// convertCMYK is whether 4-component images are converted to RGB through
+	// their ICC profile, which is collected from APP2 chunks in iccChunks.
+	convertCMYK bool
+	iccChunks   [][]byte
+
+	// blockDim is the width and height in pixels that each 8x8 block is
+	// decoded to: 8 at full size, or 4, 2 or 1 when decoding scaled down.
+	blockDim int
 }
 
 // fill fills up the d.bytes.buf buffer from the underlying io.Reader. It
@@ -520,9 +540,51 @@ func (d *decoder) processApp14Marker(n int) error {
 	return nil
 }
 
+// processApp2Marker collects the chunks of an ICC profile, which can be split
+// over several APP2 segments as each holds less than 64 KiB.
+func (d *decoder) processApp2Marker(n int) error {
+	if !d.convertCMYK || n < len(iccSignature)+2 {
+		return d.ignore(n)
+	}
+	b := make([]byte, n)
+	if err := d.readFull(b); err != nil {
+		return err
+	}
+	if string(b[:len(iccSignature)]) != iccSignature {
+		return nil
+	}
+	seq, count := int(b[len(iccSignature)]), int(b[len(iccSignature)+1])
+	if d.iccChunks == nil {
+		d.iccChunks = make([][]byte, count)
+	}
+	if seq < 1 || seq > len(d.iccChunks) || count != len(d.iccChunks) {
+		// Ignore the profile rather than the image, as decoders usually do.
+		d.iccChunks = [][]byte{nil}
+		return nil
+	}
+	d.iccChunks[seq-1] = b[len(iccSignature)+2:]
+	return nil
+}
+
+// iccProfile returns the ICC profile assembled from APP2 chunks, or nil if
+// there is no complete profile.
+func (d *decoder) iccProfile() []byte {
+	var p []byte
+	for _, c := range d.iccChunks {
+		if c == nil {
+			return nil
+		}
+		p = append(p, c...)
+	}
+	return p
+}
+
 // decode reads a JPEG image from r and returns it as an image.Image.
 func (d *decoder) decode(r io.Reader, configOnly bool) (image.Image, error) {
 	d.r = r
+	if d.blockDim == 0 {
+		d.blockDim = 8
+	}
 
 	// Check for the Start Of Image marker.
 	if err := d.readFull(d.tmp[:2]); err != nil {
@@ -634,6 +696,8 @@ func (d *decoder) decode(r io.Reader, configOnly bool) (image.Image, error) {
 			}
 		case app0Marker:
 			err = d.processApp0Marker(n)
+		case app2Marker:
+			err = d.processApp2Marker(n)
 		case app14Marker:
 			err = d.processApp14Marker(n)
 		default:
@@ -650,6 +714,12 @@ func (d *decoder) decode(r io.Reader, configOnly bool) (image.Image, error) {
 		}
 	}
 
+	return d.finish()
+}
+
+// finish converts the decoded components into the image returned to the
+// caller, reconstructing progressive images from their saved coefficients.
+func (d *decoder) finish() (image.Image, error) {
 	if d.progressive {
 		if err := d.reconstructProgressiveImage(); err != nil {
 			return nil, err
@@ -660,7 +730,11 @@ func (d *decoder) decode(r io.Reader, configOnly bool) (image.Image, error) {
 	}
 	if d.img3 != nil {
 		if d.blackPix != nil {
-			return d.applyBlack()
+			m, err := d.applyBlack()
+			if err != nil || !d.convertCMYK {
+				return m, err
+			}
+			return d.convertCMYKToRGB(m)
 		} else if d.isRGB() {
 			return d.convertToRGB()
 		}
@@ -741,6 +815,81 @@ func (d *decoder) applyBlack() (image.Image, error) {
 	return img, nil
 }
 
+// convertCMYKToRGB converts the CMYK image m to sRGB, using the embedded ICC
+// profile if it is a usable CMYK one and a generic CMYK profile otherwise.
+func (d *decoder) convertCMYKToRGB(m image.Image) (image.Image, error) {
+	var t *icc.CMYKTransform
+	if b := d.iccProfile(); b != nil {
+		if p, err := icc.Parse(b); err == nil {
+			t = cmykTransform(p)
+		}
+	}
+	if t == nil {
+		var err error
+		if t, err = genericCMYKTransform(); err != nil {
+			return nil, err
+		}
+	}
+	return t.ConvertImage(m), nil
+}
+
+// maxCMYKTransforms is how many transforms from embedded CMYK profiles are
+// kept, as the files from a printer or publisher usually share one profile.
+const maxCMYKTransforms = 8
+
+// cmykTransforms holds the transforms from embedded CMYK profiles to sRGB, or
+// nil for profiles that have none, keyed by profile ID. ids holds the keys in
+// the order they were added, and the oldest is evicted first.
+var cmykTransforms struct {
+	sync.Mutex
+	m   map[[16]byte]*icc.CMYKTransform
+	ids [][16]byte
+}
+
+// cmykTransform returns the transform from the CMYK profile p to sRGB, or nil
+// if it can't be built. Like the generic transform, it is shared with other
+// images that have the same profile, as it takes a while to compute.
+func cmykTransform(p *icc.Profile) *icc.CMYKTransform {
+	id := icc.ComputeID(p.Bytes())
+	c := &cmykTransforms
+	c.Lock()
+	t, ok := c.m[id]
+	c.Unlock()
+	if ok {
+		return t
+	}
+	t, _ = icc.NewCMYKTransform(p, icc.SRGB, icc.RelativeColorimetric)
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.m[id]; !ok {
+		if c.m == nil {
+			c.m = map[[16]byte]*icc.CMYKTransform{}
+		}
+		if len(c.ids) == maxCMYKTransforms {
+			delete(c.m, c.ids[0])
+			c.ids = c.ids[1:]
+		}
+		c.m[id] = t
+		c.ids = append(c.ids, id)
+	}
+	return t
+}
+
+var genericCMYK struct {
+	once sync.Once
+	t    *icc.CMYKTransform
+	err  error
+}
+
+// genericCMYKTransform returns the transform from icc.GenericCMYK to sRGB,
+// which is built on first use and shared, as it takes a while to compute.
+func genericCMYKTransform() (*icc.CMYKTransform, error) {
+	genericCMYK.once.Do(func() {
+		genericCMYK.t, genericCMYK.err = icc.NewCMYKTransform(icc.GenericCMYK, icc.SRGB, icc.RelativeColorimetric)
+	})
+	return genericCMYK.t, genericCMYK.err
+}
+
 func (d *decoder) isRGB() bool {
 	if d.jfif {
 		return false
@@ -777,6 +926,87 @@ func Decode(r io.Reader) (image.Image, error) {
 	return d.decode(r, false)
 }
 
+// DecodeOptions are the decoding parameters.
+//
+// AllowTruncated recovers images whose data ends early, such as interrupted
+// uploads. The MCUs decoded so far are returned, with the rest of the image
+// filled with neutral grey, and progressive images are reconstructed from the
+// scans received. The error accompanying such an image is a *TruncatedError.
+//
+// ConvertCMYK converts CMYK and YCCK images to sRGB, returning an *image.RGBA
+// instead of an *image.CMYK. Colors are converted through the embedded ICC
+// profile when it is a CMYK one, and through icc.GenericCMYK otherwise, which
+// is far closer to the printed colors than color.CMYKToRGB.
+//
+// Scale decodes the image at 1/Scale of its width and height, rounded up,
+// which must be 1, 2, 4 or 8. Each block is transformed straight to the
+// smaller size with a reduced inverse DCT, which is much faster than decoding
+// the full image and resampling it, and needs a fraction of the memory. Zero
+// means 1.
+type DecodeOptions struct {
+	AllowTruncated bool
+	ConvertCMYK    bool
+	Scale          int
+}
+
+// A TruncatedError reports that the input ended before the image was complete.
+// It is returned alongside the recovered image.
+type TruncatedError struct {
+	// Scans is the number of scans that were started. Baseline images have
+	// one scan, while progressive images usually have several.
+	Scans int
+	// MCUs is the number of MCUs (Minimum Coded Units) decoded by the last
+	// scan, out of TotalMCUs in the image.
+	MCUs, TotalMCUs int
+	// Err is the underlying error that stopped decoding.
+	Err error
+}
+
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("jpeg: truncated image: recovered %d of %d MCUs in scan %d: %v", e.MCUs, e.TotalMCUs, e.Scans, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *TruncatedError) Unwrap() error { return e.Err }
+
+// DecodeWithOptions reads a JPEG image from r and returns it as an image.Image,
+// using the given options. Default parameters are used if a nil *DecodeOptions
+// is passed.
+func DecodeWithOptions(r io.Reader, o *DecodeOptions) (image.Image, error) {
+	var d decoder
+	if o != nil {
+		d.allowTruncated = o.AllowTruncated
+		d.convertCMYK = o.ConvertCMYK
+		switch o.Scale {
+		case 0, 1:
+		case 2, 4, 8:
+			d.blockDim = 8 / o.Scale
+		default:
+			return nil, fmt.Errorf("jpeg: invalid scale %d", o.Scale)
+		}
+	}
+	m, err := d.decode(r, false)
+	if err != nil && d.allowTruncated && isTruncation(err) && (d.img1 != nil || d.img3 != nil) {
+		m, ferr := d.finish()
+		if ferr != nil {
+			return nil, ferr
+		}
+		return m, &TruncatedError{
+			Scans:     d.scans,
+			MCUs:      d.mcus,
+			TotalMCUs: d.totalMCUs,
+			Err:       err,
+		}
+	}
+	return m, err
+}
+
+// isTruncation reports whether err means that the input ended early, as
+// opposed to being malformed.
+func isTruncation(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF || err == errShortHuffmanData
+}
+
 // DecodeConfig returns the color model and dimensions of a JPEG image without
 // decoding the entire image.
 func DecodeConfig(r io.Reader) (image.Config, error) {
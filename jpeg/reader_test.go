@@ -16,6 +16,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/snapas/img/icc"
 )
 
 // TestDecodeProgressive tests that decoding the baseline and progressive
@@ -137,6 +139,233 @@ func TestDecodeEOF(t *testing.T) {
 	}
 }
 
+func TestDecodeTruncated(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			src.SetRGBA(x, y, color.RGBA{uint8(4 * x), uint8(5 * y), 0x40, 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, src, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	sos := bytes.Index(data, []byte{0xff, sosMarker})
+	if sos < 0 {
+		t.Fatal("SOS marker not found")
+	}
+
+	// Without the option, a truncated image is an error.
+	cut := data[:sos+(len(data)-sos)/2]
+	if m, err := Decode(bytes.NewReader(cut)); err == nil || m != nil {
+		t.Fatalf("Decode: got %v, %v, want nil image and an error", m, err)
+	}
+
+	for _, n := range []int{sos + 20, len(cut), len(data) - 2} {
+		m, err := DecodeWithOptions(bytes.NewReader(data[:n]), &DecodeOptions{AllowTruncated: true})
+		te, ok := err.(*TruncatedError)
+		if !ok {
+			t.Errorf("n=%d: got error %v, want a *TruncatedError", n, err)
+			continue
+		}
+		if m == nil || m.Bounds() != src.Bounds() {
+			t.Errorf("n=%d: got image %v, want bounds %v", n, m, src.Bounds())
+			continue
+		}
+		if te.Scans != 1 || te.TotalMCUs != 12 || te.MCUs > te.TotalMCUs {
+			t.Errorf("n=%d: unexpected progress %+v", n, te)
+		}
+		if n == len(data)-2 && te.MCUs != te.TotalMCUs {
+			t.Errorf("n=%d: got %d MCUs, want all %d", n, te.MCUs, te.TotalMCUs)
+		}
+		// The last pixel is never recovered unless every MCU was decoded, and
+		// is filled with neutral grey instead.
+		if te.MCUs < te.TotalMCUs {
+			r, g, b, _ := m.At(63, 47).RGBA()
+			if r>>8 != 0x80 || g>>8 != 0x80 || b>>8 != 0x80 {
+				t.Errorf("n=%d: missing pixel is (%d, %d, %d), want neutral grey", n, r>>8, g>>8, b>>8)
+			}
+		}
+	}
+}
+
+func TestDecodeScaled(t *testing.T) {
+	rgb := image.NewRGBA(image.Rect(0, 0, 61, 45))
+	gray := image.NewGray(rgb.Bounds())
+	for y := 0; y < 45; y++ {
+		for x := 0; x < 61; x++ {
+			// Subsampled chroma covers twice as many pixels as luma at every
+			// scale, so colors change slowly to keep its averages comparable.
+			rgb.SetRGBA(x, y, color.RGBA{uint8(x + 0x40), uint8(y + 0x40), 0x80, 0xff})
+			gray.SetGray(x, y, color.Gray{uint8(2*x + 3*y)})
+		}
+	}
+	for _, src := range []image.Image{rgb, gray} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, src, &Options{Quality: 95}, nil); err != nil {
+			t.Fatal(err)
+		}
+		full, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, scale := range []int{1, 2, 4, 8} {
+			m, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), &DecodeOptions{Scale: scale})
+			if err != nil {
+				t.Fatalf("%T, scale %d: %v", src, scale, err)
+			}
+			want := image.Rect(0, 0, (61+scale-1)/scale, (45+scale-1)/scale)
+			if m.Bounds() != want {
+				t.Fatalf("%T, scale %d: got bounds %v, want %v", src, scale, m.Bounds(), want)
+			}
+			if _, ok := m.(*image.Gray); ok != (src == gray) {
+				t.Errorf("%T, scale %d: got %T", src, scale, m)
+			}
+			// Compare with the full image, averaged over the pixels that each
+			// scaled pixel covers.
+			avg := image.NewRGBA(want)
+			for y := 0; y < want.Dy(); y++ {
+				for x := 0; x < want.Dx(); x++ {
+					var sum [3]uint32
+					n := uint32(0)
+					for sy := y * scale; sy < (y+1)*scale && sy < 45; sy++ {
+						for sx := x * scale; sx < (x+1)*scale && sx < 61; sx++ {
+							r, g, b, _ := full.At(sx, sy).RGBA()
+							sum[0], sum[1], sum[2] = sum[0]+r>>8, sum[1]+g>>8, sum[2]+b>>8
+							n++
+						}
+					}
+					avg.SetRGBA(x, y, color.RGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), 0xff})
+				}
+			}
+			if got := averageDelta(m, avg); got > 3<<8 {
+				t.Errorf("%T, scale %d: average delta %d, want <= %d", src, scale, got, 3<<8)
+			}
+		}
+	}
+
+	if _, err := DecodeWithOptions(bytes.NewReader(nil), &DecodeOptions{Scale: 3}); err == nil {
+		t.Error("scale 3 succeeded, want error")
+	}
+}
+
+// cmykJPEG returns an 8x8 Adobe CMYK JPEG whose samples are all 0x80, which
+// is 0x7f of each ink. The profile, if any, is split into APP2 chunks of at
+// most chunkSize bytes.
+func cmykJPEG(profile []byte, chunkSize int) []byte {
+	var b bytes.Buffer
+	segment := func(marker byte, data []byte) {
+		b.Write([]byte{0xff, marker, byte((len(data) + 2) >> 8), byte(len(data) + 2)})
+		b.Write(data)
+	}
+	b.Write([]byte{0xff, soiMarker})
+	segment(app14Marker, []byte("Adobe\x00\x64\x00\x00\x00\x00\x00"))
+	var chunks [][]byte
+	for ; len(profile) > chunkSize; profile = profile[chunkSize:] {
+		chunks = append(chunks, profile[:chunkSize])
+	}
+	if len(profile) > 0 {
+		chunks = append(chunks, profile)
+	}
+	for i, c := range chunks {
+		segment(app2Marker, append([]byte(iccSignature+string([]byte{byte(i + 1), byte(len(chunks))})), c...))
+	}
+	segment(dqtMarker, append([]byte{0x00}, bytes.Repeat([]byte{1}, blockSize)...))
+	segment(sof0Marker, []byte{8, 0, 8, 0, 8, 4, 1, 0x11, 0, 2, 0x11, 0, 3, 0x11, 0, 4, 0x11, 0})
+	// The DC and AC tables each have a single 1-bit code, for a DC difference
+	// of zero and for the end of block.
+	counts := append([]byte{1}, make([]byte, 15)...)
+	dht := append(append([]byte{0x00}, counts...), 0x00)
+	dht = append(append(append(dht, 0x10), counts...), 0x00)
+	segment(dhtMarker, dht)
+	segment(sosMarker, []byte{4, 1, 0x00, 2, 0x00, 3, 0x00, 4, 0x00, 0, 63, 0})
+	b.Write([]byte{0x00, 0xff, eoiMarker})
+	return b.Bytes()
+}
+
+func TestDecodeCMYK(t *testing.T) {
+	m, err := Decode(bytes.NewReader(cmykJPEG(nil, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := m.At(3, 3); c != (color.CMYK{0x7f, 0x7f, 0x7f, 0x7f}) {
+		t.Fatalf("without ConvertCMYK, got %v, want CMYK{0x7f, 0x7f, 0x7f, 0x7f}", c)
+	}
+
+	generic, err := icc.NewCMYKTransform(icc.GenericCMYK, icc.SRGB, icc.RelativeColorimetric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A profile whose look-up table maps every color to the same one, so that
+	// it is easily told apart from the generic profile.
+	profile := append([]byte(nil), icc.GenericCMYKData...)
+	p, err := icc.Parse(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range p.Tags() {
+		if tag.Signature == "A2B0" {
+			lut := profile[tag.Offset+52+4*4 : tag.Offset+tag.Size-3*4]
+			for i := range lut {
+				lut[i] = byte(0x40 + i%2*0x40)
+			}
+		}
+	}
+	p, err = icc.Parse(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded, err := icc.NewCMYKTransform(p, icc.SRGB, icc.RelativeColorimetric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r0, g0, b0 := generic.Convert(0x7f, 0x7f, 0x7f, 0x7f)
+	if r1, g1, b1 := embedded.Convert(0x7f, 0x7f, 0x7f, 0x7f); r0 == r1 && g0 == g1 && b0 == b1 {
+		t.Fatalf("test profile converts like the generic one, to (%d, %d, %d)", r0, g0, b0)
+	}
+
+	// Dropping the second chunk leaves a profile that can't be assembled.
+	chunked := cmykJPEG(profile, 1000)
+	first := bytes.Index(chunked, []byte{0xff, app2Marker})
+	second := first + 2 + int(chunked[first+2])<<8 + int(chunked[first+3])
+	third := second + 2 + int(chunked[second+2])<<8 + int(chunked[second+3])
+	incomplete := append(append([]byte(nil), chunked[:second]...), chunked[third:]...)
+
+	testCases := []struct {
+		name string
+		data []byte
+		t    *icc.CMYKTransform
+	}{
+		{"no profile", cmykJPEG(nil, 0), generic},
+		{"chunked profile", chunked, embedded},
+		{"incomplete profile", incomplete, generic},
+		{"RGB profile", cmykJPEG(icc.SRGBData, 1000), generic},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := DecodeWithOptions(bytes.NewReader(tc.data), &DecodeOptions{ConvertCMYK: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rgba, ok := m.(*image.RGBA)
+			if !ok {
+				t.Fatalf("got %T, want *image.RGBA", m)
+			}
+			r, g, b := tc.t.Convert(0x7f, 0x7f, 0x7f, 0x7f)
+			if c := rgba.RGBAAt(3, 3); c != (color.RGBA{r, g, b, 0xff}) {
+				t.Errorf("got %v, want %v", c, color.RGBA{r, g, b, 0xff})
+			}
+		})
+	}
+
+	// The transform of the embedded profile is built once, and then shared.
+	if c := cmykTransform(p); c == nil || c != cmykTransform(p) {
+		t.Error("transform of embedded profile isn't cached")
+	}
+}
+
 // check checks that the two pix data are equal, within the given bounds.
 func check(bounds image.Rectangle, pix0, pix1 []byte, stride0, stride1 int) error {
 	if stride0 <= 0 || stride0%8 != 0 {
@@ -436,7 +665,7 @@ func TestExtraneousData(t *testing.T) {
 	src := image.NewRGBA(image.Rect(0, 0, 1, 1))
 	src.Set(0, 0, color.RGBA{0xff, 0x00, 0x00, 0xff})
 	buf := new(bytes.Buffer)
-	if err := Encode(buf, src, nil); err != nil {
+	if err := Encode(buf, src, nil, nil); err != nil {
 		t.Fatalf("encode: %v", err)
 	}
 	enc := buf.String()
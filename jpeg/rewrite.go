@@ -0,0 +1,190 @@
+package jpeg
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/snapas/img/iccjpeg"
+	"github.com/snapas/img/mpf"
+)
+
+// Edit is a change to the metadata segments of a JPEG file, made by Rewrite.
+type Edit struct {
+	// Marker is the marker of the segments to change: one of the APPn
+	// markers, 0xe0 to 0xef, or the COM marker, 0xfe.
+	Marker uint8
+	// Prefix selects the segments with Marker whose data starts with it, such
+	// as "Exif\x00\x00" for EXIF metadata. All segments with Marker are
+	// selected if it is empty.
+	Prefix []byte
+	// Data is the new data of the selected segments, including any Prefix.
+	// It replaces the first selected segment and the others are deleted, or
+	// it is inserted if no segment is selected. All selected segments are
+	// deleted if Data is nil.
+	Data []byte
+}
+
+// Rewrite copies the JPEG file in r to w with its metadata segments changed by
+// edits, which are applied in order. Only the segments before the image data
+// are parsed: the entropy-coded data, and anything that follows it, is copied
+// as it is, so the pixels are untouched and the file is rewritten far quicker
+// than by decoding and encoding it.
+//
+// New segments are inserted after the APPn segments with lower or equal
+// markers at the start of the file, so that JFIF and EXIF segments stay first,
+// and COM segments follow all of them.
+//
+// The MP index of files with secondary images, such as previews and gain maps,
+// is updated for the change in size of the segments, unless it is edited
+// itself.
+func Rewrite(r io.Reader, w io.Writer, edits ...Edit) error {
+	for _, e := range edits {
+		if !isMetadataMarker(e.Marker) {
+			return fmt.Errorf("jpeg: can't edit segments with marker %#02x", e.Marker)
+		}
+		if len(e.Data) > maxSegmentLen {
+			return errors.New("jpeg: metadata is too large to encode")
+		}
+	}
+
+	p := iccjpeg.NewParser(r)
+	if err := p.ReadSOI(); err != nil {
+		return FormatError("missing SOI marker")
+	}
+	var segs []iccjpeg.Segment
+	for {
+		s, err := p.ReadSegment()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return FormatError("missing SOS marker")
+		} else if err != nil {
+			return err
+		}
+		segs = append(segs, *s)
+		if s.MarkerID == sosMarker || s.MarkerID == eoiMarker {
+			break
+		}
+	}
+	header, scan := segs[:len(segs)-1], segs[len(segs)-1]
+	orig := header
+	for _, e := range edits {
+		header = e.apply(header)
+	}
+	header = fixMPF(orig, header)
+
+	bw := bufio.NewWriter(w)
+	bw.Write([]byte{0xff, soiMarker})
+	for _, s := range append(header, scan) {
+		bw.Write([]byte{0xff, s.MarkerID})
+		if s.MarkerID != eoiMarker {
+			n := 2 + len(s.Data)
+			bw.Write([]byte{uint8(n >> 8), uint8(n)})
+			bw.Write(s.Data)
+		}
+	}
+	if _, err := io.Copy(bw, p); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// fixMPF returns the edited segments header, from the segments orig, with the
+// offsets and sizes of their MP index updated. The offsets of secondary images
+// are relative to the MP header, so they change with the size of the segments
+// that follow it, and the size of the primary image with that of all of them.
+// Only those fields are patched, so the segment keeps its size and the MP
+// attribute IFDs that follow the index. Indexes that were edited, or can't be
+// parsed, are left as they are.
+func fixMPF(orig, header []iccjpeg.Segment) []iccjpeg.Segment {
+	at := findMPF(header)
+	if at < 0 {
+		return header
+	}
+	k := findMPF(orig)
+	if k < 0 || !bytes.Equal(orig[k].Data, header[at].Data) {
+		return header
+	}
+	x, err := mpf.Parse(header[at].Data)
+	if err != nil {
+		return header
+	}
+	origBefore, origTotal := mpHeaderOffset(orig, k)
+	before, total := mpHeaderOffset(header, at)
+	for i := range x.Entries {
+		if i == 0 {
+			x.Entries[i].Size += uint32(total - origTotal)
+		} else if x.Entries[i].Offset != 0 {
+			x.Entries[i].Offset += uint32(total - before - (origTotal - origBefore))
+		}
+	}
+	data, err := x.Update(header[at].Data)
+	if err != nil {
+		return header
+	}
+	header = append([]iccjpeg.Segment(nil), header...)
+	header[at] = iccjpeg.Segment{MarkerID: app2Marker, Size: len(data), Data: data}
+	return header
+}
+
+// findMPF returns the index of the first segment of segs that holds an MP
+// index, or -1.
+func findMPF(segs []iccjpeg.Segment) int {
+	for k, s := range segs {
+		if s.MarkerID == app2Marker && bytes.HasPrefix(s.Data, []byte(mpf.Header)) {
+			return k
+		}
+	}
+	return -1
+}
+
+// mpHeaderOffset returns the offset in the file of the MP header in segs[at],
+// and the length of the file up to the end of segs.
+func mpHeaderOffset(segs []iccjpeg.Segment, at int) (offset, total int) {
+	total = 2
+	for k, s := range segs {
+		if k == at {
+			offset = total + 4 + len(mpf.Header)
+		}
+		total += 4 + len(s.Data)
+	}
+	return offset, total
+}
+
+// isMetadataMarker reports whether segments with the marker hold metadata.
+func isMetadataMarker(m uint8) bool {
+	return app0Marker <= m && m <= app15Marker || m == comMarker
+}
+
+// apply returns segs with the edit made.
+func (e Edit) apply(segs []iccjpeg.Segment) []iccjpeg.Segment {
+	out := make([]iccjpeg.Segment, 0, len(segs)+1)
+	replaced := false
+	for _, s := range segs {
+		if s.MarkerID != e.Marker || !bytes.HasPrefix(s.Data, e.Prefix) {
+			out = append(out, s)
+			continue
+		}
+		if e.Data != nil && !replaced {
+			out = append(out, e.segment())
+			replaced = true
+		}
+	}
+	if e.Data == nil || replaced {
+		return out
+	}
+
+	at := 0
+	for at < len(out) && isMetadataMarker(out[at].MarkerID) && out[at].MarkerID <= e.Marker {
+		at++
+	}
+	out = append(out, iccjpeg.Segment{})
+	copy(out[at+1:], out[at:])
+	out[at] = e.segment()
+	return out
+}
+
+func (e Edit) segment() iccjpeg.Segment {
+	return iccjpeg.Segment{MarkerID: e.Marker, Size: len(e.Data), Data: e.Data}
+}
@@ -0,0 +1,233 @@
+package jpeg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"testing"
+
+	"github.com/snapas/img/iccjpeg"
+	"github.com/snapas/img/mpf"
+)
+
+// metadataSegments returns the marker and data of each metadata segment in the
+// JPEG file b.
+func metadataSegments(t *testing.T, b []byte) []string {
+	p := iccjpeg.NewParser(bytes.NewReader(b))
+	if err := p.ReadSOI(); err != nil {
+		t.Fatal(err)
+	}
+	segs, err := p.GetMetadataSegments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s []string
+	for _, seg := range segs {
+		s = append(s, fmt.Sprintf("%x:%s", seg.MarkerID, seg.Data))
+	}
+	return s
+}
+
+func TestRewrite(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			m.Set(x, y, color.RGBA{uint8(6 * x), uint8(8 * y), 0x80, 0xff})
+		}
+	}
+	src := &bytes.Buffer{}
+	meta := &Meta{Exif: []byte("MM\x00*exif"), XMP: []byte("<x:xmpmeta/>"), App2: []byte("APP2")}
+	if err := Encode(src, m, nil, meta); err != nil {
+		t.Fatal(err)
+	}
+	// The image data starts at the DQT segment, after the metadata.
+	data := src.Bytes()[bytes.Index(src.Bytes(), []byte{0xff, dqtMarker}):]
+
+	testCases := []struct {
+		desc  string
+		edits []Edit
+		want  []string
+	}{
+		{
+			"no edits",
+			nil,
+			[]string{"e1:Exif\x00\x00MM\x00*exif", "e1:" + xmpHeader + "<x:xmpmeta/>", "e2:APP2"},
+		},
+		{
+			"replace XMP",
+			[]Edit{{Marker: app1Marker, Prefix: []byte(xmpHeader), Data: []byte(xmpHeader + "<new/>")}},
+			[]string{"e1:Exif\x00\x00MM\x00*exif", "e1:" + xmpHeader + "<new/>", "e2:APP2"},
+		},
+		{
+			"delete EXIF",
+			[]Edit{{Marker: app1Marker, Prefix: []byte(exifHeader)}},
+			[]string{"e1:" + xmpHeader + "<x:xmpmeta/>", "e2:APP2"},
+		},
+		{
+			"replace all APP1",
+			[]Edit{{Marker: app1Marker, Data: []byte("one")}},
+			[]string{"e1:one", "e2:APP2"},
+		},
+		{
+			"insert in order",
+			[]Edit{
+				{Marker: comMarker, Data: []byte("comment")},
+				{Marker: app0Marker, Data: []byte("JFIF\x00")},
+				{Marker: app1Marker, Prefix: []byte("x"), Data: []byte("x")},
+				{Marker: app14Marker, Data: []byte("Adobe")},
+			},
+			[]string{
+				"e0:JFIF\x00", "e1:Exif\x00\x00MM\x00*exif", "e1:" + xmpHeader + "<x:xmpmeta/>", "e1:x",
+				"e2:APP2", "ee:Adobe", "fe:comment",
+			},
+		},
+		{
+			"delete missing",
+			[]Edit{{Marker: app15Marker}},
+			[]string{"e1:Exif\x00\x00MM\x00*exif", "e1:" + xmpHeader + "<x:xmpmeta/>", "e2:APP2"},
+		},
+	}
+	for _, tc := range testCases {
+		dst := &bytes.Buffer{}
+		if err := Rewrite(bytes.NewReader(src.Bytes()), dst, tc.edits...); err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		if got := metadataSegments(t, dst.Bytes()); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tc.want) {
+			t.Errorf("%s: got segments %q, want %q", tc.desc, got, tc.want)
+		}
+		if !bytes.HasSuffix(dst.Bytes(), data) {
+			t.Errorf("%s: image data changed", tc.desc)
+		}
+	}
+}
+
+func TestRewriteMPF(t *testing.T) {
+	src, err := ioutil.ReadFile("../testdata/gopro.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, edits := range [][]Edit{
+		nil,
+		{{Marker: comMarker, Data: []byte("a comment")}},
+		{{Marker: app1Marker, Prefix: []byte(exifHeader)}},
+		{{Marker: app15Marker, Data: bytes.Repeat([]byte("x"), 1000)}, {Marker: app0Marker, Data: []byte("JFIF\x00")}},
+	} {
+		dst := &bytes.Buffer{}
+		if err := Rewrite(bytes.NewReader(src), dst, edits...); err != nil {
+			t.Fatal(err)
+		}
+		b := dst.Bytes()
+		p := iccjpeg.NewParser(bytes.NewReader(b))
+		p.ReadSOI()
+		segs, err := p.GetMetadataSegments()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var x *mpf.Index
+		at := 0
+		for _, s := range segs {
+			if s.MarkerID == app2Marker && bytes.HasPrefix(s.Data, []byte(mpf.Header)) {
+				if x, err = mpf.Parse(s.Data); err != nil {
+					t.Fatal(err)
+				}
+				at = s.Offset
+			}
+		}
+		if x == nil {
+			t.Fatal("lost MP index")
+		}
+		if len(x.Entries) < 2 {
+			t.Fatalf("got %d MP entries", len(x.Entries))
+		}
+		// The primary image ends with its EOI marker, and each secondary image starts with an SOI marker.
+		if size := int(x.Entries[0].Size); size > len(b) || !bytes.HasSuffix(b[:size], []byte{0xff, eoiMarker}) {
+			t.Errorf("%d edits: primary image size %d is off", len(edits), size)
+		}
+		for _, e := range x.Entries[1:] {
+			off := at + len(mpf.Header) + int(e.Offset)
+			if off+int(e.Size) > len(b) || !bytes.HasPrefix(b[off:], []byte{0xff, soiMarker}) {
+				t.Errorf("%d edits: secondary image offset %d is off", len(edits), e.Offset)
+			}
+		}
+	}
+}
+
+func TestRewriteMPFAttributes(t *testing.T) {
+	// An MP index followed by the MP attribute IFD of the primary image,
+	// holding its MP individual image number, which the next IFD offset of
+	// the index points to.
+	x := &mpf.Index{Entries: []mpf.Entry{{Attribute: uint32(mpf.Baseline)}, {Attribute: uint32(mpf.LargeThumbnailVGA)}}}
+	app2 := x.Marshal()
+	next := len(mpf.Header) + 8 + 2 + 12*3
+	binary.BigEndian.PutUint32(app2[next:], uint32(len(app2)-len(mpf.Header)))
+	attributes := []byte("\x00\x01\xb1\x01\x00\x04\x00\x00\x00\x01\x00\x00\x00\x01\x00\x00\x00\x00")
+	app2 = append(app2, attributes...)
+
+	primary, secondary := &bytes.Buffer{}, &bytes.Buffer{}
+	m := image.NewGray(image.Rect(0, 0, 16, 16))
+	if err := Encode(primary, m, nil, &Meta{MPF: app2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Encode(secondary, m, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	src := primary.Bytes()
+	at := bytes.Index(src, []byte(mpf.Header))
+	x.Entries[0].Size = uint32(len(src))
+	x.Entries[1].Size, x.Entries[1].Offset = uint32(secondary.Len()), uint32(len(src)-at-len(mpf.Header))
+	app2, err := x.Update(app2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(src[at:], app2)
+	src = append(src, secondary.Bytes()...)
+
+	dst := &bytes.Buffer{}
+	if err := Rewrite(bytes.NewReader(src), dst, Edit{Marker: comMarker, Data: []byte("a comment")}); err != nil {
+		t.Fatal(err)
+	}
+	b := dst.Bytes()
+	at = bytes.Index(b, []byte(mpf.Header))
+	got := b[at : at+len(app2)]
+	y, err := mpf.Parse(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the sizes and offsets of the entries differ from the original.
+	if want, err := y.Update(app2); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("MP index segment changed beyond its entries:\n%q\nwant\n%q", got, app2)
+	}
+	images, err := y.Images(b, at+len(mpf.Header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(images[0], secondary.Bytes()) || int(y.Entries[0].Size) != len(b)-secondary.Len() {
+		t.Errorf("got MP entries %+v", y.Entries)
+	}
+}
+
+func TestRewriteErrors(t *testing.T) {
+	src := &bytes.Buffer{}
+	if err := Encode(src, image.NewGray(image.Rect(0, 0, 8, 8)), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		desc  string
+		in    []byte
+		edits []Edit
+	}{
+		{"not a metadata marker", src.Bytes(), []Edit{{Marker: dqtMarker}}},
+		{"too much data", src.Bytes(), []Edit{{Marker: app1Marker, Data: make([]byte, 0x10000)}}},
+		{"not a JPEG", []byte("\x89PNG\r\n\x1a\n"), nil},
+		{"truncated", src.Bytes()[:20], nil},
+	}
+	for _, tc := range testCases {
+		if err := Rewrite(bytes.NewReader(tc.in), &bytes.Buffer{}, tc.edits...); err == nil {
+			t.Errorf("%s: no error", tc.desc)
+		}
+	}
+}
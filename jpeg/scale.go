@@ -0,0 +1,66 @@
+package jpeg
+
+import (
+	"image"
+	"math"
+)
+
+// scaledIDCTTables holds, for each reduced block size n of 1, 2 and 4, the
+// basis functions C(u)·cos((2x+1)uπ/2n)/2 of the n-point inverse DCT, indexed
+// by x*n+u.
+var scaledIDCTTables = func() (t [5][]float64) {
+	for _, n := range []int{1, 2, 4} {
+		t[n] = make([]float64, n*n)
+		for x := 0; x < n; x++ {
+			for u := 0; u < n; u++ {
+				c := math.Cos(float64((2*x+1)*u) * math.Pi / float64(2*n))
+				if u == 0 {
+					c = math.Sqrt2 / 2
+				}
+				t[n][x*n+u] = c / 2
+			}
+		}
+	}
+	return t
+}()
+
+// scaledIDCT performs a reduced inverse DCT on the dequantized coefficients of
+// b, which yields n by n pixels that each average n/8 by n/8 of the pixels of
+// the full inverse DCT. Only the lowest n by n frequencies contribute, as in
+// libjpeg's jidctred.c. The pixels are stored in the top left corner of b,
+// with the same stride of 8 as idct, and without the level shift.
+func scaledIDCT(b *block, n int) {
+	if n == 1 {
+		// The DC coefficient is 8 times the block's average.
+		b[0] = (b[0] + 4) >> 3
+		return
+	}
+	t := scaledIDCTTables[n]
+	var tmp [16]float64
+	// Rows first, keeping the n lowest frequencies of each.
+	for v := 0; v < n; v++ {
+		for x := 0; x < n; x++ {
+			var sum float64
+			for u := 0; u < n; u++ {
+				sum += t[x*n+u] * float64(b[v*8+u])
+			}
+			tmp[v*n+x] = sum
+		}
+	}
+	// Then columns.
+	for x := 0; x < n; x++ {
+		for y := 0; y < n; y++ {
+			var sum float64
+			for v := 0; v < n; v++ {
+				sum += t[y*n+v] * tmp[v*n+x]
+			}
+			b[y*8+x] = int32(math.Floor(sum + 0.5))
+		}
+	}
+}
+
+// scaledBounds returns the bounds of the decoded image, which are reduced by
+// the same factor as the blocks when decoding scaled down.
+func (d *decoder) scaledBounds() image.Rectangle {
+	return image.Rect(0, 0, (d.width*d.blockDim+7)/8, (d.height*d.blockDim+7)/8)
+}
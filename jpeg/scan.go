@@ -11,8 +11,11 @@ import (
 // makeImg allocates and initializes the destination image.
 func (d *decoder) makeImg(mxx, myy int) {
 	if d.nComp == 1 {
-		m := image.NewGray(image.Rect(0, 0, 8*mxx, 8*myy))
-		d.img1 = m.SubImage(image.Rect(0, 0, d.width, d.height)).(*image.Gray)
+		m := image.NewGray(image.Rect(0, 0, d.blockDim*mxx, d.blockDim*myy))
+		if d.allowTruncated {
+			fillNeutral(m.Pix)
+		}
+		d.img1 = m.SubImage(d.scaledBounds()).(*image.Gray)
 		return
 	}
 
@@ -37,13 +40,30 @@ func (d *decoder) makeImg(mxx, myy int) {
 	default:
 		panic("unreachable")
 	}
-	m := image.NewYCbCr(image.Rect(0, 0, 8*h0*mxx, 8*v0*myy), subsampleRatio)
-	d.img3 = m.SubImage(image.Rect(0, 0, d.width, d.height)).(*image.YCbCr)
+	m := image.NewYCbCr(image.Rect(0, 0, d.blockDim*h0*mxx, d.blockDim*v0*myy), subsampleRatio)
+	if d.allowTruncated {
+		fillNeutral(m.Y)
+		fillNeutral(m.Cb)
+		fillNeutral(m.Cr)
+	}
+	d.img3 = m.SubImage(d.scaledBounds()).(*image.YCbCr)
 
 	if d.nComp == 4 {
 		h3, v3 := d.comp[3].h, d.comp[3].v
-		d.blackPix = make([]byte, 8*h3*mxx*8*v3*myy)
-		d.blackStride = 8 * h3 * mxx
+		d.blackPix = make([]byte, d.blockDim*h3*mxx*d.blockDim*v3*myy)
+		d.blackStride = d.blockDim * h3 * mxx
+		if d.allowTruncated {
+			fillNeutral(d.blackPix)
+		}
+	}
+}
+
+// fillNeutral sets every sample in pix to 0x80, the level-shifted zero that an
+// all-zero block of coefficients decodes to. Pixels that never receive data in
+// a truncated image are left at this value, which is neutral grey.
+func fillNeutral(pix []byte) {
+	for i := range pix {
+		pix[i] = 0x80
 	}
 }
 
@@ -149,6 +169,8 @@ func (d *decoder) processSOS(n int) error {
 	if d.img1 == nil && d.img3 == nil {
 		d.makeImg(mxx, myy)
 	}
+	d.scans++
+	d.mcus, d.totalMCUs = 0, mxx*myy
 	if d.progressive {
 		for i := 0; i < nComp; i++ {
 			compIndex := scan[i].compIndex
@@ -304,6 +326,7 @@ func (d *decoder) processSOS(n int) error {
 				} // for j
 			} // for i
 			mcu++
+			d.mcus = mcu
 			if d.ri > 0 && mcu%d.ri == 0 && mcu < mxx*myy {
 				// A more sophisticated decoder could use RST[0-7] markers to resynchronize from corrupt input,
 				// but this one assumes well-formed input, and hence the restart marker follows immediately.
@@ -479,35 +502,40 @@ func (d *decoder) reconstructProgressiveImage() error {
 }
 
 // reconstructBlock dequantizes, performs the inverse DCT and stores the block
-// to the image.
+// to the image, reduced to d.blockDim pixels square when decoding scaled down.
 func (d *decoder) reconstructBlock(b *block, bx, by, compIndex int) error {
 	qt := &d.quant[d.comp[compIndex].tq]
 	for zig := 0; zig < blockSize; zig++ {
 		b[unzig[zig]] *= qt[zig]
 	}
-	idct(b)
+	n := d.blockDim
+	if n == 8 {
+		idct(b)
+	} else {
+		scaledIDCT(b, n)
+	}
 	dst, stride := []byte(nil), 0
 	if d.nComp == 1 {
-		dst, stride = d.img1.Pix[8*(by*d.img1.Stride+bx):], d.img1.Stride
+		dst, stride = d.img1.Pix[n*(by*d.img1.Stride+bx):], d.img1.Stride
 	} else {
 		switch compIndex {
 		case 0:
-			dst, stride = d.img3.Y[8*(by*d.img3.YStride+bx):], d.img3.YStride
+			dst, stride = d.img3.Y[n*(by*d.img3.YStride+bx):], d.img3.YStride
 		case 1:
-			dst, stride = d.img3.Cb[8*(by*d.img3.CStride+bx):], d.img3.CStride
+			dst, stride = d.img3.Cb[n*(by*d.img3.CStride+bx):], d.img3.CStride
 		case 2:
-			dst, stride = d.img3.Cr[8*(by*d.img3.CStride+bx):], d.img3.CStride
+			dst, stride = d.img3.Cr[n*(by*d.img3.CStride+bx):], d.img3.CStride
 		case 3:
-			dst, stride = d.blackPix[8*(by*d.blackStride+bx):], d.blackStride
+			dst, stride = d.blackPix[n*(by*d.blackStride+bx):], d.blackStride
 		default:
 			return UnsupportedError("too many components")
 		}
 	}
 	// Level shift by +128, clip to [0, 255], and write to dst.
-	for y := 0; y < 8; y++ {
+	for y := 0; y < n; y++ {
 		y8 := y * 8
 		yStride := y * stride
-		for x := 0; x < 8; x++ {
+		for x := 0; x < n; x++ {
 			c := b[y8+x]
 			if c < -128 {
 				c = 0
@@ -7,6 +7,7 @@ package jpeg
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"io"
@@ -311,10 +312,11 @@ func (e *encoder) writeDQT() {
 	}
 }
 
-// writeSOF0 writes the Start Of Frame (Baseline Sequential) marker.
-func (e *encoder) writeSOF0(size image.Point, nComponent int) {
+// writeSOF writes the Start Of Frame marker, which is sof0Marker for baseline
+// and sof2Marker for progressive images.
+func (e *encoder) writeSOF(marker uint8, size image.Point, nComponent int, subsampling Subsampling) {
 	markerlen := 8 + 3*nComponent
-	e.writeMarkerHeader(sof0Marker, markerlen)
+	e.writeMarkerHeader(marker, markerlen)
 	e.buf[0] = 8 // 8-bit color.
 	e.buf[1] = uint8(size.Y >> 8)
 	e.buf[2] = uint8(size.Y & 0xff)
@@ -329,8 +331,13 @@ func (e *encoder) writeSOF0(size image.Point, nComponent int) {
 	} else {
 		for i := 0; i < nComponent; i++ {
 			e.buf[3*i+6] = uint8(i + 1)
-			// We use 4:2:0 chroma subsampling.
-			e.buf[3*i+7] = "\x22\x11\x11"[i]
+			// Luma has 2x2 samples for each chroma sample with 4:2:0
+			// subsampling, and 1x1 with 4:4:4.
+			if subsampling == Subsample444 {
+				e.buf[3*i+7] = 0x11
+			} else {
+				e.buf[3*i+7] = "\x22\x11\x11"[i]
+			}
 			e.buf[3*i+8] = "\x00\x01\x01"[i]
 		}
 	}
@@ -360,14 +367,35 @@ func (e *encoder) writeDHT(nComponent int) {
 // returning the post-quantized DC value of the DCT-transformed block. b is in
 // natural (not zig-zag) order.
 func (e *encoder) writeBlock(b *block, q quantIndex, prevDC int32) int32 {
-	fdct(b)
+	var z coefs
+	e.quantize(&z, b, q)
 	// Emit the DC delta.
-	dc := div(b[0], 8*int32(e.quant[q][0]))
+	dc := int32(z[0])
 	e.emitHuffRLE(huffIndex(2*q+0), 0, dc-prevDC)
 	// Emit the AC components.
-	h, runLength := huffIndex(2*q+1), int32(0)
-	for zig := 1; zig < blockSize; zig++ {
-		ac := div(b[unzig[zig]], 8*int32(e.quant[q][zig]))
+	e.emitAC(huffIndex(2*q+1), &z, 1, blockSize-1)
+	return dc
+}
+
+// coefs holds the quantized DCT coefficients of a block, in zig-zag order.
+type coefs [blockSize]int16
+
+// quantize transforms b, which is in natural order, and stores its
+// coefficients quantized with the given table in z.
+func (e *encoder) quantize(z *coefs, b *block, q quantIndex) {
+	fdct(b)
+	for zig := 0; zig < blockSize; zig++ {
+		z[zig] = int16(div(b[unzig[zig]], 8*int32(e.quant[q][zig])))
+	}
+}
+
+// emitAC emits the coefficients of z from the zig-zag indexes ss to se
+// inclusive with the given Huffman encoder, ending with an EOB if the last
+// ones are zero.
+func (e *encoder) emitAC(h huffIndex, z *coefs, ss, se int) {
+	runLength := int32(0)
+	for zig := ss; zig <= se; zig++ {
+		ac := int32(z[zig])
 		if ac == 0 {
 			runLength++
 		} else {
@@ -382,7 +410,6 @@ func (e *encoder) writeBlock(b *block, q quantIndex, prevDC int32) int32 {
 	if runLength > 0 {
 		e.emitHuff(h, 0x00)
 	}
-	return dc
 }
 
 // toYCbCr converts the 8x8 region of m whose top-left corner is p to its
@@ -402,6 +429,20 @@ func toYCbCr(m image.Image, p image.Point, yBlock, cbBlock, crBlock *block) {
 	}
 }
 
+// toY converts the 8x8 region of m whose top-left corner is p to its gray
+// values, for images with a gray color model.
+func toY(m image.Image, p image.Point, yBlock *block) {
+	b := m.Bounds()
+	xmax := b.Max.X - 1
+	ymax := b.Max.Y - 1
+	for j := 0; j < 8; j++ {
+		for i := 0; i < 8; i++ {
+			c := color.GrayModel.Convert(m.At(min(p.X+i, xmax), min(p.Y+j, ymax))).(color.Gray)
+			yBlock[8*j+i] = int32(c.Y)
+		}
+	}
+}
+
 // grayToY stores the 8x8 region of m whose top-left corner is p in yBlock.
 func grayToY(m *image.Gray, p image.Point, yBlock *block) {
 	b := m.Bounds()
@@ -416,6 +457,34 @@ func grayToY(m *image.Gray, p image.Point, yBlock *block) {
 	}
 }
 
+// yCbCrToY is a specialized version of toY for image.YCbCr images, which
+// discards their chroma.
+func yCbCrToY(m *image.YCbCr, p image.Point, yBlock *block) {
+	b := m.Bounds()
+	xmax := b.Max.X - 1
+	ymax := b.Max.Y - 1
+	for j := 0; j < 8; j++ {
+		for i := 0; i < 8; i++ {
+			yBlock[8*j+i] = int32(m.Y[m.YOffset(min(p.X+i, xmax), min(p.Y+j, ymax))])
+		}
+	}
+}
+
+// gray16ToY is a specialized version of toY for image.Gray16 images.
+func gray16ToY(m *image.Gray16, p image.Point, yBlock *block) {
+	b := m.Bounds()
+	xmax := b.Max.X - 1
+	ymax := b.Max.Y - 1
+	pix := m.Pix
+	for j := 0; j < 8; j++ {
+		for i := 0; i < 8; i++ {
+			idx := m.PixOffset(min(p.X+i, xmax), min(p.Y+j, ymax))
+			// Keep the most significant byte of the big-endian sample.
+			yBlock[8*j+i] = int32(pix[idx])
+		}
+	}
+}
+
 // rgbaToYCbCr is a specialized version of toYCbCr for image.RGBA images.
 func rgbaToYCbCr(m *image.RGBA, p image.Point, yBlock, cbBlock, crBlock *block) {
 	b := m.Bounds()
@@ -465,6 +534,318 @@ func yCbCrToYCbCr(m *image.YCbCr, p image.Point, yBlock, cbBlock, crBlock *block
 	}
 }
 
+// background is the opaque color that non-opaque images are composited onto
+// before encoding.
+type background struct {
+	// r, g and b are 16-bit color values, and r8, g8 and b8 are the same
+	// values reduced to 8 bits.
+	r, g, b    uint32
+	r8, g8, b8 uint32
+}
+
+func newBackground(c color.Color) *background {
+	r, g, b, _ := c.RGBA()
+	return &background{
+		r: r, g: g, b: b,
+		r8: r >> 8, g8: g >> 8, b8: b >> 8,
+	}
+}
+
+// over composites the premultiplied 16-bit color (r, g, b, a) onto bg and
+// returns the result as 8-bit RGB values.
+func (bg *background) over(r, g, b, a uint32) (uint8, uint8, uint8) {
+	ia := 0xffff - a
+	return uint8((r + bg.r*ia/0xffff) >> 8),
+		uint8((g + bg.g*ia/0xffff) >> 8),
+		uint8((b + bg.b*ia/0xffff) >> 8)
+}
+
+// toYCbCrOver is like toYCbCr, but composites m onto bg.
+func toYCbCrOver(m image.Image, bg *background, p image.Point, yBlock, cbBlock, crBlock *block) {
+	b := m.Bounds()
+	xmax := b.Max.X - 1
+	ymax := b.Max.Y - 1
+	for j := 0; j < 8; j++ {
+		for i := 0; i < 8; i++ {
+			r, g, b, a := m.At(min(p.X+i, xmax), min(p.Y+j, ymax)).RGBA()
+			yy, cb, cr := color.RGBToYCbCr(bg.over(r, g, b, a))
+			yBlock[8*j+i] = int32(yy)
+			cbBlock[8*j+i] = int32(cb)
+			crBlock[8*j+i] = int32(cr)
+		}
+	}
+}
+
+// nrgbaToYCbCr is a specialized version of toYCbCr for image.NRGBA images.
+// Non-opaque pixels are composited onto bg, or onto black if bg is nil, as
+// toYCbCr does.
+func nrgbaToYCbCr(m *image.NRGBA, bg *background, p image.Point, yBlock, cbBlock, crBlock *block) {
+	b := m.Bounds()
+	xmax := b.Max.X - 1
+	ymax := b.Max.Y - 1
+	for j := 0; j < 8; j++ {
+		sj := p.Y + j
+		if sj > ymax {
+			sj = ymax
+		}
+		offset := (sj-b.Min.Y)*m.Stride - b.Min.X*4
+		for i := 0; i < 8; i++ {
+			sx := p.X + i
+			if sx > xmax {
+				sx = xmax
+			}
+			pix := m.Pix[offset+sx*4:]
+			r, g, bb := pix[0], pix[1], pix[2]
+			if a := uint32(pix[3]); a != 0xff && bg != nil {
+				ia := 0xff - a
+				r = uint8((uint32(r)*a + bg.r8*ia + 0x7f) / 0xff)
+				g = uint8((uint32(g)*a + bg.g8*ia + 0x7f) / 0xff)
+				bb = uint8((uint32(bb)*a + bg.b8*ia + 0x7f) / 0xff)
+			} else if a != 0xff {
+				// This is the same arithmetic as color.NRGBA's RGBA method.
+				a16 := a * 0x101
+				r = uint8(uint32(r) * 0x101 * a16 / 0xffff >> 8)
+				g = uint8(uint32(g) * 0x101 * a16 / 0xffff >> 8)
+				bb = uint8(uint32(bb) * 0x101 * a16 / 0xffff >> 8)
+			}
+			yy, cb, cr := color.RGBToYCbCr(r, g, bb)
+			yBlock[8*j+i] = int32(yy)
+			cbBlock[8*j+i] = int32(cb)
+			crBlock[8*j+i] = int32(cr)
+		}
+	}
+}
+
+// rgba64ToYCbCr is a specialized version of toYCbCr for image.RGBA64 images.
+func rgba64ToYCbCr(m *image.RGBA64, p image.Point, yBlock, cbBlock, crBlock *block) {
+	b := m.Bounds()
+	xmax := b.Max.X - 1
+	ymax := b.Max.Y - 1
+	for j := 0; j < 8; j++ {
+		sj := p.Y + j
+		if sj > ymax {
+			sj = ymax
+		}
+		offset := (sj-b.Min.Y)*m.Stride - b.Min.X*8
+		for i := 0; i < 8; i++ {
+			sx := p.X + i
+			if sx > xmax {
+				sx = xmax
+			}
+			// Samples are big-endian, so the even bytes are the most
+			// significant.
+			pix := m.Pix[offset+sx*8:]
+			yy, cb, cr := color.RGBToYCbCr(pix[0], pix[2], pix[4])
+			yBlock[8*j+i] = int32(yy)
+			cbBlock[8*j+i] = int32(cb)
+			crBlock[8*j+i] = int32(cr)
+		}
+	}
+}
+
+// cmykToYCbCr is a specialized version of toYCbCr for image.CMYK images.
+func cmykToYCbCr(m *image.CMYK, p image.Point, yBlock, cbBlock, crBlock *block) {
+	b := m.Bounds()
+	xmax := b.Max.X - 1
+	ymax := b.Max.Y - 1
+	for j := 0; j < 8; j++ {
+		sj := p.Y + j
+		if sj > ymax {
+			sj = ymax
+		}
+		offset := (sj-b.Min.Y)*m.Stride - b.Min.X*4
+		for i := 0; i < 8; i++ {
+			sx := p.X + i
+			if sx > xmax {
+				sx = xmax
+			}
+			pix := m.Pix[offset+sx*4:]
+			r, g, bb := color.CMYKToRGB(pix[0], pix[1], pix[2], pix[3])
+			yy, cb, cr := color.RGBToYCbCr(r, g, bb)
+			yBlock[8*j+i] = int32(yy)
+			cbBlock[8*j+i] = int32(cb)
+			crBlock[8*j+i] = int32(cr)
+		}
+	}
+}
+
+// paletteLUT holds the YCbCr values of each entry in an image.Paletted's
+// palette. Indexes outside of the palette map to black.
+type paletteLUT [256][3]uint8
+
+// newPaletteLUT converts the palette p, compositing non-opaque entries onto bg
+// if it is non-nil.
+func newPaletteLUT(p color.Palette, bg *background) *paletteLUT {
+	lut := new(paletteLUT)
+	for i := range lut {
+		if i >= len(p) {
+			lut[i] = [3]uint8{0, 128, 128}
+			continue
+		}
+		r, g, b, a := p[i].RGBA()
+		var yy, cb, cr uint8
+		if bg != nil {
+			yy, cb, cr = color.RGBToYCbCr(bg.over(r, g, b, a))
+		} else {
+			yy, cb, cr = color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+		}
+		lut[i] = [3]uint8{yy, cb, cr}
+	}
+	return lut
+}
+
+// palettedToYCbCr is a specialized version of toYCbCr for image.Paletted
+// images, using the palette's precomputed YCbCr values.
+func palettedToYCbCr(m *image.Paletted, lut *paletteLUT, p image.Point, yBlock, cbBlock, crBlock *block) {
+	b := m.Bounds()
+	xmax := b.Max.X - 1
+	ymax := b.Max.Y - 1
+	for j := 0; j < 8; j++ {
+		for i := 0; i < 8; i++ {
+			c := &lut[m.Pix[m.PixOffset(min(p.X+i, xmax), min(p.Y+j, ymax))]]
+			yBlock[8*j+i] = int32(c[0])
+			cbBlock[8*j+i] = int32(c[1])
+			crBlock[8*j+i] = int32(c[2])
+		}
+	}
+}
+
+// isGray reports whether m should be encoded as a single-component image.
+func isGray(m image.Image) bool {
+	switch m.ColorModel() {
+	case color.GrayModel, color.Gray16Model:
+		return true
+	}
+	return false
+}
+
+// isMonochrome reports whether every pixel of m is within tolerance of a
+// neutral gray, in 8-bit units. Images with a YCbCr model are checked on their
+// chroma, and other images on the spread of their RGB channels.
+func isMonochrome(m image.Image, tolerance int) bool {
+	b := m.Bounds()
+	within := func(x, y uint8) bool {
+		d := int(x) - int(y)
+		return -tolerance <= d && d <= tolerance
+	}
+	switch m := m.(type) {
+	case *image.YCbCr:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				ci := m.COffset(x, y)
+				if !within(m.Cb[ci], 128) || !within(m.Cr[ci], 128) {
+					return false
+				}
+			}
+		}
+		return true
+	case *image.RGBA:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			pix := m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)]
+			for i := 0; i < len(pix); i += 4 {
+				if !within(pix[i], pix[i+1]) || !within(pix[i+1], pix[i+2]) || !within(pix[i], pix[i+2]) {
+					return false
+				}
+			}
+		}
+		return true
+	case *image.NRGBA:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			pix := m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)]
+			for i := 0; i < len(pix); i += 4 {
+				if !within(pix[i], pix[i+1]) || !within(pix[i+1], pix[i+2]) || !within(pix[i], pix[i+2]) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bb, _ := m.At(x, y).RGBA()
+			r8, g8, b8 := uint8(r>>8), uint8(g>>8), uint8(bb>>8)
+			if !within(r8, g8) || !within(g8, b8) || !within(r8, b8) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// yConverter returns the function that converts m's 8x8 blocks to gray values,
+// for images with a gray color model or that were detected as monochrome.
+func yConverter(m image.Image) func(p image.Point, yBlock *block) {
+	switch m := m.(type) {
+	case *image.YCbCr:
+		return func(p image.Point, yBlock *block) { yCbCrToY(m, p, yBlock) }
+	case *image.Gray:
+		return func(p image.Point, yBlock *block) { grayToY(m, p, yBlock) }
+	case *image.Gray16:
+		return func(p image.Point, yBlock *block) { gray16ToY(m, p, yBlock) }
+	}
+	return func(p image.Point, yBlock *block) { toY(m, p, yBlock) }
+}
+
+// isOpaque reports whether m is known to be fully opaque.
+func isOpaque(m image.Image) bool {
+	if o, ok := m.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// yCbCrConverter returns the function that converts m's 8x8 blocks to YCbCr
+// values, using a specialized version of toYCbCr where one exists. If bg is
+// non-nil and m is not opaque, m is composited onto bg.
+func yCbCrConverter(m image.Image, bg *background) func(p image.Point, yBlock, cbBlock, crBlock *block) {
+	if bg != nil && !isOpaque(m) {
+		switch m := m.(type) {
+		case *image.NRGBA:
+			return func(p image.Point, yBlock, cbBlock, crBlock *block) {
+				nrgbaToYCbCr(m, bg, p, yBlock, cbBlock, crBlock)
+			}
+		case *image.Paletted:
+			lut := newPaletteLUT(m.Palette, bg)
+			return func(p image.Point, yBlock, cbBlock, crBlock *block) {
+				palettedToYCbCr(m, lut, p, yBlock, cbBlock, crBlock)
+			}
+		}
+		return func(p image.Point, yBlock, cbBlock, crBlock *block) {
+			toYCbCrOver(m, bg, p, yBlock, cbBlock, crBlock)
+		}
+	}
+	switch m := m.(type) {
+	case *image.RGBA:
+		return func(p image.Point, yBlock, cbBlock, crBlock *block) {
+			rgbaToYCbCr(m, p, yBlock, cbBlock, crBlock)
+		}
+	case *image.YCbCr:
+		return func(p image.Point, yBlock, cbBlock, crBlock *block) {
+			yCbCrToYCbCr(m, p, yBlock, cbBlock, crBlock)
+		}
+	case *image.NRGBA:
+		return func(p image.Point, yBlock, cbBlock, crBlock *block) {
+			nrgbaToYCbCr(m, nil, p, yBlock, cbBlock, crBlock)
+		}
+	case *image.RGBA64:
+		return func(p image.Point, yBlock, cbBlock, crBlock *block) {
+			rgba64ToYCbCr(m, p, yBlock, cbBlock, crBlock)
+		}
+	case *image.CMYK:
+		return func(p image.Point, yBlock, cbBlock, crBlock *block) {
+			cmykToYCbCr(m, p, yBlock, cbBlock, crBlock)
+		}
+	case *image.Paletted:
+		lut := newPaletteLUT(m.Palette, nil)
+		return func(p image.Point, yBlock, cbBlock, crBlock *block) {
+			palettedToYCbCr(m, lut, p, yBlock, cbBlock, crBlock)
+		}
+	}
+	return func(p image.Point, yBlock, cbBlock, crBlock *block) {
+		toYCbCr(m, p, yBlock, cbBlock, crBlock)
+	}
+}
+
 // scale scales the 16x16 region represented by the 4 src blocks to the 8x8
 // dst block.
 func scale(dst *block, src *[4]block) {
@@ -505,82 +886,312 @@ var sosHeaderYCbCr = []byte{
 	0x11, 0x03, 0x11, 0x00, 0x3f, 0x00,
 }
 
-// writeSOS writes the StartOfScan marker.
-func (e *encoder) writeSOS(m image.Image) {
-	switch m.(type) {
-	case *image.Gray:
-		e.write(sosHeaderY)
-	default:
-		e.write(sosHeaderYCbCr)
-	}
+// forEachBlock calls f with each block of the image in the order of a
+// baseline scan, along with its component and its position in the grid of
+// the component's blocks. If gray is set, only the image's luminance is
+// converted. Otherwise, the chroma is subsampled as given, and non-opaque
+// images are composited onto bg, if it is non-nil. f may modify the block.
+func forEachBlock(m image.Image, gray bool, subsampling Subsampling, bg *background, f func(c, bx, by int, b *block)) {
 	var (
 		// Scratch buffers to hold the YCbCr values.
 		// The blocks are in natural (not zig-zag) order.
 		b      block
 		cb, cr [4]block
-		// DC components are delta-encoded.
-		prevDCY, prevDCCb, prevDCCr int32
 	)
 	bounds := m.Bounds()
-	switch m := m.(type) {
-	// TODO(wathiede): switch on m.ColorModel() instead of type.
-	case *image.Gray:
+	if gray {
+		convert := yConverter(m)
 		for y := bounds.Min.Y; y < bounds.Max.Y; y += 8 {
 			for x := bounds.Min.X; x < bounds.Max.X; x += 8 {
 				p := image.Pt(x, y)
-				grayToY(m, p, &b)
-				prevDCY = e.writeBlock(&b, 0, prevDCY)
+				convert(p, &b)
+				f(0, (x-bounds.Min.X)/8, (y-bounds.Min.Y)/8, &b)
 			}
 		}
-	default:
-		rgba, _ := m.(*image.RGBA)
-		ycbcr, _ := m.(*image.YCbCr)
+	} else if subsampling == Subsample444 {
+		convert := yCbCrConverter(m, bg)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y += 8 {
+			for x := bounds.Min.X; x < bounds.Max.X; x += 8 {
+				p := image.Pt(x, y)
+				convert(p, &b, &cb[0], &cr[0])
+				bx, by := (x-bounds.Min.X)/8, (y-bounds.Min.Y)/8
+				f(0, bx, by, &b)
+				f(1, bx, by, &cb[0])
+				f(2, bx, by, &cr[0])
+			}
+		}
+	} else {
+		convert := yCbCrConverter(m, bg)
 		for y := bounds.Min.Y; y < bounds.Max.Y; y += 16 {
 			for x := bounds.Min.X; x < bounds.Max.X; x += 16 {
+				bx, by := (x-bounds.Min.X)/16, (y-bounds.Min.Y)/16
 				for i := 0; i < 4; i++ {
 					xOff := (i & 1) * 8
 					yOff := (i & 2) * 4
 					p := image.Pt(x+xOff, y+yOff)
-					if rgba != nil {
-						rgbaToYCbCr(rgba, p, &b, &cb[i], &cr[i])
-					} else if ycbcr != nil {
-						yCbCrToYCbCr(ycbcr, p, &b, &cb[i], &cr[i])
-					} else {
-						toYCbCr(m, p, &b, &cb[i], &cr[i])
-					}
-					prevDCY = e.writeBlock(&b, 0, prevDCY)
+					convert(p, &b, &cb[i], &cr[i])
+					f(0, 2*bx+i&1, 2*by+i>>1, &b)
 				}
 				scale(&b, &cb)
-				prevDCCb = e.writeBlock(&b, 1, prevDCCb)
+				f(1, bx, by, &b)
 				scale(&b, &cr)
-				prevDCCr = e.writeBlock(&b, 1, prevDCCr)
+				f(2, bx, by, &b)
 			}
 		}
 	}
+}
+
+// writeSOS writes the StartOfScan marker. If gray is set, only the image's
+// luminance is written. Otherwise, the chroma is subsampled as given, and
+// non-opaque images are composited onto bg, if it is non-nil.
+func (e *encoder) writeSOS(m image.Image, gray bool, subsampling Subsampling, bg *background) {
+	if gray {
+		e.write(sosHeaderY)
+	} else {
+		e.write(sosHeaderYCbCr)
+	}
+	// DC components are delta-encoded.
+	var prevDC [3]int32
+	forEachBlock(m, gray, subsampling, bg, func(c, bx, by int, b *block) {
+		q := quantIndex(min(c, 1))
+		prevDC[c] = e.writeBlock(b, q, prevDC[c])
+	})
 	// Pad the last byte with 1's.
 	e.emit(0x7f, 7)
 }
 
+// progComponent holds the quantized blocks of a component of a progressive image,
+// in rows of stride blocks that cover whole MCUs. Only the first w x h blocks
+// of the grid are written by the scans of the component alone.
+type progComponent struct {
+	blocks       []coefs
+	stride, w, h int
+	// n is the number of blocks per MCU in each direction.
+	n int
+}
+
+// writeScanHeader writes the StartOfScan marker of a progressive scan of the
+// given components, covering the zig-zag indexes ss to se of their blocks.
+func (e *encoder) writeScanHeader(components []int, ss, se int) {
+	e.writeMarkerHeader(sosMarker, 6+2*len(components))
+	e.writeByte(uint8(len(components)))
+	for _, c := range components {
+		// Luma uses the tables 0 and chroma the tables 1, as in
+		// sosHeaderYCbCr.
+		e.writeByte(uint8(c + 1))
+		e.writeByte("\x00\x11\x11"[c])
+	}
+	// Successive approximation isn't used, so Ah and Al are 0.
+	e.writeByte(uint8(ss))
+	e.writeByte(uint8(se))
+	e.writeByte(0)
+}
+
+// endScan pads the last byte of a scan with 1's, and drops the padding left
+// in the bit buffer so that the next scan starts on a byte boundary.
+func (e *encoder) endScan() {
+	e.emit(0x7f, 7)
+	e.bits, e.nBits = 0, 0
+}
+
+// writeProgressive writes the image data of a progressive image, with the
+// same arguments as writeSOS. All blocks are quantized first, then written
+// by spectral selection: the DC coefficients of all components, then the low
+// frequencies of the luma, the chroma, and the rest of the luma, so that
+// decoders can show a coarse image early.
+func (e *encoder) writeProgressive(m image.Image, gray bool, subsampling Subsampling, bg *background) {
+	nComponent, mcuBlocks := 3, 1
+	if gray {
+		nComponent = 1
+	} else if subsampling == Subsample420 {
+		mcuBlocks = 2
+	}
+	size := m.Bounds().Size()
+	mcu := 8 * mcuBlocks
+	mcuCols, mcuRows := (size.X+mcu-1)/mcu, (size.Y+mcu-1)/mcu
+	comps := make([]progComponent, nComponent)
+	for c := range comps {
+		n := 1
+		if c == 0 {
+			n = mcuBlocks
+		}
+		// The size of a component is the image size scaled by its share of
+		// the MCU, rounded up, as in section A.1.1 of the spec.
+		w := (size.X*n + mcuBlocks - 1) / mcuBlocks
+		h := (size.Y*n + mcuBlocks - 1) / mcuBlocks
+		comps[c] = progComponent{
+			blocks: make([]coefs, mcuCols*n*mcuRows*n),
+			stride: mcuCols * n,
+			w:      (w + 7) / 8,
+			h:      (h + 7) / 8,
+			n:      n,
+		}
+	}
+	forEachBlock(m, gray, subsampling, bg, func(c, bx, by int, b *block) {
+		e.quantize(&comps[c].blocks[by*comps[c].stride+bx], b, quantIndex(min(c, 1)))
+	})
+
+	// The DC scan interleaves all components, unless there is only one.
+	all := []int{0, 1, 2}[:nComponent]
+	e.writeScanHeader(all, 0, 0)
+	var prevDC [3]int32
+	if nComponent == 1 {
+		comp := &comps[0]
+		for by := 0; by < comp.h; by++ {
+			for bx := 0; bx < comp.w; bx++ {
+				dc := int32(comp.blocks[by*comp.stride+bx][0])
+				e.emitHuffRLE(0, 0, dc-prevDC[0])
+				prevDC[0] = dc
+			}
+		}
+	} else {
+		for my := 0; my < mcuRows; my++ {
+			for mx := 0; mx < mcuCols; mx++ {
+				for c := range comps {
+					comp := &comps[c]
+					for v := 0; v < comp.n; v++ {
+						for h := 0; h < comp.n; h++ {
+							dc := int32(comp.blocks[(my*comp.n+v)*comp.stride+mx*comp.n+h][0])
+							e.emitHuffRLE(huffIndex(2*min(c, 1)), 0, dc-prevDC[c])
+							prevDC[c] = dc
+						}
+					}
+				}
+			}
+		}
+	}
+	e.endScan()
+
+	// The AC scans hold a single component each.
+	type scan struct{ c, ss, se int }
+	scans := []scan{{0, 1, 5}, {1, 1, 63}, {2, 1, 63}, {0, 6, 63}}
+	if gray {
+		scans = []scan{{0, 1, 5}, {0, 6, 63}}
+	}
+	for _, s := range scans {
+		e.writeScanHeader([]int{s.c}, s.ss, s.se)
+		comp := &comps[s.c]
+		h := huffIndex(2*min(s.c, 1) + 1)
+		for by := 0; by < comp.h; by++ {
+			for bx := 0; bx < comp.w; bx++ {
+				e.emitAC(h, &comp.blocks[by*comp.stride+bx], s.ss, s.se)
+			}
+		}
+		e.endScan()
+	}
+}
+
 // DefaultQuality is the default quality encoding parameter.
 const DefaultQuality = 75
 
 // Options are the encoding parameters.
 // Quality ranges from 1 to 100 inclusive, higher is better.
+//
+// Background is the color that images with transparency are flattened onto,
+// as JPEG has no alpha channel. It should be opaque. If nil, transparent areas
+// come out black.
+//
+// DetectGray writes color images that are effectively monochrome as
+// single-component JPEGs, which are smaller and faster to decode. An image is
+// monochrome if none of its pixels stray from neutral gray by more than
+// GrayTolerance, in 8-bit units. Any color ICC profile in the Meta is replaced
+// by a gray one. Transparent images are not checked if Background is set.
+//
+// CompactProfile replaces a well-known ICC profile in the Meta, such as the
+// multi-kilobyte sRGB and Display P3 profiles that phones embed, with a
+// compact equivalent. See icc.Identify. With DropSRGB, sRGB profiles are left
+// out altogether, which is safe as software treats images without a profile
+// as sRGB.
+//
+// Subsampling is the chroma subsampling of color images, 4:2:0 by default.
+//
+// Progressive writes a progressive JPEG, whose successive scans refine the
+// whole image, instead of a baseline one that is decoded from top to bottom.
+// Progressive files show a preview sooner while loading, and are usually a
+// little smaller, but take more memory to encode and decode.
 type Options struct {
-	Quality int
+	Quality        int
+	Background     color.Color
+	DetectGray     bool
+	GrayTolerance  int
+	CompactProfile bool
+	DropSRGB       bool
+	Subsampling    Subsampling
+	Progressive    bool
+}
+
+// Subsampling is the resolution that the chroma of color images is encoded
+// at, relative to their luma.
+type Subsampling int
+
+const (
+	// Subsample420 halves the chroma resolution horizontally and vertically,
+	// which suits photos.
+	Subsample420 Subsampling = iota
+	// Subsample444 keeps the chroma at full resolution, which keeps sharp
+	// colored edges, such as red text, from bleeding, at the cost of larger
+	// files.
+	Subsample444
+)
+
+func (s Subsampling) String() string {
+	switch s {
+	case Subsample420:
+		return "4:2:0"
+	case Subsample444:
+		return "4:4:4"
+	}
+	return fmt.Sprintf("Subsampling(%d)", int(s))
 }
 
+// Meta is the metadata written along with an image. App2 is raw APP2 segment
+// data, such as an ICC profile. Exif is TIFF-structured EXIF data and XMP is
+// an XMP packet, which are each written to an APP1 segment with the header
+// that identifies them.
+//
+// MPF is raw APP2 segment data holding a Multi-Picture Format index, which is
+// written after App2. The secondary images it points to aren't written by
+// Encode: they are appended to the output by the caller, which then updates
+// the index with their offsets, as package mpf describes.
 type Meta struct {
 	App2 []byte
+	Exif []byte
+	XMP  []byte
+	MPF  []byte
 }
 
-// Encode writes the Image m to w in JPEG 4:2:0 baseline format with the given
-// options. Default parameters are used if a nil *Options is passed.
+const (
+	// exifHeader and xmpHeader start the APP1 segments holding EXIF data and
+	// XMP packets.
+	exifHeader = "Exif\x00\x00"
+	xmpHeader  = "http://ns.adobe.com/xap/1.0/\x00"
+	// maxSegmentLen is the most data that a marker segment can hold.
+	maxSegmentLen = 0xffff - 2
+)
+
+// writeSegment writes a marker segment holding header followed by data.
+func (e *encoder) writeSegment(marker uint8, header string, data []byte) {
+	e.writeMarkerHeader(marker, 2+len(header)+len(data))
+	e.write([]byte(header))
+	e.write(data)
+}
+
+// Encode writes the Image m to w in JPEG baseline format, or progressive
+// format if o.Progressive is set, with the given options, using 4:2:0 chroma
+// subsampling unless o.Subsampling says otherwise. Default parameters are
+// used if a nil *Options is passed.
 func Encode(w io.Writer, m image.Image, o *Options, meta *Meta) error {
 	b := m.Bounds()
 	if b.Dx() >= 1<<16 || b.Dy() >= 1<<16 {
 		return errors.New("jpeg: image is too large to encode")
 	}
+	subsampling := Subsample420
+	if o != nil {
+		subsampling = o.Subsampling
+	}
+	if subsampling != Subsample420 && subsampling != Subsample444 {
+		return fmt.Errorf("jpeg: unsupported subsampling %v", subsampling)
+	}
 	var e encoder
 	if ww, ok := w.(writer); ok {
 		e.w = ww
@@ -597,6 +1208,11 @@ func Encode(w io.Writer, m image.Image, o *Options, meta *Meta) error {
 			quality = 100
 		}
 	}
+	// Prepare the color that transparent images are flattened onto.
+	var bg *background
+	if o != nil && o.Background != nil {
+		bg = newBackground(o.Background)
+	}
 	// Convert from a quality rating to a scaling factor.
 	var scale int
 	if quality < 50 {
@@ -617,32 +1233,60 @@ func Encode(w io.Writer, m image.Image, o *Options, meta *Meta) error {
 			e.quant[i][j] = uint8(x)
 		}
 	}
-	// Compute number of components based on input image type.
+	// Compute number of components based on input image's color model, or on
+	// its pixels if gray detection was asked for.
 	nComponent := 3
-	switch m.(type) {
-	// TODO(wathiede): switch on m.ColorModel() instead of type.
-	case *image.Gray:
+	var app2, exif, xmp, mpf []byte
+	if meta != nil {
+		app2, exif, xmp, mpf = meta.App2, meta.Exif, meta.XMP, meta.MPF
+	}
+	if o != nil && o.CompactProfile {
+		app2 = compactApp2(app2, o.DropSRGB)
+	}
+	if isGray(m) {
+		nComponent = 1
+	} else if o != nil && o.DetectGray && (bg == nil || isOpaque(m)) && isMonochrome(m, o.GrayTolerance) {
 		nComponent = 1
+		app2 = grayApp2(app2)
+	}
+	if len(exifHeader)+len(exif) > maxSegmentLen || len(xmpHeader)+len(xmp) > maxSegmentLen ||
+		len(app2) > maxSegmentLen || len(mpf) > maxSegmentLen {
+		return errors.New("jpeg: metadata is too large to encode")
 	}
 	// Write the Start Of Image marker.
 	e.buf[0] = 0xff
 	e.buf[1] = 0xd8
 	e.write(e.buf[:2])
-	if meta != nil {
-		// Write APP2 data if specified
-		if meta.App2 != nil {
-			e.writeMarkerHeader(app2Marker, 2+len(meta.App2))
-			e.write(meta.App2)
-		}
+	// Write APP1 and APP2 data if specified
+	if exif != nil {
+		e.writeSegment(app1Marker, exifHeader, exif)
+	}
+	if xmp != nil {
+		e.writeSegment(app1Marker, xmpHeader, xmp)
+	}
+	if app2 != nil {
+		e.writeSegment(app2Marker, "", app2)
+	}
+	if mpf != nil {
+		e.writeSegment(app2Marker, "", mpf)
 	}
 	// Write the quantization tables.
 	e.writeDQT()
 	// Write the image dimensions.
-	e.writeSOF0(b.Size(), nComponent)
+	progressive := o != nil && o.Progressive
+	if progressive {
+		e.writeSOF(sof2Marker, b.Size(), nComponent, subsampling)
+	} else {
+		e.writeSOF(sof0Marker, b.Size(), nComponent, subsampling)
+	}
 	// Write the Huffman tables.
 	e.writeDHT(nComponent)
 	// Write the image data.
-	e.writeSOS(m)
+	if progressive {
+		e.writeProgressive(m, nComponent == 1, subsampling, bg)
+	} else {
+		e.writeSOS(m, nComponent == 1, subsampling, bg)
+	}
 	// Write the End Of Image marker.
 	e.buf[0] = 0xff
 	e.buf[1] = 0xd9
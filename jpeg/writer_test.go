@@ -6,14 +6,18 @@ package jpeg
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"image"
 	"image/color"
+	stdjpeg "image/jpeg"
 	"image/png"
 	"io"
 	"math/rand"
 	"os"
 	"testing"
+
+	"github.com/snapas/img/icc"
 )
 
 // zigzag maps from the natural ordering to the zig-zag ordering. For example,
@@ -137,7 +141,7 @@ func TestWriter(t *testing.T) {
 		}
 		// Encode that image as JPEG.
 		var buf bytes.Buffer
-		err = Encode(&buf, m0, &Options{Quality: tc.quality})
+		err = Encode(&buf, m0, &Options{Quality: tc.quality}, nil)
 		if err != nil {
 			t.Error(tc.filename, err)
 			continue
@@ -168,7 +172,7 @@ func TestWriteGrayscale(t *testing.T) {
 		m0.Pix[i] = uint8(i)
 	}
 	var buf bytes.Buffer
-	if err := Encode(&buf, m0, nil); err != nil {
+	if err := Encode(&buf, m0, nil, nil); err != nil {
 		t.Fatal(err)
 	}
 	m1, err := Decode(&buf)
@@ -235,13 +239,306 @@ func TestEncodeYCbCr(t *testing.T) {
 
 	// Now check that both images are identical after an encode.
 	var bufRGBA, bufYCbCr bytes.Buffer
-	Encode(&bufRGBA, imgRGBA, nil)
-	Encode(&bufYCbCr, imgYCbCr, nil)
+	Encode(&bufRGBA, imgRGBA, nil, nil)
+	Encode(&bufYCbCr, imgYCbCr, nil, nil)
 	if !bytes.Equal(bufRGBA.Bytes(), bufYCbCr.Bytes()) {
 		t.Errorf("RGBA and YCbCr encoded bytes differ")
 	}
 }
 
+// genericImage hides the concrete type of an image.Image, so that Encode
+// falls back to its generic conversion.
+type genericImage struct {
+	image.Image
+}
+
+// TestEncodeFastPaths tests that the specialized conversions for each image
+// type produce the same bytes as the generic conversion.
+func TestEncodeFastPaths(t *testing.T) {
+	bo := image.Rect(0, 0, 35, 21)
+	rnd := rand.New(rand.NewSource(123))
+	nrgba := image.NewNRGBA(bo)
+	rgba64 := image.NewRGBA64(bo)
+	cmyk := image.NewCMYK(bo)
+	gray16 := image.NewGray16(bo)
+	paletted := image.NewPaletted(bo, color.Palette{
+		color.Black, color.White, color.RGBA{0xff, 0x00, 0x00, 0xff}, color.NRGBA{0x20, 0x80, 0xc0, 0x80},
+	})
+	for y := bo.Min.Y; y < bo.Max.Y; y++ {
+		for x := bo.Min.X; x < bo.Max.X; x++ {
+			nrgba.SetNRGBA(x, y, color.NRGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256))})
+			rgba64.SetRGBA64(x, y, color.RGBA64{uint16(rnd.Intn(0x10000)), uint16(rnd.Intn(0x10000)), uint16(rnd.Intn(0x10000)), 0xffff})
+			cmyk.SetCMYK(x, y, color.CMYK{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256))})
+			gray16.SetGray16(x, y, color.Gray16{uint16(rnd.Intn(0x10000))})
+			paletted.SetColorIndex(x, y, uint8(rnd.Intn(4)))
+		}
+	}
+	for _, m := range []image.Image{nrgba, rgba64, cmyk, gray16, paletted} {
+		var fast, generic bytes.Buffer
+		if err := Encode(&fast, m, nil, nil); err != nil {
+			t.Fatalf("%T: %v", m, err)
+		}
+		if err := Encode(&generic, genericImage{m}, nil, nil); err != nil {
+			t.Fatalf("%T: %v", m, err)
+		}
+		if !bytes.Equal(fast.Bytes(), generic.Bytes()) {
+			t.Errorf("%T: specialized and generic encoded bytes differ", m)
+		}
+	}
+}
+
+// TestEncodeGrayModel tests that an image with a gray color model is encoded
+// as a single-component image, whatever its concrete type.
+func TestEncodeGrayModel(t *testing.T) {
+	m0 := image.NewGray16(image.Rect(0, 0, 16, 16))
+	for i := range m0.Pix {
+		m0.Pix[i] = uint8(i)
+	}
+	for _, m := range []image.Image{m0, genericImage{m0}} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, m, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		m1, err := Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := m1.(*image.Gray); !ok {
+			t.Errorf("%T: got %T, want *image.Gray", m, m1)
+		}
+	}
+}
+
+// TestEncodeBackground tests that transparent images are flattened onto the
+// background color.
+func TestEncodeBackground(t *testing.T) {
+	bo := image.Rect(0, 0, 32, 32)
+	nrgba := image.NewNRGBA(bo)
+	for y := bo.Min.Y; y < bo.Max.Y; y++ {
+		for x := bo.Min.X; x < bo.Max.X; x++ {
+			// The left half is fully transparent, the right half is
+			// translucent blue.
+			if x >= 16 {
+				nrgba.SetNRGBA(x, y, color.NRGBA{0x00, 0x00, 0xff, 0x80})
+			}
+		}
+	}
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	o := &Options{Quality: 90, Background: white}
+	for _, m := range []image.Image{nrgba, genericImage{nrgba}} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, m, o, nil); err != nil {
+			t.Fatal(err)
+		}
+		m1, err := Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := image.NewRGBA(bo)
+		for y := bo.Min.Y; y < bo.Max.Y; y++ {
+			for x := bo.Min.X; x < bo.Max.X; x++ {
+				if x >= 16 {
+					want.SetRGBA(x, y, color.RGBA{0x7f, 0x7f, 0xff, 0xff})
+				} else {
+					want.SetRGBA(x, y, white)
+				}
+			}
+		}
+		if got := averageDelta(want, m1); got > 3<<8 {
+			t.Errorf("%T: average delta too high; got %d", m, got)
+		}
+	}
+}
+
+// TestEncodeDetectGray tests that color images with flat chroma are written
+// as single-component images with a gray ICC profile.
+func TestEncodeDetectGray(t *testing.T) {
+	bo := image.Rect(0, 0, 40, 24)
+	m := image.NewYCbCr(bo, image.YCbCrSubsampleRatio420)
+	for i := range m.Y {
+		m.Y[i] = uint8(i)
+	}
+	for i := range m.Cb {
+		m.Cb[i] = uint8(127 + i%3)
+		m.Cr[i] = 128
+	}
+	// The APP2 data holds the start of an RGB profile header.
+	rgbApp2 := append([]byte(iccSignature+"\x01\x01"), make([]byte, 128)...)
+	copy(rgbApp2[len(iccSignature)+2+16:], "RGB ")
+
+	testCases := []struct {
+		o        *Options
+		wantGray bool
+	}{
+		{nil, false},
+		{&Options{Quality: 75, DetectGray: true}, false},
+		{&Options{Quality: 75, DetectGray: true, GrayTolerance: 1}, true},
+	}
+	for _, tc := range testCases {
+		var buf bytes.Buffer
+		if err := Encode(&buf, m, tc.o, &Meta{App2: rgbApp2}); err != nil {
+			t.Fatal(err)
+		}
+		hasGrayProfile := bytes.Contains(buf.Bytes(), grayProfileApp2)
+		m1, err := Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, isGray := m1.(*image.Gray)
+		if isGray != tc.wantGray || hasGrayProfile != tc.wantGray {
+			t.Errorf("%+v: got gray image %t and gray profile %t, want %t", tc.o, isGray, hasGrayProfile, tc.wantGray)
+		}
+		if got := averageDelta(m, m1); got > 2<<8 {
+			t.Errorf("%+v: average delta too high; got %d", tc.o, got)
+		}
+	}
+}
+
+// paddedProfile returns a copy of the given profile with n bytes of padding
+// appended, like the bloated profiles that some devices embed.
+func paddedProfile(profile []byte, n int) []byte {
+	p := append(append([]byte(nil), profile...), make([]byte, n)...)
+	binary.BigEndian.PutUint32(p, uint32(len(p)))
+	return p
+}
+
+func TestEncodeCompactProfile(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	srgbApp2 := append([]byte(iccSignature+"\x01\x01"), paddedProfile(icc.SRGBData, 4096)...)
+	p3App2 := append([]byte(iccSignature+"\x01\x01"), paddedProfile(icc.DisplayP3Data, 4096)...)
+
+	testCases := []struct {
+		o     *Options
+		app2  []byte
+		want  []byte
+		saved int
+	}{
+		{nil, srgbApp2, srgbApp2, 0},
+		{&Options{Quality: 75, CompactProfile: true}, srgbApp2, icc.SRGBData, 4096},
+		{&Options{Quality: 75, CompactProfile: true, DropSRGB: true}, srgbApp2, nil, len(srgbApp2) + 4},
+		{&Options{Quality: 75, CompactProfile: true, DropSRGB: true}, p3App2, icc.DisplayP3Data, 4096},
+	}
+	for _, tc := range testCases {
+		var plain, buf bytes.Buffer
+		if err := Encode(&plain, m, nil, &Meta{App2: tc.app2}); err != nil {
+			t.Fatal(err)
+		}
+		if err := Encode(&buf, m, tc.o, &Meta{App2: tc.app2}); err != nil {
+			t.Fatal(err)
+		}
+		if tc.want == nil && bytes.Contains(buf.Bytes(), []byte(iccSignature)) {
+			t.Errorf("%+v: profile wasn't dropped", tc.o)
+		} else if tc.want != nil && !bytes.Contains(buf.Bytes(), tc.want) {
+			t.Errorf("%+v: profile not found in output", tc.o)
+		}
+		if saved := plain.Len() - buf.Len(); saved != tc.saved {
+			t.Errorf("%+v: saved %d bytes, want %d", tc.o, saved, tc.saved)
+		}
+	}
+}
+
+func TestEncodeSubsampling(t *testing.T) {
+	// One-pixel red and blue stripes, which 4:2:0 subsampling blurs into
+	// purple.
+	m := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			c := color.RGBA{0xff, 0, 0, 0xff}
+			if x%2 == 1 {
+				c = color.RGBA{0, 0, 0xff, 0xff}
+			}
+			m.SetRGBA(x, y, c)
+		}
+	}
+	testCases := []struct {
+		s     Subsampling
+		ratio image.YCbCrSubsampleRatio
+	}{
+		{Subsample420, image.YCbCrSubsampleRatio420},
+		{Subsample444, image.YCbCrSubsampleRatio444},
+	}
+	var diffs []int64
+	for _, tc := range testCases {
+		var buf bytes.Buffer
+		if err := Encode(&buf, m, &Options{Quality: 90, Subsampling: tc.s}, nil); err != nil {
+			t.Fatal(err)
+		}
+		d, err := Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ratio := d.(*image.YCbCr).SubsampleRatio; ratio != tc.ratio {
+			t.Errorf("%v: decoded with subsample ratio %v", tc.s, ratio)
+		}
+		diffs = append(diffs, averageDelta(m, d))
+	}
+	if diffs[1] >= diffs[0]/2 {
+		t.Errorf("4:4:4 differs by %d, and 4:2:0 by %d", diffs[1], diffs[0])
+	}
+
+	if err := Encode(io.Discard, m, &Options{Subsampling: 5}, nil); err == nil {
+		t.Error("encoded with an unknown subsampling")
+	}
+}
+
+func TestEncodeProgressive(t *testing.T) {
+	// A noisy gradient whose size isn't a multiple of the MCU size, so that
+	// the scans of single components skip the padding blocks.
+	rgba := image.NewRGBA(image.Rect(3, 5, 3+37, 5+21))
+	gray := image.NewGray(rgba.Bounds())
+	rnd := rand.New(rand.NewSource(1))
+	for y := 5; y < 5+21; y++ {
+		for x := 3; x < 3+37; x++ {
+			n := uint8(rnd.Intn(32))
+			rgba.SetRGBA(x, y, color.RGBA{uint8(x * 6), uint8(y * 9), n * 4, 0xff})
+			gray.SetGray(x, y, color.Gray{uint8(x*3) + n})
+		}
+	}
+	testCases := []struct {
+		m image.Image
+		s Subsampling
+	}{
+		{rgba, Subsample420},
+		{rgba, Subsample444},
+		{gray, Subsample420},
+	}
+	for _, tc := range testCases {
+		var baseline, progressive bytes.Buffer
+		if err := Encode(&baseline, tc.m, &Options{Quality: 90, Subsampling: tc.s}, nil); err != nil {
+			t.Fatal(err)
+		}
+		o := &Options{Quality: 90, Subsampling: tc.s, Progressive: true}
+		if err := Encode(&progressive, tc.m, o, nil); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(progressive.Bytes(), []byte{0xff, sof2Marker}) {
+			t.Errorf("%T %v: no SOF2 marker", tc.m, tc.s)
+		}
+		// Both encodings hold the same coefficients, so they decode to the
+		// same pixels, with this package and the standard library.
+		for _, decode := range []func(io.Reader) (image.Image, error){Decode, stdjpeg.Decode} {
+			want, err := decode(bytes.NewReader(baseline.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := decode(bytes.NewReader(progressive.Bytes()))
+			if err != nil {
+				t.Fatalf("%T %v: %v", tc.m, tc.s, err)
+			}
+			b := want.Bounds()
+		loop:
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					if c0, c1 := want.At(x, y), got.At(x, y); c0 != c1 {
+						t.Errorf("%T %v: progressive pixel (%d, %d) is %v, want %v", tc.m, tc.s, x, y, c1, c0)
+						break loop
+					}
+				}
+			}
+		}
+	}
+}
+
 func BenchmarkEncodeRGBA(b *testing.B) {
 	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
 	bo := img.Bounds()
@@ -261,7 +558,7 @@ func BenchmarkEncodeRGBA(b *testing.B) {
 	b.ResetTimer()
 	options := &Options{Quality: 90}
 	for i := 0; i < b.N; i++ {
-		Encode(io.Discard, img, options)
+		Encode(io.Discard, img, options, nil)
 	}
 }
 
@@ -283,6 +580,6 @@ func BenchmarkEncodeYCbCr(b *testing.B) {
 	b.ResetTimer()
 	options := &Options{Quality: 90}
 	for i := 0; i < b.N; i++ {
-		Encode(io.Discard, img, options)
+		Encode(io.Discard, img, options, nil)
 	}
 }
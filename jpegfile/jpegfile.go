@@ -0,0 +1,276 @@
+// Package jpegfile models the structure of JPEG files as the ordered list of their marker segments, so that any of them
+// can be found, edited, added or removed, and the file written back without decoding its image data.
+//
+// Every segment between the SOI and EOI markers is kept, including the frame, table and scan headers, and the
+// entropy-coded data that follows each scan header is kept as an opaque block, with any RSTn markers in it.
+package jpegfile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Marker identifies the type of a segment.
+type Marker uint8
+
+// Markers, as defined in table B.1 of ITU T.81.
+const (
+	// Scan is the pseudo-marker of the entropy-coded data that follows an SOS segment, which has no marker of its own.
+	Scan Marker = 0x00
+	TEM  Marker = 0x01
+
+	SOF0  Marker = 0xc0
+	SOF1  Marker = 0xc1
+	SOF2  Marker = 0xc2
+	SOF3  Marker = 0xc3
+	DHT   Marker = 0xc4
+	SOF5  Marker = 0xc5
+	SOF6  Marker = 0xc6
+	SOF7  Marker = 0xc7
+	JPG   Marker = 0xc8
+	SOF9  Marker = 0xc9
+	SOF10 Marker = 0xca
+	SOF11 Marker = 0xcb
+	DAC   Marker = 0xcc
+	SOF13 Marker = 0xcd
+	SOF14 Marker = 0xce
+	SOF15 Marker = 0xcf
+	RST0  Marker = 0xd0
+	RST7  Marker = 0xd7
+	SOI   Marker = 0xd8
+	EOI   Marker = 0xd9
+	SOS   Marker = 0xda
+	DQT   Marker = 0xdb
+	DNL   Marker = 0xdc
+	DRI   Marker = 0xdd
+	DHP   Marker = 0xde
+	EXP   Marker = 0xdf
+	APP0  Marker = 0xe0
+	APP1  Marker = 0xe1
+	APP2  Marker = 0xe2
+	APP14 Marker = 0xee
+	APP15 Marker = 0xef
+	COM   Marker = 0xfe
+)
+
+var markerNames = map[Marker]string{
+	Scan: "Scan", TEM: "TEM", DHT: "DHT", JPG: "JPG", DAC: "DAC", SOI: "SOI", EOI: "EOI", SOS: "SOS", DQT: "DQT",
+	DNL: "DNL", DRI: "DRI", DHP: "DHP", EXP: "EXP", COM: "COM",
+}
+
+func (m Marker) String() string {
+	switch {
+	case m == DHT || m == JPG || m == DAC:
+		// These share the range of the SOFn markers.
+	case SOF0 <= m && m <= SOF15:
+		return fmt.Sprintf("SOF%d", m-SOF0)
+	case RST0 <= m && m <= RST7:
+		return fmt.Sprintf("RST%d", m-RST0)
+	case APP0 <= m && m <= APP15:
+		return fmt.Sprintf("APP%d", m-APP0)
+	case 0xf0 <= m && m <= 0xfd:
+		return fmt.Sprintf("JPG%d", m-0xf0)
+	}
+	if s, ok := markerNames[m]; ok {
+		return s
+	}
+	return fmt.Sprintf("Marker(%#02x)", uint8(m))
+}
+
+// HasLength reports whether segments with the marker have a length and data. SOI, EOI, RSTn and TEM markers stand
+// alone, and so does Scan, whose data isn't delimited by a length.
+func (m Marker) HasLength() bool {
+	return !(m == Scan || m == TEM || RST0 <= m && m <= EOI)
+}
+
+// IsMetadata reports whether segments with the marker hold metadata, which are the APPn and COM segments.
+func (m Marker) IsMetadata() bool {
+	return APP0 <= m && m <= APP15 || m == COM
+}
+
+// maxDataLen is the most data that a segment with a length can hold.
+const maxDataLen = 0xffff - 2
+
+// Segment is a marker segment of a JPEG file, or a block of entropy-coded data.
+type Segment struct {
+	Marker Marker
+	// Data is the data of the segment after its length, which is nil for markers without one, or the entropy-coded
+	// data of Scan segments, as it is in the file.
+	Data []byte
+}
+
+// WriteTo writes the segment to w as it is stored in a file, with its marker and length.
+func (s Segment) WriteTo(w io.Writer) (int64, error) {
+	if s.Marker == Scan {
+		n, err := w.Write(s.Data)
+		return int64(n), err
+	}
+	if !s.Marker.HasLength() {
+		n, err := w.Write([]byte{0xff, uint8(s.Marker)})
+		return int64(n), err
+	}
+	if len(s.Data) > maxDataLen {
+		return 0, fmt.Errorf("jpegfile: %v segment data is too long", s.Marker)
+	}
+	l := 2 + len(s.Data)
+	n, err := w.Write([]byte{0xff, uint8(s.Marker), uint8(l >> 8), uint8(l)})
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := w.Write(s.Data)
+	return int64(n + m), err
+}
+
+// File is the structure of a JPEG file.
+type File struct {
+	// Segments holds the segments of the file between its SOI and EOI markers, in order.
+	Segments []Segment
+	// Trailer holds any data after the EOI marker, such as the secondary images of MPF files.
+	Trailer []byte
+}
+
+// A FormatError reports that a JPEG file is malformed.
+type FormatError string
+
+func (e FormatError) Error() string { return "jpegfile: invalid format: " + string(e) }
+
+// Read reads and parses the JPEG file in r, as Parse does.
+func Read(r io.Reader) (*File, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Parse parses the JPEG file in b. The data of the segments shares the memory of b. Fill bytes before markers are
+// dropped, but otherwise writing the file back with WriteTo reproduces b.
+func Parse(b []byte) (*File, error) {
+	if len(b) < 2 || b[0] != 0xff || Marker(b[1]) != SOI {
+		return nil, FormatError("missing SOI marker")
+	}
+	f := &File{}
+	i := 2
+	for {
+		if i >= len(b) {
+			return nil, FormatError("missing EOI marker")
+		}
+		if b[i] != 0xff {
+			return nil, FormatError(fmt.Sprintf("no marker at offset %d", i))
+		}
+		// Markers may be preceded by any number of 0xff fill bytes.
+		for i+1 < len(b) && b[i+1] == 0xff {
+			i++
+		}
+		if i+1 >= len(b) {
+			return nil, FormatError("missing EOI marker")
+		}
+		m := Marker(b[i+1])
+		i += 2
+		if m == EOI {
+			if i < len(b) {
+				f.Trailer = b[i:]
+			}
+			return f, nil
+		}
+		if !m.HasLength() {
+			if m == Scan || m == SOI {
+				return nil, FormatError(fmt.Sprintf("unexpected %v marker", m))
+			}
+			f.Segments = append(f.Segments, Segment{Marker: m})
+			continue
+		}
+		if i+2 > len(b) {
+			return nil, FormatError("short segment length")
+		}
+		n := int(b[i])<<8 | int(b[i+1])
+		if n < 2 || i+n > len(b) {
+			return nil, FormatError(fmt.Sprintf("bad %v segment length", m))
+		}
+		f.Segments = append(f.Segments, Segment{Marker: m, Data: b[i+2 : i+n]})
+		i += n
+		if m != SOS {
+			continue
+		}
+		// The entropy-coded data runs up to the next marker other than RSTn. 0xff bytes in the data are followed by
+		// 0x00.
+		start := i
+		for ; i+1 < len(b); i++ {
+			if b[i] == 0xff && b[i+1] != 0 && !(RST0 <= Marker(b[i+1]) && Marker(b[i+1]) <= RST7) {
+				break
+			}
+		}
+		if i+1 >= len(b) {
+			return nil, FormatError("missing EOI marker")
+		}
+		f.Segments = append(f.Segments, Segment{Marker: Scan, Data: b[start:i]})
+	}
+}
+
+// WriteTo writes the file to w, with its SOI and EOI markers.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write([]byte{0xff, uint8(SOI)})
+	total := int64(n)
+	if err != nil {
+		return total, err
+	}
+	for _, s := range f.Segments {
+		n, err := s.WriteTo(w)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	n, err = w.Write([]byte{0xff, uint8(EOI)})
+	total += int64(n)
+	if err != nil {
+		return total, err
+	}
+	n, err = w.Write(f.Trailer)
+	return total + int64(n), err
+}
+
+// Find returns the index of the first segment with marker m whose data starts with prefix, starting at index from, or
+// -1 if there is none.
+func (f *File) Find(m Marker, prefix []byte, from int) int {
+	for i := from; i < len(f.Segments); i++ {
+		if s := f.Segments[i]; s.Marker == m && bytes.HasPrefix(s.Data, prefix) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Insert inserts segs before the segment at index i, or at the end if i is len(f.Segments).
+func (f *File) Insert(i int, segs ...Segment) {
+	f.Segments = append(f.Segments, segs...)
+	copy(f.Segments[i+len(segs):], f.Segments[i:])
+	copy(f.Segments[i:], segs)
+}
+
+// Remove removes the segments with marker m whose data starts with prefix, and returns how many there were.
+func (f *File) Remove(m Marker, prefix []byte) int {
+	n := 0
+	segs := f.Segments[:0]
+	for _, s := range f.Segments {
+		if s.Marker == m && bytes.HasPrefix(s.Data, prefix) {
+			n++
+			continue
+		}
+		segs = append(segs, s)
+	}
+	f.Segments = segs
+	return n
+}
+
+// MetadataIndex returns where a new metadata segment with marker m belongs: after the APPn segments with lower or equal
+// markers at the start of the file, so that JFIF and EXIF segments stay first, and COM segments follow all of them.
+func (f *File) MetadataIndex(m Marker) int {
+	i := 0
+	for i < len(f.Segments) && f.Segments[i].Marker.IsMetadata() && f.Segments[i].Marker <= m {
+		i++
+	}
+	return i
+}
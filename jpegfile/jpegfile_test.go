@@ -0,0 +1,140 @@
+package jpegfile
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func markers(f *File) string {
+	var s []string
+	for _, seg := range f.Segments {
+		s = append(s, seg.Marker.String())
+	}
+	return fmt.Sprint(s)
+}
+
+func TestParse(t *testing.T) {
+	b, err := ioutil.ReadFile("../testdata/gopro.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := Parse(b)
+	if err != nil {
+		t.Fatal("Parse failed:", err)
+	}
+	if got, want := markers(f), "[APP1 APP2 DQT SOF0 DHT SOS Scan]"; got != want {
+		t.Errorf("got segments %s, want %s", got, want)
+	}
+	// The secondary image of the MPF file follows the primary one.
+	if !bytes.HasPrefix(f.Trailer, []byte{0xff, 0xd8}) {
+		t.Errorf("trailer starts with % x", f.Trailer[:4])
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := f.WriteTo(buf)
+	if err != nil {
+		t.Fatal("WriteTo failed:", err)
+	}
+	if n != int64(buf.Len()) || !bytes.Equal(buf.Bytes(), b) {
+		t.Errorf("wrote %d bytes, which differ from the %d-byte file", n, len(b))
+	}
+}
+
+func TestParseScan(t *testing.T) {
+	b := []byte("\xff\xd8" +
+		"\xff\xdd\x00\x04\x00\x01" +
+		"\xff\xda\x00\x03\x01" +
+		"\x12\xff\x00\x34\xff\xd0\x56\xff\xd1\x78" +
+		"\xff\xc4\x00\x02" +
+		"\xff\xda\x00\x02" +
+		"\x9a" +
+		"\xff\xff\xd9")
+	f, err := Parse(b)
+	if err != nil {
+		t.Fatal("Parse failed:", err)
+	}
+	if got, want := markers(f), "[DRI SOS Scan DHT SOS Scan]"; got != want {
+		t.Errorf("got segments %s, want %s", got, want)
+	}
+	if got := f.Segments[2].Data; string(got) != "\x12\xff\x00\x34\xff\xd0\x56\xff\xd1\x78" {
+		t.Errorf("got scan data % x", got)
+	}
+	if got := f.Segments[5].Data; string(got) != "\x9a" {
+		t.Errorf("got scan data % x", got)
+	}
+	if f.Trailer != nil {
+		t.Errorf("got trailer % x", f.Trailer)
+	}
+
+	for _, c := range []struct {
+		name string
+		b    string
+	}{
+		{"not a JPEG", "\x89PNG\r\n\x1a\n"},
+		{"no EOI", "\xff\xd8\xff\xdd\x00\x04\x00\x01"},
+		{"truncated scan", "\xff\xd8\xff\xda\x00\x02\x12\xff\x00"},
+		{"bad length", "\xff\xd8\xff\xe1\x00\x10ab\xff\xd9"},
+		{"garbage", "\xff\xd8\x00\xff\xd9"},
+	} {
+		if _, err := Parse([]byte(c.b)); err == nil {
+			t.Errorf("%s: no error", c.name)
+		}
+	}
+}
+
+func TestEdit(t *testing.T) {
+	f := &File{Segments: []Segment{
+		{Marker: APP0, Data: []byte("JFIF\x00")},
+		{Marker: APP1, Data: []byte("Exif\x00\x00MM")},
+		{Marker: DQT, Data: []byte{0}},
+		{Marker: COM, Data: []byte("a")},
+		{Marker: SOS, Data: []byte{0}},
+		{Marker: Scan, Data: []byte{1, 2}},
+	}}
+	if i := f.Find(APP1, []byte("Exif"), 0); i != 1 {
+		t.Errorf("found EXIF at %d", i)
+	}
+	if i := f.Find(APP1, []byte("Exif"), 2); i != -1 {
+		t.Errorf("found EXIF at %d after index 2", i)
+	}
+	f.Insert(f.MetadataIndex(APP2), Segment{Marker: APP2, Data: []byte("ICC_PROFILE\x00")})
+	f.Insert(f.MetadataIndex(COM), Segment{Marker: COM, Data: []byte("b")})
+	f.Insert(len(f.Segments), Segment{Marker: RST0})
+	if got, want := markers(f), "[APP0 APP1 APP2 COM DQT COM SOS Scan RST0]"; got != want {
+		t.Errorf("got segments %s, want %s", got, want)
+	}
+	if n := f.Remove(COM, nil); n != 2 {
+		t.Errorf("removed %d COM segments, want 2", n)
+	}
+	if n := f.Remove(APP1, []byte("http://ns.adobe.com/xap/1.0/")); n != 0 {
+		t.Errorf("removed %d XMP segments, want 0", n)
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := f.WriteTo(buf); err != nil {
+		t.Fatal("WriteTo failed:", err)
+	}
+	want := "\xff\xd8" + "\xff\xe0\x00\x07JFIF\x00" + "\xff\xe1\x00\x0aExif\x00\x00MM" + "\xff\xe2\x00\x0eICC_PROFILE\x00" +
+		"\xff\xdb\x00\x03\x00" + "\xff\xda\x00\x03\x00" + "\x01\x02" + "\xff\xd0" + "\xff\xd9"
+	if buf.String() != want {
+		t.Errorf("wrote % x, want % x", buf.Bytes(), want)
+	}
+
+	f.Segments[0].Data = make([]byte, 0x10000)
+	if _, err := f.WriteTo(&bytes.Buffer{}); err == nil {
+		t.Error("wrote a segment with too much data")
+	}
+}
+
+func TestMarkerString(t *testing.T) {
+	for m, want := range map[Marker]string{
+		SOF0: "SOF0", SOF2: "SOF2", DHT: "DHT", RST7: "RST7", APP14: "APP14", COM: "COM", Scan: "Scan", 0xf3: "JPG3",
+		0x02: "Marker(0x02)",
+	} {
+		if got := m.String(); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+// Package mpf implements the Multi-Picture Format (CIPA DC-007), which phones and cameras use to store secondary
+// images, such as previews, depth maps, gain maps and stereo pairs, after the primary image of a JPEG file.
+//
+// The primary image carries an index of all the images in an APP2 segment starting with "MPF\x00". The offsets of the
+// secondary images in the index are relative to the MP header, which follows that signature.
+package mpf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Header is the signature at the start of the APP2 segments that hold an MP index.
+const Header = "MPF\x00"
+
+// Type is the type of an image in an MP file.
+type Type uint32
+
+// Image types, as defined in section 5.2.3.3.1 of the specification.
+const (
+	Undefined            Type = 0x000000
+	LargeThumbnailVGA    Type = 0x010001
+	LargeThumbnailFullHD Type = 0x010002
+	Panorama             Type = 0x020001
+	Disparity            Type = 0x020002
+	MultiAngle           Type = 0x020003
+	Baseline             Type = 0x030000
+)
+
+var typeNames = map[Type]string{
+	Undefined:            "Undefined",
+	LargeThumbnailVGA:    "Large Thumbnail (VGA)",
+	LargeThumbnailFullHD: "Large Thumbnail (Full HD)",
+	Panorama:             "Multi-Frame Panorama",
+	Disparity:            "Multi-Frame Disparity",
+	MultiAngle:           "Multi-Frame Multi-Angle",
+	Baseline:             "Baseline MP Primary Image",
+}
+
+func (t Type) String() string {
+	if s, ok := typeNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("Type(%#06x)", uint32(t))
+}
+
+// Flags of the individual image attribute of an Entry.
+const (
+	DependentParent = 1 << 31
+	DependentChild  = 1 << 30
+	Representative  = 1 << 29
+)
+
+// Entry describes one of the images in an MP file.
+type Entry struct {
+	// Attribute holds the flags of the image in its top 5 bits, its data format in the next 3, where 0 is JPEG, and
+	// its Type in the remaining 24.
+	Attribute uint32
+	// Size is the length of the image in bytes, from its SOI marker to its EOI marker.
+	Size uint32
+	// Offset is where the image starts, relative to the MP header. It is 0 for the primary image.
+	Offset uint32
+	// Dependents are the 1-based entry numbers of images that this image depends on, or 0.
+	Dependents [2]uint16
+}
+
+// Type returns the type of the image.
+func (e Entry) Type() Type {
+	return Type(e.Attribute & 0xffffff)
+}
+
+// Index is the MP index of an MP file, which is stored with its primary image.
+type Index struct {
+	// ByteOrder is the byte order of the index, which is kept when it is written back.
+	ByteOrder binary.ByteOrder
+	// Version is the MPF version, usually "0100".
+	Version string
+	// Entries describes the images in the file, starting with the primary image.
+	Entries []Entry
+	// UIDs holds an optional 33-byte unique ID for each entry.
+	UIDs [][]byte
+	// TotalFrames is the number of captured frames, or 0 if it isn't known.
+	TotalFrames uint32
+}
+
+// A FormatError reports that an MP index is malformed.
+type FormatError string
+
+func (e FormatError) Error() string { return "mpf: invalid format: " + string(e) }
+
+// MP index IFD tags.
+const (
+	tagVersion        = 0xb000
+	tagNumberOfImages = 0xb001
+	tagMPEntry        = 0xb002
+	tagImageUIDList   = 0xb003
+	tagTotalFrames    = 0xb004
+)
+
+// TIFF field types.
+const (
+	typeLong      = 4
+	typeUndefined = 7
+)
+
+const (
+	entryLen = 16
+	uidLen   = 33
+)
+
+// Parse parses the MP index in the raw data of an APP2 segment, which starts with Header. The MP attribute IFDs that
+// may follow the index aren't parsed.
+func Parse(app2 []byte) (*Index, error) {
+	x, _, err := parse(app2)
+	return x, err
+}
+
+// parse parses the MP index in app2 like Parse, and also returns the offset in app2 of the data of its MP entries.
+func parse(app2 []byte) (*Index, int, error) {
+	if len(app2) < len(Header) || string(app2[:len(Header)]) != Header {
+		return nil, 0, FormatError("missing MPF header")
+	}
+	b := app2[len(Header):]
+	if len(b) < 8 {
+		return nil, 0, FormatError("short MP header")
+	}
+	x := &Index{}
+	switch string(b[:4]) {
+	case "II*\x00":
+		x.ByteOrder = binary.LittleEndian
+	case "MM\x00*":
+		x.ByteOrder = binary.BigEndian
+	default:
+		return nil, 0, FormatError("bad byte order")
+	}
+	order := x.ByteOrder
+	off := uint64(order.Uint32(b[4:]))
+	if off+2 > uint64(len(b)) {
+		return nil, 0, FormatError("index IFD out of bounds")
+	}
+	n := uint64(order.Uint16(b[off:]))
+	if off+2+12*n > uint64(len(b)) {
+		return nil, 0, FormatError("index IFD out of bounds")
+	}
+	count, entries := -1, 0
+	for i := uint64(0); i < n; i++ {
+		e := b[off+2+12*i:]
+		tag, typ, cnt := order.Uint16(e), order.Uint16(e[2:]), uint64(order.Uint32(e[4:]))
+		value, at := e[8:12], off+2+12*i+8
+		if typ == typeUndefined && cnt > 4 {
+			at = uint64(order.Uint32(value))
+			if at+cnt > uint64(len(b)) {
+				return nil, 0, FormatError("tag data out of bounds")
+			}
+			value = b[at : at+cnt]
+		}
+		switch tag {
+		case tagVersion:
+			x.Version = string(value[:4])
+		case tagNumberOfImages:
+			count = int(order.Uint32(value))
+		case tagMPEntry:
+			if typ != typeUndefined || cnt%entryLen != 0 {
+				return nil, 0, FormatError("bad MP entries")
+			}
+			entries = len(Header) + int(at)
+			for j := 0; j+entryLen <= len(value); j += entryLen {
+				e := value[j:]
+				x.Entries = append(x.Entries, Entry{
+					Attribute:  order.Uint32(e),
+					Size:       order.Uint32(e[4:]),
+					Offset:     order.Uint32(e[8:]),
+					Dependents: [2]uint16{order.Uint16(e[12:]), order.Uint16(e[14:])},
+				})
+			}
+		case tagImageUIDList:
+			if typ == typeUndefined && cnt%uidLen == 0 {
+				for j := 0; j+uidLen <= len(value); j += uidLen {
+					x.UIDs = append(x.UIDs, append([]byte(nil), value[j:j+uidLen]...))
+				}
+			}
+		case tagTotalFrames:
+			x.TotalFrames = order.Uint32(value)
+		}
+	}
+	if len(x.Entries) == 0 {
+		return nil, 0, FormatError("no MP entries")
+	}
+	if count != len(x.Entries) {
+		return nil, 0, FormatError("number of images doesn't match MP entries")
+	}
+	if len(x.UIDs) != len(x.Entries) {
+		x.UIDs = nil
+	}
+	return x, entries, nil
+}
+
+// Marshal returns the raw data of the APP2 segment that holds the MP index, starting with Header. Its length only
+// depends on the number of entries, so the index can be written with placeholder offsets and sizes, and updated in
+// place once they are known.
+func (x *Index) Marshal() []byte {
+	order := x.ByteOrder
+	if order == nil {
+		order = binary.BigEndian
+	}
+	version := x.Version
+	if len(version) != 4 {
+		version = "0100"
+	}
+	uids := x.UIDs
+	if len(uids) != len(x.Entries) {
+		uids = nil
+	}
+
+	n := 3
+	if uids != nil {
+		n++
+	}
+	if x.TotalFrames != 0 {
+		n++
+	}
+	// The MP header, the index IFD and the offset of the next IFD, followed by the values that don't fit in tags.
+	dataOff := 8 + 2 + 12*n + 4
+	b := make([]byte, dataOff, dataOff+entryLen*len(x.Entries)+uidLen*len(uids))
+	if order == binary.LittleEndian {
+		copy(b, "II*\x00")
+	} else {
+		copy(b, "MM\x00*")
+	}
+	order.PutUint32(b[4:], 8)
+	order.PutUint16(b[8:], uint16(n))
+	next := 10
+	put := func(t, typ uint16, cnt uint32, value []byte) {
+		tag := b[next : next+12]
+		next += 12
+		order.PutUint16(tag, t)
+		order.PutUint16(tag[2:], typ)
+		order.PutUint32(tag[4:], cnt)
+		if len(value) <= 4 {
+			copy(tag[8:], value)
+		} else {
+			order.PutUint32(tag[8:], uint32(len(b)))
+			b = append(b, value...)
+		}
+	}
+	long := func(v uint32) []byte {
+		var l [4]byte
+		order.PutUint32(l[:], v)
+		return l[:]
+	}
+
+	put(tagVersion, typeUndefined, 4, []byte(version))
+	put(tagNumberOfImages, typeLong, 1, long(uint32(len(x.Entries))))
+	entries := make([]byte, entryLen*len(x.Entries))
+	for i, e := range x.Entries {
+		p := entries[entryLen*i:]
+		order.PutUint32(p, e.Attribute)
+		order.PutUint32(p[4:], e.Size)
+		order.PutUint32(p[8:], e.Offset)
+		order.PutUint16(p[12:], e.Dependents[0])
+		order.PutUint16(p[14:], e.Dependents[1])
+	}
+	put(tagMPEntry, typeUndefined, uint32(len(entries)), entries)
+	if uids != nil {
+		var list []byte
+		for _, u := range uids {
+			list = append(list, u...)
+		}
+		put(tagImageUIDList, typeUndefined, uint32(len(list)), list)
+	}
+	if x.TotalFrames != 0 {
+		put(tagTotalFrames, typeLong, 1, long(x.TotalFrames))
+	}
+	return append([]byte(Header), b...)
+}
+
+// Update returns a copy of app2, the raw data of the APP2 segment that x was parsed from, with the sizes and offsets of
+// x.Entries written in place of those of its MP entries. The rest of the segment, such as the MP attribute IFDs that
+// follow the index, is kept as it is.
+func (x *Index) Update(app2 []byte) ([]byte, error) {
+	y, entries, err := parse(app2)
+	if err != nil {
+		return nil, err
+	}
+	if len(y.Entries) != len(x.Entries) {
+		return nil, FormatError("number of MP entries changed")
+	}
+	b := append([]byte(nil), app2...)
+	for i, e := range x.Entries {
+		p := b[entries+entryLen*i:]
+		y.ByteOrder.PutUint32(p[4:], e.Size)
+		y.ByteOrder.PutUint32(p[8:], e.Offset)
+	}
+	return b, nil
+}
+
+// Images returns the data of the secondary images in file, in the order of x.Entries[1:]. headerOffset is the offset
+// in file of the MP header, which follows Header in the APP2 segment of the primary image.
+func (x *Index) Images(file []byte, headerOffset int) ([][]byte, error) {
+	var images [][]byte
+	for i, e := range x.Entries[1:] {
+		start := uint64(headerOffset) + uint64(e.Offset)
+		end := start + uint64(e.Size)
+		if e.Offset == 0 || end > uint64(len(file)) {
+			return nil, FormatError(fmt.Sprintf("image %d out of bounds", i+2))
+		}
+		images = append(images, file[start:end])
+	}
+	return images, nil
+}
@@ -0,0 +1,104 @@
+package mpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/snapas/img/iccjpeg"
+)
+
+func TestParse(t *testing.T) {
+	file, err := ioutil.ReadFile("../testdata/gopro.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := iccjpeg.NewParser(bytes.NewReader(file))
+	if err := p.ReadSOI(); err != nil {
+		t.Fatal(err)
+	}
+	segs, err := p.GetMetadataSegments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seg *iccjpeg.Segment
+	for i := range segs {
+		if bytes.HasPrefix(segs[i].Data, []byte(Header)) {
+			seg = &segs[i]
+		}
+	}
+	if seg == nil {
+		t.Fatal("no MPF segment")
+	}
+
+	x, err := Parse(seg.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.ByteOrder != binary.BigEndian || x.Version != "0100" || len(x.Entries) != 2 {
+		t.Fatalf("got %+v", x)
+	}
+	primary, preview := x.Entries[0], x.Entries[1]
+	if primary.Type() != Baseline || primary.Attribute&Representative == 0 || primary.Offset != 0 {
+		t.Errorf("primary image is %+v, a %v", primary, primary.Type())
+	}
+	if preview.Type() != LargeThumbnailVGA {
+		t.Errorf("secondary image is a %v, want %v", preview.Type(), LargeThumbnailVGA)
+	}
+
+	images, err := x.Images(file, seg.Offset+len(Header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GoPro counts the secondary image without its EOI marker, so only its start is checked.
+	if len(images) != 1 || !bytes.HasPrefix(images[0], []byte{0xff, 0xd8, 0xff}) {
+		t.Errorf("secondary image isn't a JPEG")
+	}
+	if !bytes.HasSuffix(file[:primary.Size], []byte{0xff, 0xd9}) {
+		t.Errorf("primary image size %d doesn't end at its EOI marker", primary.Size)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	uid := bytes.Repeat([]byte{'0'}, uidLen)
+	for _, x := range []*Index{
+		{
+			ByteOrder: binary.BigEndian,
+			Version:   "0100",
+			Entries: []Entry{
+				{Attribute: DependentParent | Representative | uint32(Baseline), Size: 1000, Dependents: [2]uint16{2, 0}},
+				{Attribute: DependentChild | uint32(LargeThumbnailVGA), Size: 200, Offset: 950},
+			},
+		},
+		{
+			ByteOrder:   binary.LittleEndian,
+			Version:     "0100",
+			Entries:     []Entry{{Attribute: uint32(Baseline), Size: 10}, {Size: 20, Offset: 30}, {Size: 40, Offset: 50}},
+			UIDs:        [][]byte{uid, uid, uid},
+			TotalFrames: 3,
+		},
+	} {
+		b := x.Marshal()
+		y, err := Parse(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(x, y) {
+			t.Errorf("got %+v, want %+v", y, x)
+		}
+
+		// The length doesn't depend on the offsets.
+		x.Entries[1].Offset = 1 << 30
+		if n := len(x.Marshal()); n != len(b) {
+			t.Errorf("length changed from %d to %d", len(b), n)
+		}
+	}
+
+	for _, b := range []string{"", "MPF\x00", "MPF\x00MM\x00*\x00\x00\x00\x08", "ICC_PROFILE\x00"} {
+		if _, err := Parse([]byte(b)); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", b)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package img
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+// orient returns m transformed according to the EXIF orientation o, so that it is upright. Gray, RGBA and NRGBA
+// images keep their type, while others are converted to RGBA.
+func orient(m image.Image, o int) image.Image {
+	if o < 2 || o > 8 {
+		return m
+	}
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+
+	var src, dst []byte
+	var srcStride, dstStride, bpp int
+	var out image.Image
+	switch m := m.(type) {
+	case *image.Gray:
+		d := image.NewGray(image.Rect(0, 0, dw, dh))
+		src, srcStride, dst, dstStride, bpp, out = m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], m.Stride, d.Pix, d.Stride, 1, d
+	case *image.NRGBA:
+		d := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+		src, srcStride, dst, dstStride, bpp, out = m.Pix[m.PixOffset(b.Min.X, b.Min.Y):], m.Stride, d.Pix, d.Stride, 4, d
+	default:
+		rgba, ok := m.(*image.RGBA)
+		if !ok {
+			rgba = image.NewRGBA(b)
+			draw.Draw(rgba, b, m, b.Min, draw.Src)
+		}
+		d := image.NewRGBA(image.Rect(0, 0, dw, dh))
+		src, srcStride, dst, dstStride, bpp, out = rgba.Pix[rgba.PixOffset(b.Min.X, b.Min.Y):], rgba.Stride, d.Pix, d.Stride, 4, d
+	}
+
+	parallel(h, func(lo, hi int) {
+		for y := lo; y < hi; y++ {
+			for x := 0; x < w; x++ {
+				var dx, dy int
+				switch o {
+				case 2: // Flipped horizontally.
+					dx, dy = w-1-x, y
+				case 3: // Rotated 180°.
+					dx, dy = w-1-x, h-1-y
+				case 4: // Flipped vertically.
+					dx, dy = x, h-1-y
+				case 5: // Transposed.
+					dx, dy = y, x
+				case 6: // Needs rotating 90° clockwise.
+					dx, dy = h-1-y, x
+				case 7: // Transversed.
+					dx, dy = h-1-y, w-1-x
+				case 8: // Needs rotating 90° counter-clockwise.
+					dx, dy = y, w-1-x
+				}
+				copy(dst[dy*dstStride+dx*bpp:dy*dstStride+dx*bpp+bpp], src[y*srcStride+x*bpp:])
+			}
+		}
+	})
+	return out
+}
+
+// Rotate returns i rotated clockwise by the given number of degrees, which must be a multiple of 90, along with any
+// gain map. The metadata is kept, with the dimensions updated.
+func Rotate(i Image, degrees int) (Image, error) {
+	var o int
+	switch (degrees%360 + 360) % 360 {
+	case 0:
+		return i, nil
+	case 90:
+		o = 6
+	case 180:
+		o = 3
+	case 270:
+		o = 8
+	default:
+		return i, fmt.Errorf("can't rotate by %d degrees", degrees)
+	}
+	i.Image = orient(i.Image, o)
+	if i.GainMap != nil {
+		g := *i.GainMap
+		g.Image = orient(g.Image, o)
+		i.GainMap = &g
+	}
+	fixMetadata(&i)
+	return i, nil
+}
@@ -0,0 +1,197 @@
+package img
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image/png"
+	"io"
+	"io/ioutil"
+
+	"github.com/snapas/img/iccjpeg"
+)
+
+// pngHeader is the signature that PNG files start with.
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+// xmpKeyword is the keyword of the iTXt chunk that holds the XMP packet of PNG images.
+const xmpKeyword = "XML:com.adobe.xmp"
+
+// maxApp2Len is the most data that an APP2 segment holds, which bounds the ICC profiles that are kept.
+const maxApp2Len = 0xffff - 2
+
+// maxXMPLen is the longest XMP packet that fits in an APP1 segment, which bounds the compressed packets that are kept.
+const maxXMPLen = 0xffff - 2 - len(xmpHeader)
+
+// PNGChunk is an ancillary chunk of a PNG image, such as a tEXt chunk, with its 4-letter type and its data.
+type PNGChunk struct {
+	Type string
+	Data []byte
+}
+
+// readPNGMetadata reads the metadata chunks of the PNG file in b into i: the ICC profile of the iCCP chunk into i.App2,
+// as it would be stored in a JPEG, the eXIf chunk into i.Exif and the XMP packet of the iTXt chunk with the xmpKeyword
+// into i.XMP. Other textual metadata, and the pixel density of the pHYs chunk, are kept as they are in i.PNGChunks.
+// Profiles too large for an APP2 segment aren't kept, like those split over several segments in JPEGs.
+func readPNGMetadata(i *Image, b []byte) {
+	b = b[len(pngHeader):]
+	for len(b) >= 12 {
+		n := binary.BigEndian.Uint32(b)
+		if uint64(n)+12 > uint64(len(b)) {
+			return
+		}
+		typ, data := string(b[4:8]), b[8:8+n]
+		b = b[12+n:]
+		switch typ {
+		case "iCCP":
+			if profile := iccpProfile(data); profile != nil && i.App2 == nil {
+				if app2 := iccjpeg.App2Data(profile); len(app2) <= maxApp2Len {
+					i.App2 = app2
+				}
+			}
+		case "eXIf":
+			if i.Exif == nil {
+				i.Exif = append([]byte(nil), data...)
+			}
+		case "tEXt", "zTXt", "iTXt":
+			if xmp := pngXMP(typ, data); xmp != nil {
+				if i.XMP == nil {
+					i.XMP = xmp
+				}
+				continue
+			}
+			fallthrough
+		case "pHYs":
+			i.PNGChunks = append(i.PNGChunks, PNGChunk{typ, append([]byte(nil), data...)})
+		case "IEND":
+			return
+		}
+	}
+}
+
+// iccpProfile returns the ICC profile in the data of an iCCP chunk, which follows the name of the profile, or nil if it
+// is malformed.
+func iccpProfile(data []byte) []byte {
+	k := bytes.IndexByte(data, 0)
+	if k < 0 || k+2 > len(data) || data[k+1] != 0 {
+		return nil
+	}
+	profile, err := inflate(data[k+2:], maxApp2Len)
+	if err != nil {
+		return nil
+	}
+	return profile
+}
+
+// pngXMP returns the XMP packet in the data of a text chunk with the given type, or nil if it holds something else.
+// XMP is stored in iTXt chunks, but some software uses tEXt ones.
+func pngXMP(typ string, data []byte) []byte {
+	if !bytes.HasPrefix(data, []byte(xmpKeyword+"\x00")) {
+		return nil
+	}
+	data = data[len(xmpKeyword)+1:]
+	switch typ {
+	case "tEXt":
+		return append([]byte(nil), data...)
+	case "iTXt":
+		// The compression flag and method are followed by the language tag and translated keyword.
+		if len(data) < 2 {
+			return nil
+		}
+		compressed, rest := data[0] == 1, data[2:]
+		for n := 0; n < 2; n++ {
+			k := bytes.IndexByte(rest, 0)
+			if k < 0 {
+				return nil
+			}
+			rest = rest[k+1:]
+		}
+		if !compressed {
+			return append([]byte(nil), rest...)
+		}
+		xmp, err := inflate(rest, maxXMPLen)
+		if err != nil {
+			return nil
+		}
+		return xmp
+	}
+	return nil
+}
+
+// inflate decompresses the zlib data in b, failing if it holds more than max bytes, so that small chunks can't make
+// huge allocations.
+func inflate(b []byte, max int) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > max {
+		return nil, errors.New("inflate: data too long")
+	}
+	return data, nil
+}
+
+// EncodePNG writes the Image to w in PNG format with the given encoder, including any ICC profile (APP2 data), EXIF
+// and XMP metadata and PNG chunks preserved by Decode, which are written before the image data. Secondary MPF images,
+// gain maps and trailers are JPEG-only, and aren't written. The default encoder is used if enc is nil.
+func EncodePNG(w io.Writer, i Image, enc *png.Encoder) error {
+	if enc == nil {
+		enc = &png.Encoder{}
+	}
+	buf := &bytes.Buffer{}
+	if err := enc.Encode(buf, i.Image); err != nil {
+		return err
+	}
+	b := buf.Bytes()
+
+	// IHDR is always the first chunk, with 13 bytes of data.
+	ihdrEnd := len(pngHeader) + 12 + 13
+	meta := &bytes.Buffer{}
+	if profile := iccjpeg.ProfileData(i.App2); profile != nil {
+		z := &bytes.Buffer{}
+		zw := zlib.NewWriter(z)
+		zw.Write(profile)
+		zw.Close()
+		writePNGChunk(meta, "iCCP", append([]byte("ICC Profile\x00\x00"), z.Bytes()...))
+	}
+	if i.Exif != nil {
+		writePNGChunk(meta, "eXIf", i.Exif)
+	}
+	if i.XMP != nil {
+		// An uncompressed iTXt chunk without a language tag or translated keyword.
+		writePNGChunk(meta, "iTXt", append([]byte(xmpKeyword+"\x00\x00\x00\x00\x00"), i.XMP...))
+	}
+	for _, c := range i.PNGChunks {
+		writePNGChunk(meta, c.Type, c.Data)
+	}
+
+	if _, err := w.Write(b[:ihdrEnd]); err != nil {
+		return err
+	}
+	if _, err := w.Write(meta.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(b[ihdrEnd:])
+	return err
+}
+
+// writePNGChunk writes a chunk with the given type and data to buf, with its length and CRC.
+func writePNGChunk(buf *bytes.Buffer, typ string, data []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+	buf.Write(n[:])
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	buf.WriteString(typ)
+	buf.Write(data)
+	binary.BigEndian.PutUint32(n[:], crc.Sum32())
+	buf.Write(n[:])
+}
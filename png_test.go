@@ -0,0 +1,121 @@
+package img
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/color"
+	"runtime"
+	"testing"
+
+	"github.com/snapas/img/icc"
+	"github.com/snapas/img/iccjpeg"
+)
+
+func TestPNG(t *testing.T) {
+	// A 3x2 image stored sideways, with an orientation of 6, whose top left pixel ends up at the top right.
+	m := image.NewNRGBA(image.Rect(0, 0, 2, 3))
+	m.Set(0, 1, color.NRGBA{0xff, 0, 0, 0x80})
+	chunks := []PNGChunk{
+		{"pHYs", []byte("\x00\x00\x0b\x13\x00\x00\x0b\x13\x01")},
+		{"tEXt", []byte("Title\x00Sunset")},
+		{"iTXt", []byte("Author\x00\x00\x00en\x00\x00Ana")},
+	}
+	src := Image{
+		Image:     m,
+		App2:      iccjpeg.App2Data(icc.DisplayP3Data),
+		Exif:      testExif(6, 2, 3),
+		XMP:       []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`),
+		PNGChunks: chunks,
+	}
+	buf := &bytes.Buffer{}
+	if err := EncodePNG(buf, src, nil); err != nil {
+		t.Fatal("EncodePNG failed:", err)
+	}
+
+	i, format, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if format != "png" || i.Image.Bounds() != image.Rect(0, 0, 3, 2) {
+		t.Fatalf("got %s image %v", format, i.Image.Bounds())
+	}
+	if _, _, _, a := i.Image.At(1, 0).RGBA(); a>>8 != 0x80 {
+		t.Error("image wasn't turned upright")
+	}
+	if o := Orientation(i.Exif); o != 1 {
+		t.Errorf("orientation is %d", o)
+	}
+	if w, _ := exifTag(i.Exif, tagPixelXDimension); w != 3 {
+		t.Errorf("EXIF width is %d", w)
+	}
+	if !bytes.Equal(iccjpeg.ProfileData(i.App2), icc.DisplayP3Data) {
+		t.Error("ICC profile was lost")
+	}
+	if !bytes.Equal(i.XMP, src.XMP) {
+		t.Errorf("got XMP %q", i.XMP)
+	}
+	if len(i.PNGChunks) != len(chunks) {
+		t.Fatalf("got chunks %q", i.PNGChunks)
+	}
+	for k, c := range i.PNGChunks {
+		if c.Type != chunks[k].Type || !bytes.Equal(c.Data, chunks[k].Data) {
+			t.Errorf("got chunk %q, want %q", c, chunks[k])
+		}
+	}
+
+	// The metadata carries over to JPEGs, except for the PNG chunks.
+	buf.Reset()
+	if err := Encode(buf, i, nil); err != nil {
+		t.Fatal("Encode failed:", err)
+	}
+	j, _, err := Decode(buf)
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if !bytes.Equal(j.App2, i.App2) || !bytes.Equal(j.Exif, i.Exif) || !bytes.Equal(j.XMP, i.XMP) {
+		t.Error("metadata was lost in JPEG")
+	}
+}
+
+func TestPNGXMP(t *testing.T) {
+	xmp := "<x:xmpmeta/>"
+	z := &bytes.Buffer{}
+	zw := zlib.NewWriter(z)
+	zw.Write([]byte(xmp))
+	zw.Close()
+	for _, c := range []struct {
+		typ, data string
+		want      string
+	}{
+		{"iTXt", xmpKeyword + "\x00\x00\x00\x00\x00" + xmp, xmp},
+		{"iTXt", xmpKeyword + "\x00\x01\x00en\x00XMP\x00" + z.String(), xmp},
+		{"tEXt", xmpKeyword + "\x00" + xmp, xmp},
+		{"iTXt", "Comment\x00\x00\x00\x00\x00" + xmp, ""},
+		{"iTXt", xmpKeyword + "\x00\x00\x00\x00", ""},
+		{"iTXt", xmpKeyword + "\x00\x01\x00\x00\x00garbage", ""},
+	} {
+		if got := pngXMP(c.typ, []byte(c.data)); string(got) != c.want {
+			t.Errorf("%s %q: got %q, want %q", c.typ, c.data, got, c.want)
+		}
+	}
+}
+
+func TestPNGCompressedBomb(t *testing.T) {
+	// 32 MiB of zeros compress to a chunk of about 32 KiB, which must not be inflated in full.
+	z := &bytes.Buffer{}
+	zw := zlib.NewWriter(z)
+	zw.Write(make([]byte, 32<<20))
+	zw.Close()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	profile := iccpProfile(append([]byte("ICC Profile\x00\x00"), z.Bytes()...))
+	xmp := pngXMP("iTXt", append([]byte(xmpKeyword+"\x00\x01\x00\x00\x00"), z.Bytes()...))
+	runtime.ReadMemStats(&after)
+	if profile != nil || xmp != nil {
+		t.Errorf("got %d-byte profile and %d-byte XMP", len(profile), len(xmp))
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 4<<20 {
+		t.Errorf("allocated %d bytes", n)
+	}
+}
@@ -0,0 +1,83 @@
+package img
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+
+	"github.com/snapas/img/icc"
+	"github.com/snapas/img/iccjpeg"
+)
+
+// ConvertProfile converts the pixels of i from its embedded ICC profile to dst, using the given rendering intent, and
+// embeds dst in place of the original profile, keeping the rest of the metadata. This way the photo looks the same
+// whether or not the software displaying it honours embedded profiles. Images without a profile are assumed to be sRGB,
+// except for CMYK images, which are converted through icc.GenericCMYK unless they have a CMYK profile. Images whose
+// profile describes the same color space as dst, such as the sRGB profiles of phones when dst is icc.SRGB, are
+// returned unchanged.
+func ConvertProfile(i Image, dst *icc.Profile, intent icc.Intent) (Image, error) {
+	src := icc.SRGB
+	if data := iccjpeg.ProfileData(i.App2); data != nil {
+		var err error
+		src, err = icc.Parse(data)
+		if err != nil {
+			return i, fmt.Errorf("icc.Parse: %s", err)
+		}
+	}
+	if _, ok := i.Image.(*image.CMYK); ok {
+		if src.ColorSpace != "CMYK" {
+			src = icc.GenericCMYK
+		}
+		t, err := icc.NewCMYKTransform(src, dst, intent)
+		if err != nil {
+			return i, fmt.Errorf("icc.NewCMYKTransform: %s", err)
+		}
+		i.Image, i.App2 = t.ConvertImage(i.Image), iccjpeg.App2Data(dst.Bytes())
+		fixMetadata(&i)
+		return i, nil
+	}
+	if sameColorSpace(src, dst) {
+		return i, nil
+	}
+
+	t, err := icc.NewTransform(src, dst, intent)
+	if err != nil {
+		return i, fmt.Errorf("icc.NewTransform: %s", err)
+	}
+	i.Image, i.App2 = t.ConvertImage(i.Image), iccjpeg.App2Data(dst.Bytes())
+	fixMetadata(&i)
+	return i, nil
+}
+
+// sameColorSpace reports whether the profiles p and q describe the same color space, either by being identical or by
+// being identified as the same well-known one.
+func sameColorSpace(p, q *icc.Profile) bool {
+	if bytes.Equal(p.Bytes(), q.Bytes()) {
+		return true
+	}
+	k := icc.Identify(p)
+	return k != icc.Unknown && k == icc.Identify(q)
+}
+
+// ToSRGB converts i to the sRGB color space with the perceptual rendering intent, and embeds a compact sRGB profile.
+// Images that are already sRGB, including those without a profile, are returned unchanged.
+func ToSRGB(i Image) (Image, error) {
+	return ConvertProfile(i, icc.SRGB, icc.Perceptual)
+}
+
+// CompactProfile replaces the ICC profile of i with a compact equivalent if it is a well-known one, such as the
+// multi-kilobyte sRGB and Display P3 profiles that phones embed. With dropSRGB, sRGB profiles are removed entirely,
+// since images without a profile are treated as sRGB. It returns the new Image and the number of bytes saved.
+func CompactProfile(i Image, dropSRGB bool) (Image, int) {
+	data := iccjpeg.ProfileData(i.App2)
+	if data == nil {
+		return i, 0
+	}
+	c := icc.Compact(data, dropSRGB)
+	if c == nil {
+		i.App2 = nil
+	} else if len(c) < len(data) {
+		i.App2 = iccjpeg.App2Data(c)
+	}
+	return i, len(data) - len(c)
+}
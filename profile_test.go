@@ -0,0 +1,126 @@
+package img
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+
+	"github.com/snapas/img/icc"
+	"github.com/snapas/img/iccjpeg"
+)
+
+func TestToSRGB(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 8, 8))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(4 * i)
+	}
+
+	// Images without a profile are already sRGB.
+	i, err := ToSRGB(Image{Image: m})
+	if err != nil {
+		t.Fatal("ToSRGB failed:", err)
+	}
+	if i.Image != m || i.App2 != nil {
+		t.Error("image without profile was changed")
+	}
+
+	// Nor are images with another vendor's sRGB profile, which only differs from the canonical one by its creation
+	// date and profile ID.
+	vendor := append([]byte(nil), icc.SRGBData...)
+	copy(vendor[24:36], "\x07\xe6\x00\x01\x00\x01\x00\x00\x00\x00\x00\x00")
+	copy(vendor[84:100], make([]byte, 16))
+	app2 := iccjpeg.App2Data(vendor)
+	i, err = ToSRGB(Image{Image: m, App2: app2})
+	if err != nil {
+		t.Fatal("ToSRGB failed:", err)
+	}
+	if i.Image != m || !bytes.Equal(i.App2, app2) {
+		t.Error("image with a vendor sRGB profile was changed")
+	}
+
+	i, err = ToSRGB(Image{Image: m, App2: iccjpeg.App2Data(icc.SGrayData)})
+	if err != nil {
+		t.Fatal("ToSRGB failed:", err)
+	}
+	if !bytes.Equal(iccjpeg.ProfileData(i.App2), icc.SRGBData) {
+		t.Error("sRGB profile not embedded")
+	}
+	if _, ok := i.Image.(*image.NRGBA); !ok {
+		t.Errorf("got %T, want *image.NRGBA", i.Image)
+	}
+}
+
+func TestToSRGBCMYK(t *testing.T) {
+	m := image.NewCMYK(image.Rect(0, 0, 2, 1))
+	copy(m.Pix, []byte{0, 0, 0, 0, 0, 0, 0, 0xff})
+
+	i, err := ToSRGB(Image{Image: m})
+	if err != nil {
+		t.Fatal("ToSRGB failed:", err)
+	}
+	rgba, ok := i.Image.(*image.RGBA)
+	if !ok {
+		t.Fatalf("got %T, want *image.RGBA", i.Image)
+	}
+	if c := rgba.RGBAAt(0, 0); c.R < 254 || c.G < 254 || c.B < 254 {
+		t.Errorf("paper is %v, want white", c)
+	}
+	if c := rgba.RGBAAt(1, 0); c.R > 64 || c.G > 64 || c.B > 64 {
+		t.Errorf("black ink is %v, want dark", c)
+	}
+	if !bytes.Equal(iccjpeg.ProfileData(i.App2), icc.SRGBData) {
+		t.Error("sRGB profile not embedded")
+	}
+}
+
+func TestConvertProfileKeepsMetadata(t *testing.T) {
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`)
+	chunks := []PNGChunk{{"tEXt", []byte("Title\x00Sunset")}}
+	trailer := []byte("video data")
+	for _, m := range []image.Image{image.NewGray(image.Rect(0, 0, 8, 4)), image.NewCMYK(image.Rect(0, 0, 8, 4))} {
+		src := Image{
+			buf:       &bytes.Buffer{},
+			Image:     m,
+			App2:      iccjpeg.App2Data(icc.SGrayData),
+			Exif:      testExif(1, 8, 4),
+			XMP:       xmp,
+			PNGChunks: chunks,
+			Trailer:   trailer,
+		}
+		if _, ok := m.(*image.CMYK); ok {
+			src.App2 = nil
+		}
+		i, err := ToSRGB(src)
+		if err != nil {
+			t.Fatal("ToSRGB failed:", err)
+		}
+		if !bytes.Equal(iccjpeg.ProfileData(i.App2), icc.SRGBData) {
+			t.Errorf("%T: sRGB profile not embedded", m)
+		}
+		if w, _ := exifTag(i.Exif, tagPixelXDimension); w != 8 {
+			t.Errorf("%T: EXIF width is %d", m, w)
+		}
+		if !bytes.Equal(i.XMP, xmp) || len(i.PNGChunks) != 1 || !bytes.Equal(i.Trailer, trailer) {
+			t.Errorf("%T: got XMP %q, PNG chunks %q and trailer %q", m, i.XMP, i.PNGChunks, i.Trailer)
+		}
+	}
+}
+
+func TestCompactProfile(t *testing.T) {
+	p3 := append(append([]byte(nil), icc.DisplayP3Data...), make([]byte, 1000)...)
+	binary.BigEndian.PutUint32(p3, uint32(len(p3)))
+
+	i, saved := CompactProfile(Image{App2: iccjpeg.App2Data(p3)}, true)
+	if saved != 1000 || !bytes.Equal(iccjpeg.ProfileData(i.App2), icc.DisplayP3Data) {
+		t.Errorf("got %d byte profile, saving %d bytes; want the Display P3 profile, saving 1000", len(i.App2), saved)
+	}
+	i, saved = CompactProfile(Image{App2: iccjpeg.App2Data(icc.SRGBData)}, true)
+	if saved != len(icc.SRGBData) || i.App2 != nil {
+		t.Errorf("got %d byte profile, saving %d bytes; want no profile", len(i.App2), saved)
+	}
+	i, saved = CompactProfile(Image{App2: iccjpeg.App2Data(icc.SRGBData)}, false)
+	if saved != 0 || !bytes.Equal(iccjpeg.ProfileData(i.App2), icc.SRGBData) {
+		t.Errorf("canonical sRGB profile changed, saving %d bytes", saved)
+	}
+}
@@ -0,0 +1,363 @@
+package img
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// Filter is a resampling filter, which weighs the source pixels around each destination pixel.
+type Filter struct {
+	// support is the radius of the kernel, in source pixels when enlarging. It grows with the scale factor when
+	// shrinking, so that every source pixel contributes.
+	support float64
+	kernel  func(float64) float64
+}
+
+var (
+	// Box averages the source pixels covered by each destination pixel. It is the fastest filter, and the blurriest.
+	Box = Filter{0.5, box}
+	// Mitchell is the Mitchell-Netravali cubic filter, a good compromise between blurring and ringing.
+	Mitchell = Filter{2, cubic(1.0/3, 1.0/3)}
+	// CatmullRom is the Catmull-Rom cubic filter, which is sharper than Mitchell.
+	CatmullRom = Filter{2, cubic(0, 0.5)}
+	// Lanczos3 is the Lanczos filter with 3 lobes, which is sharp with some ringing around edges. It is the best
+	// choice for photos.
+	Lanczos3 = Filter{3, lanczos3}
+)
+
+func box(x float64) float64 {
+	if -0.5 <= x && x < 0.5 {
+		return 1
+	}
+	return 0
+}
+
+// cubic returns the BC-spline kernel with the given B and C parameters.
+func cubic(b, c float64) func(float64) float64 {
+	return func(x float64) float64 {
+		x = math.Abs(x)
+		switch {
+		case x < 1:
+			return ((12-9*b-6*c)*x*x*x + (-18+12*b+6*c)*x*x + (6 - 2*b)) / 6
+		case x < 2:
+			return ((-b-6*c)*x*x*x + (6*b+30*c)*x*x + (-12*b-48*c)*x + (8*b + 24*c)) / 6
+		}
+		return 0
+	}
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+func lanczos3(x float64) float64 {
+	if -3 < x && x < 3 {
+		return sinc(x) * sinc(x/3)
+	}
+	return 0
+}
+
+// Resize scales i to w by h pixels with the given filter, keeping its metadata and updating the dimensions it records.
+// If one of w and h is 0, it is derived from the other so that the aspect ratio is kept.
+//
+// Pixels are resampled in linear light, assuming the sRGB tone curve, so that bright and dark details keep their
+// brightness. Gray images stay gray, images with transparency become *image.NRGBA and others become *image.RGBA.
+func Resize(i Image, w, h int, f Filter) (Image, error) {
+	b := i.Image.Bounds()
+	if w == 0 && h > 0 && b.Dy() > 0 {
+		w = int(math.Max(1, math.Round(float64(b.Dx())*float64(h)/float64(b.Dy()))))
+	} else if h == 0 && w > 0 && b.Dx() > 0 {
+		h = int(math.Max(1, math.Round(float64(b.Dy())*float64(w)/float64(b.Dx()))))
+	}
+	if w <= 0 || h <= 0 || b.Empty() {
+		return i, fmt.Errorf("invalid size %dx%d for %dx%d image", w, h, b.Dx(), b.Dy())
+	}
+	if i.GainMap != nil {
+		i.GainMap = i.GainMap.resize(i.Image.Bounds(), w, h, f)
+	}
+	i.Image = resample(i.Image, w, h, f, false)
+	fixMetadata(&i)
+	return i, nil
+}
+
+// resample scales m to w by h pixels. It works in two passes, first resampling every row of m horizontally, and then
+// the columns of the result vertically. Pixels are held as linear, premultiplied float32 values meanwhile, unless raw
+// is set for images whose values aren't light, such as gain maps, which are resampled as they are.
+func resample(m image.Image, w, h int, f Filter, raw bool) image.Image {
+	b := m.Bounds()
+	src := newLinearReader(m, raw)
+	nc := src.channels
+	xw := newWeights(w, b.Dx(), f)
+	yw := newWeights(h, b.Dy(), f)
+
+	tmp := make([]float32, w*b.Dy()*nc)
+	parallel(b.Dy(), func(lo, hi int) {
+		row := make([]float32, b.Dx()*nc)
+		for y := lo; y < hi; y++ {
+			src.read(row, b.Min.Y+y)
+			out := tmp[y*w*nc : (y+1)*w*nc]
+			for x := 0; x < w; x++ {
+				start, ws := xw.at(x)
+				for c := 0; c < nc; c++ {
+					var sum float32
+					for k, wk := range ws {
+						sum += row[(start+k)*nc+c] * wk
+					}
+					out[x*nc+c] = sum
+				}
+			}
+		}
+	})
+
+	dst := src.newImage(w, h)
+	parallel(h, func(lo, hi int) {
+		row := make([]float32, w*nc)
+		for y := lo; y < hi; y++ {
+			start, ws := yw.at(y)
+			for i := range row {
+				row[i] = 0
+			}
+			for k, wk := range ws {
+				in := tmp[(start+k)*w*nc : (start+k+1)*w*nc]
+				for i, v := range in {
+					row[i] += v * wk
+				}
+			}
+			dst.write(row, y)
+		}
+	})
+	return dst.img
+}
+
+// weights holds the filter weights of each destination pixel along one axis, which apply to the source pixels
+// starting at starts[i]. Every pixel has taps weights, padded with zeros at the edges.
+type weights struct {
+	taps   int
+	starts []int
+	w      []float32
+}
+
+func newWeights(dst, src int, f Filter) *weights {
+	scale := float64(src) / float64(dst)
+	filterScale := math.Max(scale, 1)
+	support := f.support * filterScale
+	ws := &weights{taps: int(math.Ceil(support))*2 + 1, starts: make([]int, dst)}
+	if ws.taps > src {
+		ws.taps = src
+	}
+	ws.w = make([]float32, dst*ws.taps)
+	for i := range ws.starts {
+		center := (float64(i) + 0.5) * scale
+		start := int(math.Floor(center - support + 0.5))
+		if start < 0 {
+			start = 0
+		} else if start > src-ws.taps {
+			start = src - ws.taps
+		}
+		ws.starts[i] = start
+		w := ws.w[i*ws.taps : (i+1)*ws.taps]
+		var sum float64
+		for k := range w {
+			v := f.kernel((float64(start+k) + 0.5 - center) / filterScale)
+			w[k] = float32(v)
+			sum += v
+		}
+		if sum == 0 {
+			// Box filters can miss every source pixel when enlarging; fall back to the nearest one.
+			w[int(center)-start] = 1
+			continue
+		}
+		for k := range w {
+			w[k] /= float32(sum)
+		}
+	}
+	return ws
+}
+
+func (ws *weights) at(i int) (int, []float32) {
+	return ws.starts[i], ws.w[i*ws.taps : (i+1)*ws.taps]
+}
+
+// parallel calls f for consecutive ranges of [0, n), from as many goroutines as there are CPUs to run them.
+func parallel(n int, f func(lo, hi int)) {
+	procs := runtime.GOMAXPROCS(0)
+	if procs > n {
+		procs = n
+	}
+	if procs <= 1 {
+		f(0, n)
+		return
+	}
+	var wg sync.WaitGroup
+	for p := 0; p < procs; p++ {
+		wg.Add(1)
+		go func(lo, hi int) {
+			defer wg.Done()
+			f(lo, hi)
+		}(n*p/procs, n*(p+1)/procs)
+	}
+	wg.Wait()
+}
+
+// linearLUTSize is the number of steps in the table that encodes linear light back to sRGB.
+const linearLUTSize = 1 << 14
+
+var (
+	// toLinear maps sRGB encoded values to linear light.
+	toLinear [256]float32
+	// fromLinear maps linear light to sRGB encoded values.
+	fromLinear [linearLUTSize + 1]uint8
+	// toUnit maps 8-bit values to [0, 1] as they are.
+	toUnit [256]float32
+)
+
+func init() {
+	for i := range toUnit {
+		toUnit[i] = float32(i) / 255
+	}
+	for i := range toLinear {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			toLinear[i] = float32(v / 12.92)
+		} else {
+			toLinear[i] = float32(math.Pow((v+0.055)/1.055, 2.4))
+		}
+	}
+	for i := range fromLinear {
+		v := float64(i) / linearLUTSize
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		fromLinear[i] = uint8(v*255 + 0.5)
+	}
+}
+
+func encodeLinear(v float32) uint8 {
+	if v <= 0 {
+		return 0
+	} else if v >= 1 {
+		return 255
+	}
+	return fromLinear[int(v*linearLUTSize+0.5)]
+}
+
+func encodeUnit(v float32) uint8 {
+	if v <= 0 {
+		return 0
+	} else if v >= 1 {
+		return 255
+	}
+	return uint8(v*255 + 0.5)
+}
+
+// linearReader reads rows of an image as linear light, with 1 channel for gray images and premultiplied RGBA
+// otherwise. Raw readers read values as they are instead.
+type linearReader struct {
+	m        image.Image
+	channels int
+	opaque   bool
+	lut      *[256]float32
+	encode   func(float32) uint8
+}
+
+func newLinearReader(m image.Image, raw bool) *linearReader {
+	r := &linearReader{m: m, channels: 4, opaque: true, lut: &toLinear, encode: encodeLinear}
+	if raw {
+		r.lut, r.encode = &toUnit, encodeUnit
+	}
+	if _, ok := m.(*image.Gray); ok {
+		r.channels = 1
+	} else if o, ok := m.(interface{ Opaque() bool }); ok {
+		r.opaque = o.Opaque()
+	} else {
+		r.opaque = false
+	}
+	return r
+}
+
+func (r *linearReader) read(row []float32, y int) {
+	b := r.m.Bounds()
+	lut := r.lut
+	switch m := r.m.(type) {
+	case *image.Gray:
+		pix := m.Pix[m.PixOffset(b.Min.X, y):]
+		for x := range row {
+			row[x] = lut[pix[x]]
+		}
+	case *image.YCbCr:
+		for x := 0; x < b.Dx(); x++ {
+			yi, ci := m.YOffset(b.Min.X+x, y), m.COffset(b.Min.X+x, y)
+			cr, cg, cb := color.YCbCrToRGB(m.Y[yi], m.Cb[ci], m.Cr[ci])
+			row[4*x], row[4*x+1], row[4*x+2], row[4*x+3] = lut[cr], lut[cg], lut[cb], 1
+		}
+	case *image.NRGBA:
+		pix := m.Pix[m.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			p := pix[4*x : 4*x+4]
+			a := float32(p[3]) / 255
+			row[4*x], row[4*x+1], row[4*x+2], row[4*x+3] = lut[p[0]]*a, lut[p[1]]*a, lut[p[2]]*a, a
+		}
+	default:
+		for x := 0; x < b.Dx(); x++ {
+			c := color.NRGBAModel.Convert(m.At(b.Min.X+x, y)).(color.NRGBA)
+			a := float32(c.A) / 255
+			row[4*x], row[4*x+1], row[4*x+2], row[4*x+3] = lut[c.R]*a, lut[c.G]*a, lut[c.B]*a, a
+		}
+	}
+}
+
+// linearWriter writes rows of linear light to an image of the type that suits its linearReader.
+type linearWriter struct {
+	img   image.Image
+	write func(row []float32, y int)
+}
+
+func (r *linearReader) newImage(w, h int) *linearWriter {
+	rect := image.Rect(0, 0, w, h)
+	enc := r.encode
+	switch {
+	case r.channels == 1:
+		m := image.NewGray(rect)
+		return &linearWriter{m, func(row []float32, y int) {
+			pix := m.Pix[y*m.Stride:]
+			for x, v := range row {
+				pix[x] = enc(v)
+			}
+		}}
+	case r.opaque:
+		m := image.NewRGBA(rect)
+		return &linearWriter{m, func(row []float32, y int) {
+			pix := m.Pix[y*m.Stride:]
+			for x := 0; x < w; x++ {
+				p := pix[4*x : 4*x+4]
+				p[0], p[1], p[2], p[3] = enc(row[4*x]), enc(row[4*x+1]), enc(row[4*x+2]), 0xff
+			}
+		}}
+	}
+	m := image.NewNRGBA(rect)
+	return &linearWriter{m, func(row []float32, y int) {
+		pix := m.Pix[y*m.Stride:]
+		for x := 0; x < w; x++ {
+			p := pix[4*x : 4*x+4]
+			a := row[4*x+3]
+			if a <= 0 {
+				p[0], p[1], p[2], p[3] = 0, 0, 0, 0
+				continue
+			} else if a > 1 {
+				a = 1
+			}
+			p[0], p[1], p[2] = enc(row[4*x]/a), enc(row[4*x+1]/a), enc(row[4*x+2]/a)
+			p[3] = uint8(a*255 + 0.5)
+		}
+	}}
+}
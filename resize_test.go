@@ -0,0 +1,194 @@
+package img
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+var filters = []struct {
+	name string
+	f    Filter
+}{
+	{"box", Box},
+	{"mitchell", Mitchell},
+	{"catmullrom", CatmullRom},
+	{"lanczos3", Lanczos3},
+}
+
+func TestResizeUniform(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 37, 23), image.YCbCrSubsampleRatio420)
+	y, cb, cr := color.RGBToYCbCr(200, 120, 40)
+	for i := range src.Y {
+		src.Y[i] = y
+	}
+	for i := range src.Cb {
+		src.Cb[i], src.Cr[i] = cb, cr
+	}
+	want := color.RGBAModel.Convert(color.YCbCr{Y: y, Cb: cb, Cr: cr}).(color.RGBA)
+	for _, f := range filters {
+		for _, size := range []image.Point{{10, 7}, {37, 23}, {80, 51}} {
+			t.Run(f.name, func(t *testing.T) {
+				i, err := Resize(Image{Image: src}, size.X, size.Y, f.f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				m, ok := i.Image.(*image.RGBA)
+				if !ok {
+					t.Fatalf("got %T, want *image.RGBA", i.Image)
+				}
+				if m.Bounds() != image.Rect(0, 0, size.X, size.Y) {
+					t.Fatalf("got bounds %v, want %v", m.Bounds(), size)
+				}
+				for p := 0; p < len(m.Pix); p += 4 {
+					c := color.RGBA{m.Pix[p], m.Pix[p+1], m.Pix[p+2], m.Pix[p+3]}
+					if diff(c.R, want.R) > 1 || diff(c.G, want.G) > 1 || diff(c.B, want.B) > 1 || c.A != 0xff {
+						t.Fatalf("pixel %d = %v, want %v", p/4, c, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func diff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestResizeLinearLight(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if (x+y)%2 == 0 {
+				src.SetGray(x, y, color.Gray{0xff})
+			}
+		}
+	}
+	i, err := Resize(Image{Image: src}, 4, 4, Box)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := i.Image.(*image.Gray)
+	if !ok {
+		t.Fatalf("got %T, want *image.Gray", i.Image)
+	}
+	// Half the light of white is 188 in sRGB, rather than 128.
+	for _, v := range m.Pix {
+		if diff(v, 188) > 1 {
+			t.Fatalf("got %d, want 188", v)
+		}
+	}
+}
+
+func TestResizeAlpha(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			if x < 8 {
+				src.SetNRGBA(x, y, color.NRGBA{0, 0xff, 0, 0xff})
+			} else {
+				// Invisible red must not bleed into the green.
+				src.SetNRGBA(x, y, color.NRGBA{0xff, 0, 0, 0})
+			}
+		}
+	}
+	i, err := Resize(Image{Image: src}, 5, 5, Lanczos3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := i.Image.(*image.NRGBA)
+	if !ok {
+		t.Fatalf("got %T, want *image.NRGBA", i.Image)
+	}
+	for p := 0; p < len(m.Pix); p += 4 {
+		if a := m.Pix[p+3]; a > 8 && (m.Pix[p] > 2 || m.Pix[p+1] < 0xfd) {
+			t.Fatalf("pixel %d = %v, want green", p/4, m.Pix[p:p+4])
+		}
+	}
+}
+
+func TestResizeSize(t *testing.T) {
+	src := Image{Image: image.NewRGBA(image.Rect(0, 0, 300, 200))}
+	testCases := []struct {
+		w, h int
+		want image.Rectangle
+	}{
+		{150, 0, image.Rect(0, 0, 150, 100)},
+		{0, 50, image.Rect(0, 0, 75, 50)},
+		{1, 1, image.Rect(0, 0, 1, 1)},
+		{1000, 0, image.Rect(0, 0, 1000, 667)},
+	}
+	for _, tc := range testCases {
+		i, err := Resize(src, tc.w, tc.h, CatmullRom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := i.Image.Bounds(); got != tc.want {
+			t.Errorf("Resize(%d, %d) = %v, want %v", tc.w, tc.h, got, tc.want)
+		}
+	}
+	for _, size := range [][2]int{{0, 0}, {-1, 10}, {10, -1}} {
+		if _, err := Resize(src, size[0], size[1], Box); err == nil {
+			t.Errorf("Resize(%d, %d) succeeded, want error", size[0], size[1])
+		}
+	}
+}
+
+// testExif builds big-endian EXIF data with an orientation in IFD0, and the pixel dimensions as a LONG and a SHORT in
+// the Exif IFD.
+func testExif(orientation uint16, w, h int) []byte {
+	b := []byte("MM\x00*\x00\x00\x00\x08")
+	entry := func(tag, typ uint16, v uint32) {
+		e := make([]byte, 12)
+		binary.BigEndian.PutUint16(e, tag)
+		binary.BigEndian.PutUint16(e[2:], typ)
+		binary.BigEndian.PutUint32(e[4:], 1)
+		if typ == typeShort {
+			binary.BigEndian.PutUint16(e[8:], uint16(v))
+		} else {
+			binary.BigEndian.PutUint32(e[8:], v)
+		}
+		b = append(b, e...)
+	}
+	b = append(b, 0, 2)
+	entry(tagOrientation, typeShort, uint32(orientation))
+	entry(tagExifIFD, typeLong, 8+2+2*12+4)
+	b = append(b, 0, 0, 0, 0)
+	b = append(b, 0, 2)
+	entry(tagPixelXDimension, typeLong, uint32(w))
+	entry(tagPixelYDimension, typeShort, uint32(h))
+	return append(b, 0, 0, 0, 0)
+}
+
+func TestResizeMetadata(t *testing.T) {
+	src := Image{
+		Image: image.NewRGBA(image.Rect(0, 0, 40, 30)),
+		App2:  []byte("ICC_PROFILE\x00\x01\x01profile"),
+		Exif:  testExif(1, 40, 30),
+		XMP: []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF><rdf:Description exif:PixelXDimension="40">` +
+			`<exif:PixelYDimension>30</exif:PixelYDimension></rdf:Description></rdf:RDF></x:xmpmeta>`),
+	}
+	i, err := Resize(src, 20, 0, Mitchell)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(i.App2) != string(src.App2) {
+		t.Errorf("App2 = %q, want %q", i.App2, src.App2)
+	}
+	if want := testExif(1, 20, 15); string(i.Exif) != string(want) {
+		t.Errorf("Exif = %x, want %x", i.Exif, want)
+	}
+	if string(src.Exif) != string(testExif(1, 40, 30)) {
+		t.Error("source EXIF was modified")
+	}
+	for _, s := range []string{`exif:PixelXDimension="20"`, `<exif:PixelYDimension>15</exif:PixelYDimension>`} {
+		if !strings.Contains(string(i.XMP), s) {
+			t.Errorf("XMP %s doesn't contain %s", i.XMP, s)
+		}
+	}
+}
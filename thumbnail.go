@@ -0,0 +1,167 @@
+package img
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/snapas/img/jpeg"
+)
+
+// ThumbnailMode is how an image is made to fit the size of a thumbnail.
+type ThumbnailMode int
+
+const (
+	// Fit scales the image down to fit inside the thumbnail, keeping its aspect ratio. Either the width or the height
+	// may be 0 to leave it unbounded. Images that already fit are left at their size.
+	Fit ThumbnailMode = iota
+	// Fill scales the image to cover the thumbnail, keeping its aspect ratio, and crops the overflow equally on both
+	// sides.
+	Fill
+	// Stretch scales the image to the size of the thumbnail, changing its aspect ratio if need be.
+	Stretch
+)
+
+// Thumbnail returns a w by h thumbnail of i, or one that fits inside w by h in Fit mode, resampled with Lanczos3. The
+// ICC profile, EXIF and XMP metadata are kept, with the dimensions updated, and any gain map is cropped and scaled
+// along with the image.
+func Thumbnail(i Image, w, h int, mode ThumbnailMode) (Image, error) {
+	b := i.Image.Bounds()
+	tw, th, err := thumbnailScale(b.Dx(), b.Dy(), w, h, mode)
+	if err != nil {
+		return i, err
+	}
+	if mode == Fill {
+		// Crop the part of i that ends up in the thumbnail once it's scaled to tw by th.
+		cw := int(math.Min(float64(b.Dx()), math.Max(1, math.Round(float64(w)*float64(b.Dx())/float64(tw)))))
+		ch := int(math.Min(float64(b.Dy()), math.Max(1, math.Round(float64(h)*float64(b.Dy())/float64(th)))))
+		r := image.Rect(0, 0, cw, ch).Add(b.Min).Add(image.Pt((b.Dx()-cw)/2, (b.Dy()-ch)/2))
+		if r != b {
+			if i.GainMap != nil {
+				i.GainMap = i.GainMap.crop(b, r)
+			}
+			i.Image = crop(i.Image, r)
+		}
+		tw, th = w, h
+	}
+	if i.Image.Bounds().Size() == image.Pt(tw, th) {
+		fixMetadata(&i)
+		return i, nil
+	}
+	return Resize(i, tw, th, Lanczos3)
+}
+
+// thumbnailScale returns the size that a sw by sh image is scaled to for a w by h thumbnail, before any cropping.
+func thumbnailScale(sw, sh, w, h int, mode ThumbnailMode) (int, int, error) {
+	if sw <= 0 || sh <= 0 || w < 0 || h < 0 {
+		return 0, 0, fmt.Errorf("invalid thumbnail size %dx%d for %dx%d image", w, h, sw, sh)
+	}
+	xs, ys := float64(w)/float64(sw), float64(h)/float64(sh)
+	var scale float64
+	switch mode {
+	case Fit:
+		if w == 0 && h == 0 {
+			return 0, 0, fmt.Errorf("invalid thumbnail size %dx%d", w, h)
+		} else if w == 0 {
+			xs = ys
+		} else if h == 0 {
+			ys = xs
+		}
+		scale = math.Min(1, math.Min(xs, ys))
+	case Fill:
+		if w == 0 || h == 0 {
+			return 0, 0, fmt.Errorf("invalid thumbnail size %dx%d", w, h)
+		}
+		scale = math.Max(xs, ys)
+	case Stretch:
+		if w == 0 || h == 0 {
+			return 0, 0, fmt.Errorf("invalid thumbnail size %dx%d", w, h)
+		}
+		return w, h, nil
+	default:
+		return 0, 0, fmt.Errorf("unknown thumbnail mode %d", mode)
+	}
+	tw := int(math.Max(1, math.Round(float64(sw)*scale)))
+	th := int(math.Max(1, math.Round(float64(sh)*scale)))
+	if mode == Fill {
+		// Rounding mustn't leave the scaled image smaller than the thumbnail.
+		tw, th = int(math.Max(float64(tw), float64(w))), int(math.Max(float64(th), float64(h)))
+	}
+	return tw, th, nil
+}
+
+// crop returns the part of m within r, copying it if m can't share its pixels.
+func crop(m image.Image, r image.Rectangle) image.Image {
+	if s, ok := m.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	c := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(c, c.Bounds(), m, r.Min, draw.Src)
+	return c
+}
+
+// DecodeThumbnail decodes an image from r and returns a thumbnail of it, as Thumbnail does for an image returned by
+// Decode. JPEGs are decoded at 1/2, 1/4 or 1/8 of their size when that is still at least as large as the thumbnail,
+// which is far quicker than decoding them in full, and the rest of the way is resampled with Lanczos3.
+func DecodeThumbnail(r io.Reader, w, h int, mode ThumbnailMode) (Image, string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Image{}, "", err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "jpeg" {
+		i, format, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			return i, format, err
+		}
+		i, err = Thumbnail(i, w, h, mode)
+		return i, format, err
+	}
+
+	i := Image{
+		buf: &bytes.Buffer{},
+	}
+	mpfOffset, err := readMetadata(&i, bytes.NewReader(data))
+	if err != nil {
+		return i, "", fmt.Errorf("readMetadata: %s", err)
+	}
+	o := Orientation(i.Exif)
+	if i.MPF != nil {
+		readSecondary(&i, data, mpfOffset)
+	}
+	readTrailer(&i, data, mpfOffset)
+	if i.MPF != nil {
+		readGainMap(&i, o)
+	}
+	sw, sh := cfg.Width, cfg.Height
+	if o >= 5 {
+		sw, sh = sh, sw
+	}
+	tw, th, err := thumbnailScale(sw, sh, w, h, mode)
+	if err != nil {
+		return i, format, err
+	}
+	scale := 8
+	for scale > 1 && ((sw+scale-1)/scale < tw || (sh+scale-1)/scale < th) {
+		scale /= 2
+	}
+	m, err := jpeg.DecodeWithOptions(bytes.NewReader(data), &jpeg.DecodeOptions{Scale: scale})
+	if err != nil {
+		return i, format, fmt.Errorf("jpeg.DecodeWithOptions: %s", err)
+	}
+	i.Image = orient(m, o)
+	if mode == Fit {
+		// The size was worked out from the full image, which rounding may have changed the aspect ratio of when scaling
+		// it down.
+		i, err = Thumbnail(i, tw, th, Stretch)
+	} else {
+		i, err = Thumbnail(i, w, h, mode)
+	}
+	return i, format, err
+}
@@ -0,0 +1,246 @@
+package img
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+
+	"github.com/snapas/img/jpeg"
+)
+
+func TestThumbnailModes(t *testing.T) {
+	// Red, green and blue vertical stripes.
+	src := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 300; x++ {
+			c := color.RGBA{A: 0xff}
+			switch x / 100 {
+			case 0:
+				c.R = 0xff
+			case 1:
+				c.G = 0xff
+			case 2:
+				c.B = 0xff
+			}
+			src.SetRGBA(x, y, c)
+		}
+	}
+	testCases := []struct {
+		name string
+		w, h int
+		mode ThumbnailMode
+		want image.Point
+	}{
+		{"fit", 60, 60, Fit, image.Pt(60, 20)},
+		{"fit width", 150, 0, Fit, image.Pt(150, 50)},
+		{"fit height", 0, 10, Fit, image.Pt(30, 10)},
+		{"fit larger", 600, 600, Fit, image.Pt(300, 100)},
+		{"fill", 50, 50, Fill, image.Pt(50, 50)},
+		{"fill larger", 400, 400, Fill, image.Pt(400, 400)},
+		{"stretch", 30, 30, Stretch, image.Pt(30, 30)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			i, err := Thumbnail(Image{Image: src}, tc.w, tc.h, tc.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := i.Image.Bounds().Size(); got != tc.want {
+				t.Fatalf("got size %v, want %v", got, tc.want)
+			}
+			if tc.mode != Fill {
+				return
+			}
+			// Only the green stripe in the middle is left.
+			b := i.Image.Bounds()
+			for _, p := range []image.Point{b.Min, b.Max.Sub(image.Pt(1, 1)), b.Min.Add(b.Max).Div(2)} {
+				if r, g, b, _ := i.Image.At(p.X, p.Y).RGBA(); r>>8 > 8 || g>>8 < 0xf0 || b>>8 > 8 {
+					t.Errorf("pixel %v = (%d, %d, %d), want green", p, r>>8, g>>8, b>>8)
+				}
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		w, h int
+		mode ThumbnailMode
+	}{{0, 0, Fit}, {10, 0, Fill}, {0, 10, Stretch}, {-1, 10, Fit}, {10, 10, ThumbnailMode(-1)}} {
+		if _, err := Thumbnail(Image{Image: src}, tc.w, tc.h, tc.mode); err == nil {
+			t.Errorf("Thumbnail(%d, %d, %d) succeeded, want error", tc.w, tc.h, tc.mode)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// Where the top left and top right pixels of a stored w by h image end up, for each orientation.
+	const w, h = 3, 2
+	want := map[int][2]image.Point{
+		1: {{0, 0}, {w - 1, 0}},
+		2: {{w - 1, 0}, {0, 0}},
+		3: {{w - 1, h - 1}, {0, h - 1}},
+		4: {{0, h - 1}, {w - 1, h - 1}},
+		5: {{0, 0}, {0, w - 1}},
+		6: {{h - 1, 0}, {h - 1, w - 1}},
+		7: {{h - 1, w - 1}, {h - 1, 0}},
+		8: {{0, w - 1}, {0, 0}},
+	}
+	src := image.NewGray(image.Rect(0, 0, w, h))
+	src.SetGray(0, 0, color.Gray{1})
+	src.SetGray(w-1, 0, color.Gray{2})
+	for o, ps := range want {
+		for _, m := range []image.Image{src, image.NewRGBA(src.Bounds()), image.NewNRGBA(src.Bounds())} {
+			if m != src {
+				for _, p := range []image.Point{{0, 0}, {w - 1, 0}} {
+					m.(interface{ Set(int, int, color.Color) }).Set(p.X, p.Y, src.At(p.X, p.Y))
+				}
+			}
+			got := orient(m, o)
+			if (o >= 5) != (got.Bounds().Dx() == h) {
+				t.Errorf("orientation %d, %T: got bounds %v", o, m, got.Bounds())
+				continue
+			}
+			for i, p := range ps {
+				if r, _, _, _ := got.At(p.X, p.Y).RGBA(); r>>8 != uint32(i+1) {
+					t.Errorf("orientation %d, %T: pixel %v = %d, want %d", o, m, p, r>>8, i+1)
+				}
+			}
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 3, 2))
+	src.SetGray(0, 0, color.Gray{1})
+	i := Image{Image: src, Exif: testExif(1, 3, 2), GainMap: &GainMap{Image: image.NewGray(image.Rect(0, 0, 6, 4))}}
+	for _, tc := range []struct {
+		degrees int
+		corner  image.Point
+	}{
+		{90, image.Pt(1, 0)},
+		{-270, image.Pt(1, 0)},
+		{180, image.Pt(2, 1)},
+		{270, image.Pt(0, 2)},
+		{360, image.Pt(0, 0)},
+	} {
+		r, err := Rotate(i, tc.degrees)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := r.Image.Bounds()
+		if r, _, _, _ := r.Image.At(tc.corner.X, tc.corner.Y).RGBA(); r>>8 != 1 {
+			t.Errorf("%d°: top left pixel didn't move to %v", tc.degrees, tc.corner)
+		}
+		if gb := r.GainMap.Image.Bounds(); gb.Dx() != 2*b.Dx() || gb.Dy() != 2*b.Dy() {
+			t.Errorf("%d°: gain map is %v for a %v image", tc.degrees, gb, b)
+		}
+		if w, _ := exifTag(r.Exif, tagPixelXDimension); int(w) != b.Dx() {
+			t.Errorf("%d°: EXIF width is %d, want %d", tc.degrees, w, b.Dx())
+		}
+	}
+	if _, err := Rotate(i, 45); err == nil {
+		t.Error("rotated by 45°")
+	}
+	if i.GainMap.Image.Bounds().Dx() != 6 {
+		t.Error("rotating changed the original gain map")
+	}
+}
+
+func TestDecodeThumbnail(t *testing.T) {
+	// A 640x480 gradient, stored sideways with an orientation of 6.
+	src := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			src.SetRGBA(x, y, color.RGBA{uint8(x * 255 / 640), uint8(y * 255 / 480), 0x80, 0xff})
+		}
+	}
+	buf := &bytes.Buffer{}
+	err := jpeg.Encode(buf, src, &jpeg.Options{Quality: 95}, &jpeg.Meta{Exif: testExif(6, 640, 480)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	full, _, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []image.Point{{60, 80}, {150, 150}, {480, 640}} {
+		want, err := Thumbnail(full, size.X, size.Y, Fill)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, format, err := DecodeThumbnail(bytes.NewReader(data), size.X, size.Y, Fill)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != "jpeg" || got.Image.Bounds() != want.Image.Bounds() {
+			t.Fatalf("%v: got %s %v, want jpeg %v", size, format, got.Image.Bounds(), want.Image.Bounds())
+		}
+		if d := averageDiff(got.Image, want.Image); d > 2 {
+			t.Errorf("%v: average difference %.1f from the full-size thumbnail", size, d)
+		}
+		if string(got.Exif) != string(want.Exif) {
+			t.Errorf("%v: got EXIF %x, want %x", size, got.Exif, want.Exif)
+		}
+		if o := Orientation(got.Exif); o != 1 {
+			t.Errorf("%v: orientation %d, want 1", size, o)
+		}
+	}
+
+	// Fit thumbnails are the size worked out from the full image, even when it is decoded at a smaller scale.
+	for _, size := range []image.Point{{100, 0}, {0, 30}, {45, 45}} {
+		want, err := Thumbnail(full, size.X, size.Y, Fit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, err := DecodeThumbnail(bytes.NewReader(data), size.X, size.Y, Fit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Image.Bounds() != want.Image.Bounds() {
+			t.Errorf("%v: got %v, want %v", size, got.Image.Bounds(), want.Image.Bounds())
+		}
+	}
+
+	f, err := os.Open("testdata/holden-3-noicc.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	full, _, err = Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Thumbnail(full, 100, 100, Fit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Seek(0, 0)
+	got, _, err := DecodeThumbnail(f, 100, 100, Fit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Image.Bounds() != want.Image.Bounds() {
+		t.Fatalf("holden: got %v, want %v", got.Image.Bounds(), want.Image.Bounds())
+	}
+	if d := averageDiff(got.Image, want.Image); d > 4 {
+		t.Errorf("holden: average difference %.1f from the full-size thumbnail", d)
+	}
+}
+
+// averageDiff returns the average difference between the 8-bit RGB values of m0 and m1, which have the same bounds.
+func averageDiff(m0, m1 image.Image) float64 {
+	b := m0.Bounds()
+	var sum float64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r0, g0, b0, _ := m0.At(x, y).RGBA()
+			r1, g1, b1, _ := m1.At(x, y).RGBA()
+			sum += float64(diff(uint8(r0>>8), uint8(r1>>8))) + float64(diff(uint8(g0>>8), uint8(g1>>8))) +
+				float64(diff(uint8(b0>>8), uint8(b1>>8)))
+		}
+	}
+	return sum / float64(3*b.Dx()*b.Dy())
+}
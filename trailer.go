@@ -0,0 +1,111 @@
+package img
+
+import (
+	"strconv"
+
+	"github.com/snapas/img/jpegfile"
+	"github.com/snapas/img/mpf"
+)
+
+// jpegLength returns the length of the JPEG image at the start of b, up to and including its EOI marker, or 0 if it
+// doesn't end within b.
+func jpegLength(b []byte) int {
+	f, err := jpegfile.Parse(b)
+	if err != nil {
+		return 0
+	}
+	return len(b) - len(f.Trailer)
+}
+
+// readTrailer copies the data that follows the primary image of the JPEG file in file, and any secondary images of i,
+// into i.Trailer, given the offset of the data of its MPF segment. Nothing is kept for truncated files.
+func readTrailer(i *Image, file []byte, mpfOffset int) {
+	end := jpegLength(file)
+	if end == 0 {
+		return
+	}
+	if i.MPF != nil {
+		header := mpfOffset + len(mpf.Header)
+		for _, e := range i.MPF.Entries[1:] {
+			start := header + int(e.Offset)
+			// Some cameras leave the EOI marker out of the size of secondary images.
+			n := jpegLength(file[start:])
+			if n == 0 {
+				n = int(e.Size)
+			}
+			if start+n > end {
+				end = start + n
+			}
+		}
+	}
+	if end < len(file) {
+		i.Trailer = append([]byte(nil), file[end:]...)
+	}
+}
+
+// isMP4 reports whether b starts with the ftyp box of an MP4 file.
+func isMP4(b []byte) bool {
+	return len(b) >= 8 && string(b[4:8]) == "ftyp"
+}
+
+// isMotionPhoto reports whether the XMP packet describes a motion photo, in either version of Google's format, which
+// Samsung uses too.
+func isMotionPhoto(xmp []byte) bool {
+	for _, name := range []string{"GCamera:MotionPhoto", "GCamera:MicroVideo"} {
+		if v := xmpProperty(xmp, name); len(v) == 1 && v[0] == "1" {
+			return true
+		}
+	}
+	return hasContainerItem(xmp, "MotionPhoto")
+}
+
+// motionPhotoLength returns the length of the motion photo video at the end of trailer, as described by the XMP
+// packet, or the length of trailer if it is an MP4 video of a different length. It returns 0 if trailer isn't a video.
+func motionPhotoLength(xmp, trailer []byte) int {
+	n := containerItemLength(xmp, "MotionPhoto")
+	if v := xmpProperty(xmp, "GCamera:MicroVideoOffset"); n == 0 && len(v) == 1 {
+		n, _ = strconv.Atoi(v[0])
+	}
+	if 0 < n && n <= len(trailer) && isMP4(trailer[len(trailer)-n:]) {
+		return n
+	}
+	if isMP4(trailer) {
+		return len(trailer)
+	}
+	return 0
+}
+
+// MotionPhoto returns the video of a motion photo, which is kept at the end of i.Trailer, or nil if i isn't a motion
+// photo.
+func (i Image) MotionPhoto() []byte {
+	if !isMotionPhoto(i.XMP) {
+		return nil
+	}
+	n := motionPhotoLength(i.XMP, i.Trailer)
+	if n == 0 {
+		return nil
+	}
+	return i.Trailer[len(i.Trailer)-n:]
+}
+
+// setTrailerXMP returns a copy of the XMP packet of an image that is followed by trailer, with the motion photo
+// properties updated to locate the video at its end, or turned off if there is no video.
+func setTrailerXMP(xmp, trailer []byte) []byte {
+	if !isMotionPhoto(xmp) {
+		return xmp
+	}
+	n := motionPhotoLength(xmp, trailer)
+	if n == 0 {
+		xmp = setXMPProperties(xmp, map[string]string{
+			"GCamera:MicroVideo":       "0",
+			"GCamera:MotionPhoto":      "0",
+			"GCamera:MicroVideoOffset": "0",
+		})
+		return removeContainerItem(xmp, "MotionPhoto")
+	}
+	xmp = setXMPProperties(xmp, map[string]string{
+		"GCamera:MicroVideoOffset": strconv.Itoa(n),
+	})
+	xmp, _ = setContainerItemLength(xmp, "MotionPhoto", n)
+	return xmp
+}
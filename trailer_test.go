@@ -0,0 +1,171 @@
+package img
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestJPEGLength(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		b    string
+		want int
+	}{
+		{"empty", "", 0},
+		{"not a JPEG", "\x89PNG", 0},
+		{"no scan", "\xff\xd8\xff\xe0\x00\x04ab\xff\xd9", 10},
+		{"fill bytes", "\xff\xd8\xff\xff\xff\xd9trailer", 6},
+		{"entropy data", "\xff\xd8\xff\xda\x00\x02\x12\xff\x00\x34\xff\xd0\x56\xff\xd9\xff\xd9", 15},
+		{"second scan", "\xff\xd8\xff\xda\x00\x02\x12\xff\xc4\x00\x02\xff\xda\x00\x02\x34\xff\xd9", 18},
+		{"truncated scan", "\xff\xd8\xff\xda\x00\x02\x12\xff\x00", 0},
+		{"truncated segment", "\xff\xd8\xff\xe1\x00\x10ab", 0},
+	} {
+		if got := jpegLength([]byte(c.b)); got != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+// testVideo is the start of an MP4 file.
+var testVideo = []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom\x00\x00\x00\x08free")
+
+// motionPhotoXMP describes a motion photo with a video of the given length in both versions of the format.
+const motionPhotoXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:GCamera="http://ns.google.com/photos/1.0/camera/"
+    xmlns:Container="http://ns.google.com/photos/1.0/container/"
+    xmlns:Item="http://ns.google.com/photos/1.0/container/item/"
+    GCamera:MicroVideo="1"
+    GCamera:MicroVideoOffset="%d"
+    GCamera:MotionPhoto="1">
+   <Container:Directory>
+    <rdf:Seq>
+     <rdf:li rdf:parseType="Resource">
+      <Container:Item Item:Semantic="Primary" Item:Mime="image/jpeg"/>
+     </rdf:li>
+     <rdf:li rdf:parseType="Resource">
+      <Container:Item Item:Semantic="MotionPhoto" Item:Mime="video/mp4" Item:Length="%[1]d"/>
+     </rdf:li>
+    </rdf:Seq>
+   </Container:Directory>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+// containerItems returns the semantics and lengths of the items in the container directory of the XMP packet.
+func containerItems(xmp []byte) []string {
+	var items []string
+	for _, tag := range xmpItemTag.FindAll(xmp, -1) {
+		s := regexp.MustCompile(`Item:Semantic="(\w+)"`).FindSubmatch(tag)
+		l := regexp.MustCompile(`Item:Length="(\d+)"`).FindSubmatch(tag)
+		if l == nil {
+			items = append(items, string(s[1]))
+		} else {
+			items = append(items, string(s[1])+":"+string(l[1]))
+		}
+	}
+	return items
+}
+
+func TestMotionPhoto(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 64, 48))
+	buf := &bytes.Buffer{}
+	err := Encode(buf, Image{Image: m, XMP: []byte(fmt.Sprintf(motionPhotoXMP, len(testVideo))), Trailer: testVideo}, nil)
+	if err != nil {
+		t.Fatal("Encode failed:", err)
+	}
+
+	i, _, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if !bytes.Equal(i.Trailer, testVideo) || !bytes.Equal(i.MotionPhoto(), testVideo) {
+		t.Fatalf("got trailer %q", i.Trailer)
+	}
+
+	// Adding a gain map puts it between the image and the video, in the file and in the directory.
+	if i, err = Resize(i, 32, 0, Box); err != nil {
+		t.Fatal(err)
+	}
+	i.GainMap = testGainMap(8, 6)
+	buf.Reset()
+	if err := Encode(buf, i, nil); err != nil {
+		t.Fatal("Encode failed:", err)
+	}
+	j, _, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if j.GainMap == nil || !bytes.Equal(j.MotionPhoto(), testVideo) {
+		t.Fatal("gain map or video was lost")
+	}
+	items := containerItems(j.XMP)
+	gm := regexp.MustCompile(`GainMap:(\d+)`).FindStringSubmatch(fmt.Sprint(items))
+	if len(items) != 3 || items[0] != "Primary" || gm == nil || items[2] != "MotionPhoto:"+strconv.Itoa(len(testVideo)) {
+		t.Fatalf("got container items %q", items)
+	}
+	if xmpProperty(j.XMP, "hdrgm:Version") == nil {
+		t.Error("primary image has no gain map version")
+	}
+	l, _ := strconv.Atoi(gm[1])
+	if jpegLength(buf.Bytes())+l+len(testVideo) != buf.Len() {
+		t.Errorf("directory lengths don't add up to the %d-byte file", buf.Len())
+	}
+
+	// Stripping the trailer turns the motion photo off.
+	j.Trailer = nil
+	buf.Reset()
+	if err := Encode(buf, j, nil); err != nil {
+		t.Fatal("Encode failed:", err)
+	}
+	k, _, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if k.Trailer != nil || k.MotionPhoto() != nil {
+		t.Errorf("got trailer %q after stripping it", k.Trailer)
+	}
+	if v := xmpProperty(k.XMP, "GCamera:MicroVideo"); len(v) != 1 || v[0] != "0" {
+		t.Errorf("GCamera:MicroVideo is %q", v)
+	}
+	if items := containerItems(k.XMP); len(items) != 2 || items[1][:8] != "GainMap:" {
+		t.Errorf("got container items %q", items)
+	}
+}
+
+func TestTrailer(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/gopro.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, _, err := Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if i.Trailer != nil || i.MotionPhoto() != nil {
+		t.Fatalf("got %d-byte trailer after MPF images", len(i.Trailer))
+	}
+
+	// Unknown trailers are kept as they are.
+	trailer := []byte("\x00\x00SEFH")
+	i, _, err = Decode(bytes.NewReader(append(b, trailer...)))
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if !bytes.Equal(i.Trailer, trailer) || i.MotionPhoto() != nil {
+		t.Fatalf("got trailer %q", i.Trailer)
+	}
+	buf := &bytes.Buffer{}
+	if err := Encode(buf, i, nil); err != nil {
+		t.Fatal("Encode failed:", err)
+	}
+	if !bytes.HasSuffix(buf.Bytes(), trailer) {
+		t.Error("trailer was lost")
+	}
+}
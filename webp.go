@@ -0,0 +1,43 @@
+package img
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/snapas/img/iccjpeg"
+	"github.com/snapas/img/webp"
+)
+
+// isWebP reports whether b starts with the header of a WebP file: a RIFF header with the WEBP form type.
+func isWebP(b []byte) bool {
+	return len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WEBP"
+}
+
+// readWebPMetadata reads the ICC profile, EXIF and XMP chunks of the WebP file in b into i, storing the profile in
+// i.App2 as it would be stored in a JPEG. Like with PNGs, profiles too large for an APP2 segment aren't kept. Files
+// whose chunks can't be read are left for the decoder to reject.
+func readWebPMetadata(i *Image, b []byte) {
+	m, err := webp.ReadMeta(bytes.NewReader(b))
+	if err != nil {
+		return
+	}
+	if m.ICCProfile != nil {
+		if app2 := iccjpeg.App2Data(m.ICCProfile); len(app2) <= maxApp2Len {
+			i.App2 = app2
+		}
+	}
+	i.Exif = m.Exif
+	i.XMP = m.XMP
+}
+
+// EncodeWebP writes the Image to w in lossless WebP format with the given options, including any ICC profile (APP2
+// data), EXIF and XMP metadata, which make it an extended WebP file. Like with EncodePNG, the JPEG-only secondary MPF
+// images, gain maps and trailers aren't written, nor are PNG chunks. Default options are used if o is nil.
+func EncodeWebP(w io.Writer, i Image, o *webp.Options) error {
+	meta := &webp.Meta{
+		ICCProfile: iccjpeg.ProfileData(i.App2),
+		Exif:       i.Exif,
+		XMP:        i.XMP,
+	}
+	return webp.Encode(w, i.Image, o, meta)
+}
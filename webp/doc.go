@@ -0,0 +1,14 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package webp implements a decoder for WEBP images, lossy (VP8) and lossless
+// (VP8L), with or without alpha, and a lossless encoder. ReadMeta reads the
+// ICC profile, EXIF and XMP metadata of extended (VP8X) files, which Decode
+// ignores, and Encode writes it. Animated images aren't supported.
+//
+// The decoder is derived from golang.org/x/image/webp.
+//
+// WEBP is defined at:
+// https://developers.google.com/speed/webp/docs/riff_container
+package webp
@@ -0,0 +1,115 @@
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"io"
+
+	"github.com/snapas/img/webp/internal/vp8l"
+)
+
+// Options are the encoding parameters.
+//
+// Exact keeps the color values of fully transparent pixels, which are
+// otherwise set to transparent black, as they can't be seen and compress
+// better that way.
+type Options struct {
+	Exact bool
+}
+
+// VP8X flags, specified in the extended file format section.
+const (
+	xmpFlag   = 1 << 2
+	exifFlag  = 1 << 3
+	alphaFlag = 1 << 4
+	iccFlag   = 1 << 5
+)
+
+// Encode writes the Image m to w in lossless WEBP format with the given
+// options. Default parameters are used if a nil *Options is passed.
+//
+// The metadata in meta, if any, is written to an extended (VP8X) file, with
+// the ICCP chunk before the image data and the EXIF and "XMP " chunks after
+// it. Otherwise, a simple file holding only the image data is written.
+func Encode(w io.Writer, m image.Image, o *Options, meta *Meta) error {
+	exact := o != nil && o.Exact
+	nrgba, ok := m.(*image.NRGBA)
+	if !ok || !exact {
+		b := m.Bounds()
+		nrgba = image.NewNRGBA(b)
+		draw.Draw(nrgba, b, m, b.Min, draw.Src)
+	}
+	if !exact {
+		for p := 3; p < len(nrgba.Pix); p += 4 {
+			if nrgba.Pix[p] == 0 {
+				nrgba.Pix[p-3], nrgba.Pix[p-2], nrgba.Pix[p-1] = 0, 0, 0
+			}
+		}
+	}
+
+	data := &bytes.Buffer{}
+	if err := vp8l.Encode(data, nrgba); err != nil {
+		return err
+	}
+
+	if meta == nil || meta.ICCProfile == nil && meta.Exif == nil && meta.XMP == nil {
+		return writeRIFF(w, []chunk{{fccVP8L, data.Bytes()}})
+	}
+	b := nrgba.Bounds()
+	vp8x := make([]byte, 10)
+	putUint24(vp8x[4:], uint32(b.Dx()-1))
+	putUint24(vp8x[7:], uint32(b.Dy()-1))
+	if !nrgba.Opaque() {
+		vp8x[0] |= alphaFlag
+	}
+	chunks := []chunk{{fccVP8X, vp8x}}
+	if meta.ICCProfile != nil {
+		vp8x[0] |= iccFlag
+		chunks = append(chunks, chunk{fccICCP, meta.ICCProfile})
+	}
+	chunks = append(chunks, chunk{fccVP8L, data.Bytes()})
+	if meta.Exif != nil {
+		vp8x[0] |= exifFlag
+		chunks = append(chunks, chunk{fccEXIF, meta.Exif})
+	}
+	if meta.XMP != nil {
+		vp8x[0] |= xmpFlag
+		chunks = append(chunks, chunk{fccXMP, meta.XMP})
+	}
+	return writeRIFF(w, chunks)
+}
+
+// chunk is a RIFF chunk with its FourCC and data.
+type chunk struct {
+	id   [4]byte
+	data []byte
+}
+
+// writeRIFF writes a RIFF file of the WEBP form type holding chunks to w. Each
+// chunk is padded to an even length.
+func writeRIFF(w io.Writer, chunks []chunk) error {
+	size := 4
+	for _, c := range chunks {
+		size += 8 + len(c.data) + len(c.data)&1
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, 8+size))
+	buf.WriteString("RIFF")
+	binary.Write(buf, binary.LittleEndian, uint32(size))
+	buf.Write(fccWEBP[:])
+	for _, c := range chunks {
+		buf.Write(c.id[:])
+		binary.Write(buf, binary.LittleEndian, uint32(len(c.data)))
+		buf.Write(c.data)
+		if len(c.data)&1 == 1 {
+			buf.WriteByte(0)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
@@ -0,0 +1,95 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	f, err := os.Open("testdata/tux.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := image.NewNRGBA(m.Bounds())
+	for y := 0; y < m.Bounds().Dy(); y++ {
+		for x := 0; x < m.Bounds().Dx(); x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				c = color.NRGBA{}
+			}
+			want.SetNRGBA(x, y, c)
+		}
+	}
+
+	for _, c := range []struct {
+		desc     string
+		meta     *Meta
+		extended bool
+	}{
+		{"no metadata", nil, false},
+		{"empty metadata", &Meta{}, false},
+		{"ICC profile", &Meta{ICCProfile: []byte("profile")}, true},
+		{"all metadata", &Meta{[]byte("profile"), []byte("MM\x00*\x00\x00\x00\x08"), []byte("<x:xmpmeta/>")}, true},
+	} {
+		buf := &bytes.Buffer{}
+		if err := Encode(buf, m, nil, c.meta); err != nil {
+			t.Fatalf("%s: Encode: %v", c.desc, err)
+		}
+		b := buf.Bytes()
+		if extended := string(b[12:16]) == "VP8X"; extended != c.extended {
+			t.Errorf("%s: got chunk %q", c.desc, b[12:16])
+		}
+		got, err := Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", c.desc, err)
+		}
+		if !bytes.Equal(got.(*image.NRGBA).Pix, want.Pix) {
+			t.Errorf("%s: decoded image differs", c.desc)
+		}
+		meta, err := ReadMeta(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s: ReadMeta: %v", c.desc, err)
+		}
+		if c.meta == nil {
+			c.meta = &Meta{}
+		}
+		if !bytes.Equal(meta.ICCProfile, c.meta.ICCProfile) || !bytes.Equal(meta.Exif, c.meta.Exif) ||
+			!bytes.Equal(meta.XMP, c.meta.XMP) {
+			t.Errorf("%s: got metadata %q, want %q", c.desc, *meta, *c.meta)
+		}
+	}
+}
+
+func TestEncodeExact(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	m.SetNRGBA(1, 1, color.NRGBA{10, 20, 30, 0})
+	for _, exact := range []bool{false, true} {
+		buf := &bytes.Buffer{}
+		if err := Encode(buf, m, &Options{Exact: exact}, nil); err != nil {
+			t.Fatal(err)
+		}
+		got, err := Decode(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := color.NRGBA{}
+		if exact {
+			want = m.NRGBAAt(1, 1)
+		}
+		if c := got.(*image.NRGBA).NRGBAAt(1, 1); c != want {
+			t.Errorf("exact %v: got %v, want %v", exact, c, want)
+		}
+	}
+	if c := m.NRGBAAt(1, 1); c.R != 10 {
+		t.Error("Encode changed the image")
+	}
+}
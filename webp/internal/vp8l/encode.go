@@ -0,0 +1,314 @@
+package vp8l
+
+import (
+	"errors"
+	"image"
+	"io"
+	"math"
+)
+
+// maxDimension is the largest width or height of a VP8L image.
+const maxDimension = 1 << 14
+
+// Encode writes the image m to w as a VP8L bit-stream. The pixels go through
+// the subtract-green, predictor and cross-color transforms, in that order, and
+// are then compressed with LZ77 backward references, a color cache and
+// Huffman codes.
+func Encode(w io.Writer, m *image.NRGBA) error {
+	b := m.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > maxDimension || height > maxDimension {
+		return errors.New("vp8l: invalid image size")
+	}
+	pix := make([]byte, 4*width*height)
+	hasAlpha := uint32(0)
+	for y := 0; y < height; y++ {
+		row := m.Pix[m.PixOffset(b.Min.X, b.Min.Y+y):]
+		copy(pix[4*width*y:4*width*(y+1)], row)
+	}
+	for p := 3; p < len(pix); p += 4 {
+		if pix[p] != 0xff {
+			hasAlpha = 1
+			break
+		}
+	}
+
+	e := &bitWriter{}
+	e.write(0x2f, 8)
+	e.write(uint32(width-1), 14)
+	e.write(uint32(height-1), 14)
+	e.write(hasAlpha, 1)
+	e.write(0, 3)
+
+	e.write(1, 1)
+	e.write(transformTypeSubtractGreen, 2)
+	subtractGreen(pix)
+
+	e.write(1, 1)
+	e.write(transformTypePredictor, 2)
+	e.write(predictorBits-2, 3)
+	pix, tiles := applyPredictor(pix, width, height)
+	encodeImage(e, tiles, int(nTiles(int32(width), predictorBits)), false)
+
+	e.write(1, 1)
+	e.write(transformTypeCrossColor, 2)
+	e.write(crossColorBits-2, 3)
+	tiles = applyCrossColor(pix, width, height)
+	encodeImage(e, tiles, int(nTiles(int32(width), crossColorBits)), false)
+
+	e.write(0, 1)
+	argb := make([]uint32, width*height)
+	for i := range argb {
+		p := 4 * i
+		argb[i] = uint32(pix[p+3])<<24 | uint32(pix[p+0])<<16 | uint32(pix[p+1])<<8 | uint32(pix[p+2])
+	}
+	encodeImage(e, argb, width, true)
+
+	_, err := w.Write(e.bytes())
+	return err
+}
+
+const (
+	tokenLiteral = iota
+	tokenCache
+	tokenCopy
+)
+
+// token is a coded pixel or run of pixels: a literal ARGB value, a color
+// cache index or a backward reference with its length and distance code.
+type token struct {
+	kind   uint8
+	value  uint32
+	length uint32
+}
+
+// maxCacheBits is the log-2 size of the largest color cache.
+const maxCacheBits = 10
+
+// encodeImage writes the ARGB pixels of an image of width w as the decoder's
+// decodePix reads them, with the color cache size that compresses them best.
+// The top-level image, unlike those of transforms, may have several groups of
+// Huffman codes, but one is enough here.
+func encodeImage(e *bitWriter, argb []uint32, w int, topLevel bool) {
+	refs := backwardRefs(argb, w)
+	var (
+		tokens    []token
+		histogram [nHuff][]uint32
+		cacheBits uint32
+		bestCost  = math.Inf(1)
+	)
+	for bits := uint32(0); bits <= maxCacheBits; bits++ {
+		t := useCache(argb, refs, bits)
+		h := histograms(t, bits)
+		if cost := entropy(h); cost < bestCost {
+			tokens, histogram, cacheBits, bestCost = t, h, bits, cost
+		}
+	}
+
+	if cacheBits > 0 {
+		e.write(1, 1)
+		e.write(cacheBits, 4)
+	} else {
+		e.write(0, 1)
+	}
+	if topLevel {
+		e.write(0, 1)
+	}
+	var codes [nHuff]*huffmanCode
+	for i := range codes {
+		codes[i] = writeHuffmanCode(e, histogram[i])
+	}
+	for _, t := range tokens {
+		switch t.kind {
+		case tokenLiteral:
+			codes[huffGreen].writeSymbol(e, t.value>>8&0xff)
+			codes[huffRed].writeSymbol(e, t.value>>16&0xff)
+			codes[huffBlue].writeSymbol(e, t.value&0xff)
+			codes[huffAlpha].writeSymbol(e, t.value>>24)
+		case tokenCache:
+			codes[huffGreen].writeSymbol(e, nLiteralCodes+nLengthCodes+t.value)
+		case tokenCopy:
+			symbol, n, extra := prefixEncode(t.length)
+			codes[huffGreen].writeSymbol(e, nLiteralCodes+symbol)
+			e.write(extra, n)
+			symbol, n, extra = prefixEncode(t.value)
+			codes[huffDistance].writeSymbol(e, symbol)
+			e.write(extra, n)
+		}
+	}
+}
+
+// prefixEncode returns the prefix code symbol of an LZ77 length or distance
+// code, with the number and value of its extra bits, the inverse of
+// decoder.lz77Param.
+func prefixEncode(v uint32) (symbol, n, extra uint32) {
+	v--
+	if v < 4 {
+		return v, 0, 0
+	}
+	high := uint32(31)
+	for v>>high == 0 {
+		high--
+	}
+	n = high - 1
+	return 2*high + (v>>n)&1, n, v & (1<<n - 1)
+}
+
+// histograms returns the counts of the symbols of each Huffman code in tokens.
+func histograms(tokens []token, cacheBits uint32) (h [nHuff][]uint32) {
+	for i, n := range alphabetSizes {
+		if i == huffGreen && cacheBits > 0 {
+			n += 1 << cacheBits
+		}
+		h[i] = make([]uint32, n)
+	}
+	for _, t := range tokens {
+		switch t.kind {
+		case tokenLiteral:
+			h[huffGreen][t.value>>8&0xff]++
+			h[huffRed][t.value>>16&0xff]++
+			h[huffBlue][t.value&0xff]++
+			h[huffAlpha][t.value>>24]++
+		case tokenCache:
+			h[huffGreen][nLiteralCodes+nLengthCodes+t.value]++
+		case tokenCopy:
+			symbol, _, _ := prefixEncode(t.length)
+			h[huffGreen][nLiteralCodes+symbol]++
+			symbol, _, _ = prefixEncode(t.value)
+			h[huffDistance][symbol]++
+		}
+	}
+	return h
+}
+
+// entropy estimates the number of bits taken by the symbols counted in h,
+// leaving out the extra bits of backward references, which don't depend on
+// the color cache.
+func entropy(h [nHuff][]uint32) float64 {
+	bits := 0.0
+	for _, counts := range h {
+		total := 0.0
+		for _, n := range counts {
+			total += float64(n)
+		}
+		for _, n := range counts {
+			if n > 0 {
+				bits += float64(n) * math.Log2(total/float64(n))
+			}
+		}
+	}
+	return bits
+}
+
+// useCache returns refs with the literals that are found in a color cache of
+// the given log-2 size replaced by cache indexes, or refs itself if bits is 0.
+// Like the decoder, the cache holds every pixel, whether literal or copied.
+func useCache(argb []uint32, refs []token, bits uint32) []token {
+	if bits == 0 {
+		return refs
+	}
+	tokens := make([]token, len(refs))
+	cache := make([]uint32, 1<<bits)
+	shift := 32 - bits
+	p := 0
+	for i, t := range refs {
+		tokens[i] = t
+		if t.kind == tokenLiteral {
+			key := (t.value * colorCacheMultiplier) >> shift
+			if cache[key] == t.value {
+				tokens[i] = token{kind: tokenCache, value: key}
+			}
+			cache[key] = t.value
+			p++
+			continue
+		}
+		for _, v := range argb[p : p+int(t.length)] {
+			cache[(v*colorCacheMultiplier)>>shift] = v
+		}
+		p += int(t.length)
+	}
+	return tokens
+}
+
+const (
+	hashBits       = 16
+	maxChainLength = 32
+	minCopyLength  = 3
+	maxCopyLength  = 4096
+	// maxCopyDistance keeps distance codes within the 40 distance symbols.
+	maxCopyDistance = 1<<20 - len(distanceMapTable)
+)
+
+// backwardRefs returns the pixels of an image of width w as literals and
+// LZ77 backward references. Each pixel is matched greedily against the
+// pixels to its left and above, which have short distance codes, and then
+// against earlier pixels found through a hash chain of pixel pairs.
+func backwardRefs(argb []uint32, w int) []token {
+	// planeCodes maps the distances of nearby pixels to the short codes of
+	// their offsets, the inverse of distanceMap.
+	planeCodes := make(map[int]uint32)
+	for code := len(distanceMapTable); code >= 1; code-- {
+		distCode := int(distanceMapTable[code-1])
+		if d := (distCode>>4)*w + 8 - distCode&0xf; d >= 1 {
+			planeCodes[d] = uint32(code)
+		}
+	}
+
+	head := make([]int32, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	chain := make([]int32, len(argb))
+	hash := func(p int) uint32 {
+		return (argb[p]*0x9e3779b1 ^ argb[p+1]*colorCacheMultiplier) >> (32 - hashBits)
+	}
+	insert := func(p int) {
+		if p+1 < len(argb) {
+			h := hash(p)
+			chain[p], head[h] = head[h], int32(p)
+		}
+	}
+
+	var tokens []token
+	for p := 0; p < len(argb); {
+		bestLength, bestDistance := 0, 0
+		try := func(q int) {
+			if q < 0 || q >= p || p-q > maxCopyDistance {
+				return
+			}
+			if p+bestLength >= len(argb) || argb[q+bestLength] != argb[p+bestLength] {
+				return
+			}
+			n := 0
+			for p+n < len(argb) && n < maxCopyLength && argb[q+n] == argb[p+n] {
+				n++
+			}
+			if n > bestLength {
+				bestLength, bestDistance = n, p-q
+			}
+		}
+		if p+1 < len(argb) {
+			try(p - 1)
+			try(p - w)
+			for q, k := head[hash(p)], 0; q >= 0 && k < maxChainLength && p-int(q) <= maxCopyDistance; q, k = chain[q], k+1 {
+				try(int(q))
+			}
+		}
+
+		if bestLength < minCopyLength {
+			tokens = append(tokens, token{kind: tokenLiteral, value: argb[p]})
+			insert(p)
+			p++
+			continue
+		}
+		code, ok := planeCodes[bestDistance]
+		if !ok {
+			code = uint32(bestDistance + len(distanceMapTable))
+		}
+		tokens = append(tokens, token{kind: tokenCopy, value: code, length: uint32(bestLength)})
+		for end := p + bestLength; p < end; p++ {
+			insert(p)
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,306 @@
+package vp8l
+
+import "sort"
+
+// This file builds and writes the Huffman codes of the encoder, specified in
+// section 5.2.2.
+
+// bitWriter accumulates a bit-stream, least significant bits first.
+type bitWriter struct {
+	buf   []byte
+	bits  uint64
+	nBits uint32
+}
+
+// write writes the low n bits of v, where n is at most 32.
+func (b *bitWriter) write(v uint32, n uint32) {
+	b.bits |= uint64(v&uint32(1<<n-1)) << b.nBits
+	b.nBits += n
+	for b.nBits >= 8 {
+		b.buf = append(b.buf, byte(b.bits))
+		b.bits >>= 8
+		b.nBits -= 8
+	}
+}
+
+// bytes returns the bit-stream, padded with zeroes to a whole byte.
+func (b *bitWriter) bytes() []byte {
+	if b.nBits > 0 {
+		b.buf = append(b.buf, byte(b.bits))
+		b.bits, b.nBits = 0, 0
+	}
+	return b.buf
+}
+
+const (
+	maxCodeLength           = 15
+	maxCodeLengthCodeLength = 7
+)
+
+// huffmanCode holds the code of each symbol of an alphabet.
+type huffmanCode struct {
+	// lengths are the code lengths of the symbols, and codes are their
+	// canonical codes with the bits reversed, as they are written to the
+	// least significant bits first bit-stream.
+	lengths []uint32
+	codes   []uint32
+	// single is set for codes of a single symbol, which take no bits.
+	single bool
+}
+
+// writeSymbol writes the code of symbol to b.
+func (h *huffmanCode) writeSymbol(b *bitWriter, symbol uint32) {
+	if !h.single {
+		b.write(h.codes[symbol], h.lengths[symbol])
+	}
+}
+
+// writeHuffmanCode writes a code for the symbol counts in histogram to b, and
+// returns it. A simple code is written for up to two symbols below 256, and a
+// normal code, whose code lengths are themselves Huffman coded, otherwise.
+func writeHuffmanCode(b *bitWriter, histogram []uint32) *huffmanCode {
+	var used []uint32
+	for symbol, n := range histogram {
+		if n > 0 {
+			used = append(used, uint32(symbol))
+		}
+	}
+	if len(used) == 0 {
+		// The decoder needs at least one symbol, even if it is never read.
+		used = []uint32{0}
+	}
+	h := &huffmanCode{
+		lengths: make([]uint32, len(histogram)),
+		codes:   make([]uint32, len(histogram)),
+		single:  len(used) == 1,
+	}
+
+	if len(used) <= 2 && used[len(used)-1] < 256 {
+		b.write(1, 1)
+		b.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			b.write(0, 1)
+			b.write(used[0], 1)
+		} else {
+			b.write(1, 1)
+			b.write(used[0], 8)
+		}
+		if len(used) == 2 {
+			b.write(used[1], 8)
+		}
+		for i, symbol := range used {
+			h.lengths[symbol] = uint32(len(used) - 1)
+			h.codes[symbol] = uint32(i)
+		}
+		return h
+	}
+
+	if h.single {
+		h.lengths[used[0]] = 1
+	} else {
+		h.lengths = codeLengths(histogram, maxCodeLength)
+		h.codes = canonicalCodes(h.lengths)
+	}
+	b.write(0, 1)
+	writeCodeLengths(b, h.lengths)
+	return h
+}
+
+// codeLengthToken is a symbol of the code length code with the value of its
+// extra bits, which repeat code lengths for symbols 16 to 18.
+type codeLengthToken struct {
+	symbol, extra uint32
+}
+
+// writeCodeLengths writes the code lengths of a normal code to b, run-length
+// encoded with the code length code.
+func writeCodeLengths(b *bitWriter, lengths []uint32) {
+	tokens := runLengths(lengths)
+	histogram := make([]uint32, len(codeLengthCodeOrder))
+	for _, t := range tokens {
+		histogram[t.symbol]++
+	}
+	h := &huffmanCode{
+		lengths: codeLengths(histogram, maxCodeLengthCodeLength),
+	}
+	h.codes = canonicalCodes(h.lengths)
+	used := []int(nil)
+	for symbol, n := range histogram {
+		if n > 0 {
+			used = append(used, symbol)
+		}
+	}
+	if len(used) == 1 {
+		h.single = true
+		h.lengths[used[0]] = 1
+	}
+
+	nCodes := len(codeLengthCodeOrder)
+	for nCodes > 4 && h.lengths[codeLengthCodeOrder[nCodes-1]] == 0 {
+		nCodes--
+	}
+	b.write(uint32(nCodes-4), 4)
+	for _, symbol := range codeLengthCodeOrder[:nCodes] {
+		b.write(h.lengths[symbol], 3)
+	}
+	// All the symbols have a code length, rather than a maximum symbol.
+	b.write(0, 1)
+	for _, t := range tokens {
+		h.writeSymbol(b, t.symbol)
+		if t.symbol >= repeatsCodeLength {
+			b.write(t.extra, uint32(repeatBits[t.symbol-repeatsCodeLength]))
+		}
+	}
+}
+
+// runLengths returns the code length code symbols for lengths. Runs of zeroes
+// are written with symbols 17 and 18, and runs of other lengths with symbol
+// 16, which repeats the last non-zero length written.
+func runLengths(lengths []uint32) []codeLengthToken {
+	var tokens []codeLengthToken
+	// The decoder repeats a length of 8 for a symbol 16 before any non-zero
+	// length.
+	prev := uint32(8)
+	for i := 0; i < len(lengths); {
+		l, run := lengths[i], 1
+		for i+run < len(lengths) && lengths[i+run] == l {
+			run++
+		}
+		i += run
+
+		if l == 0 {
+			for run >= 3 {
+				n := run
+				if n > 138 {
+					n = 138
+				}
+				if n >= 11 {
+					tokens = append(tokens, codeLengthToken{18, uint32(n - 11)})
+				} else {
+					tokens = append(tokens, codeLengthToken{17, uint32(n - 3)})
+				}
+				run -= n
+			}
+		} else {
+			if l != prev {
+				tokens = append(tokens, codeLengthToken{l, 0})
+				prev = l
+				run--
+			}
+			for run >= 3 {
+				n := run
+				if n > 6 {
+					n = 6
+				}
+				tokens = append(tokens, codeLengthToken{16, uint32(n - 3)})
+				run -= n
+			}
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, codeLengthToken{l, 0})
+		}
+	}
+	return tokens
+}
+
+// codeLengths returns the lengths of a Huffman code for the symbol counts in
+// histogram, of at most maxLength bits. Codes that are too long are flattened
+// by raising the counts of the rarest symbols until they fit. A single symbol
+// gets a length of 0.
+func codeLengths(histogram []uint32, maxLength uint32) []uint32 {
+	lengths := make([]uint32, len(histogram))
+	for minCount := uint32(1); ; minCount *= 2 {
+		if buildCodeLengths(lengths, histogram, minCount) <= maxLength {
+			return lengths
+		}
+	}
+}
+
+// buildCodeLengths sets the lengths of a Huffman code for the symbol counts in
+// histogram, with each count raised to at least minCount, and returns the
+// longest length.
+func buildCodeLengths(lengths, histogram []uint32, minCount uint32) uint32 {
+	type node struct {
+		count       uint64
+		left, right int
+	}
+	var nodes []node
+	for symbol, n := range histogram {
+		lengths[symbol] = 0
+		if n > 0 {
+			if n < minCount {
+				n = minCount
+			}
+			nodes = append(nodes, node{uint64(n), -1, symbol})
+		}
+	}
+	if len(nodes) < 2 {
+		return 0
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].count < nodes[j].count })
+
+	// Merge the two rarest nodes until one is left, taking them from the front
+	// of the sorted leaves or of the internal nodes, which are made in order.
+	nLeaves := len(nodes)
+	leaf, internal := 0, nLeaves
+	pick := func() int {
+		if leaf < nLeaves && (internal == len(nodes) || nodes[leaf].count <= nodes[internal].count) {
+			leaf++
+			return leaf - 1
+		}
+		internal++
+		return internal - 1
+	}
+	for len(nodes) < 2*nLeaves-1 {
+		a, b := pick(), pick()
+		nodes = append(nodes, node{nodes[a].count + nodes[b].count, a, b})
+	}
+
+	// Walk down from the root, the last node.
+	depths := make([]uint32, len(nodes))
+	maxDepth := uint32(0)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		n := nodes[i]
+		if n.left < 0 {
+			lengths[n.right] = depths[i]
+			if maxDepth < depths[i] {
+				maxDepth = depths[i]
+			}
+			continue
+		}
+		depths[n.left] = depths[i] + 1
+		depths[n.right] = depths[i] + 1
+	}
+	return maxDepth
+}
+
+// canonicalCodes returns the canonical codes for lengths, with their bits
+// reversed.
+func canonicalCodes(lengths []uint32) []uint32 {
+	var count [maxCodeLength + 1]uint32
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [maxCodeLength + 1]uint32
+	code := uint32(0)
+	for l := 1; l <= maxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for symbol, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		// Reverse the l bits of c.
+		r := uint32(0)
+		for i := uint32(0); i < l; i++ {
+			r = r<<1 | (c>>i)&1
+		}
+		codes[symbol] = r
+	}
+	return codes
+}
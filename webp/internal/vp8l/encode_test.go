@@ -0,0 +1,116 @@
+package vp8l
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, c := range []struct {
+		desc string
+		w, h int
+		at   func(x, y int) color.NRGBA
+	}{
+		{"single pixel", 1, 1, func(x, y int) color.NRGBA { return color.NRGBA{10, 20, 30, 255} }},
+		{"column", 1, 37, func(x, y int) color.NRGBA { return color.NRGBA{uint8(y), 0, 0, 255} }},
+		{"flat", 40, 30, func(x, y int) color.NRGBA { return color.NRGBA{200, 100, 50, 255} }},
+		{"gradient", 67, 45, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(3 * x), uint8(x + y), uint8(5 * y), 255}
+		}},
+		{"alpha", 33, 17, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * y), uint8(x), 0x80, uint8(8 * x)}
+		}},
+		{"noise", 50, 50, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256))}
+		}},
+		{"few colors", 100, 80, func(x, y int) color.NRGBA {
+			palette := []color.NRGBA{{255, 0, 0, 255}, {0, 0, 255, 255}, {255, 255, 255, 255}}
+			return palette[(x/7+y/5)%3]
+		}},
+		{"repeats", 300, 20, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x % 13 * 19), uint8(x % 7 * 31), uint8(y % 3), 255}
+		}},
+	} {
+		m := image.NewNRGBA(image.Rect(0, 0, c.w, c.h))
+		for y := 0; y < c.h; y++ {
+			for x := 0; x < c.w; x++ {
+				m.SetNRGBA(x, y, c.at(x, y))
+			}
+		}
+		buf := &bytes.Buffer{}
+		if err := Encode(buf, m); err != nil {
+			t.Errorf("%s: Encode: %v", c.desc, err)
+			continue
+		}
+		got, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Errorf("%s: Decode: %v", c.desc, err)
+			continue
+		}
+		if got.Bounds() != m.Bounds() || !bytes.Equal(got.(*image.NRGBA).Pix, m.Pix) {
+			t.Errorf("%s: decoded image differs", c.desc)
+		}
+	}
+}
+
+func TestEncodeSubImage(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	for k := range m.Pix {
+		m.Pix[k] = uint8(k * 7)
+	}
+	sub := m.SubImage(image.Rect(3, 5, 14, 9)).(*image.NRGBA)
+	buf := &bytes.Buffer{}
+	if err := Encode(buf, sub); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 11; x++ {
+			if g, w := got.At(x, y), sub.At(x+3, y+5); g != w {
+				t.Fatalf("at (%d, %d): got %v, want %v", x, y, g, w)
+			}
+		}
+	}
+
+	if err := Encode(buf, image.NewNRGBA(image.Rect(0, 0, maxDimension+1, 1))); err == nil {
+		t.Error("encoded an image that is too wide")
+	}
+}
+
+func TestCodeLengths(t *testing.T) {
+	// Fibonacci counts make the deepest Huffman trees.
+	histogram := make([]uint32, 30)
+	a, b := uint32(1), uint32(1)
+	for i := range histogram {
+		histogram[i] = a
+		a, b = b, a+b
+	}
+	lengths := codeLengths(histogram, maxCodeLength)
+	kraft := 0.0
+	for _, l := range lengths {
+		if l == 0 || l > maxCodeLength {
+			t.Fatalf("got code lengths %v", lengths)
+		}
+		kraft += 1 / float64(uint32(1)<<l)
+	}
+	if kraft != 1 {
+		t.Errorf("code lengths %v aren't complete", lengths)
+	}
+}
+
+func TestPrefixEncode(t *testing.T) {
+	for v := uint32(1); v < 1<<20; v += 1 + v/64 {
+		symbol, n, extra := prefixEncode(v)
+		d := &decoder{r: bytes.NewReader([]byte{byte(extra), byte(extra >> 8), byte(extra >> 16)})}
+		if got, err := d.lz77Param(symbol); err != nil || got != v {
+			t.Fatalf("%d: got symbol %d with %d extra bits %d, decoded to %d, %v", v, symbol, n, extra, got, err)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package vp8l
+
+// This file applies the transforms of the encoder, the inverses of those in
+// transform.go. Like there, pixels are held as RGBA bytes.
+
+const (
+	// predictorBits and crossColorBits are the log-2 sizes of the tiles of
+	// the predictor and cross-color transforms.
+	predictorBits  = 4
+	crossColorBits = 5
+
+	nPredictorModes = 14
+)
+
+// residualCost approximates the cost in bits, times 16, of a residual, which
+// grows with its distance from zero.
+var residualCost [256]uint32
+
+func init() {
+	for v := range residualCost {
+		d := int32(int8(v))
+		if d < 0 {
+			d = -d
+		}
+		// 16 * log2(1+d), rounded down, from the integer part and the next
+		// four bits.
+		x := uint32(d + 1)
+		n := uint32(0)
+		for x>>n > 1 {
+			n++
+		}
+		frac := (x << 4 >> n) & 0x0f
+		residualCost[v] = 16*n + frac
+	}
+}
+
+// subtractGreen subtracts the green value of each pixel from its red and blue
+// values.
+func subtractGreen(pix []byte) {
+	for p := 0; p < len(pix); p += 4 {
+		green := pix[p+1]
+		pix[p+0] -= green
+		pix[p+2] -= green
+	}
+}
+
+// predict returns the prediction of the pixel at offset p from its neighbors,
+// whose row above starts at offset top, with the given mode.
+func predict(pix []byte, p, top int, mode byte) (pred [4]byte) {
+	if mode == 11 {
+		// Select(L, T, TL), which predicts L or T, whichever is closer to
+		// the gradient.
+		var l, t int32
+		for c := 0; c < 4; c++ {
+			l += abs(int32(pix[top-4+c]) - int32(pix[top+c]))
+			t += abs(int32(pix[top-4+c]) - int32(pix[p-4+c]))
+		}
+		if l < t {
+			copy(pred[:], pix[p-4:p])
+		} else {
+			copy(pred[:], pix[top:top+4])
+		}
+		return pred
+	}
+	for c := 0; c < 4; c++ {
+		l, t, tl, tr := pix[p-4+c], pix[top+c], pix[top-4+c], pix[top+4+c]
+		switch mode {
+		case 0:
+			if c == 3 {
+				pred[c] = 0xff
+			}
+		case 1:
+			pred[c] = l
+		case 2:
+			pred[c] = t
+		case 3:
+			pred[c] = tr
+		case 4:
+			pred[c] = tl
+		case 5:
+			pred[c] = avg2(avg2(l, tr), t)
+		case 6:
+			pred[c] = avg2(l, tl)
+		case 7:
+			pred[c] = avg2(l, t)
+		case 8:
+			pred[c] = avg2(tl, t)
+		case 9:
+			pred[c] = avg2(t, tr)
+		case 10:
+			pred[c] = avg2(avg2(l, tl), avg2(t, tr))
+		case 12:
+			pred[c] = clampAddSubtractFull(l, t, tl)
+		case 13:
+			pred[c] = clampAddSubtractHalf(avg2(l, t), tl)
+		}
+	}
+	return pred
+}
+
+// applyPredictor returns the residuals of the w×h pixels after prediction,
+// and the tile image that holds the predictor mode of each tile in its green
+// values. Each tile uses the mode whose residuals cost the least.
+func applyPredictor(pix []byte, w, h int) (residuals []byte, tiles []uint32) {
+	residuals = make([]byte, len(pix))
+	tilesPerRow := int(nTiles(int32(w), predictorBits))
+	tiles = make([]uint32, tilesPerRow*int(nTiles(int32(h), predictorBits)))
+	residual := func(p, top int, mode byte) {
+		pred := predict(pix, p, top, mode)
+		for c := 0; c < 4; c++ {
+			residuals[p+c] = pix[p+c] - pred[c]
+		}
+	}
+
+	// The first pixel is predicted as opaque black, the rest of the first
+	// row by L and the first column by T.
+	if len(pix) > 0 {
+		copy(residuals, pix[:4])
+		residuals[3] -= 0xff
+	}
+	for p := 4; p < 4*w; p++ {
+		residuals[p] = pix[p] - pix[p-4]
+	}
+	for y := 1; y < h; y++ {
+		for p := 4 * y * w; p < 4*y*w+4; p++ {
+			residuals[p] = pix[p] - pix[p-4*w]
+		}
+	}
+
+	tileSize := 1 << predictorBits
+	for ty := 0; ty*tileSize < h; ty++ {
+		for tx := 0; tx < tilesPerRow; tx++ {
+			x0, x1 := tx*tileSize, (tx+1)*tileSize
+			y0, y1 := ty*tileSize, (ty+1)*tileSize
+			if x0 == 0 {
+				x0 = 1
+			}
+			if y0 == 0 {
+				y0 = 1
+			}
+			if x1 > w {
+				x1 = w
+			}
+			if y1 > h {
+				y1 = h
+			}
+			bestMode, bestCost := byte(0), ^uint32(0)
+			for mode := byte(0); mode < nPredictorModes && x0 < x1 && y0 < y1; mode++ {
+				cost := uint32(0)
+				for y := y0; y < y1 && cost < bestCost; y++ {
+					for x := x0; x < x1; x++ {
+						p := 4 * (y*w + x)
+						pred := predict(pix, p, p-4*w, mode)
+						for c := 0; c < 4; c++ {
+							cost += residualCost[pix[p+c]-pred[c]]
+						}
+					}
+				}
+				if cost < bestCost {
+					bestMode, bestCost = mode, cost
+				}
+			}
+			tiles[ty*tilesPerRow+tx] = 0xff000000 | uint32(bestMode)<<8
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					p := 4 * (y*w + x)
+					residual(p, p-4*w, bestMode)
+				}
+			}
+		}
+	}
+	return residuals, tiles
+}
+
+// colorTransformDelta returns the change to a color value by another one,
+// given their multiplier in 3.5 fixed point.
+func colorTransformDelta(t, c int8) byte {
+	return byte(int32(t) * int32(c) >> 5)
+}
+
+// applyCrossColor decorrelates the red and blue values of the w×h pixels from
+// their green values, and the blue ones from the red ones, in place. It
+// returns the tile image of the multipliers, which are fitted to each tile by
+// least squares and then adjusted to cost the least.
+func applyCrossColor(pix []byte, w, h int) (tiles []uint32) {
+	tilesPerRow := int(nTiles(int32(w), crossColorBits))
+	tiles = make([]uint32, tilesPerRow*int(nTiles(int32(h), crossColorBits)))
+	tileSize := 1 << crossColorBits
+	for ty := 0; ty*tileSize < h; ty++ {
+		for tx := 0; tx < tilesPerRow; tx++ {
+			x0, y0 := tx*tileSize, ty*tileSize
+			x1, y1 := x0+tileSize, y0+tileSize
+			if x1 > w {
+				x1 = w
+			}
+			if y1 > h {
+				y1 = h
+			}
+			forEach := func(f func(red, green, blue int8)) {
+				for y := y0; y < y1; y++ {
+					for p := 4 * (y*w + x0); p < 4*(y*w+x1); p += 4 {
+						f(int8(pix[p+0]), int8(pix[p+1]), int8(pix[p+2]))
+					}
+				}
+			}
+
+			// Fit red ≈ greenToRed*green/32 and blue ≈ (greenToBlue*green +
+			// redToBlue*red)/32.
+			var gg, rr, gr, gb, rb float64
+			forEach(func(red, green, blue int8) {
+				r, g, b := float64(red), float64(green), float64(blue)
+				gg += g * g
+				rr += r * r
+				gr += g * r
+				gb += g * b
+				rb += r * b
+			})
+			greenToRed, greenToBlue, redToBlue := int8(0), int8(0), int8(0)
+			if gg > 0 {
+				greenToRed = fitMultiplier(32 * gr / gg)
+			}
+			if det := gg*rr - gr*gr; det > 0 {
+				greenToBlue = fitMultiplier(32 * (gb*rr - rb*gr) / det)
+				redToBlue = fitMultiplier(32 * (rb*gg - gb*gr) / det)
+			} else if gg > 0 {
+				greenToBlue = fitMultiplier(32 * gb / gg)
+			}
+
+			// Least squares is thrown off by outliers, so the fitted
+			// multipliers compete with their neighbors and with none at all.
+			redCost := func(gtr int8) uint32 {
+				cost := uint32(0)
+				forEach(func(red, green, blue int8) {
+					cost += residualCost[byte(red)-colorTransformDelta(gtr, green)]
+				})
+				return cost
+			}
+			blueCost := func(gtb, rtb int8) uint32 {
+				cost := uint32(0)
+				forEach(func(red, green, blue int8) {
+					cost += residualCost[byte(blue)-colorTransformDelta(gtb, green)-colorTransformDelta(rtb, red)]
+				})
+				return cost
+			}
+			bestGTR, bestCost := int8(0), redCost(0)
+			for _, gtr := range neighbors(greenToRed) {
+				if cost := redCost(gtr); cost < bestCost {
+					bestGTR, bestCost = gtr, cost
+				}
+			}
+			bestGTB, bestRTB, bestCost := int8(0), int8(0), blueCost(0, 0)
+			for _, gtb := range neighbors(greenToBlue) {
+				for _, rtb := range neighbors(redToBlue) {
+					if cost := blueCost(gtb, rtb); cost < bestCost {
+						bestGTB, bestRTB, bestCost = gtb, rtb, cost
+					}
+				}
+			}
+
+			tiles[ty*tilesPerRow+tx] = 0xff000000 | uint32(byte(bestRTB))<<16 | uint32(byte(bestGTB))<<8 |
+				uint32(byte(bestGTR))
+			for y := y0; y < y1; y++ {
+				for p := 4 * (y*w + x0); p < 4*(y*w+x1); p += 4 {
+					red, green := int8(pix[p+0]), int8(pix[p+1])
+					pix[p+0] -= colorTransformDelta(bestGTR, green)
+					pix[p+2] -= colorTransformDelta(bestGTB, green) + colorTransformDelta(bestRTB, red)
+				}
+			}
+		}
+	}
+	return tiles
+}
+
+// fitMultiplier rounds and clamps a fitted multiplier.
+func fitMultiplier(m float64) int8 {
+	switch {
+	case m <= -128:
+		return -128
+	case m >= 127:
+		return 127
+	case m < 0:
+		return int8(m - 0.5)
+	}
+	return int8(m + 0.5)
+}
+
+// neighbors returns m and the multipliers next to it.
+func neighbors(m int8) []int8 {
+	n := []int8{m}
+	if m > -128 {
+		n = append(n, m-1)
+	}
+	if m < 127 {
+		n = append(n, m+1)
+	}
+	return n
+}
@@ -0,0 +1,74 @@
+package webp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"github.com/snapas/img/webp/internal/riff"
+)
+
+var (
+	fccICCP = riff.FourCC{'I', 'C', 'C', 'P'}
+	fccEXIF = riff.FourCC{'E', 'X', 'I', 'F'}
+	fccXMP  = riff.FourCC{'X', 'M', 'P', ' '}
+)
+
+// exifHeader is the header of EXIF data in JPEG APP1 segments, which some
+// software also writes at the start of EXIF chunks.
+const exifHeader = "Exif\x00\x00"
+
+// Meta is the metadata of a WEBP image. Fields are nil for metadata that the
+// image doesn't have.
+type Meta struct {
+	// ICCProfile is the ICC profile of the ICCP chunk.
+	ICCProfile []byte
+	// Exif is the TIFF structured EXIF data of the EXIF chunk.
+	Exif []byte
+	// XMP is the XMP packet of the "XMP " chunk.
+	XMP []byte
+}
+
+// ReadMeta reads the metadata chunks of the WEBP image in r, without decoding
+// it. Only extended (VP8X) files have metadata; the EXIF and XMP chunks follow
+// the image data, so all of r is read.
+func ReadMeta(r io.Reader) (*Meta, error) {
+	formType, riffReader, err := riff.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if formType != fccWEBP {
+		return nil, errInvalidFormat
+	}
+	m := &Meta{}
+	for {
+		chunkID, _, chunkData, err := riffReader.Next()
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var field *[]byte
+		switch chunkID {
+		case fccICCP:
+			field = &m.ICCProfile
+		case fccEXIF:
+			field = &m.Exif
+		case fccXMP:
+			field = &m.XMP
+		default:
+			continue
+		}
+		data, err := ioutil.ReadAll(chunkData)
+		if err != nil {
+			return nil, err
+		}
+		if chunkID == fccEXIF {
+			data = bytes.TrimPrefix(data, []byte(exifHeader))
+		}
+		if *field == nil {
+			*field = data
+		}
+	}
+}
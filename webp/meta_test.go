@@ -0,0 +1,63 @@
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+// riffFile returns a WEBP file holding the given chunks, each a FourCC followed by its data.
+func riffFile(chunks ...string) []byte {
+	body := &bytes.Buffer{}
+	body.WriteString("WEBP")
+	for _, c := range chunks {
+		body.WriteString(c[:4])
+		binary.Write(body, binary.LittleEndian, uint32(len(c)-4))
+		body.WriteString(c[4:])
+		if len(c)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+	b := []byte("RIFF\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint32(b[4:], uint32(body.Len()))
+	return append(b, body.Bytes()...)
+}
+
+func TestReadMeta(t *testing.T) {
+	lossless, err := ioutil.ReadFile("testdata/gopher-doc.1bpp.lossless.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vp8l := "VP8L" + string(lossless[20:20+binary.LittleEndian.Uint32(lossless[16:])])
+	vp8x := "VP8X\x2c\x00\x00\x00\x00\x00\x00\x00\x00\x00"
+
+	for _, c := range []struct {
+		desc string
+		file []byte
+		want Meta
+	}{
+		{"simple", lossless, Meta{}},
+		{"extended", riffFile(vp8x, "ICCPprofile", vp8l, "EXIFMM\x00*", "XMP <x:xmpmeta/>"),
+			Meta{[]byte("profile"), []byte("MM\x00*"), []byte("<x:xmpmeta/>")}},
+		{"EXIF with header", riffFile(vp8x, vp8l, "EXIFExif\x00\x00II*\x00"), Meta{Exif: []byte("II*\x00")}},
+		{"unknown chunks", riffFile(vp8x, "ICCPone", "ABCDxyz", vp8l, "ICCPtwo"), Meta{ICCProfile: []byte("one")}},
+	} {
+		m, err := ReadMeta(bytes.NewReader(c.file))
+		if err != nil {
+			t.Errorf("%s: %v", c.desc, err)
+			continue
+		}
+		if !bytes.Equal(m.ICCProfile, c.want.ICCProfile) || !bytes.Equal(m.Exif, c.want.Exif) ||
+			!bytes.Equal(m.XMP, c.want.XMP) {
+			t.Errorf("%s: got %q, want %q", c.desc, *m, c.want)
+		}
+		if _, err := Decode(bytes.NewReader(c.file)); err != nil {
+			t.Errorf("%s: Decode: %v", c.desc, err)
+		}
+	}
+
+	if _, err := ReadMeta(bytes.NewReader(riffFile(vp8x, "ICCPprofile")[:20])); err == nil {
+		t.Error("read the metadata of a truncated file")
+	}
+}
@@ -0,0 +1,120 @@
+package img
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"testing"
+
+	"github.com/snapas/img/icc"
+	"github.com/snapas/img/iccjpeg"
+	"github.com/snapas/img/webp"
+)
+
+func TestWebP(t *testing.T) {
+	lossless, err := ioutil.ReadFile("webp/testdata/gopher-doc.8bpp.lossless.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := webp.DecodeConfig(bytes.NewReader(lossless))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exif := testExif(6, cfg.Width, cfg.Height)
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`)
+
+	// An extended file with an ICC profile, EXIF and XMP around the lossless image data.
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x20 | 0x08 | 0x04
+	vp8x[4], vp8x[5] = byte(cfg.Width-1), byte((cfg.Width-1)>>8)
+	vp8x[7], vp8x[8] = byte(cfg.Height-1), byte((cfg.Height-1)>>8)
+	body := &bytes.Buffer{}
+	body.WriteString("WEBP")
+	for _, c := range []struct {
+		fourCC string
+		data   []byte
+	}{
+		{"VP8X", vp8x},
+		{"ICCP", icc.DisplayP3Data},
+		{"VP8L", lossless[20 : 20+binary.LittleEndian.Uint32(lossless[16:])]},
+		{"EXIF", exif},
+		{"XMP ", xmp},
+	} {
+		body.WriteString(c.fourCC)
+		binary.Write(body, binary.LittleEndian, uint32(len(c.data)))
+		body.Write(c.data)
+		if len(c.data)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+	file := []byte("RIFF\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint32(file[4:], uint32(body.Len()))
+	file = append(file, body.Bytes()...)
+
+	i, format, err := Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if format != "webp" || i.Image.Bounds() != image.Rect(0, 0, cfg.Height, cfg.Width) {
+		t.Fatalf("got %s image %v", format, i.Image.Bounds())
+	}
+	if o := Orientation(i.Exif); o != 1 {
+		t.Errorf("orientation is %d", o)
+	}
+	if w, _ := exifTag(i.Exif, tagPixelXDimension); int(w) != cfg.Height {
+		t.Errorf("EXIF width is %d", w)
+	}
+	if !bytes.Equal(iccjpeg.ProfileData(i.App2), icc.DisplayP3Data) {
+		t.Error("ICC profile was lost")
+	}
+	if !bytes.HasPrefix(i.XMP, []byte("<x:xmpmeta")) {
+		t.Errorf("got XMP %q", i.XMP)
+	}
+
+	// Simple files have no metadata.
+	i, _, err = Decode(bytes.NewReader(lossless))
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if i.Image.Bounds() != image.Rect(0, 0, cfg.Width, cfg.Height) || i.App2 != nil || i.XMP != nil {
+		t.Errorf("got image %v with ICC profile %v and XMP %q", i.Image.Bounds(), i.App2 != nil, i.XMP)
+	}
+}
+
+func TestEncodeWebP(t *testing.T) {
+	// A 3x2 image stored sideways, with an orientation of 6, whose top left pixel ends up at the top right.
+	m := image.NewNRGBA(image.Rect(0, 0, 2, 3))
+	m.Set(0, 1, color.NRGBA{0xff, 0, 0, 0x80})
+	src := Image{
+		Image: m,
+		App2:  iccjpeg.App2Data(icc.DisplayP3Data),
+		Exif:  testExif(6, 2, 3),
+		XMP:   []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`),
+	}
+	buf := &bytes.Buffer{}
+	if err := EncodeWebP(buf, src, nil); err != nil {
+		t.Fatal("EncodeWebP failed:", err)
+	}
+
+	i, format, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+	if format != "webp" || i.Image.Bounds() != image.Rect(0, 0, 3, 2) {
+		t.Fatalf("got %s image %v", format, i.Image.Bounds())
+	}
+	if c := color.NRGBAModel.Convert(i.Image.At(1, 0)).(color.NRGBA); c != (color.NRGBA{0xff, 0, 0, 0x80}) {
+		t.Errorf("got %v at the top right", c)
+	}
+	if o := Orientation(i.Exif); o != 1 {
+		t.Errorf("orientation is %d", o)
+	}
+	if !bytes.Equal(iccjpeg.ProfileData(i.App2), icc.DisplayP3Data) {
+		t.Error("ICC profile was lost")
+	}
+	if !bytes.Equal(i.XMP, src.XMP) {
+		t.Errorf("got XMP %q", i.XMP)
+	}
+}
@@ -0,0 +1,208 @@
+package img
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// setXMPProperties returns a copy of the XMP packet with the given simple properties, such as "tiff:Orientation", set
+// to new values, whether they are written as attributes or as elements. Properties that aren't present are left out.
+func setXMPProperties(xmp []byte, props map[string]string) []byte {
+	if len(xmp) == 0 {
+		return xmp
+	}
+	xmp = xmpAttr.ReplaceAllFunc(xmp, func(b []byte) []byte {
+		m := xmpAttr.FindSubmatch(b)
+		if v, ok := props[string(m[2])]; ok {
+			return []byte(string(m[1]) + `"` + v + `"`)
+		}
+		return b
+	})
+	return xmpElem.ReplaceAllFunc(xmp, func(b []byte) []byte {
+		m := xmpElem.FindSubmatch(b)
+		if v, ok := props[string(m[1])]; ok && bytes.Equal(m[1], m[3]) {
+			return []byte("<" + string(m[1]) + ">" + v + "</" + string(m[1]) + ">")
+		}
+		return b
+	})
+}
+
+var (
+	// xmpAttr, xmpElem and xmpSeq match simple properties written as attributes or elements, and ordered arrays, of
+	// any name. The names of elements are captured at both ends, as they can't be matched with a backreference.
+	xmpAttr        = regexp.MustCompile(`(\s([\w.-]+:[\w.-]+)\s*=\s*)(?:"([^"]*)"|'([^']*)')`)
+	xmpElem        = regexp.MustCompile(`<([\w.-]+:[\w.-]+)>([^<]*)</([\w.-]+:[\w.-]+)>`)
+	xmpSeq         = regexp.MustCompile(`(?s)<([\w.-]+:[\w.-]+)>\s*<rdf:Seq>(.*?)</rdf:Seq>\s*</([\w.-]+:[\w.-]+)>`)
+	xmpSeqItem     = regexp.MustCompile(`<rdf:li>([^<]*)</rdf:li>`)
+	xmpItem        = regexp.MustCompile(`\s*<rdf:li\b[^>]*>\s*<Container:Item\b[^>]*/>\s*</rdf:li>`)
+	xmpItemTag     = regexp.MustCompile(`<Container:Item\b[^>]*>`)
+	xmpItemLen     = regexp.MustCompile(`(\sItem:Length\s*=\s*)(?:"([^"]*)"|'([^']*)')`)
+	xmpItemEnd     = regexp.MustCompile(`\s*/?>$`)
+	xmpDirectory   = regexp.MustCompile(`<Container:Directory>\s*<rdf:Seq>\s*<rdf:li\b[^>]*>\s*<Container:Item\b[^>]*/>\s*</rdf:li>`)
+	xmpDescription = regexp.MustCompile(`<rdf:Description\b`)
+	xmpRDFEnd      = regexp.MustCompile(`</rdf:RDF>`)
+	xmpNoPadding   = regexp.MustCompile(`^\s*$`)
+	xmpGainMapVer  = regexp.MustCompile(`\s+hdrgm:Version\s*=\s*(?:"[^"]*"|'[^']*')`)
+)
+
+// xmpProperty returns the values of a simple or ordered array property, such as "hdrgm:GainMapMin", of the XMP packet,
+// or nil if it isn't present.
+func xmpProperty(xmp []byte, name string) []string {
+	for _, m := range xmpAttr.FindAllSubmatch(xmp, -1) {
+		if string(m[2]) == name {
+			return []string{string(m[3]) + string(m[4])}
+		}
+	}
+	for _, m := range xmpElem.FindAllSubmatch(xmp, -1) {
+		if string(m[1]) == name && string(m[3]) == name {
+			return []string{string(m[2])}
+		}
+	}
+	for _, m := range xmpSeq.FindAllSubmatch(xmp, -1) {
+		if string(m[1]) == name && string(m[3]) == name {
+			var vs []string
+			for _, li := range xmpSeqItem.FindAllSubmatch(m[2], -1) {
+				vs = append(vs, string(li[1]))
+			}
+			return vs
+		}
+	}
+	return nil
+}
+
+// isContainerItem reports whether the Container:Item tag has the given Item:Semantic, such as "GainMap".
+func isContainerItem(tag []byte, semantic string) bool {
+	return bytes.Contains(tag, []byte(`Item:Semantic="`+semantic+`"`))
+}
+
+// hasContainerItem reports whether the container directory of the XMP packet has an item with the given semantic.
+func hasContainerItem(xmp []byte, semantic string) bool {
+	for _, tag := range xmpItemTag.FindAll(xmp, -1) {
+		if isContainerItem(tag, semantic) {
+			return true
+		}
+	}
+	return false
+}
+
+// containerItemLength returns the Item:Length of the item with the given semantic in the container directory of the
+// XMP packet, or 0 if there is no such item or it has no length.
+func containerItemLength(xmp []byte, semantic string) int {
+	for _, tag := range xmpItemTag.FindAll(xmp, -1) {
+		if !isContainerItem(tag, semantic) {
+			continue
+		}
+		if m := xmpItemLen.FindSubmatch(tag); m != nil {
+			n, _ := strconv.Atoi(string(m[2]) + string(m[3]))
+			return n
+		}
+		return 0
+	}
+	return 0
+}
+
+// setContainerItemLength returns a copy of the XMP packet with the Item:Length of the item with the given semantic
+// set, and reports whether there is such an item.
+func setContainerItemLength(xmp []byte, semantic string, length int) ([]byte, bool) {
+	found := false
+	xmp = xmpItemTag.ReplaceAllFunc(xmp, func(tag []byte) []byte {
+		if !isContainerItem(tag, semantic) {
+			return tag
+		}
+		found = true
+		l := []byte(strconv.Itoa(length))
+		if xmpItemLen.Match(tag) {
+			return xmpItemLen.ReplaceAll(tag, append([]byte(`${1}"`), append(l, '"')...))
+		}
+		end := xmpItemEnd.Find(tag)
+		t := append([]byte(nil), tag[:len(tag)-len(end)]...)
+		t = append(t, ` Item:Length="`...)
+		t = append(t, l...)
+		return append(append(t, '"'), end...)
+	})
+	return xmp, found
+}
+
+// removeContainerItem returns a copy of the XMP packet without the item with the given semantic in its container
+// directory.
+func removeContainerItem(xmp []byte, semantic string) []byte {
+	return xmpItem.ReplaceAllFunc(xmp, func(li []byte) []byte {
+		if isContainerItem(li, semantic) {
+			return nil
+		}
+		return li
+	})
+}
+
+// gainMapItem is the container directory item of an Ultra HDR gain map.
+const gainMapItem = `
+     <rdf:li rdf:parseType="Resource">
+      <Container:Item Item:Semantic="GainMap" Item:Mime="image/jpeg" Item:Length="%d"/>
+     </rdf:li>`
+
+// gainMapDirectory is the description of an Ultra HDR primary image, which lists the gain map that follows it.
+const gainMapDirectory = `<rdf:Description rdf:about=""
+    xmlns:Container="http://ns.google.com/photos/1.0/container/"
+    xmlns:Item="http://ns.google.com/photos/1.0/container/item/"
+    xmlns:hdrgm="` + hdrgmNamespace + `"
+    hdrgm:Version="1.0">
+   <Container:Directory>
+    <rdf:Seq>
+     <rdf:li rdf:parseType="Resource">
+      <Container:Item Item:Semantic="Primary" Item:Mime="image/jpeg"/>
+     </rdf:li>%s
+    </rdf:Seq>
+   </Container:Directory>
+  </rdf:Description>
+ `
+
+// setGainMapDirectory returns a copy of the XMP packet of an Ultra HDR primary image that lists a gain map of the
+// given length in its container directory. An existing gain map item is updated, one is added after the primary image
+// in directories without one, such as those of motion photos, and a description with the directory is added to packets
+// without one.
+func setGainMapDirectory(xmp []byte, length int) []byte {
+	if out, ok := setContainerItemLength(xmp, "GainMap", length); ok {
+		return out
+	}
+	item := fmt.Sprintf(gainMapItem, length)
+	if loc := xmpDirectory.FindIndex(xmp); loc != nil {
+		out := append([]byte(nil), xmp[:loc[1]]...)
+		out = append(out, item...)
+		out = append(out, xmp[loc[1]:]...)
+		if xmpProperty(out, "hdrgm:Version") != nil {
+			return out
+		}
+		// Declare the gain map version in the description that holds the directory.
+		descs := xmpDescription.FindAllIndex(out[:loc[0]], -1)
+		if descs == nil {
+			return out
+		}
+		at := descs[len(descs)-1][1]
+		attrs := ` xmlns:hdrgm="` + hdrgmNamespace + `" hdrgm:Version="1.0"`
+		return append(append(append([]byte(nil), out[:at]...), attrs...), out[at:]...)
+	}
+	desc := []byte(fmt.Sprintf(gainMapDirectory, item))
+	if xmpNoPadding.Match(xmp) {
+		return append(append([]byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  `), desc...), "</rdf:RDF>\n</x:xmpmeta>"...)
+	}
+	loc := xmpRDFEnd.FindIndex(xmp)
+	if loc == nil {
+		return xmp
+	}
+	out := append([]byte(nil), xmp[:loc[0]]...)
+	out = append(out, desc...)
+	return append(out, xmp[loc[0]:]...)
+}
+
+// removeGainMapDirectory returns a copy of the XMP packet of a primary image without the gain map item and version
+// that setGainMapDirectory adds, for images whose gain map was dropped.
+func removeGainMapDirectory(xmp []byte) []byte {
+	if !hasContainerItem(xmp, "GainMap") {
+		return xmp
+	}
+	return xmpGainMapVer.ReplaceAll(removeContainerItem(xmp, "GainMap"), nil)
+}
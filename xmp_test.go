@@ -0,0 +1,22 @@
+package img
+
+import "testing"
+
+func TestSetXMPProperties(t *testing.T) {
+	xmp := `<rdf:Description tiff:Orientation="6" exif:PixelXDimension='4032' tiff:Make="Orientation">` +
+		`<tiff:ImageWidth>4032</tiff:ImageWidth><tiff:ImageLength>3024</exif:ImageLength>` +
+		`<exif:PixelYDimension>3024</exif:PixelYDimension></rdf:Description>`
+	want := `<rdf:Description tiff:Orientation="1" exif:PixelXDimension="3024" tiff:Make="Orientation">` +
+		`<tiff:ImageWidth>3024</tiff:ImageWidth><tiff:ImageLength>3024</exif:ImageLength>` +
+		`<exif:PixelYDimension>3024</exif:PixelYDimension></rdf:Description>`
+	got := setXMPProperties([]byte(xmp), map[string]string{
+		"tiff:Orientation":     "1",
+		"exif:PixelXDimension": "3024",
+		"tiff:ImageWidth":      "3024",
+		"tiff:ImageLength":     "4032",
+		"tiff:Missing":         "0",
+	})
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}